@@ -0,0 +1,123 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when a call is rejected by an open circuit
+var ErrCircuitOpen = fmt.Errorf("circuit breaker is open")
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// CircuitBreaker stops calls to an upstream after consecutive failures, once
+// openDuration has elapsed a single probe call is allowed, if the probe
+// succeeds the circuit closes otherwise it opens again
+type CircuitBreaker struct {
+	threshold    int
+	openDuration time.Duration
+	now          func() time.Time
+
+	mutex    sync.Mutex
+	state    string
+	failures int
+	lastTrip time.Time
+	probing  bool
+}
+
+// CircuitStats reports the current state of a circuit breaker
+type CircuitStats struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	LastTrip  *time.Time `json:"last_trip,omitempty"`
+	NextProbe *time.Time `json:"next_probe,omitempty"`
+}
+
+// NewCircuitBreaker creates a circuit breaker which opens after threshold
+// consecutive failures and stays open for openDuration
+func NewCircuitBreaker(threshold int, openDuration time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold:    threshold,
+		openDuration: openDuration,
+		now:          time.Now,
+		state:        CircuitClosed,
+	}
+}
+
+// Allow returns true when a call can be made
+func (c *CircuitBreaker) Allow() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch c.state {
+	case CircuitOpen:
+		if c.now().Before(c.lastTrip.Add(c.openDuration)) {
+			return false
+		}
+
+		// allow a single probe call
+		c.state = CircuitHalfOpen
+		c.probing = true
+		return true
+	case CircuitHalfOpen:
+		if c.probing {
+			return false
+		}
+
+		c.probing = true
+		return true
+	}
+
+	return true
+}
+
+// Success records a successful call and closes the circuit
+func (c *CircuitBreaker) Success() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.state = CircuitClosed
+	c.failures = 0
+	c.probing = false
+}
+
+// Failure records a failed call, the circuit opens when the threshold is
+// reached or a probe call fails
+func (c *CircuitBreaker) Failure() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.failures++
+	c.probing = false
+
+	if c.state == CircuitHalfOpen || c.failures >= c.threshold {
+		c.state = CircuitOpen
+		c.lastTrip = c.now()
+	}
+}
+
+// Stats returns the current state of the circuit breaker
+func (c *CircuitBreaker) Stats() CircuitStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	s := CircuitStats{State: c.state, Failures: c.failures}
+
+	if !c.lastTrip.IsZero() {
+		lt := c.lastTrip
+		s.LastTrip = &lt
+	}
+
+	if c.state == CircuitOpen {
+		np := c.lastTrip.Add(c.openDuration)
+		s.NextProbe = &np
+	}
+
+	return s
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupCircuitBreaker(t *testing.T) (*CircuitBreaker, *time.Time) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewCircuitBreaker(2, 10*time.Second)
+	c.now = func() time.Time { return now }
+
+	return c, &now
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	c, _ := setupCircuitBreaker(t)
+
+	c.Failure()
+	assert.True(t, c.Allow())
+
+	c.Failure()
+	assert.False(t, c.Allow())
+	assert.Equal(t, CircuitOpen, c.Stats().State)
+}
+
+func TestCircuitBreakerAllowsSingleProbeAfterOpenDuration(t *testing.T) {
+	c, now := setupCircuitBreaker(t)
+	c.Failure()
+	c.Failure()
+
+	*now = now.Add(10 * time.Second)
+
+	assert.True(t, c.Allow())
+	assert.False(t, c.Allow())
+	assert.Equal(t, CircuitHalfOpen, c.Stats().State)
+
+	c.Success()
+	assert.True(t, c.Allow())
+	assert.Equal(t, CircuitClosed, c.Stats().State)
+}
+
+func TestCircuitBreakerReopensWhenProbeFails(t *testing.T) {
+	c, now := setupCircuitBreaker(t)
+	c.Failure()
+	c.Failure()
+
+	*now = now.Add(10 * time.Second)
+	c.Allow()
+	c.Failure()
+
+	assert.False(t, c.Allow())
+	assert.Equal(t, *now, *c.Stats().LastTrip)
+}
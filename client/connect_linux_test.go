@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/grpc/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// blackHoleAddr returns the address of a listener which never completes a
+// connection. The listener has a backlog of 0 which is filled by the first
+// connection, Linux drops the SYN of every later connection so they time out
+// regardless of how quickly the dial is made
+func blackHoleAddr(t *testing.T) string {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	assert.NoError(t, err)
+	t.Cleanup(func() { syscall.Close(fd) })
+
+	err = syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}})
+	assert.NoError(t, err)
+
+	err = syscall.Listen(fd, 0)
+	assert.NoError(t, err)
+
+	sa, err := syscall.Getsockname(fd)
+	assert.NoError(t, err)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+
+	c, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+
+	return addr
+}
+
+func TestHTTPReturnsConnectErrorWhenConnectTimeoutExceeded(t *testing.T) {
+	addr := blackHoleAddr(t)
+
+	c := NewHTTP(false, false, 10*time.Second, 100*time.Millisecond, false, DefaultSocketOptions, 0, nil, nil)
+	r, _ := http.NewRequest(http.MethodGet, "http://"+addr, nil)
+
+	st := time.Now()
+	code, _, _, _, err := c.Do(r, nil)
+
+	assert.Equal(t, -1, code)
+	assert.IsType(t, &ConnectError{}, err)
+	assert.True(t, err.(*ConnectError).Err.(net.Error).Timeout())
+	assert.Less(t, int64(time.Since(st)), int64(5*time.Second))
+}
+
+func TestGRPCReturnsConnectErrorWhenConnectTimeoutExceeded(t *testing.T) {
+	addr := blackHoleAddr(t)
+
+	c, err := NewGRPC(addr, 10*time.Second, 100*time.Millisecond, 4*1024*1024, DefaultSocketOptions, nil, "", "")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, _, err = c.Handle(ctx, &api.Nil{})
+
+	assert.IsType(t, &ConnectError{}, err)
+	assert.True(t, err.(*ConnectError).Err.(net.Error).Timeout())
+}
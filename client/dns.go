@@ -0,0 +1,132 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrDNSInjected is returned when a DNS failure is injected by the Resolver
+var ErrDNSInjected = fmt.Errorf("DNS failure automatically injected")
+
+// DNSError is returned when the upstream host name could not be resolved, this
+// allows DNS failures to be distinguished from connection failures
+type DNSError struct {
+	Host string
+	Err  error
+}
+
+func (d *DNSError) Error() string {
+	return fmt.Sprintf("Unable to resolve upstream host %s: %s", d.Host, d.Err)
+}
+
+// Lookup resolves a host name to a list of addresses, net.Resolver implements
+// this interface
+type Lookup interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// Resolver resolves upstream host names, resolved addresses are cached for
+// cacheTTL and lookups can be delayed or failed to simulate a slow or failing
+// DNS server
+type Resolver struct {
+	lookup      Lookup
+	cacheTTL    time.Duration
+	delay       time.Duration
+	failureRate float64
+
+	cache map[string]dnsEntry
+	mutex sync.Mutex
+
+	now        func() time.Time
+	randomFunc func() float64
+}
+
+// NewResolver creates a new Resolver, when server is not empty lookups are
+// sent to the DNS server at the given address e.g. 10.0.0.2:53 in place of
+// the system resolver. The TTL of the DNS records is not exposed by the Go
+// resolver so entries are cached for cacheTTL, 0 disables the cache
+func NewResolver(server string, cacheTTL, delay time.Duration, failureRate float64) *Resolver {
+	var l Lookup = net.DefaultResolver
+	if server != "" {
+		l = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	return newResolver(l, cacheTTL, delay, failureRate)
+}
+
+func newResolver(l Lookup, cacheTTL, delay time.Duration, failureRate float64) *Resolver {
+	return &Resolver{
+		lookup:      l,
+		cacheTTL:    cacheTTL,
+		delay:       delay,
+		failureRate: failureRate,
+		cache:       map[string]dnsEntry{},
+		now:         time.Now,
+		randomFunc:  rand.Float64,
+	}
+}
+
+// Resolve returns the addresses for the host, any failure is returned as a
+// DNSError
+func (r *Resolver) Resolve(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r.cached(host); ok {
+		return addrs, nil
+	}
+
+	if r.delay > 0 {
+		select {
+		case <-time.After(r.delay):
+		case <-ctx.Done():
+			return nil, &DNSError{host, ctx.Err()}
+		}
+	}
+
+	if r.failureRate > 0 && r.randomFunc() < r.failureRate {
+		return nil, &DNSError{host, ErrDNSInjected}
+	}
+
+	addrs, err := r.lookup.LookupHost(ctx, host)
+	if err != nil {
+		return nil, &DNSError{host, err}
+	}
+
+	if r.cacheTTL > 0 {
+		r.mutex.Lock()
+		r.cache[host] = dnsEntry{addrs, r.now().Add(r.cacheTTL)}
+		r.mutex.Unlock()
+	}
+
+	return addrs, nil
+}
+
+func (r *Resolver) cached(host string) ([]string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	e, ok := r.cache[host]
+	if !ok {
+		return nil, false
+	}
+
+	if r.now().After(e.expires) {
+		delete(r.cache, host)
+		return nil, false
+	}
+
+	return e.addrs, true
+}
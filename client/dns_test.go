@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockLookup struct {
+	delay time.Duration
+	addrs []string
+	err   error
+	calls int
+}
+
+func (m *mockLookup) LookupHost(ctx context.Context, host string) ([]string, error) {
+	m.calls++
+	time.Sleep(m.delay)
+
+	return m.addrs, m.err
+}
+
+func TestResolverReturnsDNSErrorWhenLookupDelaysThenFails(t *testing.T) {
+	ml := &mockLookup{delay: 50 * time.Millisecond, err: fmt.Errorf("no such host")}
+	r := newResolver(ml, 0, 0, 0)
+
+	st := time.Now()
+	_, err := r.Resolve(context.Background(), "upstream")
+
+	assert.GreaterOrEqual(t, int64(time.Since(st)), int64(50*time.Millisecond))
+	assert.IsType(t, &DNSError{}, err)
+	assert.Equal(t, "upstream", err.(*DNSError).Host)
+}
+
+func TestResolverInjectsDelayAndFailure(t *testing.T) {
+	ml := &mockLookup{addrs: []string{"127.0.0.1"}}
+	r := newResolver(ml, 0, 50*time.Millisecond, 0.5)
+	r.randomFunc = func() float64 { return 0.1 }
+
+	st := time.Now()
+	_, err := r.Resolve(context.Background(), "upstream")
+
+	assert.GreaterOrEqual(t, int64(time.Since(st)), int64(50*time.Millisecond))
+	assert.Equal(t, &DNSError{"upstream", ErrDNSInjected}, err)
+	assert.Equal(t, 0, ml.calls)
+}
+
+func TestResolverCachesAddressesForTTL(t *testing.T) {
+	ml := &mockLookup{addrs: []string{"127.0.0.1"}}
+	r := newResolver(ml, 10*time.Second, 0, 0)
+
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	r.Resolve(context.Background(), "upstream")
+	addrs, err := r.Resolve(context.Background(), "upstream")
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"127.0.0.1"}, addrs)
+	assert.Equal(t, 1, ml.calls)
+
+	// expire the entry
+	now = now.Add(11 * time.Second)
+	r.Resolve(context.Background(), "upstream")
+
+	assert.Equal(t, 2, ml.calls)
+}
+
+func TestHTTPReturnsDNSErrorWhenResolutionFails(t *testing.T) {
+	ml := &mockLookup{err: fmt.Errorf("no such host")}
+
+	c := NewHTTP(false, false, 10*time.Second, 1*time.Second, false, DefaultSocketOptions, 0, newResolver(ml, 0, 0, 0), nil)
+	r, _ := http.NewRequest(http.MethodGet, "http://upstream:9090", nil)
+
+	code, _, _, _, err := c.Do(r, nil)
+
+	assert.Equal(t, -1, code)
+	assert.IsType(t, &DNSError{}, err)
+}
+
+func TestHTTPConnectsToResolvedAddress(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	ml := &mockLookup{addrs: []string{"127.0.0.1"}}
+
+	c := NewHTTP(false, false, 10*time.Second, 1*time.Second, false, DefaultSocketOptions, 0, newResolver(ml, 0, 0, 0), nil)
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	r, _ := http.NewRequest(http.MethodGet, "http://upstream:"+port, nil)
+
+	code, _, _, _, err := c.Do(r, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, ml.calls)
+}
@@ -2,12 +2,19 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nicholasjackson/fake-service/grpc/api"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip" // register the gzip compressor
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 // GRPC defines the interface for a GRPC client
@@ -15,25 +22,64 @@ type GRPC interface {
 	Handle(context.Context, *api.Nil) (*api.Response, map[string]string, error)
 }
 
-// NewGRPC creates a new GRPC client
-func NewGRPC(uri string, timeout time.Duration) (GRPC, error) {
+// NewGRPC creates a new GRPC client, connectTimeout is the max time to wait
+// for a connection to the upstream to be established, maxMessageSize is the
+// largest message in bytes the client will receive, sent messages keep the
+// gRPC default which is unlimited, socketOptions
+// are applied to the connection, when resolver is not nil it resolves the
+// upstream host name, when compression is not empty requests are compressed
+// with the named compressor e.g. gzip, when serverName is not empty the
+// upstream is called using TLS with serverName as the server name (SNI) and
+// the name its certificate is verified against
+func NewGRPC(uri string, timeout, connectTimeout time.Duration, maxMessageSize int, socketOptions SocketOptions, resolver *Resolver, compression string, serverName string) (GRPC, error) {
+	dial := dialContext(&net.Dialer{Timeout: connectTimeout}, socketOptions, resolver)
+
+	callOptions := []grpc.CallOption{
+		grpc.MaxCallRecvMsgSize(maxMessageSize),
+	}
+
+	// the server responds using the same compression as the request
+	if compression != "" {
+		callOptions = append(callOptions, grpc.UseCompressor(compression))
+	}
+
+	security := grpc.WithInsecure()
+	if serverName != "" {
+		security = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{ServerName: serverName}))
+	}
+
+	g := &GRPCImpl{}
 
 	conn, err := grpc.Dial(
 		uri,
-		grpc.WithInsecure(),
+		security,
 		grpc.WithTimeout(timeout),
+		grpc.WithDefaultCallOptions(callOptions...),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			c, err := dial(ctx, "tcp", addr)
+			g.setDialError(err)
+
+			return c, err
+		}),
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &GRPCImpl{api.NewFakeServiceClient(conn)}, nil
+	g.client = api.NewFakeServiceClient(conn)
+
+	return g, nil
 }
 
 // GRPCImpl is the concrete implementation of the GRPC client
 type GRPCImpl struct {
 	client api.FakeServiceClient
+
+	// dialError is the error of the last attempt to connect to the upstream,
+	// nil once a connection is established
+	dialError error
+	mutex     sync.Mutex
 }
 
 // Handle calls the upstream client
@@ -56,5 +102,35 @@ func (c *GRPCImpl) Handle(ctx context.Context, n *api.Nil) (*api.Response, map[s
 		headers[k] = strings.Join(v, ",")
 	}
 
+	// gRPC reports a failed connection as Unavailable, return the
+	// ConnectError or DNSError of the dial so that connection failures can be
+	// told apart from failing upstreams as they are for HTTP
+	if status.Code(err) == codes.Unavailable {
+		if de := c.connectError(); de != nil {
+			err = de
+		}
+	}
+
 	return resp, headers, err
 }
+
+func (c *GRPCImpl) setDialError(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.dialError = err
+}
+
+// connectError returns the error of the last dial when the connection
+// could not be established
+func (c *GRPCImpl) connectError() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	switch c.dialError.(type) {
+	case *ConnectError, *DNSError:
+		return c.dialError
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/grpc/api"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+// largeServer returns a message of the given size
+type largeServer struct {
+	size int
+}
+
+func (s *largeServer) Handle(context.Context, *api.Nil) (*api.Response, error) {
+	return &api.Response{Message: strings.Repeat("a", s.size)}, nil
+}
+
+// countingListener counts the bytes written to accepted connections
+type countingListener struct {
+	net.Listener
+	written int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &countingConn{c, &l.written}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	written *int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(c.written, int64(n))
+	return n, err
+}
+
+func setupGRPCServer(t *testing.T, size, maxMessageSize int, opts ...grpc.ServerOption) (string, func()) {
+	addr, _, cleanup := setupCountingGRPCServer(t, size, maxMessageSize, opts...)
+	return addr, cleanup
+}
+
+func setupCountingGRPCServer(t *testing.T, size, maxMessageSize int, opts ...grpc.ServerOption) (string, *countingListener, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	cl := &countingListener{Listener: lis}
+
+	opts = append(opts, grpc.MaxSendMsgSize(maxMessageSize), grpc.MaxRecvMsgSize(maxMessageSize))
+	s := grpc.NewServer(opts...)
+	api.RegisterFakeServiceServer(s, &largeServer{size})
+	go s.Serve(cl)
+
+	return lis.Addr().String(), cl, s.Stop
+}
+
+func TestGRPCReceivesMessageLargerThanDefaultLimit(t *testing.T) {
+	addr, cleanup := setupGRPCServer(t, 5*1024*1024, 8*1024*1024)
+	defer cleanup()
+
+	c, err := NewGRPC(addr, 5*time.Second, 5*time.Second, 8*1024*1024, DefaultSocketOptions, nil, "", "")
+	assert.NoError(t, err)
+
+	resp, _, err := c.Handle(context.Background(), &api.Nil{})
+
+	assert.NoError(t, err)
+	assert.Len(t, resp.Message, 5*1024*1024)
+}
+
+func TestGRPCReturnsErrorForMessageLargerThanLimit(t *testing.T) {
+	addr, cleanup := setupGRPCServer(t, 5*1024*1024, 8*1024*1024)
+	defer cleanup()
+
+	c, err := NewGRPC(addr, 5*time.Second, 5*time.Second, 4*1024*1024, DefaultSocketOptions, nil, "", "")
+	assert.NoError(t, err)
+
+	_, _, err = c.Handle(context.Background(), &api.Nil{})
+
+	assert.Error(t, err)
+}
+
+func TestGRPCDecodesResponseCompressedWithClientCompression(t *testing.T) {
+	addr, cl, cleanup := setupCountingGRPCServer(t, 1024*1024, 8*1024*1024)
+	defer cleanup()
+
+	c, err := NewGRPC(addr, 5*time.Second, 5*time.Second, 8*1024*1024, DefaultSocketOptions, nil, "gzip", "")
+	assert.NoError(t, err)
+
+	resp, _, err := c.Handle(context.Background(), &api.Nil{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", 1024*1024), resp.Message)
+	// the repeated message compresses to a fraction of its size
+	assert.Less(t, atomic.LoadInt64(&cl.written), int64(100*1024))
+}
+
+func TestGRPCDecodesResponseCompressedByServer(t *testing.T) {
+	addr, cl, cleanup := setupCountingGRPCServer(t, 1024*1024, 8*1024*1024, grpc.RPCCompressor(grpc.NewGZIPCompressor()))
+	defer cleanup()
+
+	c, err := NewGRPC(addr, 5*time.Second, 5*time.Second, 8*1024*1024, DefaultSocketOptions, nil, "", "")
+	assert.NoError(t, err)
+
+	resp, _, err := c.Handle(context.Background(), &api.Nil{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("a", 1024*1024), resp.Message)
+	assert.Less(t, atomic.LoadInt64(&cl.written), int64(100*1024))
+}
+
+func TestGRPCResponseIsUncompressedWithoutCompression(t *testing.T) {
+	addr, cl, cleanup := setupCountingGRPCServer(t, 1024*1024, 8*1024*1024)
+	defer cleanup()
+
+	c, err := NewGRPC(addr, 5*time.Second, 5*time.Second, 8*1024*1024, DefaultSocketOptions, nil, "", "")
+	assert.NoError(t, err)
+
+	resp, _, err := c.Handle(context.Background(), &api.Nil{})
+
+	assert.NoError(t, err)
+	assert.Len(t, resp.Message, 1024*1024)
+	assert.Greater(t, atomic.LoadInt64(&cl.written), int64(1024*1024))
+}
@@ -1,10 +1,14 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -14,25 +18,70 @@ type HTTP interface {
 	Do(r *http.Request, pr *http.Request) (int, []byte, map[string]string, map[string]string, error)
 }
 
+// ConnectError is returned when a connection to the upstream could not be
+// established, this allows connection failures to be distinguished from
+// slow or failing upstreams
+type ConnectError struct {
+	Err error
+}
+
+func (c *ConnectError) Error() string {
+	return fmt.Sprintf("Unable to connect to upstream service: %s", c.Err)
+}
+
+// TruncatedError is returned when the upstream response body is larger than
+// the maximum response size, the body returned contains the first Limit bytes
+type TruncatedError struct {
+	Limit int64
+}
+
+func (t *TruncatedError) Error() string {
+	return fmt.Sprintf("Upstream response body exceeded the maximum size of %d bytes and was truncated", t.Limit)
+}
+
 // HTTPImpl is the concrete implementation of the HTTP interface
 type HTTPImpl struct {
 	defaultClient *http.Client
 	appendRequest bool // should we append the headers path and query from the original request
+	// maxResponseBytes limits the size of the response body read from the
+	// upstream, 0 is unlimited
+	maxResponseBytes int64
+	// serverNameClients are used for the upstream hosts which are sent a
+	// TLS server name other than the host
+	serverNameClients map[string]*http.Client
 }
 
-// NewHTTP creates a new HTTP client
-func NewHTTP(upstreamClientKeepAlives bool, appendRequest bool, timeOut time.Duration, allowInsecure bool) HTTP {
-	client := &http.Client{
-		Transport: &http.Transport{
-			DisableKeepAlives: !upstreamClientKeepAlives,
-			TLSClientConfig:   &tls.Config{InsecureSkipVerify: allowInsecure},
-		},
-		Timeout: timeOut,
+// NewHTTP creates a new HTTP client, connectTimeout is the max time to wait
+// for a connection to be established and is independent of timeOut which
+// applies to the entire request, socketOptions are applied to every upstream
+// connection, response bodies larger than maxResponseBytes are truncated,
+// when resolver is not nil it resolves the upstream host names, serverNames
+// maps an upstream host e.g. 10.0.0.5:8443 to the TLS server name (SNI) sent
+// to it and used to verify its certificate
+func NewHTTP(upstreamClientKeepAlives bool, appendRequest bool, timeOut, connectTimeout time.Duration, allowInsecure bool, socketOptions SocketOptions, maxResponseBytes int64, resolver *Resolver, serverNames map[string]string) HTTP {
+	dialer := &net.Dialer{Timeout: connectTimeout}
+
+	transport := &http.Transport{
+		DialContext:       dialContext(dialer, socketOptions, resolver),
+		DisableKeepAlives: !upstreamClientKeepAlives,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: allowInsecure},
+	}
+
+	// the server name is part of the TLS config of the transport so each
+	// overridden host has its own transport
+	serverNameClients := map[string]*http.Client{}
+	for host, name := range serverNames {
+		t := transport.Clone()
+		t.TLSClientConfig.ServerName = name
+
+		serverNameClients[host] = &http.Client{Transport: t, Timeout: timeOut}
 	}
 
 	return &HTTPImpl{
-		defaultClient: client,
-		appendRequest: appendRequest,
+		defaultClient:     &http.Client{Transport: transport, Timeout: timeOut},
+		serverNameClients: serverNameClients,
+		appendRequest:     appendRequest,
+		maxResponseBytes:  maxResponseBytes,
 	}
 }
 
@@ -46,19 +95,47 @@ func (h *HTTPImpl) Do(r *http.Request, pr *http.Request) (int, []byte, map[strin
 		appendPath(r, pr)
 	}
 
+	c := h.defaultClient
+	if sc, ok := h.serverNameClients[r.URL.Host]; ok {
+		c = sc
+	}
+
 	// call the upstream service
-	resp, err := h.defaultClient.Do(r)
+	resp, err := c.Do(r)
 	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			if ce, ok := ue.Err.(*ConnectError); ok {
+				return -1, nil, nil, nil, ce
+			}
+
+			if de, ok := ue.Err.(*DNSError); ok {
+				return -1, nil, nil, nil, de
+			}
+		}
+
 		return -1, nil, nil, nil, fmt.Errorf("Error communicating with upstream service: %s", err)
 	}
 
 	defer resp.Body.Close()
 
-	data, err = ioutil.ReadAll(resp.Body)
+	// read one byte more than the limit so that a body of exactly the limit
+	// is not reported as truncated
+	var body io.Reader = resp.Body
+	if h.maxResponseBytes > 0 {
+		body = io.LimitReader(resp.Body, h.maxResponseBytes+1)
+	}
+
+	data, err = ioutil.ReadAll(body)
 	if err != nil {
 		return resp.StatusCode, nil, nil, nil, fmt.Errorf("Error reading response body: %d", err)
 	}
 
+	var truncated bool
+	if h.maxResponseBytes > 0 && int64(len(data)) > h.maxResponseBytes {
+		data = data[:h.maxResponseBytes]
+		truncated = true
+	}
+
 	var statusError error
 	if resp.StatusCode != http.StatusOK {
 		// if a request err
@@ -75,9 +152,76 @@ func (h *HTTPImpl) Do(r *http.Request, pr *http.Request) (int, []byte, map[strin
 		cookies[c.Name] = c.Value
 	}
 
+	if statusError == nil && truncated {
+		return resp.StatusCode, data, headers, cookies, &TruncatedError{h.maxResponseBytes}
+	}
+
 	return resp.StatusCode, data, headers, cookies, statusError
 }
 
+// dialContext wraps the dialer so that any connection failure is returned as
+// a ConnectError and the socket options are applied to new connections, when
+// resolver is not nil it is used to resolve the host in place of the dialer
+func dialContext(d *net.Dialer, so SocketOptions, resolver *Resolver) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		addrs := []string{addr}
+		if resolver != nil {
+			var err error
+			addrs, err = resolveAddr(ctx, resolver, addr)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// try each resolved address in turn returning the first connection
+		var conn net.Conn
+		var err error
+		for _, a := range addrs {
+			conn, err = d.DialContext(ctx, network, a)
+			if err == nil {
+				break
+			}
+		}
+
+		if err != nil {
+			return nil, &ConnectError{err}
+		}
+
+		err = so.Apply(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
+
+// resolveAddr resolves the host part of addr returning a list of host:port
+// addresses, IP addresses are returned unchanged
+func resolveAddr(ctx context.Context, resolver *Resolver, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+
+	ips, err := resolver.Resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []string{}
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip, port))
+	}
+
+	return addrs, nil
+}
+
 // appendHeaders from the original request
 func appendHeaders(r, pr *http.Request) {
 	for k, v := range pr.Header {
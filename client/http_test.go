@@ -0,0 +1,139 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPConnectsWithinConnectTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	c := NewHTTP(false, false, 10*time.Second, 1*time.Second, false, DefaultSocketOptions, 0, nil, nil)
+	r, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+
+	code, _, _, _, err := c.Do(r, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestHTTPTruncatesResponseLargerThanMaxResponseBytes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer ts.Close()
+
+	c := NewHTTP(false, false, 10*time.Second, 1*time.Second, false, DefaultSocketOptions, 10, nil, nil)
+	r, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+
+	code, data, _, _, err := c.Do(r, nil)
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, data, 10)
+	assert.Equal(t, &TruncatedError{Limit: 10}, err)
+}
+
+func TestHTTPDoesNotTruncateResponseEqualToMaxResponseBytes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(strings.Repeat("a", 10)))
+	}))
+	defer ts.Close()
+
+	c := NewHTTP(false, false, 10*time.Second, 1*time.Second, false, DefaultSocketOptions, 10, nil, nil)
+	r, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+
+	_, data, _, _, err := c.Do(r, nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, data, 10)
+}
+
+// newSNIServer starts a TLS server with a certificate which is only valid for
+// serverName, the returned pool trusts the certificate
+func newSNIServer(t *testing.T, serverName string) (*httptest.Server, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: serverName},
+		DNSNames:              []string{serverName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte(r.TLS.ServerName))
+	}))
+	ts.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	ts.StartTLS()
+
+	return ts, pool
+}
+
+// trust sets the root certificates of all the transports of the client
+func trust(c HTTP, pool *x509.CertPool) {
+	h := c.(*HTTPImpl)
+
+	h.defaultClient.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+	for _, sc := range h.serverNameClients {
+		sc.Transport.(*http.Transport).TLSClientConfig.RootCAs = pool
+	}
+}
+
+func TestHTTPSendsOverriddenServerName(t *testing.T) {
+	ts, pool := newSNIServer(t, "upstream.internal")
+	defer ts.Close()
+
+	host := strings.TrimPrefix(ts.URL, "https://")
+	c := NewHTTP(false, false, 10*time.Second, 1*time.Second, false, DefaultSocketOptions, 0, nil, map[string]string{host: "upstream.internal"})
+	trust(c, pool)
+
+	r, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	code, data, _, _, err := c.Do(r, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "upstream.internal", string(data))
+}
+
+func TestHTTPFailsHandshakeWithoutServerNameOverride(t *testing.T) {
+	ts, pool := newSNIServer(t, "upstream.internal")
+	defer ts.Close()
+
+	c := NewHTTP(false, false, 10*time.Second, 1*time.Second, false, DefaultSocketOptions, 0, nil, nil)
+	trust(c, pool)
+
+	r, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	code, _, _, _, err := c.Do(r, nil)
+
+	assert.Equal(t, -1, code)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "certificate")
+}
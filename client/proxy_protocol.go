@@ -0,0 +1,175 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature is the first 12 bytes of a PROXY protocol v2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyHeaderTimeout is the maximum time to wait for the PROXY header
+const proxyHeaderTimeout = 5 * time.Second
+
+// NewProxyProtocolListener wraps the listener so that the PROXY protocol v1
+// or v2 header sent by a load balancer is read from every accepted
+// connection, RemoteAddr of the connection returns the original client
+// address. Connections without a header are accepted unchanged
+func NewProxyProtocolListener(l net.Listener) net.Listener {
+	return &proxyListener{l}
+}
+
+type proxyListener struct {
+	net.Listener
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyConn{Conn: c, reader: bufio.NewReader(c)}, nil
+}
+
+// proxyConn reads the PROXY header on first use, the header is read lazily
+// so that a slow client does not block Accept
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	b, err := c.reader.Peek(1)
+	if err != nil {
+		// leave any error to be returned by the next read
+		return
+	}
+
+	switch b[0] {
+	case 'P':
+		c.remoteAddr, c.err = readProxyV1(c.reader)
+	case '\r':
+		c.remoteAddr, c.err = readProxyV2(c.reader)
+	}
+}
+
+// readProxyV1 reads a text header e.g. PROXY TCP4 192.168.0.1 10.0.0.1 56324 443
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	p, err := r.Peek(6)
+	if err != nil || string(p) != "PROXY " {
+		return nil, nil
+	}
+
+	// the longest v1 header is 107 bytes
+	line := []byte{}
+	for len(line) < 107 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read PROXY header: %s", err)
+		}
+
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("invalid PROXY header, header is not terminated")
+	}
+
+	parts := strings.Fields(string(line))
+	if len(parts) >= 2 && parts[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY header %q", strings.TrimSpace(string(line)))
+	}
+
+	ip := net.ParseIP(parts[2])
+	port, err := strconv.Atoi(parts[4])
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid PROXY header source address %s:%s", parts[2], parts[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+// readProxyV2 reads a binary header
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	p, err := r.Peek(16)
+	if err != nil || !bytes.Equal(p[:12], proxyV2Signature) {
+		return nil, nil
+	}
+
+	header := make([]byte, 16)
+	io.ReadFull(r, header)
+
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("invalid PROXY header version %d", header[12]>>4)
+	}
+
+	addr := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	_, err = io.ReadFull(r, addr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read PROXY header: %s", err)
+	}
+
+	// LOCAL connections are health checks from the proxy itself
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+
+	switch header[13] >> 4 {
+	case 1: // IPv4
+		if len(addr) < 12 {
+			return nil, fmt.Errorf("invalid PROXY header, address too short")
+		}
+
+		return &net.TCPAddr{IP: net.IP(addr[0:4]), Port: int(binary.BigEndian.Uint16(addr[8:10]))}, nil
+	case 2: // IPv6
+		if len(addr) < 36 {
+			return nil, fmt.Errorf("invalid PROXY header, address too short")
+		}
+
+		return &net.TCPAddr{IP: net.IP(addr[0:16]), Port: int(binary.BigEndian.Uint16(addr[32:34]))}, nil
+	}
+
+	// unix sockets and unspecified families keep the connection address
+	return nil, nil
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func acceptProxyConn(t *testing.T, header []byte) (net.Conn, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	pl := NewProxyProtocolListener(l)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+
+	c.Write(header)
+	c.Write([]byte("GET / HTTP/1.1\r\n"))
+
+	sc, err := pl.Accept()
+	assert.NoError(t, err)
+
+	return sc, func() {
+		c.Close()
+		sc.Close()
+		l.Close()
+	}
+}
+
+func TestProxyProtocolV1SetsRemoteAddr(t *testing.T) {
+	c, cleanup := acceptProxyConn(t, []byte("PROXY TCP4 192.168.10.1 10.0.0.1 56324 443\r\n"))
+	defer cleanup()
+
+	assert.Equal(t, "192.168.10.1:56324", c.RemoteAddr().String())
+
+	// the header is removed from the stream
+	line, _ := bufio.NewReader(c).ReadString('\n')
+	assert.Equal(t, "GET / HTTP/1.1\r\n", line)
+}
+
+func TestProxyProtocolV2SetsRemoteAddr(t *testing.T) {
+	h := append([]byte{}, proxyV2Signature...)
+	h = append(h, 0x21, 0x11, 0, 12)
+	h = append(h, 192, 168, 10, 2, 10, 0, 0, 1)
+	h = binary.BigEndian.AppendUint16(h, 40000)
+	h = binary.BigEndian.AppendUint16(h, 443)
+
+	c, cleanup := acceptProxyConn(t, h)
+	defer cleanup()
+
+	assert.Equal(t, "192.168.10.2:40000", c.RemoteAddr().String())
+
+	line, _ := bufio.NewReader(c).ReadString('\n')
+	assert.Equal(t, "GET / HTTP/1.1\r\n", line)
+}
+
+func TestProxyProtocolWithoutHeaderKeepsRemoteAddr(t *testing.T) {
+	c, cleanup := acceptProxyConn(t, nil)
+	defer cleanup()
+
+	assert.Contains(t, c.RemoteAddr().String(), "127.0.0.1:")
+
+	line, _ := bufio.NewReader(c).ReadString('\n')
+	assert.Equal(t, "GET / HTTP/1.1\r\n", line)
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"net"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// SocketOptions tunes TCP connections, buffer sizes of 0 leave the OS
+// default in place
+type SocketOptions struct {
+	// NoDelay disables Nagle's algorithm, this is the Go default
+	NoDelay       bool
+	SendBuffer    int
+	ReceiveBuffer int
+}
+
+// DefaultSocketOptions leaves connections with the Go defaults
+var DefaultSocketOptions = SocketOptions{NoDelay: true}
+
+// Apply sets the options on the connection, the options are applied once the
+// connection is established as Go enables TCP_NODELAY on every new connection
+// after any dialer or listener control functions have run
+func (s SocketOptions) Apply(c net.Conn) error {
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+
+	err := tc.SetNoDelay(s.NoDelay)
+	if err != nil {
+		return err
+	}
+
+	if s.SendBuffer > 0 {
+		err = tc.SetWriteBuffer(s.SendBuffer)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.ReceiveBuffer > 0 {
+		err = tc.SetReadBuffer(s.ReceiveBuffer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Listen creates a TCP listener which applies the options to every accepted
+// connection, a connection the options can not be applied to is logged and
+// closed without stopping the listener
+func (s SocketOptions) Listen(addr string, logger hclog.Logger) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &socketListener{l, s.Apply, logger}, nil
+}
+
+// socketListener applies the socket options to accepted connections
+type socketListener struct {
+	net.Listener
+	apply  func(net.Conn) error
+	logger hclog.Logger
+}
+
+// Accept waits for the next connection the options are applied to, an error
+// applying the options only affects that connection, e.g. when the peer
+// resets it before the options are set, so it is closed and the listener
+// keeps accepting
+func (l *socketListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		err = l.apply(c)
+		if err != nil {
+			l.logger.Error("Unable to apply socket options, closing connection", "remote_addr", c.RemoteAddr(), "error", err)
+			c.Close()
+			continue
+		}
+
+		return c, nil
+	}
+}
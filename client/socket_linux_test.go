@@ -0,0 +1,108 @@
+package client
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func getsockopt(t *testing.T, c net.Conn, level, opt int) int {
+	rc, err := c.(*net.TCPConn).SyscallConn()
+	assert.NoError(t, err)
+
+	var v int
+	rc.Control(func(fd uintptr) {
+		v, err = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+	assert.NoError(t, err)
+
+	return v
+}
+
+func TestSocketOptionsAreSetOnAcceptedConnections(t *testing.T) {
+	so := SocketOptions{NoDelay: false, SendBuffer: 65536, ReceiveBuffer: 65536}
+
+	l, err := so.Listen("127.0.0.1:0", hclog.Default())
+	assert.NoError(t, err)
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	dc, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer dc.Close()
+
+	c := <-accepted
+	defer c.Close()
+
+	assert.Equal(t, 0, getsockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+	// linux doubles the requested buffer size to allow for bookkeeping
+	assert.GreaterOrEqual(t, getsockopt(t, c, syscall.SOL_SOCKET, syscall.SO_SNDBUF), 65536)
+	assert.GreaterOrEqual(t, getsockopt(t, c, syscall.SOL_SOCKET, syscall.SO_RCVBUF), 65536)
+}
+
+func TestDefaultSocketOptionsEnableNoDelay(t *testing.T) {
+	l, err := DefaultSocketOptions.Listen("127.0.0.1:0", hclog.Default())
+	assert.NoError(t, err)
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	dc, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer dc.Close()
+
+	c := <-accepted
+	defer c.Close()
+
+	assert.Equal(t, 1, getsockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+}
+
+func TestSocketListenerKeepsAcceptingWhenOptionsFail(t *testing.T) {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	// fail to apply the options to the first connection only
+	calls := 0
+	l := &socketListener{nl, func(c net.Conn) error {
+		calls++
+		if calls == 1 {
+			return syscall.ECONNRESET
+		}
+
+		return nil
+	}, hclog.Default()}
+	defer l.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		c, _ := l.Accept()
+		accepted <- c
+	}()
+
+	dc1, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer dc1.Close()
+
+	dc2, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer dc2.Close()
+
+	c := <-accepted
+	assert.NotNil(t, c)
+	defer c.Close()
+
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, dc2.LocalAddr().String(), c.RemoteAddr().String())
+}
@@ -0,0 +1,72 @@
+package errors
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// CodeDistribution picks response codes at random in proportion to their
+// weights
+type CodeDistribution struct {
+	codes      []int
+	cumulative []float64
+	randomFunc func() float64
+}
+
+// ParseCodeDistribution parses a semicolon separated list of code=weight
+// e.g. 200=80;500=10;503=5;429=5, the weights do not need to sum to 100
+func ParseCodeDistribution(s string) (*CodeDistribution, error) {
+	d := &CodeDistribution{randomFunc: rand.Float64}
+	total := 0.0
+
+	for _, e := range strings.Split(s, ";") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+
+		parts := strings.SplitN(e, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid code weight %s, expected code=weight", e)
+		}
+
+		code, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid code weight %s, code must be a valid response code", e)
+		}
+
+		w, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid code weight %s, weight must be a positive number", e)
+		}
+
+		total += w
+		d.codes = append(d.codes, code)
+		d.cumulative = append(d.cumulative, total)
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("invalid code distribution %s, at least one code must have a weight", s)
+	}
+
+	// normalize the cumulative weights to the range [0, 1]
+	for i := range d.cumulative {
+		d.cumulative[i] /= total
+	}
+
+	return d, nil
+}
+
+// Pick returns a code drawn from the distribution
+func (d *CodeDistribution) Pick() int {
+	r := d.randomFunc()
+	for i, c := range d.cumulative {
+		if r < c {
+			return d.codes[i]
+		}
+	}
+
+	return d.codes[len(d.codes)-1]
+}
@@ -0,0 +1,32 @@
+package errors
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCodeDistributionReturnsErrorForInvalidEntries(t *testing.T) {
+	for _, s := range []string{"200", "abc=1", "200=-1", "999=1", "200=0", ""} {
+		_, err := ParseCodeDistribution(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestCodeDistributionPicksCodesByWeight(t *testing.T) {
+	d, err := ParseCodeDistribution("200=80; 500=10; 503=5; 429=5")
+	assert.NoError(t, err)
+
+	d.randomFunc = rand.New(rand.NewSource(1)).Float64
+
+	counts := map[int]int{}
+	for i := 0; i < 10000; i++ {
+		counts[d.Pick()]++
+	}
+
+	assert.InDelta(t, 8000, counts[200], 200)
+	assert.InDelta(t, 1000, counts[500], 150)
+	assert.InDelta(t, 500, counts[503], 100)
+	assert.InDelta(t, 500, counts[429], 100)
+}
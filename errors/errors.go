@@ -2,6 +2,8 @@ package errors
 
 import (
 	"fmt"
+	"math"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
@@ -11,11 +13,18 @@ import (
 type Response struct {
 	Code  int
 	Error error
+	// ContentLengthOffset is added to the declared Content-Length of the
+	// response when Error is ErrorContentLength
+	ContentLengthOffset int
+	// RetryAfter is the time the client should wait before retrying a rate
+	// limited request, 0 when no Retry-After should be sent
+	RetryAfter time.Duration
 }
 
 var ErrorRateLimit = fmt.Errorf("Service exceeded rate limit")
 var ErrorInjection = fmt.Errorf("Service error automatically injected")
 var ErrorDelay = fmt.Errorf("Service delay automatically injected")
+var ErrorContentLength = fmt.Errorf("Service Content-Length mismatch automatically injected")
 
 // Injector allows errors and ratelmiting to be injected to a service
 type Injector struct {
@@ -27,26 +36,98 @@ type Injector struct {
 	rateLimitRPS    float64
 	rateLimitBurst  int
 	rateLimitCode   int
+	errorEveryN     int
+	errorEveryNCode int
+	// contentLengthOffset is the difference between the declared and actual
+	// body length for the content_length error type
+	contentLengthOffset int
+	// retryAfterMin and retryAfterMax bound the Retry-After returned with a
+	// rate limited response, a retryAfterMax of 0 disables Retry-After
+	retryAfterMin time.Duration
+	retryAfterMax time.Duration
+	// codes when set draws the response code of every request from the
+	// distribution in place of the errorPercentage
+	codes *CodeDistribution
+	// failThenSucceed when greater than 0 fails that many consecutive
+	// requests before one succeeds, the pattern repeats
+	failThenSucceed int
 
 	limiter      *rate.Limiter
+	overflow     float64 // rejected requests not yet drained by the limit
+	overflowAt   time.Time
 	requestCount int
+	mutex        sync.Mutex
 }
 
-func NewInjector(l hclog.Logger, errorPercentage float64, errorCode int, errorType string, errorDelay time.Duration, rateLimitRPS float64, rateLimitCode int) *Injector {
+// NewInjector creates a new Injector, when errorEveryN is greater than 0
+// exactly every Nth request fails with errorEveryNCode in place of the
+// errorPercentage. Rate limited responses return a Retry-After between
+// retryAfterMin and retryAfterMax which grows with the excess load. When codes
+// is set the code of each request is drawn from the distribution
+func NewInjector(l hclog.Logger, errorPercentage float64, errorCode int, errorType string, errorDelay time.Duration, rateLimitRPS float64, rateLimitCode int, errorEveryN int, errorEveryNCode int, contentLengthOffset int, retryAfterMin, retryAfterMax time.Duration, codes *CodeDistribution) *Injector {
 	return &Injector{
-		logger:          l,
-		errorPercentage: errorPercentage,
-		errorCode:       errorCode,
-		errorType:       errorType,
-		errorDelay:      errorDelay,
-		rateLimitRPS:    rateLimitRPS,
-		rateLimitCode:   rateLimitCode,
+		logger:              l,
+		errorPercentage:     errorPercentage,
+		errorCode:           errorCode,
+		errorType:           errorType,
+		errorDelay:          errorDelay,
+		rateLimitRPS:        rateLimitRPS,
+		rateLimitCode:       rateLimitCode,
+		errorEveryN:         errorEveryN,
+		errorEveryNCode:     errorEveryNCode,
+		contentLengthOffset: contentLengthOffset,
+		retryAfterMin:       retryAfterMin,
+		retryAfterMax:       retryAfterMax,
+		codes:               codes,
 	}
 }
 
+// SetErrors changes the error percentage and code while the service is
+// running, a code of 0 leaves the current code unchanged
+func (e *Injector) SetErrors(errorPercentage float64, errorCode int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.errorPercentage = errorPercentage
+	if errorCode != 0 {
+		e.errorCode = errorCode
+	}
+}
+
+// SetFailThenSucceed fails n consecutive requests with the error code before
+// letting one succeed, the pattern repeats so that a client which retries at
+// least n times always succeeds. A value of 0 disables the pattern
+func (e *Injector) SetFailThenSucceed(n int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.failThenSucceed = n
+}
+
+// Errors returns the current error percentage and code
+func (e *Injector) Errors() (float64, int) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return e.errorPercentage, e.errorCode
+}
+
 // Do returns an error
 func (e *Injector) Do() *Response {
-	e.requestCount++ // increment the request count
+	e.mutex.Lock()
+	errorPercentage := e.errorPercentage
+	errorCode := e.errorCode
+	failThenSucceed := e.failThenSucceed
+
+	// the count is incremented under the lock so that concurrent requests
+	// each see a distinct count and every N is exact
+	e.requestCount++
+
+	// if the request count is greater than max int reset
+	if e.requestCount == int(^uint(0)>>1) {
+		e.requestCount = 1
+	}
+	requestCount := e.requestCount
 
 	// lazy instatiate rate limiter
 	if e.rateLimitRPS > 0 && e.limiter == nil {
@@ -57,32 +138,101 @@ func (e *Injector) Do() *Response {
 
 		e.limiter = rate.NewLimiter(rate.Limit(e.rateLimitRPS), e.rateLimitBurst)
 	}
+	limiter := e.limiter
+	e.mutex.Unlock()
 
-	if e.limiter != nil && !e.limiter.Allow() {
+	if limiter != nil && !limiter.Allow() {
 		e.logger.Info("Rate limiting service")
 
-		return &Response{Error: ErrorRateLimit, Code: e.rateLimitCode}
+		return &Response{Error: ErrorRateLimit, Code: e.rateLimitCode, RetryAfter: e.retryAfter()}
 	}
 
-	// if the request count is greater than max int reset
-	if e.requestCount == int(^uint(0)>>1) {
-		e.requestCount = 1
+	// draw the code from the distribution, codes below 400 are served as a
+	// normal response
+	if e.codes != nil {
+		if code := e.codes.Pick(); code >= 400 {
+			e.logger.Info("Injecting error from code distribution", "code", code)
+
+			return &Response{Error: ErrorInjection, Code: code}
+		}
+
+		return nil
+	}
+
+	// fail exactly every Nth request
+	if e.errorEveryN > 0 {
+		if requestCount%e.errorEveryN == 0 {
+			e.logger.Info("Injecting error", "request_count", requestCount, "every_n", e.errorEveryN)
+
+			return &Response{Error: ErrorInjection, Code: e.errorEveryNCode}
+		}
+
+		return nil
+	}
+
+	// fail a run of requests then let the next succeed
+	if failThenSucceed > 0 {
+		if requestCount%(failThenSucceed+1) != 0 {
+			e.logger.Info("Injecting error", "request_count", requestCount, "fail_then_succeed", failThenSucceed)
+
+			return &Response{Error: ErrorInjection, Code: errorCode}
+		}
+
+		return nil
 	}
 
 	// calculate if we need to throw an error or continue as normal
-	if e.requestCount%int(1/e.errorPercentage) == 0 {
-		e.logger.Info("Injecting error", "request_count", e.requestCount, "error_percentage", e.errorPercentage, "error_type", e.errorType)
+	if requestCount%int(1/errorPercentage) == 0 {
+		e.logger.Info("Injecting error", "request_count", requestCount, "error_percentage", errorPercentage, "error_type", e.errorType)
 
 		// is our error a delay or a timeout
 		if e.errorType == "http_error" {
-			return &Response{Error: ErrorInjection, Code: e.errorCode}
+			return &Response{Error: ErrorInjection, Code: errorCode}
+		}
+
+		// the response is sent as normal but with an incorrect Content-Length
+		if e.errorType == "content_length" {
+			return &Response{Error: ErrorContentLength, ContentLengthOffset: e.contentLengthOffset}
 		}
 
 		// delay
 		e.logger.Info("Delaying service execution", "duration", e.errorDelay)
 		time.Sleep(e.errorDelay)
-		return &Response{Error: ErrorDelay, Code: e.errorCode}
+		return &Response{Error: ErrorDelay, Code: errorCode}
 	}
 
 	return nil
 }
+
+// retryAfter records a rate limited request and returns the time for the
+// excess requests to drain at the rate limit, bounded by the configured
+// minimum and maximum. The more saturated the service the longer the client
+// is asked to wait
+func (e *Injector) retryAfter() time.Duration {
+	if e.retryAfterMax == 0 {
+		return 0
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// drain the overflow at the rate limit since the last rejected request
+	now := time.Now()
+	if !e.overflowAt.IsZero() {
+		e.overflow = math.Max(0, e.overflow-now.Sub(e.overflowAt).Seconds()*e.rateLimitRPS)
+	}
+
+	e.overflow++
+	e.overflowAt = now
+
+	d := time.Duration(e.overflow / e.rateLimitRPS * float64(time.Second))
+	if d < e.retryAfterMin {
+		d = e.retryAfterMin
+	}
+
+	if d > e.retryAfterMax {
+		d = e.retryAfterMax
+	}
+
+	return d
+}
@@ -2,6 +2,8 @@ package errors
 
 import (
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -67,3 +69,152 @@ func TestErrorsDelayForCorrectTime(t *testing.T) {
 	assert.Equal(t, err1.Error, ErrorDelay)
 	assert.True(t, dur > 100*time.Millisecond)
 }
+
+func TestErrorsEveryNthRequest(t *testing.T) {
+	e := setup(t)
+	e.errorEveryN = 3
+	e.errorEveryNCode = http.StatusServiceUnavailable
+
+	pattern := []bool{}
+	for i := 0; i < 9; i++ {
+		r := e.Do()
+		pattern = append(pattern, r != nil)
+
+		if r != nil {
+			assert.Equal(t, http.StatusServiceUnavailable, r.Code)
+			assert.Equal(t, ErrorInjection, r.Error)
+		}
+	}
+
+	assert.Equal(t, []bool{false, false, true, false, false, true, false, false, true}, pattern)
+}
+
+// doParallel calls the injector n times from concurrent goroutines and
+// returns the number of injected errors
+func doParallel(e *Injector, n int) int {
+	var errors int64
+	wg := sync.WaitGroup{}
+	wg.Add(n)
+
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+
+			if e.Do() != nil {
+				atomic.AddInt64(&errors, 1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return int(errors)
+}
+
+func TestErrorsEveryNthRequestIsExactForConcurrentRequests(t *testing.T) {
+	e := setup(t)
+	e.errorEveryN = 3
+	e.errorEveryNCode = http.StatusServiceUnavailable
+
+	assert.Equal(t, 100, doParallel(e, 300))
+}
+
+func TestErrorsFailThenSucceed(t *testing.T) {
+	e := setup(t)
+	e.errorCode = http.StatusServiceUnavailable
+	e.SetFailThenSucceed(2)
+
+	pattern := []bool{}
+	for i := 0; i < 6; i++ {
+		r := e.Do()
+		pattern = append(pattern, r != nil)
+
+		if r != nil {
+			assert.Equal(t, http.StatusServiceUnavailable, r.Code)
+			assert.Equal(t, ErrorInjection, r.Error)
+		}
+	}
+
+	assert.Equal(t, []bool{true, true, false, true, true, false}, pattern)
+}
+
+func TestErrorsFailThenSucceedIsExactForConcurrentRequests(t *testing.T) {
+	e := setup(t)
+	e.errorCode = http.StatusServiceUnavailable
+	e.SetFailThenSucceed(2)
+
+	// two of every three requests fail regardless of how they interleave
+	assert.Equal(t, 200, doParallel(e, 300))
+}
+
+func TestContentLengthErrorReturnsOffset(t *testing.T) {
+	e := setup(t)
+	e.errorPercentage = 1
+	e.errorType = "content_length"
+	e.contentLengthOffset = -5
+
+	err1 := e.Do()
+
+	assert.NotNil(t, err1)
+	assert.Equal(t, ErrorContentLength, err1.Error)
+	assert.Equal(t, -5, err1.ContentLengthOffset)
+}
+
+func TestRetryAfterGrowsWithSaturation(t *testing.T) {
+	e := setup(t)
+	e.rateLimitRPS = 1
+	e.rateLimitCode = http.StatusTooManyRequests
+	e.retryAfterMin = time.Second
+	e.retryAfterMax = 5 * time.Second
+
+	// the first request is allowed by the burst
+	assert.Nil(t, e.Do())
+
+	last := time.Duration(0)
+	for i := 0; i < 4; i++ {
+		r := e.Do()
+		assert.Equal(t, ErrorRateLimit, r.Error)
+		assert.Greater(t, int64(r.RetryAfter), int64(last))
+
+		last = r.RetryAfter
+	}
+
+	// the Retry-After is bounded by the maximum
+	for i := 0; i < 10; i++ {
+		e.Do()
+	}
+
+	assert.Equal(t, 5*time.Second, e.Do().RetryAfter)
+}
+
+func TestRetryAfterDisabledWithoutMaximum(t *testing.T) {
+	e := setup(t)
+	e.rateLimitRPS = 1
+
+	e.Do()
+	r := e.Do()
+
+	assert.Equal(t, ErrorRateLimit, r.Error)
+	assert.Equal(t, time.Duration(0), r.RetryAfter)
+}
+
+func TestErrorsDrawnFromCodeDistribution(t *testing.T) {
+	d, _ := ParseCodeDistribution("200=50;503=50")
+
+	e := setup(t)
+	e.codes = d
+
+	counts := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		if r := e.Do(); r != nil {
+			assert.Equal(t, ErrorInjection, r.Error)
+			counts[r.Code]++
+			continue
+		}
+
+		counts[http.StatusOK]++
+	}
+
+	assert.InDelta(t, 500, counts[http.StatusOK], 75)
+	assert.InDelta(t, 500, counts[http.StatusServiceUnavailable], 75)
+}
@@ -0,0 +1,43 @@
+package errors
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+var ErrorRequestSize = fmt.Errorf("Service error injected for request body larger than the size limit")
+
+// SizeInjector injects errors into requests whose body is larger than a
+// limit, modelling a service which fails on large inputs
+type SizeInjector struct {
+	limit      int64
+	rate       float64
+	code       int
+	randomFunc func() float64
+}
+
+// NewSizeInjector creates a SizeInjector which fails rate of the requests
+// with a body larger than limit bytes with code, a rate of 1 fails every
+// large request
+func NewSizeInjector(limit int64, rate float64, code int) *SizeInjector {
+	return &SizeInjector{limit: limit, rate: rate, code: code, randomFunc: rand.Float64}
+}
+
+// Limit returns the size in bytes above which requests may fail
+func (s *SizeInjector) Limit() int64 {
+	return s.limit
+}
+
+// Do returns an error for a request with a body of size bytes, nil is
+// returned when the request does not fail
+func (s *SizeInjector) Do(size int64) *Response {
+	if size <= s.limit {
+		return nil
+	}
+
+	if s.randomFunc() >= s.rate {
+		return nil
+	}
+
+	return &Response{Error: ErrorRequestSize, Code: s.code}
+}
@@ -0,0 +1,33 @@
+package errors
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeInjectorPassesSmallRequests(t *testing.T) {
+	s := NewSizeInjector(1024, 1, 413)
+
+	assert.Nil(t, s.Do(0))
+	assert.Nil(t, s.Do(1024))
+}
+
+func TestSizeInjectorFailsLargeRequests(t *testing.T) {
+	s := NewSizeInjector(1024, 1, 413)
+
+	r := s.Do(1025)
+	assert.NotNil(t, r)
+	assert.Equal(t, ErrorRequestSize, r.Error)
+	assert.Equal(t, 413, r.Code)
+}
+
+func TestSizeInjectorFailsRateOfLargeRequests(t *testing.T) {
+	s := NewSizeInjector(1024, 0.5, 500)
+
+	s.randomFunc = func() float64 { return 0.4 }
+	assert.NotNil(t, s.Do(2048))
+
+	s.randomFunc = func() float64 { return 0.6 }
+	assert.Nil(t, s.Do(2048))
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// BodyContext is the request data available to response body templates
+type BodyContext struct {
+	Path      string
+	Headers   map[string]string
+	RequestID string
+}
+
+// Body renders the response body from a file or a Go template, when a file
+// is used it is re-read whenever its modification time changes
+type Body struct {
+	message    string
+	path       string
+	isTemplate bool
+
+	mutex   sync.Mutex
+	modTime time.Time
+	content string
+	tmpl    *template.Template
+}
+
+// NewBody creates a new Body, when path is set the body is read from the file
+// otherwise message is used. When isTemplate is true the body is rendered as a
+// Go template with the BodyContext of the request
+func NewBody(message, path string, isTemplate bool) *Body {
+	return &Body{
+		message:    message,
+		path:       path,
+		isTemplate: isTemplate,
+	}
+}
+
+// Render returns the body for the request
+func (b *Body) Render(c BodyContext) (string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	err := b.load()
+	if err != nil {
+		return "", err
+	}
+
+	if !b.isTemplate {
+		return b.content, nil
+	}
+
+	buf := new(bytes.Buffer)
+	err = b.tmpl.Execute(buf, c)
+	if err != nil {
+		return "", fmt.Errorf("unable to render body template: %s", err)
+	}
+
+	return buf.String(), nil
+}
+
+// load reads the body source if it has not been read or the file has changed
+func (b *Body) load() error {
+	content := b.message
+
+	if b.path != "" {
+		fi, err := os.Stat(b.path)
+		if err != nil {
+			return fmt.Errorf("unable to read body file: %s", err)
+		}
+
+		// the file has not changed since it was last read
+		if b.content != "" && fi.ModTime().Equal(b.modTime) {
+			return nil
+		}
+
+		d, err := ioutil.ReadFile(b.path)
+		if err != nil {
+			return fmt.Errorf("unable to read body file: %s", err)
+		}
+
+		content = strings.TrimSpace(string(d))
+		b.modTime = fi.ModTime()
+	} else if b.content != "" {
+		return nil
+	}
+
+	if b.isTemplate {
+		tmpl, err := template.New("body").Funcs(bodyFuncs).Option("missingkey=zero").Parse(content)
+		if err != nil {
+			return fmt.Errorf("unable to parse body template: %s", err)
+		}
+
+		b.tmpl = tmpl
+	}
+
+	b.content = content
+
+	return nil
+}
+
+// bodyJSON returns the rendered body as JSON, a body which is a valid JSON
+// object or array is used as is, any other text including JSON scalars such
+// as 42 or true is encoded as a string
+func bodyJSON(message string) json.RawMessage {
+	t := strings.TrimSpace(message)
+	if (strings.HasPrefix(t, "{") || strings.HasPrefix(t, "[")) && json.Valid([]byte(t)) {
+		return json.RawMessage(message)
+	}
+
+	// marshalling a string can not fail
+	d, _ := json.Marshal(message)
+	return json.RawMessage(d)
+}
+
+// bodyFuncs are the functions available to body templates, they generate
+// fresh values for every response e.g. {{uuid}}, {{now}}, {{randInt 1 100}}
+var bodyFuncs = template.FuncMap{
+	"uuid":    newUUID,
+	"now":     func() string { return time.Now().Format(time.RFC3339Nano) },
+	"randInt": randInt,
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	_, err := crand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	// set the version and variant bits
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// randInt returns a random integer in the interval [min,max]
+func randInt(min, max int) (int, error) {
+	if max < min {
+		return 0, fmt.Errorf("randInt max %d is less than min %d", max, min)
+	}
+
+	return min + rand.Intn(max-min+1), nil
+}
+
+// httpBodyContext returns the template context for an HTTP request
+func httpBodyContext(r *http.Request) BodyContext {
+	h := map[string]string{}
+	for k, v := range r.Header {
+		h[k] = strings.Join(v, ",")
+	}
+
+	return BodyContext{
+		Path:      r.URL.Path,
+		Headers:   h,
+		RequestID: r.Header.Get("X-Request-Id"),
+	}
+}
+
+// grpcBodyContext returns the template context for a gRPC request, the path
+// is the full gRPC method name and the headers are the request metadata
+func grpcBodyContext(ctx context.Context) BodyContext {
+	path, _ := grpc.Method(ctx)
+	md := echoMetadata(ctx, nil)
+
+	return BodyContext{
+		Path:      path,
+		Headers:   md,
+		RequestID: md["x-request-id"],
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeBodyFile(t *testing.T, path, content string, mod time.Time) {
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	assert.NoError(t, err)
+
+	// set the modification time explicitly as writes in quick succession can
+	// have the same timestamp
+	err = os.Chtimes(path, mod, mod)
+	assert.NoError(t, err)
+}
+
+func TestBodyReadsFileAndReloadsOnChange(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "body.json")
+	writeBodyFile(t, p, `{"version": 1}`, time.Now().Add(-1*time.Minute))
+
+	b := NewBody("", p, false)
+
+	d, err := b.Render(BodyContext{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"version": 1}`, d)
+
+	writeBodyFile(t, p, `{"version": 2}`, time.Now())
+
+	d, err = b.Render(BodyContext{})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"version": 2}`, d)
+}
+
+func TestBodyReturnsErrorForMissingFile(t *testing.T) {
+	b := NewBody("", filepath.Join(t.TempDir(), "missing.json"), false)
+
+	_, err := b.Render(BodyContext{})
+
+	assert.Error(t, err)
+}
+
+func TestBodyRendersTemplateWithRequestContext(t *testing.T) {
+	b := NewBody(`{"path": "{{.Path}}", "id": "{{.RequestID}}", "user": "{{index .Headers "X-User"}}"}`, "", true)
+
+	d, err := b.Render(BodyContext{Path: "/orders", RequestID: "abc", Headers: map[string]string{"X-User": "nic"}})
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"path": "/orders", "id": "abc", "user": "nic"}`, d)
+}
+
+func TestRequestReturnsErrorResponseForInvalidBodyTemplate(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.body = NewBody(`{{.Path`, "", true)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Contains(t, mr.Error, "unable to parse body template")
+	assert.Nil(t, mr.Body)
+}
+
+func TestRequestRendersBodyTemplate(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.body = NewBody(`{"path": "{{.Path}}"}`, "", true)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"path": "/orders"}`, string(mr.Body))
+}
+
+func TestBodyTemplateFunctionsGenerateFreshValues(t *testing.T) {
+	b := NewBody(`{"id": "{{uuid}}", "at": "{{now}}", "n": {{randInt 1 100}}}`, "", true)
+
+	type generated struct {
+		ID string `json:"id"`
+		At string `json:"at"`
+		N  int    `json:"n"`
+	}
+
+	seen := []generated{}
+	for i := 0; i < 2; i++ {
+		d, err := b.Render(BodyContext{})
+		assert.NoError(t, err)
+
+		g := generated{}
+		err = json.Unmarshal([]byte(d), &g)
+		assert.NoError(t, err)
+
+		assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, g.ID)
+
+		_, err = time.Parse(time.RFC3339Nano, g.At)
+		assert.NoError(t, err)
+
+		assert.GreaterOrEqual(t, g.N, 1)
+		assert.LessOrEqual(t, g.N, 100)
+
+		seen = append(seen, g)
+	}
+
+	assert.NotEqual(t, seen[0].ID, seen[1].ID)
+	assert.NotEqual(t, seen[0].At, seen[1].At)
+}
+
+func TestBodyTemplateRandIntReturnsErrorForInvalidRange(t *testing.T) {
+	b := NewBody(`{{randInt 10 1}}`, "", true)
+
+	_, err := b.Render(BodyContext{})
+
+	assert.Error(t, err)
+}
+
+func TestRequestReturnsJSONArrayBodyFromFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "body.json")
+	writeBodyFile(t, p, `[{"id": 1}, {"id": 2}]`, time.Now())
+
+	h, _, _ := setupRequest(t, nil, 0)
+	h.body = NewBody("", p, false)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	err := mr.FromJSON(rr.Body.Bytes())
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[{"id": 1}, {"id": 2}]`, string(mr.Body))
+}
+
+func TestRequestEncodesJSONScalarMessageAsString(t *testing.T) {
+	for _, m := range []string{"42", "true", "null", `"quoted"`} {
+		h, _, _ := setupRequest(t, nil, 0)
+		h.message = m
+
+		rr := httptest.NewRecorder()
+		h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		mr := response.Response{}
+		err := mr.FromJSON(rr.Body.Bytes())
+		assert.NoError(t, err)
+
+		var body string
+		err = json.Unmarshal(mr.Body, &body)
+		assert.NoError(t, err)
+		assert.Equal(t, m, body)
+	}
+}
+
+func TestRequestEncodesTextBodyWithQuotesAsString(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.body = NewBody("say \"hi\" \\ bye\nnow", "", false)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	err := mr.FromJSON(rr.Body.Bytes())
+	assert.NoError(t, err)
+
+	var body string
+	err = json.Unmarshal(mr.Body, &body)
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "say \"hi\" \\ bye\nnow", body)
+}
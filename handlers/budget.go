@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// DeadlineHeader carries the time remaining before the deadline of a request
+// to upstream HTTP services, the value is a duration e.g. 250ms
+const DeadlineHeader = "X-Request-Deadline"
+
+// ErrDeadlineExceeded is returned for upstreams which are not called because
+// the deadline of the request has passed
+var ErrDeadlineExceeded = fmt.Errorf("upstream call skipped, request deadline exceeded")
+
+// withBudget returns a context which expires at the earliest of the parent
+// deadline, the deadline propagated in header and now plus budget, a budget
+// of 0 does not limit the request
+func withBudget(parent context.Context, header string, budget time.Duration) (context.Context, context.CancelFunc) {
+	deadline, ok := parent.Deadline()
+
+	if d, err := time.ParseDuration(header); err == nil {
+		if hd := time.Now().Add(d); !ok || hd.Before(deadline) {
+			deadline, ok = hd, true
+		}
+	}
+
+	if budget > 0 {
+		if bd := time.Now().Add(budget); !ok || bd.Before(deadline) {
+			deadline, ok = bd, true
+		}
+	}
+
+	if !ok {
+		return context.WithCancel(parent)
+	}
+
+	return context.WithDeadline(parent, deadline)
+}
+
+// callBeforeDeadline calls the upstream unless ctx has expired, an upstream
+// which is not called is marked as deadline exceeded. When report is true the
+// time remaining before the deadline when the upstream was called is added to
+// its response
+func callBeforeDeadline(ctx context.Context, uri string, report bool, call func() (*response.Response, error)) (*response.Response, error) {
+	if ctx.Err() != nil {
+		return &response.Response{URI: uri, Error: ErrDeadlineExceeded.Error(), DeadlineExceeded: true}, ErrDeadlineExceeded
+	}
+
+	d, ok := ctx.Deadline()
+	remaining := time.Until(d)
+
+	resp, err := call()
+	if report && ok && resp != nil {
+		resp.DeadlineRemaining = remaining.String()
+	}
+
+	return resp, err
+}
+
+// setDeadline bounds the upstream request by the deadline of ctx and
+// propagates the time remaining to the upstream in the DeadlineHeader
+func setDeadline(r *http.Request, ctx context.Context) *http.Request {
+	if ctx == nil {
+		return r
+	}
+
+	if d, ok := ctx.Deadline(); ok {
+		r.Header.Set(DeadlineHeader, time.Until(d).String())
+	}
+
+	return r.WithContext(ctx)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// Circuits holds a circuit breaker for each upstream
+type Circuits struct {
+	breakers map[string]*client.CircuitBreaker
+}
+
+// NewCircuits creates a circuit breaker for each of the upstream uris, the
+// breakers open after threshold consecutive failures for openDuration
+func NewCircuits(uris []string, threshold int, openDuration time.Duration) *Circuits {
+	c := &Circuits{breakers: map[string]*client.CircuitBreaker{}}
+	for _, u := range uris {
+		c.breakers[u] = client.NewCircuitBreaker(threshold, openDuration)
+	}
+
+	return c
+}
+
+// Do calls the upstream through its circuit breaker, when the circuit is
+// open the call is not made and an error response is returned. When c is nil
+// the upstream is called directly
+func (c *Circuits) Do(uri string, f func() (*response.Response, error)) (*response.Response, error) {
+	if c == nil || c.breakers[uri] == nil {
+		return f()
+	}
+
+	cb := c.breakers[uri]
+	if !cb.Allow() {
+		return &response.Response{
+			URI:   uri,
+			Code:  http.StatusServiceUnavailable,
+			Error: client.ErrCircuitOpen.Error(),
+		}, client.ErrCircuitOpen
+	}
+
+	r, err := f()
+	if err != nil {
+		cb.Failure()
+	} else {
+		cb.Success()
+	}
+
+	return r, err
+}
+
+// State returns the state of the circuit breaker for the upstream, an empty
+// string is returned when the upstream has no circuit breaker
+func (c *Circuits) State(uri string) string {
+	if c == nil || c.breakers[uri] == nil {
+		return ""
+	}
+
+	return c.breakers[uri].Stats().State
+}
+
+// Stats returns the state of the circuit breaker for each upstream
+func (c *Circuits) Stats() map[string]client.CircuitStats {
+	s := map[string]client.CircuitStats{}
+	for u, cb := range c.breakers {
+		s[u] = cb.Stats()
+	}
+
+	return s
+}
+
+// Handle the stats request
+func (c *Circuits) Handle(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(c.Stats())
+}
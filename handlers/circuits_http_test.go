@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCircuitsReportsOpenStateAfterUpstreamFailures(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	h.circuits = NewCircuits([]string{"http://test.com"}, 2, 10*time.Second)
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusInternalServerError, nil, fmt.Errorf("Boom"))
+
+	for i := 0; i < 3; i++ {
+		h.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	// the third call is rejected by the open circuit
+	c.AssertNumberOfCalls(t, "Do", 2)
+
+	rr := httptest.NewRecorder()
+	h.circuits.Handle(rr, httptest.NewRequest(http.MethodGet, "/stats/circuits", nil))
+
+	s := map[string]client.CircuitStats{}
+	err := json.Unmarshal(rr.Body.Bytes(), &s)
+	assert.NoError(t, err)
+
+	assert.Equal(t, client.CircuitOpen, s["http://test.com"].State)
+	assert.Equal(t, 2, s["http://test.com"].Failures)
+	assert.NotNil(t, s["http://test.com"].LastTrip)
+	assert.NotNil(t, s["http://test.com"].NextProbe)
+}
+
+func TestCircuitsReturnsErrorResponseWhenOpen(t *testing.T) {
+	cs := NewCircuits([]string{"http://test.com"}, 1, 10*time.Second)
+	cs.Do("http://test.com", func() (*response.Response, error) { return &response.Response{}, fmt.Errorf("Boom") })
+
+	r, err := cs.Do("http://test.com", func() (*response.Response, error) { return &response.Response{}, nil })
+
+	assert.Equal(t, client.ErrCircuitOpen, err)
+	assert.Equal(t, http.StatusServiceUnavailable, r.Code)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// Connections tracks the number of open inbound connections to the HTTP server
+type Connections struct {
+	logger *logging.Logger
+	open   int64
+}
+
+// ConnectionStats is the response returned from the connections handler
+type ConnectionStats struct {
+	Open int64 `json:"open"`
+}
+
+// NewConnections creates a new connections handler
+func NewConnections(logger *logging.Logger) *Connections {
+	return &Connections{logger: logger}
+}
+
+// ConnState implements the http.Server ConnState callback
+func (c *Connections) ConnState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		c.logger.ConnectionsChanged(atomic.AddInt64(&c.open, 1))
+	case http.StateHijacked, http.StateClosed:
+		c.logger.ConnectionsChanged(atomic.AddInt64(&c.open, -1))
+	}
+}
+
+// Open returns the number of currently open connections
+func (c *Connections) Open() int64 {
+	return atomic.LoadInt64(&c.open)
+}
+
+// Handle the request
+func (c *Connections) Handle(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(ConnectionStats{Open: c.Open()})
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupConnections(t *testing.T) (*Connections, *httptest.Server) {
+	c := NewConnections(logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil))
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(c.Handle))
+	ts.Config.ConnState = c.ConnState
+	ts.Start()
+
+	return c, ts
+}
+
+func TestConnectionsTracksOpenAndClosedConnections(t *testing.T) {
+	c, ts := setupConnections(t)
+	defer ts.Close()
+
+	conns := []net.Conn{}
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		assert.NoError(t, err)
+
+		conns = append(conns, conn)
+	}
+
+	assert.Eventually(t, func() bool { return c.Open() == 3 }, 1*time.Second, 5*time.Millisecond)
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	assert.Eventually(t, func() bool { return c.Open() == 0 }, 1*time.Second, 5*time.Millisecond)
+}
+
+func TestConnectionsReturnsOpenConnections(t *testing.T) {
+	c, ts := setupConnections(t)
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.Eventually(t, func() bool { return c.Open() == 1 }, 1*time.Second, 5*time.Millisecond)
+
+	rr := httptest.NewRecorder()
+	c.Handle(rr, httptest.NewRequest(http.MethodGet, "/stats/connections", nil))
+
+	cs := ConnectionStats{}
+	json.Unmarshal(rr.Body.Bytes(), &cs)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, int64(1), cs.Open)
+}
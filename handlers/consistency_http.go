@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// storedValue is a value written to the EventualStore
+type storedValue struct {
+	data    json.RawMessage
+	written time.Time
+}
+
+// EventualStore models an eventually consistent data store, the body of a
+// POST request is stored in memory for the request path but only becomes
+// visible to a GET request for the path after the propagation delay. Until
+// then a GET returns the previous value or 404 when there is none. Requests
+// for paths which have not been written are passed to next
+type EventualStore struct {
+	logger *logging.Logger
+	name   string
+	delay  time.Duration
+	size   int
+	now    func() time.Time
+	mutex  sync.Mutex
+	values map[string][]storedValue
+	order  []string
+	next   http.HandlerFunc
+}
+
+// NewEventualStore creates a new EventualStore which holds at most size
+// paths, when full the path written least recently is removed
+func NewEventualStore(logger *logging.Logger, name string, delay time.Duration, size int, next http.HandlerFunc) *EventualStore {
+	return &EventualStore{
+		logger: logger,
+		name:   name,
+		delay:  delay,
+		size:   size,
+		now:    time.Now,
+		values: map[string][]storedValue{},
+		next:   next,
+	}
+}
+
+// Handle the request
+func (s *EventualStore) Handle(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.write(rw, r)
+	case http.MethodGet, http.MethodHead:
+		s.read(rw, r)
+	default:
+		s.next(rw, r)
+	}
+}
+
+func (s *EventualStore) write(rw http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.respond(rw, r, http.StatusBadRequest, nil, "unable to read body: "+err.Error())
+		return
+	}
+
+	// store bodies which are not JSON as a JSON string
+	if !json.Valid(data) {
+		data, _ = json.Marshal(string(data))
+	}
+
+	s.mutex.Lock()
+	s.remove(r.URL.Path)
+	if len(s.order) >= s.size && len(s.order) > 0 {
+		delete(s.values, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	now := s.now()
+	values, _ := s.visible(s.values[r.URL.Path], now)
+	s.values[r.URL.Path] = append(values, storedValue{data: data, written: now})
+	s.order = append(s.order, r.URL.Path)
+	s.mutex.Unlock()
+
+	s.logger.Log().Info("Stored value", "path", r.URL.Path, "visible_after", s.delay)
+
+	s.respond(rw, r, http.StatusAccepted, data, "")
+}
+
+func (s *EventualStore) read(rw http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	values, ok := s.values[r.URL.Path]
+	if !ok {
+		s.mutex.Unlock()
+		s.next(rw, r)
+		return
+	}
+
+	values, ok = s.visible(values, s.now())
+	s.values[r.URL.Path] = values
+
+	var data json.RawMessage
+	if ok {
+		data = values[0].data
+	}
+	s.mutex.Unlock()
+
+	if data == nil {
+		s.respond(rw, r, http.StatusNotFound, nil, "value has not propagated")
+		return
+	}
+
+	s.respond(rw, r, http.StatusOK, data, "")
+}
+
+// visible drops the values older than the newest value which has
+// propagated, they are no longer needed once a newer value is visible. The
+// newest visible value is first, ok is false when no value has propagated
+func (s *EventualStore) visible(values []storedValue, now time.Time) ([]storedValue, bool) {
+	index := -1
+	for i, v := range values {
+		if !now.Before(v.written.Add(s.delay)) {
+			index = i
+		}
+	}
+
+	if index < 0 {
+		return values, false
+	}
+
+	return values[index:], true
+}
+
+// remove deletes the path from the eviction order, the caller must hold the
+// mutex
+func (s *EventualStore) remove(path string) {
+	for i, p := range s.order {
+		if p == path {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *EventualStore) respond(rw http.ResponseWriter, r *http.Request, code int, data json.RawMessage, message string) {
+	resp := &response.Response{
+		Name:  s.name,
+		Type:  "HTTP",
+		URI:   r.URL.String(),
+		Code:  code,
+		Body:  data,
+		Error: message,
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	writeResponse(rw, r, code, []byte(resp.ToJSON()))
+}
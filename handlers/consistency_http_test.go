@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupEventualStore(t *testing.T, size int) (*EventualStore, *time.Time) {
+	s := NewEventualStore(
+		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
+		"test",
+		time.Second,
+		size,
+		func(rw http.ResponseWriter, r *http.Request) { fmt.Fprint(rw, "OK") },
+	)
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	return s, &now
+}
+
+func doEventualStore(s *EventualStore, method, body string) (int, *response.Response) {
+	rr := httptest.NewRecorder()
+	s.Handle(rr, httptest.NewRequest(method, "/items/1", strings.NewReader(body)))
+
+	resp := &response.Response{}
+	resp.FromJSON(rr.Body.Bytes())
+
+	return rr.Code, resp
+}
+
+func TestEventualStoreReadIsStaleUntilDelay(t *testing.T) {
+	s, now := setupEventualStore(t, 10)
+
+	code, _ := doEventualStore(s, http.MethodPost, `{"v":1}`)
+	assert.Equal(t, http.StatusAccepted, code)
+
+	// the first write has not propagated
+	code, resp := doEventualStore(s, http.MethodGet, "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "value has not propagated", resp.Error)
+
+	*now = now.Add(time.Second)
+	code, resp = doEventualStore(s, http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"v":1}`, string(resp.Body))
+
+	// a new write returns the old value until it propagates
+	doEventualStore(s, http.MethodPost, `{"v":2}`)
+
+	_, resp = doEventualStore(s, http.MethodGet, "")
+	assert.JSONEq(t, `{"v":1}`, string(resp.Body))
+
+	*now = now.Add(time.Second)
+	_, resp = doEventualStore(s, http.MethodGet, "")
+	assert.JSONEq(t, `{"v":2}`, string(resp.Body))
+}
+
+func TestEventualStoreDropsPropagatedValuesOnWrite(t *testing.T) {
+	s, now := setupEventualStore(t, 10)
+
+	for i := 0; i < 100; i++ {
+		doEventualStore(s, http.MethodPost, fmt.Sprintf(`{"v":%d}`, i))
+		*now = now.Add(time.Second)
+	}
+
+	// only the newest visible value and the pending write are kept
+	doEventualStore(s, http.MethodPost, `{"v":100}`)
+	assert.Len(t, s.values["/items/1"], 2)
+
+	_, resp := doEventualStore(s, http.MethodGet, "")
+	assert.JSONEq(t, `{"v":99}`, string(resp.Body))
+}
+
+func TestEventualStorePassesUnknownPathsToNext(t *testing.T) {
+	s, _ := setupEventualStore(t, 10)
+
+	rr := httptest.NewRecorder()
+	s.Handle(rr, httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.Equal(t, "OK", rr.Body.String())
+}
+
+func TestEventualStoreEvictsOldestPath(t *testing.T) {
+	s, _ := setupEventualStore(t, 1)
+
+	s.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/a", strings.NewReader("a")))
+	s.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/b", strings.NewReader("b")))
+
+	rr := httptest.NewRecorder()
+	s.Handle(rr, httptest.NewRequest(http.MethodGet, "/a", nil))
+	assert.Equal(t, "OK", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	s.Handle(rr, httptest.NewRequest(http.MethodGet, "/b", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
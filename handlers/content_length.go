@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// writeContentLengthMismatch writes data with a Content-Length header which
+// differs from the length of data by offset.
+// When the declared length is longer than the body the connection is closed
+// once the body has been written and the client sees an unexpected EOF.
+// When it is shorter the net/http server would truncate the body, so the
+// connection is hijacked and the full body written after the headers. When
+// the connection can not be hijacked the response is written normally and an
+// error is returned.
+func writeContentLengthMismatch(rw http.ResponseWriter, code int, data []byte, offset int) error {
+	cl := len(data) + offset
+	if cl < 0 {
+		cl = 0
+	}
+
+	// a body at least as long as the declared length needs no hijacking
+	if offset >= 0 {
+		rw.Header().Set("Content-Length", strconv.Itoa(cl))
+		rw.WriteHeader(code)
+		_, err := rw.Write(data)
+		return err
+	}
+
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		rw.WriteHeader(code)
+		rw.Write(data)
+
+		return fmt.Errorf("unable to write a short Content-Length, the connection does not support hijacking")
+	}
+
+	conn, bufrw, err := hj.Hijack()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rw.Header().Set("Content-Length", strconv.Itoa(cl))
+	rw.Header().Set("Connection", "close")
+
+	fmt.Fprintf(bufrw, "HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	rw.Header().Write(bufrw)
+	bufrw.WriteString("\r\n")
+	bufrw.Write(data)
+
+	return bufrw.Flush()
+}
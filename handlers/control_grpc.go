@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/errors"
+	"github.com/nicholasjackson/fake-service/grpc/api"
+	"github.com/nicholasjackson/fake-service/load"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/timing"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ControlServer implements the gRPC ControlService which allows a test
+// harness to change the error rate, latency, and load of the running
+// service. When a token is set every call must send it in the authorization
+// metadata as a bearer token
+type ControlServer struct {
+	logger    *logging.Logger
+	token     string
+	injector  *errors.Injector
+	duration  *timing.RequestDuration
+	generator *load.Generator
+}
+
+// NewControlServer creates a new ControlServer
+func NewControlServer(logger *logging.Logger, token string, injector *errors.Injector, duration *timing.RequestDuration, generator *load.Generator) *ControlServer {
+	return &ControlServer{
+		logger:    logger,
+		token:     token,
+		injector:  injector,
+		duration:  duration,
+		generator: generator,
+	}
+}
+
+// SetErrorRate changes the fraction of requests which fail and their code, a
+// code of 0 leaves the code unchanged
+func (c *ControlServer) SetErrorRate(ctx context.Context, in *api.ErrorRate) (*api.Config, error) {
+	if err := c.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	if in.Rate < 0 || in.Rate > 1 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid error rate %v, must be between 0 and 1", in.Rate)
+	}
+
+	c.logger.Log().Info("Control changed error rate", "rate", in.Rate, "code", in.Code)
+	c.injector.SetErrors(in.Rate, int(in.Code))
+
+	return c.config(), nil
+}
+
+// SetLatency changes the duration of requests at each percentile
+func (c *ControlServer) SetLatency(ctx context.Context, in *api.Latency) (*api.Config, error) {
+	if err := c.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	p := []time.Duration{}
+	for _, s := range []string{in.P50, in.P90, in.P99} {
+		var d time.Duration
+		if s != "" {
+			var err error
+			d, err = time.ParseDuration(s)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid latency %s: %s", s, err)
+			}
+		}
+
+		p = append(p, d)
+	}
+
+	c.logger.Log().Info("Control changed latency", "p50", p[0], "p90", p[1], "p99", p[2])
+	c.duration.Set(p[0], p[1], p[2])
+
+	return c.config(), nil
+}
+
+// SetLoad changes the CPU and memory load generated by each request
+func (c *ControlServer) SetLoad(ctx context.Context, in *api.Load) (*api.Config, error) {
+	if err := c.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	if in.CPUCores < 0 || in.CPUPercentage < 0 || in.MemoryBytes < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid load, values must not be negative")
+	}
+
+	c.logger.Log().Info("Control changed load", "cpu_cores", in.CPUCores, "cpu_percentage", in.CPUPercentage, "memory_bytes", in.MemoryBytes)
+	c.generator.SetLoad(in.CPUCores, in.CPUPercentage, int(in.MemoryBytes))
+
+	return c.config(), nil
+}
+
+// GetConfig returns the current configuration
+func (c *ControlServer) GetConfig(ctx context.Context, in *api.ConfigRequest) (*api.Config, error) {
+	if err := c.authorize(ctx); err != nil {
+		return nil, err
+	}
+
+	return c.config(), nil
+}
+
+func (c *ControlServer) config() *api.Config {
+	rate, code := c.injector.Errors()
+	p50, p90, p99 := c.duration.Percentiles()
+	cores, percentage, memory := c.generator.Load()
+
+	return &api.Config{
+		ErrorRate: &api.ErrorRate{Rate: rate, Code: int32(code)},
+		Latency:   &api.Latency{P50: p50.String(), P90: p90.String(), P99: p99.String()},
+		Load:      &api.Load{CPUCores: cores, CPUPercentage: percentage, MemoryBytes: int64(memory)},
+	}
+}
+
+// authorize checks the bearer token in the metadata of the call
+func (c *ControlServer) authorize(ctx context.Context) error {
+	if c.token == "" {
+		return nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if strings.TrimPrefix(v, "Bearer ") == c.token {
+			return nil
+		}
+	}
+
+	return status.Error(codes.Unauthenticated, "invalid control token")
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/errors"
+	"github.com/nicholasjackson/fake-service/grpc/api"
+	"github.com/nicholasjackson/fake-service/load"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/timing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func setupControlServer(t *testing.T, token string) (api.ControlServiceClient, *errors.Injector, *timing.RequestDuration, *load.Generator) {
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.NewNullLogger(), nil)
+	i := errors.NewInjector(l.Log(), 0, int(codes.Internal), "http_error", 0, 0, 0, 0, 0, 0, 0, 0, nil)
+	d := timing.NewRequestDuration(time.Millisecond, 0, 0, 0)
+	g := load.NewGenerator(0, 0, 0, 0, hclog.NewNullLogger())
+
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	api.RegisterControlServiceServer(s, NewControlServer(l, token, i, d, g))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return api.NewControlServiceClient(conn), i, d, g
+}
+
+func TestControlSetErrorRateInjectsErrors(t *testing.T) {
+	c, i, _, _ := setupControlServer(t, "")
+	assert.Nil(t, i.Do())
+
+	cfg, err := c.SetErrorRate(context.Background(), &api.ErrorRate{Rate: 1, Code: 503})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, cfg.ErrorRate.Rate)
+	assert.Equal(t, int32(503), cfg.ErrorRate.Code)
+
+	resp := i.Do()
+	require.NotNil(t, resp)
+	assert.Equal(t, 503, resp.Code)
+}
+
+func TestControlSetErrorRateRejectsInvalidRate(t *testing.T) {
+	c, _, _, _ := setupControlServer(t, "")
+
+	_, err := c.SetErrorRate(context.Background(), &api.ErrorRate{Rate: 2})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestControlSetLatencyChangesDuration(t *testing.T) {
+	c, _, d, _ := setupControlServer(t, "")
+
+	cfg, err := c.SetLatency(context.Background(), &api.Latency{P50: "50ms"})
+	require.NoError(t, err)
+	assert.Equal(t, "50ms", cfg.Latency.P50)
+	assert.Equal(t, "50ms", cfg.Latency.P99)
+
+	assert.Equal(t, 50*time.Millisecond, d.Calculate())
+}
+
+func TestControlSetLatencyRejectsInvalidDuration(t *testing.T) {
+	c, _, _, _ := setupControlServer(t, "")
+
+	_, err := c.SetLatency(context.Background(), &api.Latency{P50: "fast"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestControlSetLoadChangesGenerator(t *testing.T) {
+	c, _, _, g := setupControlServer(t, "")
+
+	cfg, err := c.SetLoad(context.Background(), &api.Load{CPUCores: 1, CPUPercentage: 10, MemoryBytes: 1024})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), cfg.Load.MemoryBytes)
+
+	cores, percentage, memory := g.Load()
+	assert.Equal(t, 1.0, cores)
+	assert.Equal(t, 10.0, percentage)
+	assert.Equal(t, 1024, memory)
+}
+
+func TestControlGetConfigReturnsCurrentConfig(t *testing.T) {
+	c, _, _, _ := setupControlServer(t, "")
+
+	cfg, err := c.GetConfig(context.Background(), &api.ConfigRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, cfg.ErrorRate.Rate)
+	assert.Equal(t, int32(codes.Internal), cfg.ErrorRate.Code)
+	assert.Equal(t, "1ms", cfg.Latency.P50)
+}
+
+func TestControlRejectsCallsWithoutToken(t *testing.T) {
+	c, i, _, _ := setupControlServer(t, "secret")
+
+	_, err := c.SetErrorRate(context.Background(), &api.ErrorRate{Rate: 1})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer wrong")
+	_, err = c.GetConfig(ctx, &api.ConfigRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the error rate is unchanged
+	assert.Nil(t, i.Do())
+}
+
+func TestControlAcceptsCallsWithToken(t *testing.T) {
+	c, _, _, _ := setupControlServer(t, "secret")
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	_, err := c.GetConfig(ctx, &api.ConfigRequest{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// DefaultEnvelopeTemplate wraps the response in the shape commonly returned
+// by API gateways
+const DefaultEnvelopeTemplate = `{"data": {{.Response}}, "meta": {"request_id": "{{.RequestID}}", "took_ms": {{.TookMS}}}}`
+
+// EnvelopeContext is the data available to envelope templates, Response is
+// the JSON response of the service which is inserted verbatim
+type EnvelopeContext struct {
+	Response  string
+	RequestID string
+	Path      string
+	Code      int
+	TookMS    int64
+}
+
+// Envelope wraps the response from next in an outer envelope rendered from a
+// Go template to emulate the responses of an API gateway
+type Envelope struct {
+	logger *logging.Logger
+	tmpl   *template.Template
+	next   http.HandlerFunc
+}
+
+// NewEnvelope creates a new Envelope, an error is returned when the template
+// can not be parsed
+func NewEnvelope(logger *logging.Logger, envelope string, next http.HandlerFunc) (*Envelope, error) {
+	tmpl, err := template.New("envelope").Funcs(bodyFuncs).Option("missingkey=zero").Parse(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse envelope template: %s", err)
+	}
+
+	return &Envelope{logger: logger, tmpl: tmpl, next: next}, nil
+}
+
+// Handle the request
+func (e *Envelope) Handle(rw http.ResponseWriter, r *http.Request) {
+	st := time.Now()
+
+	ew := &envelopeWriter{header: http.Header{}, code: http.StatusOK}
+	e.next(ew, r)
+
+	for k, v := range ew.header {
+		rw.Header()[k] = v
+	}
+
+	// a HEAD request or an empty response has nothing to wrap
+	if r.Method == http.MethodHead || ew.body.Len() == 0 {
+		rw.WriteHeader(ew.code)
+		rw.Write(ew.body.Bytes())
+		return
+	}
+
+	requestID := r.Header.Get("X-Request-Id")
+	if requestID == "" {
+		requestID, _ = newUUID()
+	}
+
+	d, err := e.Wrap(EnvelopeContext{
+		Response:  ew.body.String(),
+		RequestID: requestID,
+		Path:      r.URL.Path,
+		Code:      ew.code,
+		TookMS:    time.Since(st).Milliseconds(),
+	})
+
+	if err != nil {
+		e.logger.Log().Error("Unable to render response envelope", "error", err)
+		d = ew.body.Bytes()
+	}
+
+	rw.Header().Set("Content-Length", strconv.Itoa(len(d)))
+	rw.WriteHeader(ew.code)
+	rw.Write(d)
+}
+
+// Wrap renders the envelope for the response
+func (e *Envelope) Wrap(c EnvelopeContext) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	err := e.tmpl.Execute(buf, c)
+	if err != nil {
+		return nil, fmt.Errorf("unable to render envelope template: %s", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// envelopeWriter buffers the status code, headers and body written by the
+// handler so that the body can be wrapped before it is sent
+type envelopeWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *envelopeWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *envelopeWriter) WriteHeader(code int) {
+	w.code = code
+}
+
+func (w *envelopeWriter) Write(d []byte) (int, error) {
+	return w.body.Write(d)
+}
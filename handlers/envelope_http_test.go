@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+const envelopeTestResponse = `{"name": "test", "code": 200, "body": "hello"}`
+
+func setupEnvelope(t *testing.T, tmpl string) *Envelope {
+	e, err := NewEnvelope(
+		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
+		tmpl,
+		func(rw http.ResponseWriter, r *http.Request) {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusCreated)
+			fmt.Fprint(rw, envelopeTestResponse)
+		},
+	)
+
+	assert.NoError(t, err)
+	return e
+}
+
+func TestEnvelopeWrapsResponse(t *testing.T) {
+	e := setupEnvelope(t, DefaultEnvelopeTemplate)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("X-Request-Id", "abc123")
+	rr := httptest.NewRecorder()
+	e.Handle(rr, r)
+
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	env := struct {
+		Data json.RawMessage `json:"data"`
+		Meta struct {
+			RequestID string `json:"request_id"`
+			TookMS    *int64 `json:"took_ms"`
+		} `json:"meta"`
+	}{}
+
+	err := json.Unmarshal(rr.Body.Bytes(), &env)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", env.Meta.RequestID)
+	assert.NotNil(t, env.Meta.TookMS)
+
+	// unwrapping the envelope returns the original response
+	assert.JSONEq(t, envelopeTestResponse, string(env.Data))
+}
+
+func TestEnvelopeUsesCustomTemplate(t *testing.T) {
+	e := setupEnvelope(t, `{"result": {{.Response}}, "status": {{.Code}}, "path": "{{.Path}}"}`)
+
+	rr := httptest.NewRecorder()
+	e.Handle(rr, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	assert.JSONEq(t, `{"result": `+envelopeTestResponse+`, "status": 201, "path": "/orders"}`, rr.Body.String())
+}
+
+func TestEnvelopeDoesNotWrapHEAD(t *testing.T) {
+	e := setupEnvelope(t, DefaultEnvelopeTemplate)
+
+	rr := httptest.NewRecorder()
+	e.Handle(rr, httptest.NewRequest(http.MethodHead, "/", nil))
+
+	assert.Equal(t, envelopeTestResponse, rr.Body.String())
+}
+
+func TestNewEnvelopeReturnsErrorForInvalidTemplate(t *testing.T) {
+	_, err := NewEnvelope(logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil), "{{.Response", nil)
+
+	assert.Error(t, err)
+}
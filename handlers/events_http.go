@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// EventsPath is the path of the Server-Sent Events stream
+const EventsPath = "/events"
+
+// Events streams Server-Sent Events, a data event carrying a service response
+// is sent every interval until count events have been sent, the stream then
+// ends with a close event. The stream stops early when the client
+// disconnects
+type Events struct {
+	logger   *logging.Logger
+	name     string
+	message  string
+	count    int
+	interval time.Duration
+}
+
+// NewEvents creates a new Events handler
+func NewEvents(logger *logging.Logger, name, message string, count int, interval time.Duration) *Events {
+	return &Events{
+		logger:   logger,
+		name:     name,
+		message:  message,
+		count:    count,
+		interval: interval,
+	}
+}
+
+// Handle the request
+func (e *Events) Handle(rw http.ResponseWriter, r *http.Request) {
+	f, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+	rw.Header().Set("Connection", "keep-alive")
+	rw.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	e.logger.Log().Info("Streaming events", "count", e.count, "interval", e.interval)
+
+	for i := 1; i <= e.count; i++ {
+		if i > 1 {
+			select {
+			case <-time.After(e.interval):
+			case <-r.Context().Done():
+				e.logger.Log().Info("Client closed event stream", "sent", i-1)
+				return
+			}
+		}
+
+		data, err := json.Marshal(e.event(r))
+		if err != nil {
+			e.logger.Log().Error("Unable to encode event", "error", err)
+			return
+		}
+
+		fmt.Fprintf(rw, "id: %d\ndata: %s\n\n", i, data)
+		f.Flush()
+	}
+
+	fmt.Fprint(rw, "event: close\ndata: \n\n")
+	f.Flush()
+}
+
+// event returns the service response sent with each event
+func (e *Events) event(r *http.Request) *response.Response {
+	resp := &response.Response{
+		Name:      e.name,
+		Type:      "HTTP",
+		URI:       r.URL.String(),
+		StartTime: time.Now().Format(timeFormat),
+		Code:      http.StatusOK,
+		Body:      bodyJSON(e.message),
+	}
+
+	return resp
+}
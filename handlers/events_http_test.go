@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupEvents(t *testing.T, count int, interval time.Duration) *httptest.Server {
+	e := NewEvents(logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil), "test", "hello world", count, interval)
+
+	ts := httptest.NewServer(http.HandlerFunc(e.Handle))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func TestEventsStreamsCountEventsThenCloses(t *testing.T) {
+	ts := setupEvents(t, 3, time.Millisecond)
+
+	resp, err := http.Get(ts.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	data := []string{}
+	events := []string{}
+
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		switch l := s.Text(); {
+		case strings.HasPrefix(l, "data: {"):
+			data = append(data, strings.TrimPrefix(l, "data: "))
+		case strings.HasPrefix(l, "event: "):
+			events = append(events, strings.TrimPrefix(l, "event: "))
+		}
+	}
+
+	assert.Len(t, data, 3)
+	assert.Equal(t, []string{"close"}, events)
+
+	mr := response.Response{}
+	assert.NoError(t, mr.FromJSON([]byte(data[0])))
+	assert.Equal(t, "test", mr.Name)
+	assert.JSONEq(t, `"hello world"`, string(mr.Body))
+}
+
+func TestEventsStopsWhenClientDisconnects(t *testing.T) {
+	ts := setupEvents(t, 1000, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	// the first event is sent immediately
+	s := bufio.NewScanner(resp.Body)
+	assert.True(t, s.Scan())
+	assert.True(t, strings.HasPrefix(s.Text(), "id: 1"))
+
+	done := make(chan struct{})
+	go func() {
+		for s.Scan() {
+		}
+		close(done)
+	}()
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream did not end when the client disconnected")
+	}
+}
+
+func TestEventsEncodesInvalidJSONMessageAsString(t *testing.T) {
+	e := NewEvents(logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil), "test", "{oops", 1, time.Millisecond)
+
+	ts := httptest.NewServer(http.HandlerFunc(e.Handle))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	data := []string{}
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		if l := s.Text(); strings.HasPrefix(l, "data: {") {
+			data = append(data, strings.TrimPrefix(l, "data: "))
+		}
+	}
+
+	assert.Len(t, data, 1)
+
+	mr := response.Response{}
+	assert.NoError(t, mr.FromJSON([]byte(data[0])))
+	assert.JSONEq(t, `"{oops"`, string(mr.Body))
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// ErrUpstreamUnhealthy is returned for upstreams which are not called
+// because they are failing their health checks
+var ErrUpstreamUnhealthy = fmt.Errorf("upstream call skipped, upstream is failing health checks")
+
+// HealthCheckFunc checks the health of the upstream at the given URI
+type HealthCheckFunc func(uri string) error
+
+// HealthChecks periodically checks the health of each upstream so that
+// requests are only routed to healthy upstreams, modelling a load balancer
+// with active health checks. An upstream becomes unhealthy after threshold
+// consecutive failed checks and healthy again after a successful check
+type HealthChecks struct {
+	uris      []string
+	interval  time.Duration
+	threshold int
+	check     HealthCheckFunc
+	logger    hclog.Logger
+
+	failures map[string]int
+	mutex    sync.Mutex
+}
+
+// NewHealthChecks creates HealthChecks which check each of the uris every
+// interval
+func NewHealthChecks(uris []string, interval time.Duration, threshold int, check HealthCheckFunc, logger hclog.Logger) *HealthChecks {
+	return &HealthChecks{
+		uris:      uris,
+		interval:  interval,
+		threshold: threshold,
+		check:     check,
+		logger:    logger,
+		failures:  map[string]int{},
+	}
+}
+
+// Start checking the upstreams, the returned function stops the checks
+func (h *HealthChecks) Start() func() {
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			h.Check()
+
+			select {
+			case <-time.After(h.interval):
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// Check runs a single health check of every upstream
+func (h *HealthChecks) Check() {
+	for _, u := range h.uris {
+		err := h.check(u)
+
+		h.mutex.Lock()
+		if err != nil {
+			h.failures[u]++
+			if h.failures[u] == h.threshold {
+				h.logger.Warn("Upstream is unhealthy", "uri", u, "error", err)
+			}
+		} else {
+			if h.failures[u] >= h.threshold {
+				h.logger.Info("Upstream is healthy", "uri", u)
+			}
+
+			h.failures[u] = 0
+		}
+		h.mutex.Unlock()
+	}
+}
+
+// Healthy returns true when the upstream is passing its health checks, when
+// h is nil all upstreams are healthy
+func (h *HealthChecks) Healthy(uri string) bool {
+	if h == nil {
+		return true
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.failures[uri] < h.threshold
+}
+
+// skippedUpstream returns the response of an unhealthy upstream which is
+// not called
+func skippedUpstream(uri string) *response.Response {
+	return &response.Response{URI: uri, Error: ErrUpstreamUnhealthy.Error(), Skipped: true}
+}
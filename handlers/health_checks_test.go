@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func setupHealthChecks(t *testing.T, uris []string, unhealthy map[string]bool) *HealthChecks {
+	return NewHealthChecks(uris, time.Second, 2, func(uri string) error {
+		if unhealthy[uri] {
+			return fmt.Errorf("boom")
+		}
+
+		return nil
+	}, hclog.NewNullLogger())
+}
+
+func TestHealthChecksMarksUpstreamUnhealthyAfterThreshold(t *testing.T) {
+	unhealthy := map[string]bool{"http://b.com": true}
+	h := setupHealthChecks(t, []string{"http://a.com", "http://b.com"}, unhealthy)
+
+	h.Check()
+	assert.True(t, h.Healthy("http://b.com"))
+
+	h.Check()
+	assert.True(t, h.Healthy("http://a.com"))
+	assert.False(t, h.Healthy("http://b.com"))
+
+	// a single successful check restores the upstream
+	unhealthy["http://b.com"] = false
+	h.Check()
+	assert.True(t, h.Healthy("http://b.com"))
+}
+
+func TestNilHealthChecksIsHealthy(t *testing.T) {
+	var h *HealthChecks
+
+	assert.True(t, h.Healthy("http://a.com"))
+}
+
+func TestRequestSkipsUnhealthyUpstreams(t *testing.T) {
+	uris := []string{"http://a.com", "http://b.com"}
+	unhealthy := map[string]bool{}
+
+	h, c, _ := setupRequest(t, uris, 0)
+	h.workerCount = 2
+	h.healthChecks = setupHealthChecks(t, uris, unhealthy)
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	h.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	c.AssertNumberOfCalls(t, "Do", 2)
+
+	// b fails its health checks and is excluded from the fan out
+	unhealthy["http://b.com"] = true
+	h.healthChecks.Check()
+	h.healthChecks.Check()
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	c.AssertNumberOfCalls(t, "Do", 3)
+	c.AssertCalled(t, "Do", upstreamHostIs("a.com"), mock.Anything)
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, mr.UpstreamCalls["http://b.com"].Skipped)
+	assert.Equal(t, ErrUpstreamUnhealthy.Error(), mr.UpstreamCalls["http://b.com"].Error)
+	assert.False(t, mr.UpstreamCalls["http://a.com"].Skipped)
+}
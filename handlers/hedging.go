@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+const (
+	// HedgePrimary is the winner reported when the first attempt responds first
+	HedgePrimary = "primary"
+	// HedgeSecondary is the winner reported when the hedged attempt responds
+	// first
+	HedgeSecondary = "hedge"
+)
+
+// Hedging sends a second concurrent request to an upstream which has not
+// responded within the hedge delay, the first response wins and the other
+// attempt is cancelled
+type Hedging struct {
+	delay time.Duration
+}
+
+// NewHedging creates Hedging which fires a hedged request after delay
+func NewHedging(delay time.Duration) *Hedging {
+	return &Hedging{delay: delay}
+}
+
+type hedgeResult struct {
+	winner string
+	r      *response.Response
+	err    error
+}
+
+// Do calls the upstream with a context derived from ctx, when the call has
+// not returned after the delay a second call is made and the first
+// successful response is returned. Whether the hedge fired and which attempt
+// won is reported on the response, a nil Hedging calls the upstream once
+func (h *Hedging) Do(ctx context.Context, call func(context.Context) (*response.Response, error)) (*response.Response, error) {
+	if h == nil {
+		return call(ctx)
+	}
+
+	// buffered so that the losing attempt can finish without a reader
+	results := make(chan hedgeResult, 2)
+	attempt := func(winner string) context.CancelFunc {
+		actx, cancel := context.WithCancel(ctx)
+		go func() {
+			r, err := call(actx)
+			results <- hedgeResult{winner, r, err}
+		}()
+
+		return cancel
+	}
+
+	cancelPrimary := attempt(HedgePrimary)
+	defer cancelPrimary()
+
+	timer := time.NewTimer(h.delay)
+	defer timer.Stop()
+
+	var res hedgeResult
+	fired := false
+
+	select {
+	case res = <-results:
+	case <-timer.C:
+		fired = true
+		cancelHedge := attempt(HedgeSecondary)
+		defer cancelHedge()
+
+		// an attempt which fails waits for the other, the first error is
+		// only returned when both fail
+		res = <-results
+		if res.err != nil {
+			if other := <-results; other.err == nil {
+				res = other
+			}
+		}
+	}
+
+	if res.r != nil {
+		res.r.Hedge = &response.Hedge{Fired: fired, Winner: res.winner}
+	}
+
+	return res.r, res.err
+}
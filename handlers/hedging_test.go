@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowThenFastCall blocks the first attempt for slow or until it is
+// cancelled, later attempts return immediately
+func slowThenFastCall(slow time.Duration, attempts *int32, cancelled chan struct{}) func(context.Context) (*response.Response, error) {
+	return func(ctx context.Context) (*response.Response, error) {
+		if atomic.AddInt32(attempts, 1) == 1 {
+			select {
+			case <-time.After(slow):
+				return &response.Response{Code: 200, Name: "slow"}, nil
+			case <-ctx.Done():
+				close(cancelled)
+				return nil, ctx.Err()
+			}
+		}
+
+		return &response.Response{Code: 200, Name: "fast"}, nil
+	}
+}
+
+func TestHedgingDoesNotFireWhenPrimaryIsFast(t *testing.T) {
+	var attempts int32
+	r, err := NewHedging(time.Second).Do(context.Background(), func(ctx context.Context) (*response.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return &response.Response{Code: 200}, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	assert.False(t, r.Hedge.Fired)
+	assert.Equal(t, HedgePrimary, r.Hedge.Winner)
+}
+
+func TestHedgingUsesHedgeAndCancelsSlowPrimary(t *testing.T) {
+	var attempts int32
+	cancelled := make(chan struct{})
+
+	st := time.Now()
+	r, err := NewHedging(10*time.Millisecond).Do(context.Background(), slowThenFastCall(time.Second, &attempts, cancelled))
+
+	assert.NoError(t, err)
+	assert.Less(t, int64(time.Since(st)), int64(500*time.Millisecond))
+	assert.Equal(t, "fast", r.Name)
+	assert.True(t, r.Hedge.Fired)
+	assert.Equal(t, HedgeSecondary, r.Hedge.Winner)
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("primary attempt was not cancelled")
+	}
+}
+
+func TestHedgingWaitsForOtherAttemptWhenFirstFails(t *testing.T) {
+	var attempts int32
+	r, err := NewHedging(10*time.Millisecond).Do(context.Background(), func(ctx context.Context) (*response.Response, error) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			time.Sleep(50 * time.Millisecond)
+			return &response.Response{Code: 200}, nil
+		}
+
+		return &response.Response{Code: 500}, fmt.Errorf("Boom")
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 200, r.Code)
+	assert.True(t, r.Hedge.Fired)
+	assert.Equal(t, HedgePrimary, r.Hedge.Winner)
+}
+
+func TestNilHedgingCallsOnce(t *testing.T) {
+	var h *Hedging
+
+	var attempts int32
+	r, err := h.Do(context.Background(), func(ctx context.Context) (*response.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return &response.Response{Code: 200}, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	assert.Nil(t, r.Hedge)
+}
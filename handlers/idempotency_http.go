@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+const (
+	// IdempotencyKeyHeader is the request header which identifies repeated
+	// requests
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses returned from the cache
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// ErrorIdempotencyMismatch is returned when an idempotency key is reused
+	// for a request with a different method or path
+	ErrorIdempotencyMismatch = "idempotency key was used for a different request"
+	// ErrorIdempotencyInFlight is returned when a request with the same
+	// idempotency key is still being processed
+	ErrorIdempotencyInFlight = "a request with the idempotency key is in progress"
+)
+
+// idempotentResponse is a response cached for an idempotency key
+type idempotentResponse struct {
+	request string // method and path of the request the key was used for
+	code    int
+	headers http.Header
+	body    []byte
+	expires time.Time
+}
+
+// Idempotency models a payment style API, the response to a request with an
+// Idempotency-Key header is cached for the TTL and repeated requests with the
+// same key return the cached response without being processed again. A key
+// reused with a different method or path is rejected with 422 and a request
+// which arrives while the first with its key is processed is rejected with
+// 409. Server errors are not cached so that the client can retry them.
+// Requests without a key are passed to next
+type Idempotency struct {
+	logger    *logging.Logger
+	name      string
+	ttl       time.Duration
+	size      int
+	now       func() time.Time
+	mutex     sync.Mutex
+	responses map[string]idempotentResponse
+	order     []string
+	inFlight  map[string]string // method and path of requests being processed by key
+	next      http.HandlerFunc
+}
+
+// NewIdempotency creates a new Idempotency which caches at most size
+// responses, when full the response cached longest ago is removed
+func NewIdempotency(logger *logging.Logger, name string, ttl time.Duration, size int, next http.HandlerFunc) *Idempotency {
+	return &Idempotency{
+		logger:    logger,
+		name:      name,
+		ttl:       ttl,
+		size:      size,
+		now:       time.Now,
+		responses: map[string]idempotentResponse{},
+		inFlight:  map[string]string{},
+		next:      next,
+	}
+}
+
+// Handle the request
+func (i *Idempotency) Handle(rw http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		i.next(rw, r)
+		return
+	}
+
+	request := r.Method + " " + r.URL.Path
+
+	cached, ok, err := i.start(key, request)
+	switch err {
+	case ErrorIdempotencyMismatch:
+		i.logger.Log().Info("Rejecting idempotency key used for a different request", "key", key, "request", request)
+		i.writeError(rw, r, http.StatusUnprocessableEntity, err)
+		return
+	case ErrorIdempotencyInFlight:
+		i.logger.Log().Info("Rejecting request while the idempotency key is in progress", "key", key)
+		i.writeError(rw, r, http.StatusConflict, err)
+		return
+	}
+
+	if ok {
+		i.logger.Log().Info("Returning cached response for idempotency key", "key", key, "code", cached.code)
+
+		for k, v := range cached.headers {
+			rw.Header()[k] = v
+		}
+
+		rw.Header().Set(IdempotentReplayedHeader, "true")
+		rw.WriteHeader(cached.code)
+		rw.Write(cached.body)
+		return
+	}
+
+	defer i.finish(key)
+
+	rec := &recordingWriter{ResponseWriter: rw, code: http.StatusOK}
+	i.next(rec, r)
+
+	// a hijacked response was written directly to the connection so there
+	// is nothing to cache
+	if rec.hijacked || rec.code >= http.StatusInternalServerError {
+		return
+	}
+
+	i.set(key, idempotentResponse{
+		request: request,
+		code:    rec.code,
+		headers: rw.Header().Clone(),
+		body:    rec.body.Bytes(),
+		expires: i.now().Add(i.ttl),
+	})
+}
+
+// start returns the response cached for key when it has not expired, when
+// there is no cached response the key is marked in flight until finish is
+// called. An error is returned when the key was used for a different request
+// or a request with the key is in flight
+func (i *Idempotency) start(key, request string) (idempotentResponse, bool, string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if r, ok := i.inFlight[key]; ok {
+		if r != request {
+			return idempotentResponse{}, false, ErrorIdempotencyMismatch
+		}
+
+		return idempotentResponse{}, false, ErrorIdempotencyInFlight
+	}
+
+	cached, ok := i.responses[key]
+	if ok && i.now().Before(cached.expires) {
+		if cached.request != request {
+			return idempotentResponse{}, false, ErrorIdempotencyMismatch
+		}
+
+		return cached, true, ""
+	}
+
+	i.inFlight[key] = request
+
+	return idempotentResponse{}, false, ""
+}
+
+// finish marks the request with key as no longer in flight
+func (i *Idempotency) finish(key string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	delete(i.inFlight, key)
+}
+
+// writeError rejects the request with the given code
+func (i *Idempotency) writeError(rw http.ResponseWriter, r *http.Request, code int, err string) {
+	resp := &response.Response{
+		Name:  i.name,
+		Type:  "HTTP",
+		URI:   r.URL.String(),
+		Code:  code,
+		Error: err,
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	writeResponse(rw, r, code, []byte(resp.ToJSON()))
+}
+
+// set caches the response for key removing the oldest response when full
+func (i *Idempotency) set(key string, resp idempotentResponse) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.remove(key)
+	if len(i.order) >= i.size && len(i.order) > 0 {
+		delete(i.responses, i.order[0])
+		i.order = i.order[1:]
+	}
+
+	i.responses[key] = resp
+	i.order = append(i.order, key)
+}
+
+// remove deletes the key from the eviction order, the caller must hold the
+// mutex
+func (i *Idempotency) remove(key string) {
+	for n, k := range i.order {
+		if k == key {
+			i.order = append(i.order[:n], i.order[n+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupIdempotency(t *testing.T, code int) (*Idempotency, *int, *time.Time) {
+	calls := 0
+	i := NewIdempotency(
+		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
+		"test",
+		time.Minute,
+		10,
+		func(rw http.ResponseWriter, r *http.Request) {
+			calls++
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(code)
+			fmt.Fprintf(rw, `{"call": %d}`, calls)
+		},
+	)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	i.now = func() time.Time { return now }
+
+	return i, &calls, &now
+}
+
+func callWithIdempotencyKey(i *Idempotency, key string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/payments", nil)
+	if key != "" {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+
+	rr := httptest.NewRecorder()
+	i.Handle(rr, r)
+
+	return rr
+}
+
+func TestIdempotencyReturnsCachedResponseForRepeatedKey(t *testing.T) {
+	i, calls, _ := setupIdempotency(t, http.StatusCreated)
+
+	first := callWithIdempotencyKey(i, "abc")
+	second := callWithIdempotencyKey(i, "abc")
+
+	assert.Equal(t, 1, *calls)
+	assert.Equal(t, http.StatusCreated, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "application/json", second.Header().Get("Content-Type"))
+	assert.Equal(t, "true", second.Header().Get(IdempotentReplayedHeader))
+	assert.Empty(t, first.Header().Get(IdempotentReplayedHeader))
+}
+
+func TestIdempotencyProcessesDifferentKey(t *testing.T) {
+	i, calls, _ := setupIdempotency(t, http.StatusCreated)
+
+	first := callWithIdempotencyKey(i, "abc")
+	second := callWithIdempotencyKey(i, "def")
+
+	assert.Equal(t, 2, *calls)
+	assert.NotEqual(t, first.Body.String(), second.Body.String())
+}
+
+func TestIdempotencyProcessesKeyAgainAfterTTL(t *testing.T) {
+	i, calls, now := setupIdempotency(t, http.StatusCreated)
+
+	callWithIdempotencyKey(i, "abc")
+	*now = now.Add(time.Minute)
+	rr := callWithIdempotencyKey(i, "abc")
+
+	assert.Equal(t, 2, *calls)
+	assert.JSONEq(t, `{"call": 2}`, rr.Body.String())
+}
+
+func TestIdempotencyDoesNotCacheServerErrors(t *testing.T) {
+	i, calls, _ := setupIdempotency(t, http.StatusInternalServerError)
+
+	callWithIdempotencyKey(i, "abc")
+	callWithIdempotencyKey(i, "abc")
+
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotencyPassesRequestsWithoutKey(t *testing.T) {
+	i, calls, _ := setupIdempotency(t, http.StatusCreated)
+
+	callWithIdempotencyKey(i, "")
+	callWithIdempotencyKey(i, "")
+
+	assert.Equal(t, 2, *calls)
+}
+
+func TestIdempotencyRejectsKeyReusedForDifferentRequest(t *testing.T) {
+	i, calls, _ := setupIdempotency(t, http.StatusCreated)
+
+	callWithIdempotencyKey(i, "abc")
+
+	r := httptest.NewRequest(http.MethodPost, "/refunds", nil)
+	r.Header.Set(IdempotencyKeyHeader, "abc")
+	rr := httptest.NewRecorder()
+	i.Handle(rr, r)
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, 1, *calls)
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Equal(t, ErrorIdempotencyMismatch, mr.Error)
+}
+
+func TestIdempotencyRejectsRequestWhileKeyInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	i := NewIdempotency(
+		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
+		"test",
+		time.Minute,
+		10,
+		func(rw http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+			rw.WriteHeader(http.StatusCreated)
+		},
+	)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- callWithIdempotencyKey(i, "abc")
+	}()
+
+	<-started
+	second := callWithIdempotencyKey(i, "abc")
+	close(release)
+	first := <-done
+
+	mr := response.Response{}
+	mr.FromJSON(second.Body.Bytes())
+
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Equal(t, http.StatusConflict, second.Code)
+	assert.Equal(t, ErrorIdempotencyInFlight, mr.Error)
+
+	// the key is cached once the first request completes
+	third := callWithIdempotencyKey(i, "abc")
+	assert.Equal(t, http.StatusCreated, third.Code)
+	assert.Equal(t, "true", third.Header().Get(IdempotentReplayedHeader))
+}
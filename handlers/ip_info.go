@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net"
+	"sync"
+)
+
+// IPFilter selects the addresses of the service reported in the response,
+// loopback, multicast and IPv6 addresses are never reported
+type IPFilter struct {
+	// Interfaces when set only reports the addresses of the named interfaces
+	Interfaces []string
+	// ExcludeLinkLocal removes link-local addresses e.g. 169.254.0.0/16
+	ExcludeLinkLocal bool
+	// Prefer when set only reports the addresses in the CIDR, when no
+	// address is in the CIDR all addresses are reported
+	Prefer *net.IPNet
+}
+
+// interfaceAddrs are the addresses of a network interface
+type interfaceAddrs struct {
+	name  string
+	addrs []net.Addr
+}
+
+// Get a list of non loopback Ip addresses
+// realistically this is not going to change to cache
+var ipAddresses []string
+var ipFilter *IPFilter
+var ipMutex sync.Mutex
+
+// SetIPFilter sets the filter applied to the addresses of the service
+func SetIPFilter(f *IPFilter) {
+	ipMutex.Lock()
+	defer ipMutex.Unlock()
+
+	ipFilter = f
+	ipAddresses = nil
+}
+
+func getIPInfo() []string {
+	ipMutex.Lock()
+	defer ipMutex.Unlock()
+
+	if len(ipAddresses) > 0 {
+		return ipAddresses
+	}
+
+	list := []interfaceAddrs{}
+
+	ifaces, _ := net.Interfaces()
+	for _, i := range ifaces {
+		addrs, _ := i.Addrs()
+		list = append(list, interfaceAddrs{name: i.Name, addrs: addrs})
+	}
+
+	// cache the result
+	ipAddresses = filterIPs(list, ipFilter)
+	return ipAddresses
+}
+
+// filterIPs returns the IPv4 addresses of the interfaces selected by the
+// filter, a nil filter selects all addresses
+func filterIPs(ifaces []interfaceAddrs, f *IPFilter) []string {
+	if f == nil {
+		f = &IPFilter{}
+	}
+
+	ips := []string{}
+	preferred := []string{}
+
+	for _, i := range ifaces {
+		if len(f.Interfaces) > 0 && !contains(f.Interfaces, i.name) {
+			continue
+		}
+
+		for _, addr := range i.addrs {
+			var ip net.IP
+			switch v := addr.(type) {
+			case *net.IPNet:
+				ip = v.IP
+			case *net.IPAddr:
+				ip = v.IP
+			}
+
+			// ignore localhost
+			if ip.IsLoopback() || ip.IsMulticast() || ip.To4() == nil {
+				continue
+			}
+
+			if f.ExcludeLinkLocal && ip.IsLinkLocalUnicast() {
+				continue
+			}
+
+			ips = append(ips, ip.String())
+
+			if f.Prefer != nil && f.Prefer.Contains(ip) {
+				preferred = append(preferred, ip.String())
+			}
+		}
+	}
+
+	if len(preferred) > 0 {
+		return preferred
+	}
+
+	return ips
+}
+
+// contains returns true when s is in list
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupInterfaces(t *testing.T) []interfaceAddrs {
+	addr := func(s string) net.Addr {
+		ip, n, err := net.ParseCIDR(s)
+		assert.NoError(t, err)
+
+		n.IP = ip
+		return n
+	}
+
+	return []interfaceAddrs{
+		{name: "lo", addrs: []net.Addr{addr("127.0.0.1/8"), addr("::1/128")}},
+		{name: "eth0", addrs: []net.Addr{addr("10.0.1.5/24"), addr("fe80::1/64")}},
+		{name: "eth1", addrs: []net.Addr{addr("192.168.10.7/24"), addr("169.254.1.1/16")}},
+	}
+}
+
+func TestFilterIPsReturnsAllIPv4AddressesWithoutFilter(t *testing.T) {
+	ips := filterIPs(setupInterfaces(t), nil)
+
+	assert.Equal(t, []string{"10.0.1.5", "192.168.10.7", "169.254.1.1"}, ips)
+}
+
+func TestFilterIPsReturnsNamedInterfaces(t *testing.T) {
+	ips := filterIPs(setupInterfaces(t), &IPFilter{Interfaces: []string{"eth1"}})
+
+	assert.Equal(t, []string{"192.168.10.7", "169.254.1.1"}, ips)
+}
+
+func TestFilterIPsExcludesLinkLocal(t *testing.T) {
+	ips := filterIPs(setupInterfaces(t), &IPFilter{ExcludeLinkLocal: true})
+
+	assert.Equal(t, []string{"10.0.1.5", "192.168.10.7"}, ips)
+}
+
+func TestFilterIPsReturnsPreferredCIDR(t *testing.T) {
+	_, cidr, _ := net.ParseCIDR("10.0.0.0/8")
+	ips := filterIPs(setupInterfaces(t), &IPFilter{Prefer: cidr})
+
+	assert.Equal(t, []string{"10.0.1.5"}, ips)
+}
+
+func TestFilterIPsReturnsAllWhenNoAddressInPreferredCIDR(t *testing.T) {
+	_, cidr, _ := net.ParseCIDR("172.16.0.0/12")
+	ips := filterIPs(setupInterfaces(t), &IPFilter{Prefer: cidr, ExcludeLinkLocal: true})
+
+	assert.Equal(t, []string{"10.0.1.5", "192.168.10.7"}, ips)
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// ErrorLoadShed is returned for requests rejected to reduce the CPU usage
+const ErrorLoadShed = "Service overloaded, request shed"
+
+// LoadShedder models adaptive overload protection, when the CPU usage of the
+// service is over the threshold a fraction of requests are rejected with 503.
+// The fraction grows from 0 at the threshold to 1 at 100% usage so the
+// service sheds more load the more overloaded it is
+type LoadShedder struct {
+	logger     *logging.Logger
+	name       string
+	threshold  float64
+	usage      func() float64
+	randomFunc func() float64
+	next       http.HandlerFunc
+}
+
+// NewLoadShedder creates a new LoadShedder, usage returns the CPU usage of
+// the service as a percentage
+func NewLoadShedder(logger *logging.Logger, name string, threshold float64, usage func() float64, next http.HandlerFunc) *LoadShedder {
+	return &LoadShedder{
+		logger:     logger,
+		name:       name,
+		threshold:  threshold,
+		usage:      usage,
+		randomFunc: rand.Float64,
+		next:       next,
+	}
+}
+
+// Handle the request
+func (l *LoadShedder) Handle(rw http.ResponseWriter, r *http.Request) {
+	usage := l.usage()
+	fraction := l.shedFraction(usage)
+	if fraction == 0 || l.randomFunc() >= fraction {
+		l.next(rw, r)
+		return
+	}
+
+	l.logger.Log().Info("Shedding request", "cpu_usage", usage, "threshold", l.threshold, "fraction", fraction)
+
+	resp := &response.Response{
+		Name:  l.name,
+		Type:  "HTTP",
+		URI:   r.URL.String(),
+		Code:  http.StatusServiceUnavailable,
+		Error: ErrorLoadShed,
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	writeResponse(rw, r, http.StatusServiceUnavailable, []byte(resp.ToJSON()))
+}
+
+// shedFraction returns the fraction of requests to reject at the given usage
+func (l *LoadShedder) shedFraction(usage float64) float64 {
+	if usage <= l.threshold {
+		return 0
+	}
+
+	if l.threshold >= 100 {
+		return 1
+	}
+
+	return math.Min(1, (usage-l.threshold)/(100-l.threshold))
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupLoadShedder(t *testing.T, usage *float64) *LoadShedder {
+	l := NewLoadShedder(
+		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
+		"test",
+		60,
+		func() float64 { return *usage },
+		func(rw http.ResponseWriter, r *http.Request) { fmt.Fprint(rw, "OK") },
+	)
+
+	return l
+}
+
+func TestLoadShedderShedFractionFollowsUsage(t *testing.T) {
+	usage := 0.0
+	l := setupLoadShedder(t, &usage)
+
+	assert.Equal(t, 0.0, l.shedFraction(40))
+	assert.Equal(t, 0.0, l.shedFraction(60))
+	assert.InDelta(t, 0.25, l.shedFraction(70), 0.001)
+	assert.InDelta(t, 0.75, l.shedFraction(90), 0.001)
+	assert.Equal(t, 1.0, l.shedFraction(100))
+}
+
+func TestLoadShedderShedsRequestsUntilUsageDrops(t *testing.T) {
+	usage := 50.0
+	l := setupLoadShedder(t, &usage)
+	l.randomFunc = func() float64 { return 0.5 }
+
+	call := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		l.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rr
+	}
+
+	// below the threshold no requests are shed
+	assert.Equal(t, http.StatusOK, call().Code)
+
+	// 25% of requests are shed
+	usage = 70
+	assert.Equal(t, http.StatusOK, call().Code)
+
+	// 75% of requests are shed
+	usage = 90
+	rr := call()
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	resp := &response.Response{}
+	assert.NoError(t, resp.FromJSON(rr.Body.Bytes()))
+	assert.Equal(t, ErrorLoadShed, resp.Error)
+
+	// requests are served again once usage drops
+	usage = 55
+	assert.Equal(t, http.StatusOK, call().Code)
+}
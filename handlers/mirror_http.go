@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// Mirror asynchronously sends a copy of a fraction of requests to a shadow
+// upstream, the shadow response is discarded and never affects the caller
+type Mirror struct {
+	uri        string
+	rate       float64
+	client     client.HTTP
+	logger     *logging.Logger
+	randomFunc func() float64
+
+	success int64
+	errors  int64
+}
+
+// MirrorStats is the response returned from the mirror stats handler
+type MirrorStats struct {
+	URI     string `json:"uri"`
+	Success int64  `json:"success"`
+	Errors  int64  `json:"errors"`
+}
+
+// NewMirror creates a new Mirror, rate is the decimal percentage of requests
+// which will be mirrored e.g. 0.1 = 10%
+func NewMirror(uri string, rate float64, c client.HTTP, l *logging.Logger) *Mirror {
+	return &Mirror{
+		uri:        uri,
+		rate:       rate,
+		client:     c,
+		logger:     l,
+		randomFunc: rand.Float64,
+	}
+}
+
+// Do mirrors the request to the shadow upstream, this function does not block
+func (m *Mirror) Do(pr *http.Request) {
+	if m.randomFunc() >= m.rate {
+		return
+	}
+
+	// the parent request is cloned as it will be released when the handler
+	// returns before the mirrored call has completed
+	var cr *http.Request
+	if pr != nil {
+		cr = pr.Clone(context.Background())
+	}
+
+	go func() {
+		r, err := http.NewRequest(http.MethodGet, m.uri, nil)
+		if err != nil {
+			atomic.AddInt64(&m.errors, 1)
+			return
+		}
+
+		_, _, _, _, err = m.client.Do(r, cr)
+		if err != nil {
+			m.logger.Log().Debug("Error calling mirror upstream", "uri", m.uri, "error", err)
+			atomic.AddInt64(&m.errors, 1)
+			return
+		}
+
+		atomic.AddInt64(&m.success, 1)
+	}()
+}
+
+// Stats returns the current mirror statistics
+func (m *Mirror) Stats() MirrorStats {
+	return MirrorStats{
+		URI:     m.uri,
+		Success: atomic.LoadInt64(&m.success),
+		Errors:  atomic.LoadInt64(&m.errors),
+	}
+}
+
+// Handle the stats request
+func (m *Mirror) Handle(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(m.Stats())
+}
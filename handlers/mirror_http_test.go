@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupMirror(t *testing.T, rate float64, delay time.Duration) (*Mirror, *int64, func()) {
+	hits := int64(0)
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		time.Sleep(delay)
+	}))
+
+	c := client.NewHTTP(false, true, 5*time.Second, 5*time.Second, false, client.DefaultSocketOptions, 0, nil, nil)
+	m := NewMirror(ts.URL, rate, c, logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil))
+
+	return m, &hits, ts.Close
+}
+
+func TestMirrorSendsConfiguredFractionOfRequests(t *testing.T) {
+	m, hits, cleanup := setupMirror(t, 0.5, 0)
+	defer cleanup()
+
+	// alternate the random draw either side of the rate
+	draws := []float64{0.1, 0.9}
+	calls := 0
+	m.randomFunc = func() float64 {
+		calls++
+		return draws[calls%2]
+	}
+
+	for i := 0; i < 10; i++ {
+		m.Do(httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	assert.Eventually(t, func() bool { return m.Stats().Success == 5 }, 1*time.Second, 5*time.Millisecond)
+	assert.Equal(t, int64(5), atomic.LoadInt64(hits))
+	assert.Equal(t, int64(0), m.Stats().Errors)
+}
+
+func TestMirrorRecordsErrors(t *testing.T) {
+	c := client.NewHTTP(false, true, 5*time.Second, 5*time.Second, false, client.DefaultSocketOptions, 0, nil, nil)
+	m := NewMirror("http://localhost:0", 1, c, logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil))
+
+	m.Do(nil)
+
+	assert.Eventually(t, func() bool { return m.Stats().Errors == 1 }, 1*time.Second, 5*time.Millisecond)
+}
+
+func TestRequestDoesNotBlockOnMirror(t *testing.T) {
+	m, hits, cleanup := setupMirror(t, 1, 500*time.Millisecond)
+	defer cleanup()
+
+	r := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+	h.mirror = m
+
+	st := time.Now()
+	h.Handle(rr, r)
+
+	assert.Less(t, int64(time.Since(st)), int64(500*time.Millisecond))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(hits) == 1 }, 1*time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// Capabilities is a machine readable description of the configured behaviour
+// of the service, fields are only ever added to keep the schema stable
+type Capabilities struct {
+	Name                   string           `json:"name"`
+	Type                   string           `json:"type"`
+	Upstreams              []string         `json:"upstreams"`
+	Errors                 CapabilityErrors `json:"errors"`
+	Load                   CapabilityLoad   `json:"load"`
+	ContentTypes           []string         `json:"content_types"`
+	Methods                []string         `json:"methods"`
+	ResponseSchemaVersions []int            `json:"response_schema_versions"`
+}
+
+// CapabilityErrors describes the errors injected by the service
+type CapabilityErrors struct {
+	Rate float64 `json:"rate"`
+	Code int     `json:"code"`
+	Type string  `json:"type"`
+}
+
+// CapabilityLoad describes the load generated for each request
+type CapabilityLoad struct {
+	CPUCores       float64 `json:"cpu_cores"`
+	CPUPercentage  float64 `json:"cpu_percentage"`
+	MemoryBytes    int     `json:"memory_bytes"`
+	WorkUnits      int     `json:"work_units"`
+	RequestHeaders bool    `json:"request_headers"`
+}
+
+// Options returns the capabilities of the service for OPTIONS requests,
+// CORS preflight requests and all other requests are passed to next
+type Options struct {
+	logger       *logging.Logger
+	capabilities Capabilities
+	next         http.HandlerFunc
+}
+
+// NewOptions creates a new Options handler
+func NewOptions(logger *logging.Logger, capabilities Capabilities, next http.HandlerFunc) *Options {
+	return &Options{logger, capabilities, next}
+}
+
+// Handle the request
+func (o *Options) Handle(rw http.ResponseWriter, r *http.Request) {
+	// a preflight request sets the method of the actual request
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") != "" {
+		o.next(rw, r)
+		return
+	}
+
+	o.logger.Log().Debug("Returning capabilities", "path", r.URL.Path)
+
+	rw.Header().Set("Allow", strings.Join(o.capabilities.Methods, ", "))
+	rw.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(rw).Encode(o.capabilities)
+}
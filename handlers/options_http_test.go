@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupOptions(t *testing.T) (*Options, *bool) {
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+
+	called := false
+	next := func(rw http.ResponseWriter, r *http.Request) {
+		called = true
+	}
+
+	c := Capabilities{
+		Name:      "api",
+		Type:      "http",
+		Upstreams: []string{"http://db:9090"},
+		Errors:    CapabilityErrors{Rate: 0.2, Code: 503, Type: "http_error"},
+		Load:      CapabilityLoad{CPUCores: 2, CPUPercentage: 50, MemoryBytes: 1024},
+		Methods:   []string{"GET", "HEAD", "OPTIONS"},
+	}
+
+	return NewOptions(l, c, next), &called
+}
+
+func TestOptionsReturnsCapabilities(t *testing.T) {
+	o, called := setupOptions(t)
+
+	rr := httptest.NewRecorder()
+	o.Handle(rr, httptest.NewRequest(http.MethodOptions, "/", nil))
+
+	c := Capabilities{}
+	err := json.Unmarshal(rr.Body.Bytes(), &c)
+	assert.NoError(t, err)
+
+	assert.False(t, *called)
+	assert.Equal(t, "GET, HEAD, OPTIONS", rr.Header().Get("Allow"))
+	assert.Equal(t, "api", c.Name)
+	assert.Equal(t, []string{"http://db:9090"}, c.Upstreams)
+	assert.Equal(t, 0.2, c.Errors.Rate)
+	assert.Equal(t, 503, c.Errors.Code)
+	assert.Equal(t, 2.0, c.Load.CPUCores)
+	assert.Equal(t, 1024, c.Load.MemoryBytes)
+}
+
+func TestOptionsPassesPreflightRequestsToNext(t *testing.T) {
+	o, called := setupOptions(t)
+
+	r := httptest.NewRequest(http.MethodOptions, "/", nil)
+	r.Header.Set("Origin", "http://example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+
+	o.Handle(httptest.NewRecorder(), r)
+
+	assert.True(t, *called)
+}
+
+func TestOptionsPassesOtherMethodsToNext(t *testing.T) {
+	o, called := setupOptions(t)
+
+	o.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.True(t, *called)
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+const (
+	// PipelineHeader forwards the data to the next upstream in a request header
+	PipelineHeader = "header"
+	// PipelineBody forwards the data to the next upstream as a POST body
+	PipelineBody = "body"
+)
+
+// Pipeline passes a field from the response of each upstream to the next
+// upstream, modelling a processing pipeline. Upstreams are called one at a
+// time in the configured order
+type Pipeline struct {
+	field  string
+	mode   string
+	header string
+}
+
+// NewPipeline creates a new Pipeline, field is the top level JSON field of
+// the upstream response which is forwarded e.g. body, mode is either header
+// or body, when mode is header the data is sent in the named header
+func NewPipeline(field, mode, header string) (*Pipeline, error) {
+	if mode != PipelineHeader && mode != PipelineBody {
+		return nil, fmt.Errorf("invalid pipeline mode %s, expected %s or %s", mode, PipelineHeader, PipelineBody)
+	}
+
+	return &Pipeline{field: field, mode: mode, header: header}, nil
+}
+
+// Start a new run of the pipeline for an inbound request, when p is nil the
+// returned run does not forward any data
+func (p *Pipeline) Start() *PipelineRun {
+	if p == nil {
+		return nil
+	}
+
+	return &PipelineRun{pipeline: p}
+}
+
+// PipelineRun holds the data forwarded between the upstreams of a single
+// inbound request
+type PipelineRun struct {
+	pipeline *Pipeline
+	data     []byte
+	mutex    sync.Mutex
+}
+
+// Prepare adds the data from the previous upstream to the request, the first
+// upstream in the pipeline receives the request unchanged
+func (pr *PipelineRun) Prepare(r *http.Request) {
+	if pr == nil {
+		return
+	}
+
+	pr.mutex.Lock()
+	data := pr.data
+	pr.mutex.Unlock()
+
+	if data == nil {
+		return
+	}
+
+	if pr.pipeline.mode == PipelineHeader {
+		// send strings unquoted, other JSON values are sent as is
+		var s string
+		if json.Unmarshal(data, &s) == nil {
+			data = []byte(s)
+		}
+
+		r.Header.Set(pr.pipeline.header, string(data))
+		return
+	}
+
+	r.Method = http.MethodPost
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+	r.Header.Set("Content-Type", "application/json")
+}
+
+// Record the configured field of the upstream response so that it can be
+// forwarded to the next upstream
+func (pr *PipelineRun) Record(resp *response.Response) {
+	if pr == nil || resp == nil {
+		return
+	}
+
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal([]byte(resp.ToJSON()), &fields)
+	if err != nil {
+		return
+	}
+
+	pr.mutex.Lock()
+	defer pr.mutex.Unlock()
+
+	pr.data = fields[pr.pipeline.field]
+}
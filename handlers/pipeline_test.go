@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupPipelineUpstreams(t *testing.T) (string, string, chan *http.Request, chan string, func()) {
+	received := make(chan *http.Request, 1)
+	receivedBody := make(chan string, 1)
+
+	first := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, `{"name": "first", "body": "step one output"}`)
+	}))
+
+	second := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		d, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		receivedBody <- string(d)
+
+		fmt.Fprint(rw, `{"name": "second"}`)
+	}))
+
+	return first.URL, second.URL, received, receivedBody, func() {
+		first.Close()
+		second.Close()
+	}
+}
+
+func TestPipelinePassesResponseToNextUpstreamInHeader(t *testing.T) {
+	first, second, received, _, cleanup := setupPipelineUpstreams(t)
+	defer cleanup()
+
+	h, _, _ := setupRequest(t, []string{first, second}, 0)
+	h.defaultClient = client.NewHTTP(false, false, 5*time.Second, 5*time.Second, false, client.DefaultSocketOptions, 0, nil, nil)
+	h.pipeline, _ = NewPipeline("body", PipelineHeader, "X-Pipeline-Data")
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	r := <-received
+	assert.Equal(t, http.MethodGet, r.Method)
+	assert.Equal(t, "step one output", r.Header.Get("X-Pipeline-Data"))
+}
+
+func TestPipelinePassesResponseToNextUpstreamInBody(t *testing.T) {
+	first, second, received, receivedBody, cleanup := setupPipelineUpstreams(t)
+	defer cleanup()
+
+	h, _, _ := setupRequest(t, []string{first, second}, 0)
+	h.defaultClient = client.NewHTTP(false, false, 5*time.Second, 5*time.Second, false, client.DefaultSocketOptions, 0, nil, nil)
+	h.pipeline, _ = NewPipeline("body", PipelineBody, "")
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	r := <-received
+	assert.Equal(t, http.MethodPost, r.Method)
+	assert.Equal(t, `"step one output"`, <-receivedBody)
+}
+
+func TestNewPipelineReturnsErrorForInvalidMode(t *testing.T) {
+	_, err := NewPipeline("body", "query", "")
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/nicholasjackson/fake-service/response"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ProtocolHTTP is used for upstreams serving HTTP
+	ProtocolHTTP = "http"
+	// ProtocolGRPC is used for upstreams serving gRPC
+	ProtocolGRPC = "grpc"
+)
+
+// UpstreamFunc calls the upstream at the given URI
+type UpstreamFunc func(uri string) (*response.Response, error)
+
+// ProtocolDetector probes upstreams with HTTP then gRPC and remembers the
+// protocol which works for each host, this allows upstreams configured with
+// the wrong scheme to be called
+type ProtocolDetector struct {
+	detected map[string]string
+	mutex    sync.Mutex
+}
+
+// NewProtocolDetector creates a new ProtocolDetector
+func NewProtocolDetector() *ProtocolDetector {
+	return &ProtocolDetector{detected: map[string]string{}}
+}
+
+// Do calls the upstream using the protocol detected for the host, until the
+// protocol is known both are tried. When d is nil the scheme of the URI is
+// used, https upstreams are always called with HTTP
+func (d *ProtocolDetector) Do(uri string, callHTTP, callGRPC UpstreamFunc) (*response.Response, error) {
+	if strings.HasPrefix(uri, "https://") {
+		return callHTTP(uri)
+	}
+
+	scheme := ProtocolGRPC
+	if strings.HasPrefix(uri, "http://") {
+		scheme = ProtocolHTTP
+	}
+
+	httpURI := "http://" + strings.TrimPrefix(strings.TrimPrefix(uri, "http://"), "grpc://")
+
+	if d == nil {
+		if scheme == ProtocolHTTP {
+			return callHTTP(uri)
+		}
+
+		return callGRPC(uri)
+	}
+
+	host := upstreamHost(uri)
+	switch d.Protocol(host) {
+	case ProtocolHTTP:
+		return callHTTP(httpURI)
+	case ProtocolGRPC:
+		return callGRPC(uri)
+	}
+
+	// any HTTP response even an error confirms the upstream serves HTTP
+	hr, herr := callHTTP(httpURI)
+	if hr != nil && hr.Code > 0 {
+		d.set(host, ProtocolHTTP)
+		return hr, herr
+	}
+
+	gr, gerr := callGRPC(uri)
+	if s, _ := status.FromError(gerr); s.Code() != codes.Unavailable && s.Code() != codes.Unknown {
+		d.set(host, ProtocolGRPC)
+		return gr, gerr
+	}
+
+	// neither protocol worked return the error for the configured scheme
+	if scheme == ProtocolHTTP {
+		return hr, herr
+	}
+
+	return gr, gerr
+}
+
+// Protocol returns the detected protocol for the host, an empty string is
+// returned when the protocol has not been detected
+func (d *ProtocolDetector) Protocol(host string) string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.detected[host]
+}
+
+func (d *ProtocolDetector) set(host, protocol string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.detected[host] = protocol
+}
+
+// upstreamHost returns the host and port of the upstream uri
+func upstreamHost(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return uri
+	}
+
+	return u.Host
+}
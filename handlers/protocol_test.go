@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/grpc/api"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func TestProtocolDetectorRecoversHTTPUpstreamServingGRPC(t *testing.T) {
+	// start a real gRPC upstream as the HTTP probe needs a TCP connection
+	up, _, _ := setupFakeServer(t, nil, 0)
+	up.name = "grpc-upstream"
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	s := grpc.NewServer()
+	api.RegisterFakeServiceServer(s, up)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	uri := "http://" + lis.Addr().String()
+
+	gc, err := client.NewGRPC(lis.Addr().String(), 5*time.Second, 5*time.Second, 4*1024*1024, client.DefaultSocketOptions, nil, "", "")
+	assert.NoError(t, err)
+
+	h, _, _ := setupRequest(t, []string{uri}, 0)
+	h.defaultClient = client.NewHTTP(false, false, 5*time.Second, 5*time.Second, false, client.DefaultSocketOptions, 0, nil, nil)
+	h.grpcClients[uri] = gc
+	h.protocols = NewProtocolDetector()
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "grpc-upstream", mr.UpstreamCalls[uri].Name)
+	assert.Equal(t, "gRPC", mr.UpstreamCalls[uri].Type)
+	assert.Equal(t, ProtocolGRPC, h.protocols.Protocol(lis.Addr().String()))
+}
+
+func TestProtocolDetectorUsesCachedProtocol(t *testing.T) {
+	d := NewProtocolDetector()
+	d.set("upstream:9090", ProtocolGRPC)
+
+	httpCalls := 0
+	_, err := d.Do(
+		"http://upstream:9090",
+		func(uri string) (*response.Response, error) {
+			httpCalls++
+			return nil, fmt.Errorf("boom")
+		},
+		func(uri string) (*response.Response, error) {
+			return &response.Response{}, nil
+		},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, httpCalls)
+}
+
+func TestProtocolDetectorRemembersHTTP(t *testing.T) {
+	d := NewProtocolDetector()
+
+	called := ""
+	d.Do(
+		"grpc://upstream:9090",
+		func(uri string) (*response.Response, error) {
+			called = uri
+			return &response.Response{Code: http.StatusOK}, nil
+		},
+		func(uri string) (*response.Response, error) {
+			return nil, context.Canceled
+		},
+	)
+
+	assert.Equal(t, "http://upstream:9090", called)
+	assert.Equal(t, ProtocolHTTP, d.Protocol("upstream:9090"))
+}
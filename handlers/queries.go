@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/nicholasjackson/fake-service/timing"
+)
+
+// Queries simulates the database queries made by a request without a real
+// database, each query takes a duration from its own latency distribution
+// and fails with the configured error rate. A failed query is reported in
+// the response but does not fail the request
+type Queries struct {
+	count     int
+	variance  int
+	duration  *timing.RequestDuration
+	errorRate float64
+	// randomFunc returns a random number in [0,max), floatFunc a random
+	// number in [0,1)
+	randomFunc func(max int) int
+	floatFunc  func() float64
+}
+
+// NewQueries creates Queries which makes count queries for each request plus
+// a random number of extra queries up to variance
+func NewQueries(count, variance int, duration *timing.RequestDuration, errorRate float64) *Queries {
+	return &Queries{
+		count:      count,
+		variance:   variance,
+		duration:   duration,
+		errorRate:  errorRate,
+		randomFunc: rand.Intn,
+		floatFunc:  rand.Float64,
+	}
+}
+
+// Do makes the queries for a request, the queries stop when ctx is done. A
+// nil Queries makes no queries and returns nil
+func (q *Queries) Do(ctx context.Context) *response.Queries {
+	if q == nil {
+		return nil
+	}
+
+	n := q.count
+	if q.variance > 0 {
+		n += q.randomFunc(q.variance + 1)
+	}
+
+	var total time.Duration
+	errors := 0
+	count := 0
+
+	for ; count < n; count++ {
+		d := q.duration.Calculate()
+		if err := sleepContext(ctx, d); err != nil {
+			break
+		}
+
+		total += d
+		if q.floatFunc() < q.errorRate {
+			errors++
+		}
+	}
+
+	return &response.Queries{
+		Count:    count,
+		Duration: total.String(),
+		Errors:   errors,
+	}
+}
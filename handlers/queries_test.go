@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/timing"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueriesReportsCountDurationAndErrors(t *testing.T) {
+	q := NewQueries(5, 0, timing.NewRequestDuration(time.Millisecond, 0, 0, 0), 0.5)
+
+	// every other query fails
+	n := 0
+	q.floatFunc = func() float64 {
+		n++
+		return float64(n%2) * 0.9
+	}
+
+	resp := q.Do(context.Background())
+
+	assert.Equal(t, 5, resp.Count)
+	assert.Equal(t, "5ms", resp.Duration)
+	assert.Equal(t, 2, resp.Errors)
+}
+
+func TestQueriesAddsVariance(t *testing.T) {
+	q := NewQueries(2, 3, timing.NewRequestDuration(time.Millisecond, 0, 0, 0), 0)
+	q.randomFunc = func(max int) int { return max - 1 }
+
+	resp := q.Do(context.Background())
+
+	assert.Equal(t, 5, resp.Count)
+	assert.Equal(t, 0, resp.Errors)
+}
+
+func TestQueriesStopWhenContextIsDone(t *testing.T) {
+	q := NewQueries(5, 0, timing.NewRequestDuration(time.Hour, 0, 0, 0), 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	resp := q.Do(ctx)
+
+	assert.Equal(t, 0, resp.Count)
+}
+
+func TestNilQueriesMakesNoQueries(t *testing.T) {
+	var q *Queries
+
+	assert.Nil(t, q.Do(context.Background()))
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// ErrorQuotaExceeded is returned when the quota of the window has been used
+const ErrorQuotaExceeded = "Quota exceeded"
+
+// Quota models a hard API quota, the first limit requests in each window are
+// passed to next and the rest are rejected with 429 until the window rolls
+// over. Windows start at multiples of window on the wall clock. Every
+// response reports the quota with the X-RateLimit headers, X-RateLimit-Reset
+// is the Unix time in seconds at which the quota resets
+type Quota struct {
+	logger *logging.Logger
+	name   string
+	limit  int
+	window time.Duration
+	now    func() time.Time
+	mutex  sync.Mutex
+	start  time.Time
+	used   int
+	next   http.HandlerFunc
+}
+
+// NewQuota creates a new Quota handler
+func NewQuota(logger *logging.Logger, name string, limit int, window time.Duration, next http.HandlerFunc) *Quota {
+	return &Quota{
+		logger: logger,
+		name:   name,
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		next:   next,
+	}
+}
+
+// Handle the request
+func (q *Quota) Handle(rw http.ResponseWriter, r *http.Request) {
+	allowed, remaining, reset := q.take()
+
+	rw.Header().Set("X-RateLimit-Limit", strconv.Itoa(q.limit))
+	rw.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	rw.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+
+	if allowed {
+		q.next(rw, r)
+		return
+	}
+
+	q.logger.Log().Info("Rejecting request over quota", "limit", q.limit, "reset", reset)
+
+	resp := &response.Response{
+		Name:  q.name,
+		Type:  "HTTP",
+		URI:   r.URL.String(),
+		Code:  http.StatusTooManyRequests,
+		Error: ErrorQuotaExceeded,
+	}
+
+	rw.Header().Set("Retry-After", retryAfterSeconds(reset.Sub(q.now())))
+	rw.Header().Set("Content-Type", "application/json")
+	writeResponse(rw, r, http.StatusTooManyRequests, []byte(resp.ToJSON()))
+}
+
+// take uses one request of the quota, it returns false when the quota of the
+// current window has been used, the remaining quota, and the time the quota
+// resets
+func (q *Quota) take() (bool, int, time.Time) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// start a new window once the current one has passed
+	now := q.now()
+	if start := now.Truncate(q.window); !start.Equal(q.start) {
+		q.start = start
+		q.used = 0
+	}
+
+	reset := q.start.Add(q.window)
+	if q.used >= q.limit {
+		return false, 0, reset
+	}
+
+	q.used++
+	return true, q.limit - q.used, reset
+}
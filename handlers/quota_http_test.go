@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupQuota(t *testing.T, limit int) (*Quota, *time.Time) {
+	q := NewQuota(
+		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
+		"test",
+		limit,
+		time.Minute,
+		func(rw http.ResponseWriter, r *http.Request) { fmt.Fprint(rw, "OK") },
+	)
+
+	now := time.Date(2020, 1, 1, 12, 0, 10, 0, time.UTC)
+	q.now = func() time.Time { return now }
+
+	return q, &now
+}
+
+func TestQuotaRejectsRequestsOverQuotaUntilReset(t *testing.T) {
+	q, now := setupQuota(t, 2)
+	reset := strconv.FormatInt(time.Date(2020, 1, 1, 12, 1, 0, 0, time.UTC).Unix(), 10)
+
+	for _, remaining := range []string{"1", "0"} {
+		rr := httptest.NewRecorder()
+		q.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "OK", rr.Body.String())
+		assert.Equal(t, remaining, rr.Header().Get("X-RateLimit-Remaining"))
+		assert.Equal(t, reset, rr.Header().Get("X-RateLimit-Reset"))
+	}
+
+	rr := httptest.NewRecorder()
+	q.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, reset, rr.Header().Get("X-RateLimit-Reset"))
+	assert.Equal(t, "50", rr.Header().Get("Retry-After"))
+
+	resp := &response.Response{}
+	assert.NoError(t, resp.FromJSON(rr.Body.Bytes()))
+	assert.Equal(t, ErrorQuotaExceeded, resp.Error)
+
+	// the quota resets when the window rolls over
+	*now = now.Add(50 * time.Second)
+
+	rr = httptest.NewRecorder()
+	q.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("X-RateLimit-Remaining"))
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/go-hclog"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RateLimitInterceptor enforces a token bucket rate limit on unary gRPC
+// calls before they reach the service, calls over the limit fail with
+// ResourceExhausted and a RetryInfo detail with the time until the next
+// token is available
+type RateLimitInterceptor struct {
+	logger  hclog.Logger
+	limiter *rate.Limiter
+}
+
+// NewRateLimitInterceptor creates a RateLimitInterceptor which allows rps
+// calls per second, the bucket holds one second of calls like the HTTP rate
+// limit
+func NewRateLimitInterceptor(rps float64, logger hclog.Logger) *RateLimitInterceptor {
+	burst := int(rps)
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RateLimitInterceptor{logger: logger, limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+}
+
+// Unary is a grpc.UnaryServerInterceptor which rate limits the calls
+func (i *RateLimitInterceptor) Unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	now := time.Now()
+
+	r := i.limiter.ReserveN(now, 1)
+	delay := r.DelayFrom(now)
+	if delay == 0 {
+		return handler(ctx, req)
+	}
+
+	// the call is rejected so the reserved token is returned
+	r.CancelAt(now)
+
+	i.logger.Info("Rate limiting gRPC call", "method", info.FullMethod, "retry_delay", delay)
+
+	s := status.New(codes.ResourceExhausted, "rate limit exceeded")
+	if ds, err := s.WithDetails(&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(delay)}); err == nil {
+		s = ds
+	}
+
+	return nil, s.Err()
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func callRateLimited(i *RateLimitInterceptor) error {
+	_, err := i.Unary(
+		context.Background(),
+		nil,
+		&grpc.UnaryServerInfo{FullMethod: "/FakeService/Handle"},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil },
+	)
+
+	return err
+}
+
+func TestRateLimitInterceptorRejectsCallsOverLimit(t *testing.T) {
+	i := NewRateLimitInterceptor(10, hclog.NewNullLogger())
+
+	// the bucket holds one second of calls
+	for n := 0; n < 10; n++ {
+		assert.NoError(t, callRateLimited(i))
+	}
+
+	err := callRateLimited(i)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	details := status.Convert(err).Details()
+	assert.Len(t, details, 1)
+
+	ri, ok := details[0].(*errdetails.RetryInfo)
+	assert.True(t, ok)
+
+	delay, _ := ptypes.Duration(ri.RetryDelay)
+	assert.Greater(t, int64(delay), int64(0))
+	assert.LessOrEqual(t, int64(delay), int64(100*time.Millisecond))
+
+	// a token is available again after the retry delay
+	time.Sleep(delay)
+	assert.NoError(t, callRateLimited(i))
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// ReadOnlyPath is the path of the control endpoint which reports and
+// toggles read-only mode
+const ReadOnlyPath = "/control/read-only"
+
+// ReadOnlyState is the body of the read-only control endpoint
+type ReadOnlyState struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+// ReadOnly models a service whose data store has failed over to a read-only
+// replica, while enabled requests which modify data are rejected with the
+// configured code and all other requests are passed to next
+type ReadOnly struct {
+	logger  *logging.Logger
+	name    string
+	code    int
+	message string
+	enabled int32
+	next    http.HandlerFunc
+}
+
+// NewReadOnly creates a new ReadOnly handler
+func NewReadOnly(logger *logging.Logger, name string, enabled bool, code int, message string, next http.HandlerFunc) *ReadOnly {
+	ro := &ReadOnly{logger: logger, name: name, code: code, message: message, next: next}
+	ro.Set(enabled)
+
+	return ro
+}
+
+// Enabled returns true when the service is in read-only mode
+func (ro *ReadOnly) Enabled() bool {
+	return atomic.LoadInt32(&ro.enabled) == 1
+}
+
+// Set enables or disables read-only mode
+func (ro *ReadOnly) Set(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+
+	atomic.StoreInt32(&ro.enabled, v)
+}
+
+// Handle the request
+func (ro *ReadOnly) Handle(rw http.ResponseWriter, r *http.Request) {
+	if !ro.Enabled() || !isMutating(r.Method) {
+		ro.next(rw, r)
+		return
+	}
+
+	ro.logger.Log().Info("Rejecting request in read-only mode", "method", r.Method, "path", r.URL.Path)
+
+	resp := &response.Response{
+		Name:  ro.name,
+		Type:  "HTTP",
+		URI:   r.URL.String(),
+		Code:  ro.code,
+		Error: ro.message,
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	writeResponse(rw, r, ro.code, []byte(resp.ToJSON()))
+}
+
+// ControlHandle reports the read-only state for a GET request and sets it
+// from the JSON body of a PUT or POST request
+func (ro *ReadOnly) ControlHandle(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		s := ReadOnlyState{}
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(rw, "invalid read-only state: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ro.logger.Log().Info("Read-only mode changed", "read_only", s.ReadOnly)
+		ro.Set(s.ReadOnly)
+	default:
+		rw.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(ReadOnlyState{ReadOnly: ro.Enabled()})
+}
+
+// isMutating returns true for the methods which modify data
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+
+	return false
+}
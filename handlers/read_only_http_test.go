@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupReadOnly(t *testing.T, enabled bool) *ReadOnly {
+	return NewReadOnly(
+		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
+		"test",
+		enabled,
+		http.StatusServiceUnavailable,
+		"read-only mode",
+		func(rw http.ResponseWriter, r *http.Request) { fmt.Fprint(rw, "OK") },
+	)
+}
+
+func TestReadOnlyAllowsReadsAndRejectsWrites(t *testing.T) {
+	ro := setupReadOnly(t, true)
+
+	rr := httptest.NewRecorder()
+	ro.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "OK", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	ro.Handle(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}")))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	resp := &response.Response{}
+	err := resp.FromJSON(rr.Body.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, "read-only mode", resp.Error)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.Code)
+}
+
+func TestReadOnlyDisabledAllowsWrites(t *testing.T) {
+	ro := setupReadOnly(t, false)
+
+	rr := httptest.NewRecorder()
+	ro.Handle(rr, httptest.NewRequest(http.MethodDelete, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "OK", rr.Body.String())
+}
+
+func TestReadOnlyControlTogglesMode(t *testing.T) {
+	ro := setupReadOnly(t, false)
+
+	rr := httptest.NewRecorder()
+	ro.ControlHandle(rr, httptest.NewRequest(http.MethodPut, ReadOnlyPath, strings.NewReader(`{"read_only": true}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"read_only": true}`, rr.Body.String())
+	assert.True(t, ro.Enabled())
+
+	rr = httptest.NewRecorder()
+	ro.Handle(rr, httptest.NewRequest(http.MethodPut, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	rr = httptest.NewRecorder()
+	ro.ControlHandle(rr, httptest.NewRequest(http.MethodPut, ReadOnlyPath, strings.NewReader(`{"read_only": false}`)))
+	assert.False(t, ro.Enabled())
+
+	rr = httptest.NewRecorder()
+	ro.ControlHandle(rr, httptest.NewRequest(http.MethodGet, ReadOnlyPath, nil))
+	assert.JSONEq(t, `{"read_only": false}`, rr.Body.String())
+}
+
+func TestReadOnlyControlRejectsInvalidBody(t *testing.T) {
+	ro := setupReadOnly(t, false)
+
+	rr := httptest.NewRecorder()
+	ro.ControlHandle(rr, httptest.NewRequest(http.MethodPost, ReadOnlyPath, strings.NewReader("nope")))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.False(t, ro.Enabled())
+}
@@ -2,7 +2,11 @@ package handlers
 
 import (
 	"fmt"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/nicholasjackson/fake-service/logging"
@@ -11,6 +15,8 @@ import (
 const (
 	OKMessage       = "OK"
 	StartingMessage = "Starting Process"
+	WaitingMessage  = "Waiting for dependency"
+	FlappingMessage = "Dependency unstable"
 )
 
 // Health defines the health handler for the service
@@ -19,15 +25,37 @@ type Ready struct {
 	statusCode    int
 	statusMessage string
 	delay         time.Duration
+	// dependencyFile when set the service is not ready until the file exists
+	// and, if dependencyContent is set, contains the expected content
+	dependencyFile    string
+	dependencyContent string
+	// startup when set the service is not ready until it has started
+	startup *Startup
+	// flapRate is the probability the service is not ready each time the
+	// readiness is chosen, the choice is held for at least flapDwell
+	flapRate   float64
+	flapDwell  time.Duration
+	flapped    bool
+	flapSince  time.Time
+	flapMutex  sync.Mutex
+	randomFunc func() float64
+	nowFunc    func() time.Time
 }
 
 // NewReady creates a new ready handler
-func NewReady(logger *logging.Logger, code int, delay time.Duration) *Ready {
+func NewReady(logger *logging.Logger, code int, delay time.Duration, dependencyFile, dependencyContent string, startup *Startup, flapRate float64, flapDwell time.Duration) *Ready {
 	r := &Ready{
-		logger:        logger,
-		statusCode:    code,
-		statusMessage: OKMessage,
-		delay:         delay,
+		logger:            logger,
+		statusCode:        code,
+		statusMessage:     OKMessage,
+		delay:             delay,
+		dependencyFile:    dependencyFile,
+		dependencyContent: dependencyContent,
+		startup:           startup,
+		flapRate:          flapRate,
+		flapDwell:         flapDwell,
+		randomFunc:        rand.Float64,
+		nowFunc:           time.Now,
 	}
 
 	if delay != 0 {
@@ -48,11 +76,71 @@ func NewReady(logger *logging.Logger, code int, delay time.Duration) *Ready {
 func (h *Ready) Handle(rw http.ResponseWriter, r *http.Request) {
 	hq := h.logger.CallReadyHTTP()
 
-	hq.SetMetadata("response", fmt.Sprintf("%d", h.statusCode))
+	code := h.statusCode
+	message := h.statusMessage
 
-	rw.WriteHeader(h.statusCode)
-	fmt.Fprint(rw, h.statusMessage)
+	// requests are held while the service is starting
+	if !h.startup.Started() {
+		code = http.StatusServiceUnavailable
+		message = StartingMessage
+	}
+
+	// the dependency is checked on every probe so readiness changes as soon
+	// as the file is written, the startup delay takes precedence
+	if message == OKMessage && !h.dependencyReady() {
+		code = http.StatusServiceUnavailable
+		message = WaitingMessage
+	}
+
+	// an unstable dependency randomly flaps the readiness
+	if message == OKMessage && h.flapping() {
+		code = http.StatusServiceUnavailable
+		message = FlappingMessage
+	}
+
+	hq.SetMetadata("response", fmt.Sprintf("%d", code))
 
-	hq.SetMetadata("code", fmt.Sprintf("%d", h.statusCode))
+	rw.WriteHeader(code)
+	fmt.Fprint(rw, message)
+
+	hq.SetMetadata("code", fmt.Sprintf("%d", code))
 	hq.Finished()
 }
+
+// flapping returns true when the service is flapped to not ready, the state
+// is chosen again once it has been held for the dwell time
+func (h *Ready) flapping() bool {
+	if h.flapRate <= 0 {
+		return false
+	}
+
+	h.flapMutex.Lock()
+	defer h.flapMutex.Unlock()
+
+	now := h.nowFunc()
+	if h.flapSince.IsZero() || now.Sub(h.flapSince) >= h.flapDwell {
+		h.flapped = h.randomFunc() < h.flapRate
+		h.flapSince = now
+	}
+
+	return h.flapped
+}
+
+// dependencyReady returns true when no dependency file is configured or the
+// file exists with the expected content
+func (h *Ready) dependencyReady() bool {
+	if h.dependencyFile == "" {
+		return true
+	}
+
+	d, err := ioutil.ReadFile(h.dependencyFile)
+	if err != nil {
+		return false
+	}
+
+	if h.dependencyContent != "" && strings.TrimSpace(string(d)) != h.dependencyContent {
+		return false
+	}
+
+	return true
+}
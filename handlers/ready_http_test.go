@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -16,6 +19,11 @@ func setupReady(t *testing.T, code int, delay time.Duration) *Ready {
 		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
 		code,
 		delay,
+		"",
+		"",
+		nil,
+		0,
+		0,
 	)
 }
 
@@ -64,3 +72,89 @@ func TestReadyReturnsOKResponseWhenDelayElapsed(t *testing.T) {
 	// this test is not coded to a fixed amount due to varing speeds on CI
 	assert.Greater(t, calls, 1)
 }
+
+func TestReadyReturnsUnavailableWhenDependencyFileMissing(t *testing.T) {
+	h := setupReady(t, http.StatusOK, 0)
+	h.dependencyFile = filepath.Join(t.TempDir(), "ready")
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, WaitingMessage, rr.Body.String())
+}
+
+func TestReadyReturnsOKWhenDependencyFileWritten(t *testing.T) {
+	h := setupReady(t, http.StatusOK, 0)
+	h.dependencyFile = filepath.Join(t.TempDir(), "ready")
+	h.dependencyContent = "done"
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	// unexpected content is not ready
+	ioutil.WriteFile(h.dependencyFile, []byte("pending"), 0644)
+	rr = httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+
+	ioutil.WriteFile(h.dependencyFile, []byte("done\n"), 0644)
+	rr = httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, OKMessage, rr.Body.String())
+}
+
+func TestReadyReturnsUnavailableResponseUntilStarted(t *testing.T) {
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+	st := NewStartup(l, 50*time.Millisecond, nil)
+	h := NewReady(l, http.StatusOK, 0, "", "", st, 0, 0)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, StartingMessage, rr.Body.String())
+
+	time.Sleep(100 * time.Millisecond)
+
+	rr = httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestReadyFlapsAtRateAndHoldsForDwell(t *testing.T) {
+	h := setupReady(t, http.StatusOK, 0)
+	h.flapRate = 0.25
+	h.flapDwell = 10 * time.Second
+	h.randomFunc = rand.New(rand.NewSource(1)).Float64
+
+	now := time.Now()
+	h.nowFunc = func() time.Time { return now }
+
+	probe := func() int {
+		rr := httptest.NewRecorder()
+		h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rr.Code
+	}
+
+	unavailable := 0
+	for i := 0; i < 1000; i++ {
+		code := probe()
+		if code == http.StatusServiceUnavailable {
+			unavailable++
+		}
+
+		// probes inside the dwell time do not change the state
+		now = now.Add(3 * time.Second)
+		assert.Equal(t, code, probe())
+		now = now.Add(3 * time.Second)
+		assert.Equal(t, code, probe())
+
+		now = now.Add(4 * time.Second)
+	}
+
+	assert.InDelta(t, 250, unavailable, 50)
+}
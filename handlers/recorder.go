@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+const (
+	// RecordModeRecord writes every request and response to the record file
+	RecordModeRecord = "record"
+	// RecordModeReplay returns responses from the record file
+	RecordModeReplay = "replay"
+)
+
+// Recording is a request and the response produced by the service, one
+// recording is written per line of the record file
+type Recording struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Query   string            `json:"query,omitempty"`
+	Code    int               `json:"code"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body"`
+}
+
+// Recorder records responses produced by the service including the upstream
+// calls, or replays them without calling the service so that demos can be run
+// offline
+type Recorder struct {
+	logger *logging.Logger
+	mode   string
+	file   string
+	match  []string
+	next   http.HandlerFunc
+
+	recordings map[string]Recording
+	mutex      sync.Mutex
+}
+
+// NewRecorder creates a new Recorder, match is the list of request
+// attributes used to match recordings [method, path, query]. In replay mode
+// the recordings are loaded from file, requests without a recording are
+// passed to next
+func NewRecorder(logger *logging.Logger, mode, file string, match []string, next http.HandlerFunc) (*Recorder, error) {
+	if mode != RecordModeRecord && mode != RecordModeReplay {
+		return nil, fmt.Errorf("invalid record mode %s, expected %s or %s", mode, RecordModeRecord, RecordModeReplay)
+	}
+
+	for _, m := range match {
+		if m != "method" && m != "path" && m != "query" {
+			return nil, fmt.Errorf("invalid record match %s, expected method, path, or query", m)
+		}
+	}
+
+	r := &Recorder{
+		logger:     logger,
+		mode:       mode,
+		file:       file,
+		match:      match,
+		next:       next,
+		recordings: map[string]Recording{},
+	}
+
+	if mode == RecordModeReplay {
+		err := r.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Handle the request
+func (rc *Recorder) Handle(rw http.ResponseWriter, r *http.Request) {
+	if rc.mode == RecordModeReplay {
+		rc.replay(rw, r)
+		return
+	}
+
+	rec := &recordingWriter{ResponseWriter: rw, code: http.StatusOK}
+	rc.next(rec, r)
+
+	// the response was written directly to the connection
+	if rec.hijacked {
+		rc.logger.Log().Debug("Not recording hijacked response", "method", r.Method, "path", r.URL.Path)
+		return
+	}
+
+	headers := map[string]string{}
+	for k, v := range rw.Header() {
+		headers[k] = strings.Join(v, ",")
+	}
+
+	err := rc.write(Recording{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Query:   r.URL.RawQuery,
+		Code:    rec.code,
+		Headers: headers,
+		Body:    rec.body.String(),
+	})
+
+	if err != nil {
+		rc.logger.Log().Error("Unable to write recording", "file", rc.file, "error", err)
+	}
+}
+
+func (rc *Recorder) replay(rw http.ResponseWriter, r *http.Request) {
+	rec, ok := rc.recordings[rc.key(r.Method, r.URL.Path, r.URL.RawQuery)]
+	if !ok {
+		rc.logger.Log().Debug("No recording for request", "method", r.Method, "path", r.URL.Path)
+		rc.next(rw, r)
+		return
+	}
+
+	for k, v := range rec.Headers {
+		rw.Header().Set(k, v)
+	}
+
+	rw.WriteHeader(rec.Code)
+	rw.Write([]byte(rec.Body))
+}
+
+// write appends the recording to the record file
+func (rc *Recorder) write(rec Recording) error {
+	d, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	f, err := os.OpenFile(rc.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(d, '\n'))
+	return err
+}
+
+// load reads the recordings from the record file, when a request was
+// recorded more than once the last recording is used
+func (rc *Recorder) load() error {
+	f, err := os.Open(rc.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 64*1024*1024)
+
+	for s.Scan() {
+		if len(bytes.TrimSpace(s.Bytes())) == 0 {
+			continue
+		}
+
+		rec := Recording{}
+		err := json.Unmarshal(s.Bytes(), &rec)
+		if err != nil {
+			return fmt.Errorf("unable to read recording from %s: %s", rc.file, err)
+		}
+
+		rc.recordings[rc.key(rec.Method, rec.Path, rec.Query)] = rec
+	}
+
+	return s.Err()
+}
+
+// key returns the key used to match requests with recordings
+func (rc *Recorder) key(method, path, query string) string {
+	parts := []string{}
+	for _, m := range rc.match {
+		switch m {
+		case "method":
+			parts = append(parts, method)
+		case "path":
+			parts = append(parts, path)
+		case "query":
+			parts = append(parts, query)
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// recordingWriter captures the status code and body written to the
+// ResponseWriter
+type recordingWriter struct {
+	http.ResponseWriter
+	code     int
+	body     bytes.Buffer
+	hijacked bool
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(d []byte) (int, error) {
+	w.body.Write(d)
+	return w.ResponseWriter.Write(d)
+}
+
+// Flush sends any buffered data to the client when the underlying
+// ResponseWriter supports it
+func (w *recordingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection of the underlying ResponseWriter, the
+// response written to a hijacked connection is not captured
+func (w *recordingWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("the connection does not support hijacking")
+	}
+
+	w.hijacked = true
+
+	return hj.Hijack()
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorderReplaysRecordedResponse(t *testing.T) {
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+	file := filepath.Join(t.TempDir(), "recordings.jsonl")
+
+	rc, err := NewRecorder(l, RecordModeRecord, file, []string{"method", "path"}, func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprint(rw, `{"name": "recorded", "upstream_calls": {"http://api": {"name": "api"}}}`)
+	})
+	assert.NoError(t, err)
+
+	recorded := httptest.NewRecorder()
+	rc.Handle(recorded, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	rp, err := NewRecorder(l, RecordModeReplay, file, []string{"method", "path"}, func(rw http.ResponseWriter, r *http.Request) {
+		t.Fatal("replayed request should not call the service")
+	})
+	assert.NoError(t, err)
+
+	replayed := httptest.NewRecorder()
+	rp.Handle(replayed, httptest.NewRequest(http.MethodGet, "/orders?id=1", nil))
+
+	assert.Equal(t, recorded.Code, replayed.Code)
+	assert.Equal(t, recorded.Body.String(), replayed.Body.String())
+	assert.Equal(t, "application/json", replayed.Header().Get("Content-Type"))
+}
+
+func TestRecorderPassesUnmatchedRequestsToService(t *testing.T) {
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+	file := filepath.Join(t.TempDir(), "recordings.jsonl")
+
+	rc, _ := NewRecorder(l, RecordModeRecord, file, []string{"method", "path"}, func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "recorded")
+	})
+	rc.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	rp, _ := NewRecorder(l, RecordModeReplay, file, []string{"method", "path"}, func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(rw, "live")
+	})
+
+	rr := httptest.NewRecorder()
+	rp.Handle(rr, httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+	assert.Equal(t, "live", rr.Body.String())
+}
+
+func TestNewRecorderReturnsErrorForInvalidMatch(t *testing.T) {
+	l := logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil)
+
+	_, err := NewRecorder(l, RecordModeRecord, "", []string{"header"}, nil)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// RedirectPath is the path prefix for the redirect chain handler
+const RedirectPath = "/redirect/"
+
+// Redirect returns a chain of 302 redirects, /redirect/3 redirects to
+// /redirect/2 and so on until /redirect/0 which is handled by next
+type Redirect struct {
+	logger   *logging.Logger
+	maxChain int
+	next     http.HandlerFunc
+}
+
+// NewRedirect creates a new redirect handler, requests for /redirect/ with no
+// count start a chain of maxChain redirects
+func NewRedirect(logger *logging.Logger, maxChain int, next http.HandlerFunc) *Redirect {
+	return &Redirect{
+		logger:   logger,
+		maxChain: maxChain,
+		next:     next,
+	}
+}
+
+// Handle the request
+func (rd *Redirect) Handle(rw http.ResponseWriter, r *http.Request) {
+	n := rd.maxChain
+
+	c := strings.TrimPrefix(r.URL.Path, RedirectPath)
+	if c != "" {
+		var err error
+		n, err = strconv.Atoi(c)
+		if err != nil || n < 0 || n > rd.maxChain {
+			rw.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(rw, "redirect count must be a number between 0 and %d", rd.maxChain)
+			return
+		}
+	}
+
+	// end of the chain, return the service response
+	if n == 0 {
+		rd.next(rw, r)
+		return
+	}
+
+	loc := fmt.Sprintf("%s%d", RedirectPath, n-1)
+	if r.URL.RawQuery != "" {
+		loc = loc + "?" + r.URL.RawQuery
+	}
+
+	rd.logger.Log().Debug("Redirecting request", "location", loc)
+
+	http.Redirect(rw, r, loc, http.StatusFound)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupRedirect(t *testing.T, maxChain int) *httptest.Server {
+	rd := NewRedirect(
+		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
+		maxChain,
+		func(rw http.ResponseWriter, r *http.Request) { fmt.Fprint(rw, "done") },
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(RedirectPath, rd.Handle)
+
+	return httptest.NewServer(mux)
+}
+
+func TestRedirectFollowsChainToFinalResponse(t *testing.T) {
+	ts := setupRedirect(t, 5)
+	defer ts.Close()
+
+	hops := []string{}
+	c := &http.Client{
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			hops = append(hops, r.URL.Path)
+			return nil
+		},
+	}
+
+	resp, err := c.Get(ts.URL + "/redirect/3")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"/redirect/2", "/redirect/1", "/redirect/0"}, hops)
+}
+
+func TestRedirectWithNoCountStartsAtMaxChain(t *testing.T) {
+	ts := setupRedirect(t, 2)
+	defer ts.Close()
+
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	resp, err := c.Get(ts.URL + "/redirect/")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusFound, resp.StatusCode)
+	assert.Equal(t, "/redirect/1", resp.Header.Get("Location"))
+}
+
+func TestRedirectReturnsBadRequestOverMaxChain(t *testing.T) {
+	ts := setupRedirect(t, 2)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/redirect/3")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -2,10 +2,8 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"math/rand"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/nicholasjackson/fake-service/client"
@@ -14,9 +12,12 @@ import (
 	"github.com/nicholasjackson/fake-service/load"
 	"github.com/nicholasjackson/fake-service/logging"
 	"github.com/nicholasjackson/fake-service/response"
+	"github.com/nicholasjackson/fake-service/scenario"
 	"github.com/nicholasjackson/fake-service/timing"
 	"github.com/nicholasjackson/fake-service/worker"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -32,32 +33,201 @@ type FakeServer struct {
 	errorInjector *errors.Injector
 	loadGenerator *load.Generator
 	log           *logging.Logger
+	// omitFields are removed from the response to simulate partial data
+	omitFields []string
+	// workUnits is the number of CPU bound work units performed per request
+	workUnits int
+	// queries when set simulates the database queries made by each request
+	queries *Queries
+	// retries when set retries failed upstream calls
+	retries *Retries
+
+	// hedging when set sends a second request to slow upstreams
+	hedging *Hedging
+	// migration when set reshapes the response during and after a schema
+	// migration
+	migration *response.Migration
+	// mirror sends a copy of the request to a shadow upstream
+	mirror *Mirror
+	// tailLatency adds an extra delay to a fraction of requests
+	tailLatency *timing.TailLatency
+	// degradation slows requests down as errors accumulate
+	degradation *timing.Degradation
+	// namePool when set the response name is chosen at random from the pool
+	namePool []string
+	// echoMetadata adds the incoming request metadata to the response, the
+	// values of any keys in redactMetadata are masked
+	echoMetadata   bool
+	redactMetadata []string
+	// transforms reshape upstream responses keyed by upstream URI
+	transforms map[string][]response.Transform
+	// workerQueueSize bounds the upstream worker queue, 0 is unbounded
+	workerQueueSize int
+	// reportWorkerQueue adds the worker queue statistics to the response
+	reportWorkerQueue bool
+	// circuits when set wraps each upstream call in a circuit breaker
+	circuits *Circuits
+	// body when set renders the response body in place of message
+	body *Body
+	// scenario steps through a timeline of errors and delays
+	scenario *scenario.Engine
+	// pipeline when set passes data from each upstream response to the next
+	pipeline *Pipeline
+	// protocols when set detects whether upstreams serve HTTP or gRPC
+	// regardless of the URI scheme
+	protocols *ProtocolDetector
+	// upstreamWeights when set reports the critical path of the upstream
+	// calls, each upstream duration is multiplied by its weight
+	upstreamWeights map[string]float64
+	// budget is the total time allowed for the upstream calls, upstreams not
+	// called before the budget or the request deadline expires are skipped
+	budget time.Duration
+	// reportDeadline adds the time remaining before the deadline when each
+	// upstream was called to the upstream response
+	reportDeadline bool
+	// rawBodyLimit is the number of bytes of a non JSON upstream response
+	// wrapped in the upstream response, 0 does not wrap the body
+	rawBodyLimit int
+	// schemaVersion is the response schema version used when the request
+	// does not ask for a version, 0 is the latest version
+	schemaVersion int
+	// requestLoad when set generates the CPU and memory load requested by the
+	// load headers of each request
+	requestLoad *load.RequestLoad
+	// splitBrain when set returns a stale message for a fraction of requests
+	splitBrain *SplitBrain
+	// upstreamRepeat is the number of times each upstream is called for a
+	// request, the repeated responses are grouped under the upstream
+	upstreamRepeat int
+	// upstreamStats when true summarizes the upstream latencies in the
+	// response
+	upstreamStats bool
+	// clockSkew offsets the reported start and end times to model a clock
+	// which is ahead or behind, the duration is not affected
+	clockSkew time.Duration
+	// requestAllocation when set allocates memory for each request which is
+	// attributed to the request in heap profiles
+	requestAllocation *load.RequestAllocation
+	// fileAllocation when set opens file descriptors for each request which
+	// are held until the request completes
+	fileAllocation *load.FileAllocation
+	// shuffle when set randomizes the order upstreams are dispatched to the
+	// workers, the order is reproducible for a seed
+	shuffle *worker.Shuffle
+	// healthChecks when set routes requests only to healthy upstreams
+	healthChecks *HealthChecks
+	// reportCompression adds the compression of the request and response to
+	// the response, forceCompression is true when the server always
+	// compresses responses with gzip
+	reportCompression bool
+	forceCompression  bool
+	// partialSuccess when true returns a successful response when at least
+	// one upstream succeeds, the status of each upstream is preserved
+	partialSuccess bool
 }
 
-// NewFakeServer creates a new instance of FakeServer
-func NewFakeServer(
-	name, message string,
-	duration *timing.RequestDuration,
-	upstreamURIs []string,
-	workerCount int,
-	defaultClient client.HTTP,
-	grpcClients map[string]client.GRPC,
-	i *errors.Injector,
-	loadGenerator *load.Generator,
-	l *logging.Logger,
-) *FakeServer {
+// FakeServerConfig configures a FakeServer, each field sets the FakeServer
+// field of the same name and the zero value disables the optional features
+type FakeServerConfig struct {
+	Name              string
+	Message           string
+	Duration          *timing.RequestDuration
+	UpstreamURIs      []string
+	WorkerCount       int
+	DefaultClient     client.HTTP
+	GRPCClients       map[string]client.GRPC
+	ErrorInjector     *errors.Injector
+	LoadGenerator     *load.Generator
+	Log               *logging.Logger
+	OmitFields        []string
+	WorkUnits         int
+	Mirror            *Mirror
+	TailLatency       *timing.TailLatency
+	EchoMetadata      bool
+	RedactMetadata    []string
+	Degradation       *timing.Degradation
+	NamePool          []string
+	Transforms        map[string][]response.Transform
+	WorkerQueueSize   int
+	ReportWorkerQueue bool
+	Circuits          *Circuits
+	Body              *Body
+	Scenario          *scenario.Engine
+	Pipeline          *Pipeline
+	Protocols         *ProtocolDetector
+	UpstreamWeights   map[string]float64
+	Budget            time.Duration
+	RawBodyLimit      int
+	SchemaVersion     int
+	RequestLoad       *load.RequestLoad
+	SplitBrain        *SplitBrain
+	UpstreamRepeat    int
+	UpstreamStats     bool
+	ClockSkew         time.Duration
+	RequestAllocation *load.RequestAllocation
+	ReportCompression bool
+	ForceCompression  bool
+	PartialSuccess    bool
+	FileAllocation    *load.FileAllocation
+	Shuffle           *worker.Shuffle
+	HealthChecks      *HealthChecks
+	ReportDeadline    bool
+	Queries           *Queries
+	Retries           *Retries
+	Migration         *response.Migration
+	Hedging           *Hedging
+}
 
+// NewFakeServer creates a new instance of FakeServer
+func NewFakeServer(c FakeServerConfig) *FakeServer {
 	return &FakeServer{
-		name:          name,
-		message:       message,
-		duration:      duration,
-		upstreamURIs:  upstreamURIs,
-		workerCount:   workerCount,
-		defaultClient: defaultClient,
-		grpcClients:   grpcClients,
-		errorInjector: i,
-		loadGenerator: loadGenerator,
-		log:           l,
+		name:              c.Name,
+		message:           c.Message,
+		duration:          c.Duration,
+		upstreamURIs:      c.UpstreamURIs,
+		workerCount:       c.WorkerCount,
+		defaultClient:     c.DefaultClient,
+		grpcClients:       c.GRPCClients,
+		errorInjector:     c.ErrorInjector,
+		loadGenerator:     c.LoadGenerator,
+		log:               c.Log,
+		omitFields:        c.OmitFields,
+		workUnits:         c.WorkUnits,
+		mirror:            c.Mirror,
+		tailLatency:       c.TailLatency,
+		echoMetadata:      c.EchoMetadata,
+		redactMetadata:    c.RedactMetadata,
+		degradation:       c.Degradation,
+		namePool:          c.NamePool,
+		transforms:        c.Transforms,
+		workerQueueSize:   c.WorkerQueueSize,
+		reportWorkerQueue: c.ReportWorkerQueue,
+		circuits:          c.Circuits,
+		body:              c.Body,
+		scenario:          c.Scenario,
+		pipeline:          c.Pipeline,
+		protocols:         c.Protocols,
+		upstreamWeights:   c.UpstreamWeights,
+		budget:            c.Budget,
+		rawBodyLimit:      c.RawBodyLimit,
+		schemaVersion:     c.SchemaVersion,
+		requestLoad:       c.RequestLoad,
+		splitBrain:        c.SplitBrain,
+		upstreamRepeat:    c.UpstreamRepeat,
+		upstreamStats:     c.UpstreamStats,
+		clockSkew:         c.ClockSkew,
+		requestAllocation: c.RequestAllocation,
+		reportCompression: c.ReportCompression,
+		forceCompression:  c.ForceCompression,
+		partialSuccess:    c.PartialSuccess,
+		fileAllocation:    c.FileAllocation,
+		shuffle:           c.Shuffle,
+		healthChecks:      c.HealthChecks,
+		reportDeadline:    c.ReportDeadline,
+		queries:           c.Queries,
+		retries:           c.Retries,
+		migration:         c.Migration,
+		hedging:           c.Hedging,
 	}
 }
 
@@ -66,57 +236,230 @@ func (f *FakeServer) Handle(ctx context.Context, in *api.Nil) (*api.Response, er
 
 	// start timing the service this is used later for the total request time
 	ts := time.Now()
-	finished := f.loadGenerator.Generate()
-	defer finished()
+	// the load generator is optional to make the server easier to embed
+	if f.loadGenerator != nil {
+		finished := f.loadGenerator.Generate()
+		defer finished()
+	}
 
 	hq := f.log.HandleGRPCRequest(ctx)
 	defer hq.Finished()
 
 	resp := &response.Response{}
+	resp.SchemaVersion = response.SchemaLatest
+
+	// the response is returned in the schema version requested by the client
+	version, err := response.ParseSchemaVersion(grpcMetadata(ctx, SchemaVersionHeader), f.schemaVersion)
+	if err != nil {
+		resp.Code = int(codes.InvalidArgument)
+		resp.Error = err.Error()
+
+		hq.SetError(err)
+		hq.SetMetadata("response", strconv.Itoa(resp.Code))
+
+		s := status.New(codes.InvalidArgument, err.Error())
+		s, _ = s.WithDetails(&api.Response{Message: resp.ToJSON()})
+
+		return nil, s.Err()
+	}
+
 	resp.Name = f.name
+	if len(f.namePool) > 0 {
+		resp.Name = f.namePool[rand.Intn(len(f.namePool))]
+	}
 	resp.Type = "gRPC"
 	resp.IPAddresses = getIPInfo()
 
-	// are we injecting errors, if so return the error
-	if er := f.errorInjector.Do(); er != nil {
+	if f.reportCompression {
+		resp.Compression = grpcCompression(ctx, f.forceCompression)
+	}
+
+	if f.echoMetadata {
+		resp.Metadata = echoMetadata(ctx, f.redactMetadata)
+	}
+
+	// step the scenario, this configures the error injector for the current
+	// phase and returns any delay to add to the request
+	var scenarioDelay time.Duration
+	if f.scenario != nil {
+		scenarioDelay = f.scenario.Apply()
+	}
+
+	// are we injecting errors, if so return the error, Content-Length
+	// mismatches only apply to HTTP and are ignored
+	if er := injectError(f.errorInjector); er != nil && er.Error != errors.ErrorContentLength {
+		if f.degradation != nil {
+			f.degradation.RecordError()
+		}
+
 		resp.Code = er.Code
 		resp.Error = er.Error.Error()
 
 		hq.SetError(er.Error)
 		hq.SetMetadata("response", strconv.Itoa(er.Code))
 
+		if er.RetryAfter > 0 {
+			grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfterSeconds(er.RetryAfter)))
+		}
+
 		// encode the response into the gRPC error message
 		s := status.New(codes.Code(resp.Code), er.Error.Error())
-		s, _ = s.WithDetails(&api.Response{Message: resp.ToJSON()})
+		s, _ = s.WithDetails(&api.Response{Message: resp.ToSchemaJSON(version, f.omitFields)})
 
 		// return the error
 		return nil, s.Err()
 	}
 
+	// send a copy of the request to the shadow upstream
+	if f.mirror != nil {
+		f.mirror.Do(nil)
+	}
+
+	// generate any load requested for this request only, the memory is
+	// released when the request completes
+	requestLoad, releaseLoad := generateRequestLoad(f.requestLoad, grpcMetadata(ctx, LoadCPUHeader), grpcMetadata(ctx, LoadMemoryHeader))
+	defer releaseLoad()
+	resp.RequestLoad = requestLoad
+
+	// allocate memory which shows as a request allocation in heap profiles
+	releaseAllocation := f.requestAllocation.Allocate(ctx, "FakeService/Handle")
+	defer releaseAllocation()
+
+	// hold file descriptors open until the request completes
+	releaseFiles, err := f.fileAllocation.Allocate()
+	defer releaseFiles()
+	if err != nil {
+		f.log.Log().Warn("Unable to allocate file descriptors", "error", err)
+	}
+
+	// perform any CPU bound work for the request
+	if f.workUnits > 0 {
+		resp.WorkUnits = &response.WorkUnits{
+			Count:    f.workUnits,
+			Duration: load.DoWork(f.workUnits).String(),
+		}
+	}
+
+	// simulate the database queries for the request
+	resp.Queries = f.queries.Do(ctx)
+
 	// if we need to create upstream requests create a worker pool
 	var upstreamError error
 	if len(f.upstreamURIs) > 0 {
-		wp := worker.New(f.workerCount, func(uri string) (*response.Response, error) {
-			if strings.HasPrefix(uri, "http://") {
-				return workerHTTP(hq.Span.Context(), uri, f.defaultClient, nil, f.log)
+		// a pipeline calls the upstreams one at a time so that each call
+		// receives the response of the previous upstream
+		workerCount := f.workerCount
+		if f.pipeline != nil {
+			workerCount = 1
+		}
+
+		run := f.pipeline.Start()
+
+		// the upstream calls are bounded by the deadline of the caller and the
+		// budget for the request
+		deadline, cancel := withBudget(ctx, "", f.budget)
+		defer cancel()
+
+		wp := worker.NewBounded(workerCount, f.workerQueueSize, func(uri string) (*response.Response, error) {
+			// unhealthy upstreams are routed around rather than failing the
+			// request
+			if !f.healthChecks.Healthy(uri) {
+				return skippedUpstream(uri), nil
 			}
 
-			return workerGRPC(hq.Span.Context(), uri, f.grpcClients, f.log)
+			return callBeforeDeadline(deadline, uri, f.reportDeadline, func() (*response.Response, error) {
+				retry := -1
+				return f.retries.Do(deadline, func() (*response.Response, error) {
+					retry++
+					return f.circuits.Do(uri, func() (*response.Response, error) {
+						ur, err := f.hedging.Do(deadline, func(ctx context.Context) (*response.Response, error) {
+							call := &upstreamCall{circuit: f.circuits.State(uri), retries: retry}
+							return f.protocols.Do(
+								uri,
+								func(uri string) (*response.Response, error) {
+									return workerHTTP(hq.Span.Context(), uri, f.defaultClient, nil, f.log, run.Prepare, ctx, f.rawBodyLimit, call)
+								},
+								func(uri string) (*response.Response, error) {
+									return workerGRPC(hq.Span.Context(), uri, f.grpcClients, f.log, ctx, call)
+								},
+							)
+						})
+
+						run.Record(ur)
+
+						return ur, err
+					})
+				})
+			})
 		})
 
-		err := wp.Do(f.upstreamURIs)
+		wp.SetShuffle(f.shuffle)
+
+		err := wp.Do(repeatURIs(f.upstreamURIs, f.upstreamRepeat))
 
 		if err != nil {
 			upstreamError = err
+
+			if f.degradation != nil {
+				f.degradation.RecordError()
+			}
+		}
+
+		ws := wp.Stats()
+		f.log.UpstreamQueueStats(ws.MaxQueueDepth, ws.QueueWaits, ws.Dropped)
+
+		if f.reportWorkerQueue {
+			resp.WorkerQueue = &response.WorkerQueue{
+				MaxDepth: ws.MaxQueueDepth,
+				MaxWait:  ws.MaxQueueWait().String(),
+				Dropped:  ws.Dropped,
+			}
 		}
 
 		for _, v := range wp.Responses() {
+			if f.upstreamRepeat > 1 {
+				resp.AppendRepeatedUpstream(v.URI, *v.Response)
+				continue
+			}
+
 			resp.AppendUpstream(v.URI, *v.Response)
 		}
+
+		resp.TransformUpstreams(f.transforms)
+
+		if upstreamError != nil {
+			f.log.UpstreamCallTree(resp.UpstreamCalls)
+		}
+
+		// the failed upstreams are reported in the upstream calls
+		if upstreamError != nil && f.partialSuccess && response.AnyUpstreamSucceeded(resp.UpstreamCalls) {
+			f.log.Log().Warn("Upstream call failed, returning partial success", "error", upstreamError)
+
+			resp.PartialSuccess = true
+			upstreamError = nil
+		}
+
+		if f.upstreamWeights != nil {
+			resp.CriticalPath = response.CriticalPath(resp.UpstreamCalls, f.upstreamWeights).String()
+		}
+
+		if f.upstreamStats {
+			resp.UpstreamStats = response.NewUpstreamStats(resp.UpstreamCalls)
+		}
 	}
 
 	// service time is equal to the randomized time - the current time take
 	d := f.duration.Calculate()
+	if f.tailLatency != nil {
+		d += f.tailLatency.Calculate()
+	}
+
+	if f.degradation != nil {
+		d += f.degradation.Calculate()
+	}
+
+	d += scenarioDelay
+
 	et := time.Since(ts)
 	rd := d - et
 
@@ -129,16 +472,52 @@ func (f *FakeServer) Handle(ctx context.Context, in *api.Nil) (*api.Response, er
 
 		// encode the response into the gRPC error message
 		s := status.New(codes.Code(resp.Code), upstreamError.Error())
-		s, _ = s.WithDetails(&api.Response{Message: resp.ToJSON()})
+		s, _ = s.WithDetails(&api.Response{Message: resp.ToSchemaJSON(version, f.omitFields)})
 
 		return nil, s.Err()
 	}
 
+	// render the response body, a failure is returned as an error response
+	message := f.message
+	if f.body != nil {
+		var err error
+		message, err = f.body.Render(grpcBodyContext(ctx))
+		if err != nil {
+			resp.Code = int(codes.Internal)
+			resp.Error = err.Error()
+
+			hq.SetMetadata("response", strconv.Itoa(resp.Code))
+			hq.SetError(err)
+
+			s := status.New(codes.Code(resp.Code), err.Error())
+			s, _ = s.WithDetails(&api.Response{Message: resp.ToSchemaJSON(version, f.omitFields)})
+
+			return nil, s.Err()
+		}
+	}
+
+	// an inconsistent replica returns a stale message
+	message, resp.Replica = f.splitBrain.Choose(message)
+
 	// randomize the time the request takes
 	lp := f.log.SleepService(hq.Span, rd)
 
-	if rd > 0 {
-		time.Sleep(rd)
+	// the sleep is abandoned when the caller cancels or its deadline passes,
+	// there is no point responding to a caller which is no longer waiting
+	if err := sleepContext(ctx, rd); err != nil {
+		lp.SetError(err)
+		lp.Finished()
+
+		s := status.FromContextError(err)
+		resp.Code = int(s.Code())
+		resp.Error = s.Message()
+
+		hq.SetMetadata("response", strconv.Itoa(resp.Code))
+		hq.SetError(err)
+
+		s, _ = s.WithDetails(&api.Response{Message: resp.ToSchemaJSON(version, f.omitFields)})
+
+		return nil, s.Err()
 	}
 
 	lp.Finished()
@@ -150,18 +529,17 @@ func (f *FakeServer) Handle(ctx context.Context, in *api.Nil) (*api.Response, er
 	te := time.Now()
 	et = te.Sub(ts)
 
-	resp.StartTime = ts.Format(timeFormat)
-	resp.EndTime = te.Format(timeFormat)
+	resp.StartTime = ts.Add(f.clockSkew).Format(timeFormat)
+	resp.EndTime = te.Add(f.clockSkew).Format(timeFormat)
 	resp.Duration = et.String()
 
 	// add the response body if there is no upstream error
 	if upstreamError == nil {
-		if strings.HasPrefix(f.message, "{") {
-			resp.Body = json.RawMessage(f.message)
-		} else {
-			resp.Body = json.RawMessage(fmt.Sprintf(`"%s"`, f.message))
-		}
+		resp.Body = bodyJSON(message)
 	}
 
-	return &api.Response{Message: resp.ToJSON()}, nil
+	// reshape the response while a schema migration rolls out
+	resp.ApplyTransforms(f.migration.Transforms())
+
+	return &api.Response{Message: resp.ToSchemaJSON(version, f.omitFields)}, nil
 }
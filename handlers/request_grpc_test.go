@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"strings"
 	"testing"
@@ -19,8 +20,12 @@ import (
 	"github.com/nicholasjackson/fake-service/timing"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func setupFakeServer(t *testing.T, uris []string, errorRate float64) (*FakeServer, *client.MockHTTP, map[string]client.GRPC) {
@@ -42,10 +47,21 @@ func setupFakeServer(t *testing.T, uris []string, errorRate float64) (*FakeServe
 	}
 
 	// setup the error injector and load simulation
-	i := errors.NewInjector(l.Log(), errorRate, int(codes.Internal), "http_error", 0, 0, 0)
+	i := errors.NewInjector(l.Log(), errorRate, int(codes.Internal), "http_error", 0, 0, 0, 0, 0, 0, 0, 0, nil)
 	lg := load.NewGenerator(0, 0, 0, 0, hclog.Default())
 
-	return NewFakeServer("test", "hello world", d, uris, 1, c, grpcClients, i, lg, l), c, grpcClients
+	return NewFakeServer(FakeServerConfig{
+		Name:          "test",
+		Message:       "hello world",
+		Duration:      d,
+		UpstreamURIs:  uris,
+		WorkerCount:   1,
+		DefaultClient: c,
+		GRPCClients:   grpcClients,
+		ErrorInjector: i,
+		LoadGenerator: lg,
+		Log:           l,
+	}), c, grpcClients
 }
 
 func TestGRPCServiceHandlesRequestWithNoUpstream(t *testing.T) {
@@ -162,3 +178,185 @@ func TestGRPCServiceHandlesRequestWithGRPCUpstream(t *testing.T) {
 	assert.Equal(t, "grpc://test.com", mr.UpstreamCalls["grpc://test.com"].URI)
 	assert.Equal(t, "abc", mr.UpstreamCalls["grpc://test.com"].Headers["test"])
 }
+
+func setupBufconnServer(t *testing.T, fs *FakeServer) (api.FakeServiceClient, func()) {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	api.RegisterFakeServiceServer(s, fs)
+	go s.Serve(lis)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+	)
+	assert.NoError(t, err)
+
+	return api.NewFakeServiceClient(conn), func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+func TestGRPCServiceEchoesMetadata(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	fs.echoMetadata = true
+	fs.redactMetadata = []string{"Authorization"}
+
+	c, cleanup := setupBufconnServer(t, fs)
+	defer cleanup()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "abc123", "authorization", "Bearer secret")
+	resp, err := c.Handle(ctx, &api.Nil{})
+	assert.NoError(t, err)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(resp.Message))
+
+	assert.Equal(t, "abc123", mr.Metadata["x-request-id"])
+	assert.Equal(t, redactedValue, mr.Metadata["authorization"])
+}
+
+func TestGRPCServiceDoesNotEchoMetadataWhenDisabled(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+
+	c, cleanup := setupBufconnServer(t, fs)
+	defer cleanup()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "abc123")
+	resp, err := c.Handle(ctx, &api.Nil{})
+	assert.NoError(t, err)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(resp.Message))
+
+	assert.Nil(t, mr.Metadata)
+}
+
+func TestGRPCServiceHandlesRequestWithNilLoadGeneratorAndErrorInjector(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	fs.loadGenerator = nil
+	fs.errorInjector = nil
+
+	resp, err := fs.Handle(context.Background(), nil)
+	mr := response.Response{}
+	mr.FromJSON([]byte(resp.Message))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test", mr.Name)
+	assert.Equal(t, int(codes.OK), mr.Code)
+}
+
+func TestGRPCServiceSkipsUpstreamsAfterDeadline(t *testing.T) {
+	fs, c, _ := setupFakeServer(t, []string{"http://test.com"}, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	_, err := fs.Handle(ctx, nil)
+
+	assert.Error(t, err)
+	c.AssertNotCalled(t, "Do", mock.Anything, mock.Anything)
+}
+
+func TestGRPCServiceGeneratesLoadFromMetadata(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	fs.requestLoad = load.NewRequestLoad(time.Second, 10)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-fake-cpu-ms", "10", "x-fake-mem-mb", "20"))
+	resp, err := fs.Handle(ctx, nil)
+	assert.NoError(t, err)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(resp.Message))
+
+	assert.Equal(t, "10ms", mr.RequestLoad.CPU)
+	assert.Equal(t, 10, mr.RequestLoad.MemoryMB)
+	assert.Equal(t, int64(0), fs.requestLoad.HeldBytes())
+}
+
+func TestGRPCServiceReportsRequestCompression(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	fs.reportCompression = true
+
+	c, stop := setupBufconnServer(t, fs)
+	defer stop()
+
+	resp, err := c.Handle(context.Background(), &api.Nil{}, grpc.UseCompressor(gzip.Name))
+	assert.NoError(t, err)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(resp.Message))
+
+	assert.Equal(t, &response.Compression{Request: "gzip", Response: "gzip"}, mr.Compression)
+
+	resp, err = c.Handle(context.Background(), &api.Nil{})
+	assert.NoError(t, err)
+
+	mr = response.Response{}
+	mr.FromJSON([]byte(resp.Message))
+
+	assert.Equal(t, &response.Compression{Request: "identity", Response: "identity"}, mr.Compression)
+}
+
+func TestGRPCServiceReportsForcedResponseCompression(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	fs.reportCompression = true
+	fs.forceCompression = true
+
+	c, stop := setupBufconnServer(t, fs)
+	defer stop()
+
+	resp, err := c.Handle(context.Background(), &api.Nil{})
+	assert.NoError(t, err)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(resp.Message))
+
+	assert.Equal(t, &response.Compression{Request: "identity", Response: "gzip"}, mr.Compression)
+}
+
+func TestGRPCServiceReturnsDeadlineExceededBeforeDuration(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	fs.duration = timing.NewRequestDuration(time.Second, time.Second, time.Second, 0)
+
+	c, stop := setupBufconnServer(t, fs)
+	defer stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	st := time.Now()
+	_, err := c.Handle(ctx, &api.Nil{})
+
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Less(t, int64(time.Since(st)), int64(500*time.Millisecond))
+}
+
+func TestGRPCServiceHandlerAbandonsSleepWhenDeadlinePasses(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	fs.duration = timing.NewRequestDuration(time.Second, time.Second, time.Second, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	st := time.Now()
+	_, err := fs.Handle(ctx, &api.Nil{})
+
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Less(t, int64(time.Since(st)), int64(500*time.Millisecond))
+}
+
+func TestGRPCServiceReturnsCanceledWhenClientCancels(t *testing.T) {
+	fs, _, _ := setupFakeServer(t, nil, 0)
+	fs.duration = timing.NewRequestDuration(time.Second, time.Second, time.Second, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	st := time.Now()
+	_, err := fs.Handle(ctx, &api.Nil{})
+
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	assert.Less(t, int64(time.Since(st)), int64(500*time.Millisecond))
+}
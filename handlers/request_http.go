@@ -1,8 +1,9 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
@@ -13,6 +14,7 @@ import (
 	"github.com/nicholasjackson/fake-service/load"
 	"github.com/nicholasjackson/fake-service/logging"
 	"github.com/nicholasjackson/fake-service/response"
+	"github.com/nicholasjackson/fake-service/scenario"
 	"github.com/nicholasjackson/fake-service/timing"
 	"github.com/nicholasjackson/fake-service/worker"
 )
@@ -37,40 +39,252 @@ type Request struct {
 	errorInjector *errors.Injector
 	loadGenerator *load.Generator
 	log           *logging.Logger
+	// omitFields are removed from the response to simulate partial data
+	omitFields []string
+	// workUnits is the number of CPU bound work units performed per request
+	workUnits int
+	// queries when set simulates the database queries made by each request
+	queries *Queries
+	// retries when set retries failed upstream calls
+	retries *Retries
+
+	// hedging when set sends a second request to slow upstreams
+	hedging *Hedging
+	// mirror sends a copy of the request to a shadow upstream
+	mirror *Mirror
+	// tailLatency adds an extra delay to a fraction of requests
+	tailLatency *timing.TailLatency
+	// degradation slows requests down as errors accumulate
+	degradation *timing.Degradation
+	// namePool when set the response name is chosen at random from the pool
+	namePool []string
+	// varyHeaders are request headers which produce a distinct response
+	varyHeaders []string
+	// transforms reshape upstream responses keyed by upstream URI
+	transforms map[string][]response.Transform
+	// workerQueueSize bounds the upstream worker queue, 0 is unbounded
+	workerQueueSize int
+	// reportWorkerQueue adds the worker queue statistics to the response
+	reportWorkerQueue bool
+	// circuits when set wraps each upstream call in a circuit breaker
+	circuits *Circuits
+	// body when set renders the response body in place of message
+	body *Body
+	// scenario steps through a timeline of errors and delays
+	scenario *scenario.Engine
+	// pipeline when set passes data from each upstream response to the next
+	pipeline *Pipeline
+	// protocols when set detects whether upstreams serve HTTP or gRPC
+	// regardless of the URI scheme
+	protocols *ProtocolDetector
+	// variants when greater than 0 adds a variant derived from the request
+	// path and query to the response
+	variants int
+	// reportClientIP adds the address of the client to the response, this is
+	// the original client when the listener reads the PROXY protocol
+	reportClientIP bool
+	// upstreamWeights when set reports the critical path of the upstream
+	// calls, each upstream duration is multiplied by its weight
+	upstreamWeights map[string]float64
+	// headUpstreams when false HEAD requests do not call the upstreams
+	headUpstreams bool
+	// budget is the total time allowed for the upstream calls, upstreams not
+	// called before the budget or the request deadline expires are skipped
+	budget time.Duration
+	// reportDeadline adds the time remaining before the deadline when each
+	// upstream was called to the upstream response
+	reportDeadline bool
+	// rawBodyLimit is the number of bytes of a non JSON upstream response
+	// wrapped in the upstream response, 0 does not wrap the body
+	rawBodyLimit int
+	// schemaVersion is the response schema version used when the request
+	// does not ask for a version, 0 is the latest version
+	schemaVersion int
+	// requestLoad when set generates the CPU and memory load requested by the
+	// load headers of each request
+	requestLoad *load.RequestLoad
+	// splitBrain when set returns a stale message for a fraction of requests
+	splitBrain *SplitBrain
+	// upstreamRepeat is the number of times each upstream is called for a
+	// request, the repeated responses are grouped under the upstream
+	upstreamRepeat int
+	// upstreamStats when true summarizes the upstream latencies in the
+	// response
+	upstreamStats bool
+	// clockSkew offsets the reported start and end times to model a clock
+	// which is ahead or behind, the duration is not affected
+	clockSkew time.Duration
+	// requestAllocation when set allocates memory for each request which is
+	// attributed to the request in heap profiles
+	requestAllocation *load.RequestAllocation
+	// fileAllocation when set opens file descriptors for each request which
+	// are held until the request completes
+	fileAllocation *load.FileAllocation
+	// shuffle when set randomizes the order upstreams are dispatched to the
+	// workers, the order is reproducible for a seed
+	shuffle *worker.Shuffle
+	// healthChecks when set routes requests only to healthy upstreams
+	healthChecks *HealthChecks
+	// pageSize and pageTotal when pageTotal is greater than 0 return a page
+	// of pageSize synthetic items from a list of pageTotal for a GET request
+	// with a page query parameter
+	pageSize  int
+	pageTotal int
+	// sizeInjector when set injects errors into requests with a large body
+	sizeInjector *errors.SizeInjector
+	// echoTrailers adds the trailers sent after the request body to the
+	// response, the values of any trailers in redactTrailers are masked
+	echoTrailers   bool
+	redactTrailers []string
+	// tenants when set configures the behaviour for the tenant of each
+	// request
+	tenants *Tenants
+	// rollout when set returns a new message to a percentage of requests
+	rollout *Rollout
+	// migration when set reshapes the response during and after a schema
+	// migration
+	migration *response.Migration
+	// writeChunkSize when greater than 0 writes the response body in chunks
+	// of this many bytes
+	writeChunkSize int
+	// reportTLS adds the TLS version of the connection and whether the TLS
+	// session was resumed to the response
+	reportTLS bool
+	// partialSuccess when true returns a successful response when at least
+	// one upstream succeeds, the status of each upstream is preserved
+	partialSuccess bool
 }
 
-// NewRequest creates a new request handler
-func NewRequest(
-	name, message string,
-	duration *timing.RequestDuration,
-	upstreamURIs []string,
-	workerCount int,
-	defaultClient client.HTTP,
-	grpcClients map[string]client.GRPC,
-	errorInjector *errors.Injector,
-	loadGenerator *load.Generator,
-	log *logging.Logger,
-) *Request {
+// RequestConfig configures a Request, each field sets the Request field of
+// the same name and the zero value disables the optional features
+type RequestConfig struct {
+	Name              string
+	Message           string
+	Duration          *timing.RequestDuration
+	UpstreamURIs      []string
+	WorkerCount       int
+	DefaultClient     client.HTTP
+	GRPCClients       map[string]client.GRPC
+	ErrorInjector     *errors.Injector
+	LoadGenerator     *load.Generator
+	Log               *logging.Logger
+	OmitFields        []string
+	WorkUnits         int
+	Mirror            *Mirror
+	TailLatency       *timing.TailLatency
+	VaryHeaders       []string
+	Degradation       *timing.Degradation
+	NamePool          []string
+	Transforms        map[string][]response.Transform
+	WorkerQueueSize   int
+	ReportWorkerQueue bool
+	Circuits          *Circuits
+	Body              *Body
+	Scenario          *scenario.Engine
+	Pipeline          *Pipeline
+	Protocols         *ProtocolDetector
+	Variants          int
+	ReportClientIP    bool
+	UpstreamWeights   map[string]float64
+	HeadUpstreams     bool
+	Budget            time.Duration
+	RawBodyLimit      int
+	SchemaVersion     int
+	RequestLoad       *load.RequestLoad
+	SplitBrain        *SplitBrain
+	UpstreamRepeat    int
+	UpstreamStats     bool
+	ClockSkew         time.Duration
+	RequestAllocation *load.RequestAllocation
+	EchoTrailers      bool
+	RedactTrailers    []string
+	PartialSuccess    bool
+	FileAllocation    *load.FileAllocation
+	Shuffle           *worker.Shuffle
+	SizeInjector      *errors.SizeInjector
+	HealthChecks      *HealthChecks
+	PageSize          int
+	PageTotal         int
+	ReportTLS         bool
+	ReportDeadline    bool
+	Queries           *Queries
+	Tenants           *Tenants
+	Rollout           *Rollout
+	Retries           *Retries
+	WriteChunkSize    int
+	Migration         *response.Migration
+	Hedging           *Hedging
+}
 
+// NewRequest creates a new request handler
+func NewRequest(c RequestConfig) *Request {
 	return &Request{
-		name:          name,
-		message:       message,
-		duration:      duration,
-		upstreamURIs:  upstreamURIs,
-		workerCount:   workerCount,
-		defaultClient: defaultClient,
-		grpcClients:   grpcClients,
-		errorInjector: errorInjector,
-		loadGenerator: loadGenerator,
-		log:           log,
+		name:              c.Name,
+		message:           c.Message,
+		duration:          c.Duration,
+		upstreamURIs:      c.UpstreamURIs,
+		workerCount:       c.WorkerCount,
+		defaultClient:     c.DefaultClient,
+		grpcClients:       c.GRPCClients,
+		errorInjector:     c.ErrorInjector,
+		loadGenerator:     c.LoadGenerator,
+		log:               c.Log,
+		omitFields:        c.OmitFields,
+		workUnits:         c.WorkUnits,
+		mirror:            c.Mirror,
+		tailLatency:       c.TailLatency,
+		varyHeaders:       c.VaryHeaders,
+		degradation:       c.Degradation,
+		namePool:          c.NamePool,
+		transforms:        c.Transforms,
+		workerQueueSize:   c.WorkerQueueSize,
+		reportWorkerQueue: c.ReportWorkerQueue,
+		circuits:          c.Circuits,
+		body:              c.Body,
+		scenario:          c.Scenario,
+		pipeline:          c.Pipeline,
+		protocols:         c.Protocols,
+		variants:          c.Variants,
+		reportClientIP:    c.ReportClientIP,
+		upstreamWeights:   c.UpstreamWeights,
+		headUpstreams:     c.HeadUpstreams,
+		budget:            c.Budget,
+		rawBodyLimit:      c.RawBodyLimit,
+		schemaVersion:     c.SchemaVersion,
+		requestLoad:       c.RequestLoad,
+		splitBrain:        c.SplitBrain,
+		upstreamRepeat:    c.UpstreamRepeat,
+		upstreamStats:     c.UpstreamStats,
+		clockSkew:         c.ClockSkew,
+		requestAllocation: c.RequestAllocation,
+		echoTrailers:      c.EchoTrailers,
+		redactTrailers:    c.RedactTrailers,
+		partialSuccess:    c.PartialSuccess,
+		fileAllocation:    c.FileAllocation,
+		shuffle:           c.Shuffle,
+		sizeInjector:      c.SizeInjector,
+		healthChecks:      c.HealthChecks,
+		pageSize:          c.PageSize,
+		pageTotal:         c.PageTotal,
+		reportTLS:         c.ReportTLS,
+		reportDeadline:    c.ReportDeadline,
+		queries:           c.Queries,
+		tenants:           c.Tenants,
+		rollout:           c.Rollout,
+		retries:           c.Retries,
+		writeChunkSize:    c.WriteChunkSize,
+		migration:         c.Migration,
+		hedging:           c.Hedging,
 	}
 }
 
 // Handle the request and call the upstream servers
 func (rq *Request) Handle(rw http.ResponseWriter, r *http.Request) {
 	// generate 100% CPU load for service
-	finished := rq.loadGenerator.Generate()
-	defer finished()
+	if rq.loadGenerator != nil {
+		finished := rq.loadGenerator.Generate()
+		defer finished()
+	}
 
 	// start timing the service this is used later for the total request time
 	ts := time.Now()
@@ -80,13 +294,96 @@ func (rq *Request) Handle(rw http.ResponseWriter, r *http.Request) {
 	defer hq.Finished()
 
 	resp := &response.Response{}
+	resp.SchemaVersion = response.SchemaLatest
+
+	// the response is returned in the schema version requested by the client
+	version, err := response.ParseSchemaVersion(httpSchemaVersion(r), rq.schemaVersion)
+	if err != nil {
+		resp.Code = http.StatusNotAcceptable
+		resp.Error = err.Error()
+
+		hq.SetError(err)
+		hq.SetMetadata("response", strconv.Itoa(resp.Code))
+
+		writeResponse(rw, r, resp.Code, []byte(resp.ToJSON()))
+		return
+	}
+
 	resp.Name = rq.name
+	if len(rq.namePool) > 0 {
+		resp.Name = rq.namePool[rand.Intn(len(rq.namePool))]
+	}
 	resp.Type = "HTTP"
 	resp.URI = r.URL.String()
 	resp.IPAddresses = getIPInfo()
 
-	// are we injecting errors, if so return the error
-	if er := rq.errorInjector.Do(); er != nil {
+	if rq.reportClientIP {
+		resp.ClientIP = clientIP(r.RemoteAddr)
+	}
+
+	if rq.reportTLS {
+		resp.TLS = tlsConnection(r.TLS)
+	}
+
+	// the behaviour of the request is configured by its tenant
+	var tenant *Tenant
+	resp.Tenant, tenant = rq.tenants.Resolve(r.Header)
+
+	if rq.echoTrailers {
+		resp.Trailers = echoTrailers(r, rq.redactTrailers)
+	}
+
+	if rq.variants > 0 {
+		v := requestVariant(r.URL, rq.variants)
+		resp.Variant = &v
+	}
+
+	// return a page of synthetic items for a paginated request
+	if p := r.URL.Query().Get("page"); rq.pageTotal > 0 && r.Method == http.MethodGet && p != "" {
+		page, err := strconv.Atoi(p)
+		if err != nil || page < 1 {
+			pageErr := fmt.Errorf("invalid page %s, expected a number greater than 0", p)
+			resp.Code = http.StatusBadRequest
+			resp.Error = pageErr.Error()
+
+			hq.SetError(pageErr)
+			hq.SetMetadata("response", strconv.Itoa(resp.Code))
+
+			writeResponse(rw, r, resp.Code, []byte(resp.ToSchemaJSON(version, rq.omitFields)))
+			return
+		}
+
+		resp.Page = response.NewPage(r.URL, page, rq.pageSize, rq.pageTotal)
+	}
+
+	// step the scenario, this configures the error injector for the current
+	// phase and returns any delay to add to the request
+	var scenarioDelay time.Duration
+	if rq.scenario != nil {
+		scenarioDelay = rq.scenario.Apply()
+	}
+
+	// are we injecting errors, if so return the error, a Content-Length
+	// mismatch is not an error response and is applied when the body is written.
+	// Requests with a large body can fail before any other error is injected
+	var contentLengthOffset int
+	er := injectSizeError(rq.sizeInjector, r)
+	if er == nil {
+		er = rq.tenants.Error(tenant)
+	}
+
+	if er == nil {
+		er = injectError(rq.errorInjector)
+	}
+
+	if er != nil && er.Error == errors.ErrorContentLength {
+		contentLengthOffset = er.ContentLengthOffset
+		hq.SetMetadata("content_length_offset", strconv.Itoa(contentLengthOffset))
+	} else if er != nil {
+		if rq.degradation != nil {
+			rq.degradation.RecordError()
+		}
+
 		resp.Code = er.Code
 		resp.Error = er.Error.Error()
 
@@ -94,53 +391,214 @@ func (rq *Request) Handle(rw http.ResponseWriter, r *http.Request) {
 		hq.SetError(er.Error)
 		hq.SetMetadata("response", strconv.Itoa(er.Code))
 
-		rw.WriteHeader(er.Code)
-		rw.Write([]byte(resp.ToJSON()))
+		if er.RetryAfter > 0 {
+			rw.Header().Set("Retry-After", retryAfterSeconds(er.RetryAfter))
+		}
+
+		writeResponse(rw, r, er.Code, []byte(resp.ToSchemaJSON(version, rq.omitFields)))
 		return
 	}
 
+	// send a copy of the request to the shadow upstream
+	if rq.mirror != nil {
+		rq.mirror.Do(r)
+	}
+
+	// generate any load requested for this request only, the memory is
+	// released when the request completes
+	requestLoad, releaseLoad := generateRequestLoad(rq.requestLoad, r.Header.Get(LoadCPUHeader), r.Header.Get(LoadMemoryHeader))
+	defer releaseLoad()
+	resp.RequestLoad = requestLoad
+
+	// allocate memory which shows as a request allocation in heap profiles
+	releaseAllocation := rq.requestAllocation.Allocate(r.Context(), r.URL.Path)
+	defer releaseAllocation()
+
+	// hold file descriptors open until the request completes
+	releaseFiles, err := rq.fileAllocation.Allocate()
+	defer releaseFiles()
+	if err != nil {
+		rq.log.Log().Warn("Unable to allocate file descriptors", "error", err)
+	}
+
+	// perform any CPU bound work for the request
+	if rq.workUnits > 0 {
+		resp.WorkUnits = &response.WorkUnits{
+			Count:    rq.workUnits,
+			Duration: load.DoWork(rq.workUnits).String(),
+		}
+	}
+
+	// simulate the database queries for the request
+	resp.Queries = rq.queries.Do(r.Context())
+
 	// if we need to create upstream requests create a worker pool
 	var upstreamError error
-	if len(rq.upstreamURIs) > 0 {
-		wp := worker.New(rq.workerCount, func(uri string) (*response.Response, error) {
-			if strings.HasPrefix(uri, "http://") {
-				return workerHTTP(hq.Span.Context(), uri, rq.defaultClient, r, rq.log)
+	if len(rq.upstreamURIs) > 0 && (r.Method != http.MethodHead || rq.headUpstreams) {
+		// a pipeline calls the upstreams one at a time so that each call
+		// receives the response of the previous upstream
+		workerCount := rq.workerCount
+		if rq.pipeline != nil {
+			workerCount = 1
+		}
+
+		run := rq.pipeline.Start()
+
+		// the upstream calls are bounded by the deadline of the caller and the
+		// budget for the request
+		deadline, cancel := withBudget(r.Context(), r.Header.Get(DeadlineHeader), rq.budget)
+		defer cancel()
+
+		wp := worker.NewBounded(workerCount, rq.workerQueueSize, func(uri string) (*response.Response, error) {
+			// unhealthy upstreams are routed around rather than failing the
+			// request
+			if !rq.healthChecks.Healthy(uri) {
+				return skippedUpstream(uri), nil
 			}
 
-			return workerGRPC(hq.Span.Context(), uri, rq.grpcClients, rq.log)
+			return callBeforeDeadline(deadline, uri, rq.reportDeadline, func() (*response.Response, error) {
+				retry := -1
+				return rq.retries.Do(deadline, func() (*response.Response, error) {
+					retry++
+					return rq.circuits.Do(uri, func() (*response.Response, error) {
+						ur, err := rq.hedging.Do(deadline, func(ctx context.Context) (*response.Response, error) {
+							call := &upstreamCall{circuit: rq.circuits.State(uri), retries: retry}
+							return rq.protocols.Do(
+								uri,
+								func(uri string) (*response.Response, error) {
+									return workerHTTP(hq.Span.Context(), uri, rq.defaultClient, r, rq.log, run.Prepare, ctx, rq.rawBodyLimit, call)
+								},
+								func(uri string) (*response.Response, error) {
+									return workerGRPC(hq.Span.Context(), uri, rq.grpcClients, rq.log, ctx, call)
+								},
+							)
+						})
+
+						run.Record(ur)
+
+						return ur, err
+					})
+				})
+			})
 		})
 
-		err := wp.Do(rq.upstreamURIs)
+		wp.SetShuffle(rq.shuffle)
+
+		err := wp.Do(repeatURIs(rq.upstreamURIs, rq.upstreamRepeat))
 
 		if err != nil {
 			upstreamError = err
+
+			if rq.degradation != nil {
+				rq.degradation.RecordError()
+			}
+		}
+
+		ws := wp.Stats()
+		rq.log.UpstreamQueueStats(ws.MaxQueueDepth, ws.QueueWaits, ws.Dropped)
+
+		if rq.reportWorkerQueue {
+			resp.WorkerQueue = &response.WorkerQueue{
+				MaxDepth: ws.MaxQueueDepth,
+				MaxWait:  ws.MaxQueueWait().String(),
+				Dropped:  ws.Dropped,
+			}
 		}
 
 		for _, v := range wp.Responses() {
+			if rq.upstreamRepeat > 1 {
+				resp.AppendRepeatedUpstream(v.URI, *v.Response)
+				continue
+			}
+
 			resp.AppendUpstream(v.URI, *v.Response)
 		}
+
+		resp.TransformUpstreams(rq.transforms)
+
+		if upstreamError != nil {
+			rq.log.UpstreamCallTree(resp.UpstreamCalls)
+		}
+
+		// the failed upstreams are reported in the upstream calls
+		if upstreamError != nil && rq.partialSuccess && response.AnyUpstreamSucceeded(resp.UpstreamCalls) {
+			rq.log.Log().Warn("Upstream call failed, returning partial success", "error", upstreamError)
+
+			resp.PartialSuccess = true
+			upstreamError = nil
+		}
+
+		if rq.upstreamWeights != nil {
+			resp.CriticalPath = response.CriticalPath(resp.UpstreamCalls, rq.upstreamWeights).String()
+		}
+
+		if rq.upstreamStats {
+			resp.UpstreamStats = response.NewUpstreamStats(resp.UpstreamCalls)
+		}
 	}
 
 	// service time is equal to the randomized time - the current time take
 	d := rq.duration.Calculate()
+	if tenant != nil && tenant.Duration > 0 {
+		d = tenant.Duration
+	}
+
+	if rq.tailLatency != nil {
+		d += rq.tailLatency.Calculate()
+	}
+
+	if rq.degradation != nil {
+		d += rq.degradation.Calculate()
+	}
+
+	d += scenarioDelay
+
 	et := time.Since(ts)
 	rd := d - et
 
+	// render the response body, a failure is returned as an error response
+	message := rq.message
+	var bodyError error
+	if rq.body != nil {
+		message, bodyError = rq.body.Render(httpBodyContext(r))
+	}
+
+	// the cohort of a feature rollout receives the new message
+	message, resp.Cohort = rq.rollout.Choose(r, message)
+
+	if tenant != nil && tenant.Body != "" {
+		message = tenant.Body
+	}
+
+	// an inconsistent replica returns a stale message
+	message, resp.Replica = rq.splitBrain.Choose(message)
+
 	// set the start end end time
 
 	if upstreamError != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
 		resp.Code = http.StatusInternalServerError
 
 		// log error
 		hq.SetMetadata("response", strconv.Itoa(http.StatusInternalServerError))
 		hq.SetError(upstreamError)
+	} else if bodyError != nil {
+		resp.Code = http.StatusInternalServerError
+		resp.Error = bodyError.Error()
+
+		hq.SetMetadata("response", strconv.Itoa(http.StatusInternalServerError))
+		hq.SetError(bodyError)
 	} else {
 		// randomize the time the request takes if no error
 		lp := rq.log.SleepService(hq.Span, rd)
 
-		if rd > 0 {
-			time.Sleep(rd)
+		// the sleep is abandoned when the client disconnects, the load for
+		// the request is released as the handler returns
+		if err := sleepContext(r.Context(), rd); err != nil {
+			lp.SetError(err)
+			lp.Finished()
+
+			hq.SetError(err)
+			return
 		}
 
 		lp.Finished()
@@ -154,16 +612,40 @@ func (rq *Request) Handle(rw http.ResponseWriter, r *http.Request) {
 	te := time.Now()
 	et = te.Sub(ts)
 
-	resp.StartTime = ts.Format(timeFormat)
-	resp.EndTime = te.Format(timeFormat)
+	resp.StartTime = ts.Add(rq.clockSkew).Format(timeFormat)
+	resp.EndTime = te.Add(rq.clockSkew).Format(timeFormat)
 	resp.Duration = et.String()
 
 	// add the response body
-	if strings.HasPrefix(rq.message, "{") {
-		resp.Body = json.RawMessage(rq.message)
-	} else {
-		resp.Body = json.RawMessage(fmt.Sprintf(`"%s"`, rq.message))
+	if bodyError == nil {
+		resp.Body = bodyJSON(message)
+	}
+
+	// the response varies by the configured request headers, set the Vary
+	// header and an ETag unique to the varied values so caches store each
+	// variant separately
+	if len(rq.varyHeaders) > 0 {
+		resp.Vary = map[string]string{}
+		for _, h := range rq.varyHeaders {
+			resp.Vary[h] = r.Header.Get(h)
+		}
+
+		rw.Header().Set("Vary", strings.Join(rq.varyHeaders, ", "))
+		rw.Header().Set("ETag", varyETag(rq.name, rq.message, rq.varyHeaders, resp.Vary))
+	}
+
+	// reshape the response while a schema migration rolls out
+	resp.ApplyTransforms(rq.migration.Transforms())
+
+	// the header has not been written so the Content-Length can still be
+	// changed, a HEAD request has no body to mismatch
+	if contentLengthOffset != 0 && resp.Code == http.StatusOK && r.Method != http.MethodHead {
+		if err := writeContentLengthMismatch(rw, resp.Code, []byte(resp.ToSchemaJSON(version, rq.omitFields)), contentLengthOffset); err != nil {
+			rq.log.Log().Error("Unable to write Content-Length mismatch", "error", err)
+		}
+
+		return
 	}
 
-	rw.Write([]byte(resp.ToJSON()))
+	writeResponseChunks(rw, r, resp.Code, []byte(resp.ToSchemaJSON(version, rq.omitFields)), rq.writeChunkSize)
 }
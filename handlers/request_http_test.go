@@ -1,11 +1,21 @@
 package handlers
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,6 +27,8 @@ import (
 	"github.com/nicholasjackson/fake-service/logging"
 	"github.com/nicholasjackson/fake-service/response"
 	"github.com/nicholasjackson/fake-service/timing"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/grpc/codes"
@@ -41,7 +53,7 @@ func setupRequest(t *testing.T, uris []string, errorRate float64) (*Request, *cl
 		}
 	}
 
-	i := errors.NewInjector(hclog.Default(), errorRate, http.StatusInternalServerError, "http_error", 0, 0, 0)
+	i := errors.NewInjector(hclog.Default(), errorRate, http.StatusInternalServerError, "http_error", 0, 0, 0, 0, 0, 0, 0, 0, nil)
 	lg := load.NewGenerator(0, 0, 0, 0, hclog.Default())
 
 	return &Request{
@@ -55,6 +67,7 @@ func setupRequest(t *testing.T, uris []string, errorRate float64) (*Request, *cl
 		errorInjector: i,
 		loadGenerator: lg,
 		log:           l,
+		headUpstreams: true,
 	}, c, grpcClients
 }
 
@@ -234,3 +247,1278 @@ func TestRequestCompletesWithGRPCUpstreamsError(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 	assert.Equal(t, "test", mr.Name)
 }
+
+func TestRequestReportsGRPCUpstreamConnectError(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	h, _, gc := setupRequest(t, []string{"grpc://something.com"}, 0)
+
+	ce := &client.ConnectError{Err: fmt.Errorf("i/o timeout")}
+	gc["grpc://something.com"].(*client.MockGRPC).On("Handle", mock.Anything, mock.Anything).Return(nil, map[string]string{}, ce)
+
+	h.Handle(rr, r)
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Equal(t, -1, mr.UpstreamCalls["grpc://something.com"].Code)
+	assert.Equal(t, ce.Error(), mr.UpstreamCalls["grpc://something.com"].Error)
+}
+
+func TestRequestOmitsConfiguredFields(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+	h.omitFields = []string{"duration", "ip_addresses"}
+
+	h.Handle(rr, r)
+
+	d := map[string]interface{}{}
+	err := json.Unmarshal(rr.Body.Bytes(), &d)
+	assert.NoError(t, err)
+
+	assert.NotContains(t, d, "duration")
+	assert.NotContains(t, d, "ip_addresses")
+	assert.Equal(t, "test", d["name"])
+	assert.Contains(t, d, "start_time")
+}
+
+func TestRequestReportsWorkUnits(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+	h.workUnits = 1000
+
+	h.Handle(rr, r)
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 1000, mr.WorkUnits.Count)
+
+	d, err := time.ParseDuration(mr.WorkUnits.Duration)
+	assert.NoError(t, err)
+	assert.Greater(t, int64(d), int64(0))
+}
+
+func TestRequestDoesNotReportWorkUnitsWhenDisabled(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+
+	h.Handle(rr, r)
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Nil(t, mr.WorkUnits)
+}
+
+func TestRequestAddsTailLatency(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", bytes.NewReader([]byte("")))
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+	h.tailLatency = timing.NewTailLatency(1, 20*time.Millisecond)
+
+	h.Handle(rr, r)
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	d, err := time.ParseDuration(mr.Duration)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, int64(d), int64(20*time.Millisecond))
+}
+
+func TestRequestVariesByConfiguredHeaders(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.varyHeaders = []string{"Accept-Language"}
+
+	call := func(lang string) (*httptest.ResponseRecorder, response.Response) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Language", lang)
+		rr := httptest.NewRecorder()
+
+		h.Handle(rr, r)
+		mr := response.Response{}
+		mr.FromJSON(rr.Body.Bytes())
+
+		return rr, mr
+	}
+
+	enRR, enResp := call("en-GB")
+	frRR, frResp := call("fr-FR")
+	en2RR, _ := call("en-GB")
+
+	assert.Equal(t, "Accept-Language", enRR.Header().Get("Vary"))
+	assert.Equal(t, "en-GB", enResp.Vary["Accept-Language"])
+	assert.Equal(t, "fr-FR", frResp.Vary["Accept-Language"])
+
+	assert.NotEmpty(t, enRR.Header().Get("ETag"))
+	assert.NotEqual(t, enRR.Header().Get("ETag"), frRR.Header().Get("ETag"))
+	assert.Equal(t, enRR.Header().Get("ETag"), en2RR.Header().Get("ETag"))
+}
+
+func TestRequestSlowsDownAfterErrorsInDegradedMode(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	h.degradation = timing.NewDegradation(1, 1*time.Minute, 10*time.Millisecond)
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusInternalServerError, nil, fmt.Errorf("Boom")).Times(3)
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	for i := 0; i < 3; i++ {
+		h.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	// 3 errors with a threshold of 1 adds a delay of 2 steps
+	d, _ := time.ParseDuration(mr.Duration)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.GreaterOrEqual(t, int64(d), int64(20*time.Millisecond))
+}
+
+func TestRequestChoosesNameFromPool(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.namePool = []string{"web-1", "web-2", "web-3"}
+
+	seen := map[string]int{}
+	for i := 0; i < 100; i++ {
+		rr := httptest.NewRecorder()
+		h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		mr := response.Response{}
+		mr.FromJSON(rr.Body.Bytes())
+		seen[mr.Name]++
+	}
+
+	assert.Len(t, seen, 3)
+	for _, n := range h.namePool {
+		assert.Greater(t, seen[n], 0)
+	}
+}
+
+func TestRequestTransformsUpstreamResponses(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	h.transforms = map[string][]response.Transform{
+		"http://test.com": {response.Strip("ip_addresses"), response.Rename("body", "payload")},
+	}
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream", "body": "OK", "ip_addresses": ["10.0.0.1"]}`), nil)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := struct {
+		UpstreamCalls map[string]map[string]interface{} `json:"upstream_calls"`
+	}{}
+	err := json.Unmarshal(rr.Body.Bytes(), &mr)
+	assert.NoError(t, err)
+
+	up := mr.UpstreamCalls["http://test.com"]
+	assert.Equal(t, "upstream", up["name"])
+	assert.Equal(t, "OK", up["payload"])
+	assert.NotContains(t, up, "body")
+	assert.NotContains(t, up, "ip_addresses")
+}
+
+func TestRequestReportsWorkerQueueWhenEnabled(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://a.com", "http://b.com", "http://c.com"}, 0)
+	h.reportWorkerQueue = true
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotNil(t, mr.WorkerQueue)
+	assert.GreaterOrEqual(t, mr.WorkerQueue.MaxDepth, 2)
+	assert.Equal(t, 0, mr.WorkerQueue.Dropped)
+}
+
+func TestRequestWithLongContentLengthReturnsUnexpectedEOF(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.errorInjector = errors.NewInjector(hclog.Default(), 1, 0, "content_length", 0, 0, 0, 0, 0, 10, 0, 0, nil)
+
+	ts := httptest.NewServer(http.HandlerFunc(h.Handle))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	_, err = io.ReadAll(resp.Body)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestRequestWithShortContentLengthWritesExtraBytes(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.errorInjector = errors.NewInjector(hclog.Default(), 1, 0, "content_length", 0, 0, 0, 0, 0, -10, 0, 0, nil)
+
+	ts := httptest.NewServer(http.HandlerFunc(h.Handle))
+	defer ts.Close()
+
+	// read the raw response as the http client discards the extra bytes
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	assert.NoError(t, err)
+
+	declared, _ := io.ReadAll(resp.Body)
+	extra, _ := io.ReadAll(br)
+
+	assert.Equal(t, resp.ContentLength, int64(len(declared)))
+	assert.Len(t, extra, 10)
+}
+
+func TestRequestWithShortContentLengthHijacksThroughIdempotency(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.errorInjector = errors.NewInjector(hclog.Default(), 1, 0, "content_length", 0, 0, 0, 0, 0, -10, 0, 0, nil)
+	id := NewIdempotency(h.log, "test", time.Minute, 10, h.Handle)
+
+	ts := httptest.NewServer(http.HandlerFunc(id.Handle))
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: test\r\nIdempotency-Key: abc\r\n\r\n")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	assert.NoError(t, err)
+
+	declared, _ := io.ReadAll(resp.Body)
+	extra, _ := io.ReadAll(br)
+
+	assert.Equal(t, resp.ContentLength, int64(len(declared)))
+	assert.Len(t, extra, 10)
+
+	// the hijacked response is not cached
+	_, ok, _ := id.start("abc", "GET /")
+	assert.False(t, ok)
+}
+
+func TestContentLengthMismatchWithoutOffsetWritesNormally(t *testing.T) {
+	rr := httptest.NewRecorder()
+	err := writeContentLengthMismatch(rr, http.StatusOK, []byte("hello"), 0)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "5", rr.Header().Get("Content-Length"))
+	assert.Equal(t, "hello", rr.Body.String())
+}
+
+func TestShortContentLengthWritesBodyWhenHijackingUnsupported(t *testing.T) {
+	rr := httptest.NewRecorder()
+	err := writeContentLengthMismatch(rr, http.StatusOK, []byte("hello"), -2)
+
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "hello", rr.Body.String())
+}
+
+func TestRequestReportsTruncatedHTTPUpstream(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "ups`), &client.TruncatedError{Limit: 13})
+
+	h.Handle(rr, r)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(rr.Body.String()))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, mr.UpstreamCalls["http://test.com"].Truncated)
+	assert.Equal(t, int64(13), mr.UpstreamCalls["http://test.com"].BytesRead)
+}
+
+func TestRequestCompletesWithNilLoadGeneratorAndErrorInjector(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	rr := httptest.NewRecorder()
+	h, _, _ := setupRequest(t, nil, 0)
+	h.loadGenerator = nil
+	h.errorInjector = nil
+
+	h.Handle(rr, r)
+
+	mr := response.Response{}
+	mr.FromJSON([]byte(rr.Body.String()))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "test", mr.Name)
+}
+
+func TestRequestReturnsSameVariantForSameRequest(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.variants = 5
+
+	variant := func(uri string) int {
+		rr := httptest.NewRecorder()
+		h.Handle(rr, httptest.NewRequest(http.MethodGet, uri, nil))
+
+		mr := response.Response{}
+		mr.FromJSON(rr.Body.Bytes())
+		assert.NotNil(t, mr.Variant)
+
+		return *mr.Variant
+	}
+
+	// query parameter order does not change the variant
+	assert.Equal(t, variant("/orders?a=1&b=2"), variant("/orders?b=2&a=1"))
+	assert.Equal(t, variant("/orders/1"), variant("/orders/1"))
+}
+
+func TestRequestVariantsCoverAllValues(t *testing.T) {
+	seen := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		u, _ := url.Parse(fmt.Sprintf("/orders/%d", i))
+		v := requestVariant(u, 4)
+
+		assert.GreaterOrEqual(t, v, 0)
+		assert.Less(t, v, 4)
+		seen[v] = true
+	}
+
+	assert.Len(t, seen, 4)
+}
+
+func TestRequestReportsClientIPFromProxyProtocol(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.reportClientIP = true
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	s := &http.Server{Handler: http.HandlerFunc(h.Handle)}
+	go s.Serve(client.NewProxyProtocolListener(l))
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	fmt.Fprint(conn, "PROXY TCP4 203.0.113.7 10.0.0.1 56324 9090\r\nGET / HTTP/1.1\r\nHost: test\r\n\r\n")
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	assert.NoError(t, err)
+
+	d, _ := io.ReadAll(resp.Body)
+	mr := response.Response{}
+	mr.FromJSON(d)
+
+	assert.Equal(t, "203.0.113.7", mr.ClientIP)
+}
+
+func TestRequestReportsWeightedCriticalPath(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	h.upstreamWeights = map[string]float64{"http://test.com": 0.5}
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream", "duration": "100ms"}`), nil)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, "50ms", mr.CriticalPath)
+}
+
+func TestRequestHEADReturnsGETHeadersWithoutBody(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	get := httptest.NewRecorder()
+	h.Handle(get, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	head := httptest.NewRecorder()
+	h.Handle(head, httptest.NewRequest(http.MethodHead, "/", nil))
+
+	assert.Equal(t, http.StatusOK, head.Code)
+	assert.Equal(t, strconv.Itoa(get.Body.Len()), get.Header().Get("Content-Length"))
+	assert.NotEmpty(t, head.Header().Get("Content-Length"))
+	assert.Len(t, head.Header(), len(get.Header()))
+	assert.Empty(t, head.Body.Bytes())
+	c.AssertNumberOfCalls(t, "Do", 2)
+}
+
+func TestRequestHEADHonorsErrorInjection(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 1)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodHead, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.NotEmpty(t, rr.Header().Get("Content-Length"))
+	assert.Empty(t, rr.Body.Bytes())
+}
+
+func TestRequestHEADSkipsUpstreamsWhenDisabled(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	h.headUpstreams = false
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodHead, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	c.AssertNotCalled(t, "Do", mock.Anything, mock.Anything)
+}
+
+func TestRequestSkipsUpstreamsAfterBudgetExpires(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com", "http://test2.com"}, 0)
+	h.budget = 20 * time.Millisecond
+
+	c.On("Do", mock.Anything, mock.Anything).After(50*time.Millisecond).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	c.AssertNumberOfCalls(t, "Do", 1)
+	assert.False(t, mr.UpstreamCalls["http://test.com"].DeadlineExceeded)
+	assert.True(t, mr.UpstreamCalls["http://test2.com"].DeadlineExceeded)
+	assert.Equal(t, ErrDeadlineExceeded.Error(), mr.UpstreamCalls["http://test2.com"].Error)
+}
+
+func TestRequestReportsDeadlineRemainingForEachUpstream(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com", "http://test2.com"}, 0)
+	h.budget = time.Second
+	h.reportDeadline = true
+
+	c.On("Do", mock.Anything, mock.Anything).After(50*time.Millisecond).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	first, err := time.ParseDuration(mr.UpstreamCalls["http://test.com"].DeadlineRemaining)
+	assert.NoError(t, err)
+	second, err := time.ParseDuration(mr.UpstreamCalls["http://test2.com"].DeadlineRemaining)
+	assert.NoError(t, err)
+
+	// the upstreams are called one after the other so the second upstream
+	// has less of the budget remaining
+	assert.LessOrEqual(t, int64(first), int64(time.Second))
+	assert.LessOrEqual(t, int64(second), int64(first-50*time.Millisecond))
+}
+
+func TestRequestPropagatesDeadlineToUpstreams(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(DeadlineHeader, "1s")
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, r)
+
+	ur := c.Calls[0].Arguments.Get(0).(*http.Request)
+	d, err := time.ParseDuration(ur.Header.Get(DeadlineHeader))
+	assert.NoError(t, err)
+	assert.True(t, d > 0 && d <= time.Second)
+
+	_, ok := ur.Context().Deadline()
+	assert.True(t, ok)
+}
+
+func TestRequestWrapsNonJSONUpstreamResponse(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	h.rawBodyLimit = 20
+
+	html := "<html><body><h1>502 Bad Gateway</h1></body></html>"
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusBadGateway, []byte(html), nil)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	ur := mr.UpstreamCalls["http://test.com"]
+	assert.True(t, ur.NonJSON)
+	assert.Equal(t, html[:20], ur.RawBody)
+	assert.Equal(t, http.StatusBadGateway, ur.Code)
+}
+
+func TestRequestDoesNotWrapNonJSONUpstreamResponseWhenDisabled(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusBadGateway, []byte("<html></html>"), nil)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	ur := mr.UpstreamCalls["http://test.com"]
+	assert.False(t, ur.NonJSON)
+	assert.Empty(t, ur.RawBody)
+}
+
+func TestRequestReturnsRequestedSchemaVersion(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(SchemaVersionHeader, "1")
+
+	v1 := httptest.NewRecorder()
+	h.Handle(v1, r)
+
+	v2 := httptest.NewRecorder()
+	h.Handle(v2, httptest.NewRequest(http.MethodGet, "/?schema_version=2", nil))
+
+	b1 := map[string]interface{}{}
+	json.Unmarshal(v1.Body.Bytes(), &b1)
+	assert.NotContains(t, b1, "schema_version")
+	assert.IsType(t, []interface{}{}, b1["upstream_calls"])
+
+	b2 := map[string]interface{}{}
+	json.Unmarshal(v2.Body.Bytes(), &b2)
+	assert.Equal(t, float64(2), b2["schema_version"])
+	assert.IsType(t, map[string]interface{}{}, b2["upstream_calls"])
+}
+
+func TestRequestReturnsNotAcceptableForUnknownSchemaVersion(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(SchemaVersionHeader, "99")
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, r)
+
+	assert.Equal(t, http.StatusNotAcceptable, rr.Code)
+}
+
+func TestRequestGeneratesLoadFromHeaders(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.requestLoad = load.NewRequestLoad(20*time.Millisecond, 10)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(LoadCPUHeader, "50")
+	r.Header.Set(LoadMemoryHeader, "5")
+
+	st := time.Now()
+	rr := httptest.NewRecorder()
+	h.Handle(rr, r)
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	// the CPU burst is clamped to the maximum
+	assert.Equal(t, "20ms", mr.RequestLoad.CPU)
+	assert.Equal(t, 5, mr.RequestLoad.MemoryMB)
+	assert.GreaterOrEqual(t, int64(time.Since(st)), int64(20*time.Millisecond))
+
+	// the memory is released when the request completes
+	assert.Equal(t, int64(0), h.requestLoad.HeldBytes())
+}
+
+func TestRequestIgnoresLoadHeadersWhenDisabled(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(LoadCPUHeader, "50")
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, r)
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Nil(t, mr.RequestLoad)
+}
+
+func TestRequestLogsCallTreeOnUpstreamError(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+
+	buf := &bytes.Buffer{}
+	h.log = logging.NewLogger(&logging.NullMetrics{}, hclog.New(&hclog.LoggerOptions{Output: buf}), nil)
+	h.log.SetCallTreeFormat(logging.CallTreeJSON)
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusInternalServerError, []byte(`{"name": "upstream", "code": 500, "upstream_calls": {"http://db": {"name": "db", "code": 503, "error": "db down"}}}`), fmt.Errorf("boom"))
+
+	h.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Contains(t, buf.String(), "Upstream call failed")
+	assert.Contains(t, buf.String(), "db down")
+}
+
+func TestRequestDoesNotLogCallTreeOnSuccess(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+
+	buf := &bytes.Buffer{}
+	h.log = logging.NewLogger(&logging.NullMetrics{}, hclog.New(&hclog.LoggerOptions{Output: buf}), nil)
+	h.log.SetCallTreeFormat(logging.CallTreeJSON)
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	h.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.NotContains(t, buf.String(), "Upstream call failed")
+}
+
+func TestRequestCallsEachUpstreamRepeatedly(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://a.com", "http://b.com"}, 0)
+	h.upstreamRepeat = 3
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	calls := map[string]int{}
+	for _, call := range c.Calls {
+		calls[call.Arguments.Get(0).(*http.Request).URL.String()]++
+	}
+
+	assert.Equal(t, 3, calls["http://a.com"])
+	assert.Equal(t, 3, calls["http://b.com"])
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Len(t, mr.UpstreamCalls, 2)
+	assert.Len(t, mr.UpstreamCalls["http://a.com"].Repeats, 2)
+	assert.Len(t, mr.UpstreamCalls["http://b.com"].Repeats, 2)
+}
+
+func TestRequestTransformsEachRepeatedUpstreamResponse(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	h.upstreamRepeat = 3
+	h.transforms = map[string][]response.Transform{
+		"http://test.com": {response.Rename("body", "payload")},
+	}
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"name": "upstream", "body": "OK"}`), nil)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := struct {
+		UpstreamCalls map[string]struct {
+			Payload string                   `json:"payload"`
+			Repeats []map[string]interface{} `json:"repeats"`
+		} `json:"upstream_calls"`
+	}{}
+	err := json.Unmarshal(rr.Body.Bytes(), &mr)
+	assert.NoError(t, err)
+
+	up := mr.UpstreamCalls["http://test.com"]
+	assert.Equal(t, "OK", up.Payload)
+	assert.Len(t, up.Repeats, 2)
+	for _, r := range up.Repeats {
+		assert.Equal(t, "OK", r["payload"])
+		assert.NotContains(t, r, "body")
+	}
+}
+
+func TestRequestReportsUpstreamStats(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://fast.com", "http://slow.com"}, 0)
+	h.upstreamStats = true
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"duration": "10ms"}`), nil).Once()
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusOK, []byte(`{"duration": "50ms"}`), nil).Once()
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.NotNil(t, mr.UpstreamStats)
+	assert.Equal(t, 2, mr.UpstreamStats.Count)
+	assert.Equal(t, "10ms", mr.UpstreamStats.Min)
+	assert.Equal(t, "50ms", mr.UpstreamStats.Max)
+	assert.Equal(t, "30ms", mr.UpstreamStats.Mean)
+	assert.Equal(t, "http://slow.com", mr.UpstreamStats.Slowest)
+}
+
+func TestRequestReportsSkewedTimestamps(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.clockSkew = -time.Hour
+
+	before := time.Now()
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	start, err := time.ParseInLocation(timeFormat, mr.StartTime, time.Local)
+	assert.NoError(t, err)
+	end, err := time.ParseInLocation(timeFormat, mr.EndTime, time.Local)
+	assert.NoError(t, err)
+
+	assert.InDelta(t, float64(-time.Hour), float64(start.Sub(before)), float64(time.Second))
+	assert.InDelta(t, float64(-time.Hour), float64(end.Sub(before)), float64(time.Second))
+
+	d, err := time.ParseDuration(mr.Duration)
+	assert.NoError(t, err)
+	assert.Less(t, int64(d), int64(time.Second))
+}
+
+func TestRequestReturnsRetryAfterWhenRateLimited(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.errorInjector = errors.NewInjector(hclog.Default(), 0, 0, "http_error", 0, 1, http.StatusTooManyRequests, 0, 0, 0, time.Second, 10*time.Second, nil)
+
+	codes := []int{}
+	retries := []string{}
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		codes = append(codes, rr.Code)
+		retries = append(retries, rr.Header().Get("Retry-After"))
+	}
+
+	assert.Equal(t, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}, codes)
+	assert.Equal(t, []string{"", "1", "2"}, retries)
+}
+
+// upstreamSpans returns the tags of the finished upstream spans keyed by the
+// upstream protocol
+func upstreamSpans(tracer *mocktracer.MockTracer) map[string]map[string]interface{} {
+	spans := map[string]map[string]interface{}{}
+	for _, s := range tracer.FinishedSpans() {
+		if s.OperationName == "call_upstream" {
+			spans[s.Tag("upstream.protocol").(string)] = s.Tags()
+		}
+	}
+
+	return spans
+}
+
+func TestRequestAnnotatesUpstreamSpans(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	uris := []string{"http://test.com", "grpc://test.com"}
+	h, c, gc := setupRequest(t, uris, 0)
+	h.circuits = NewCircuits(uris, 5, time.Second)
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusAccepted, []byte(`{"name": "upstream"}`), nil)
+	gc["grpc://test.com"].(*client.MockGRPC).On("Handle", mock.Anything, mock.Anything).Return(&api.Response{Message: `{"name": "upstream"}`}, map[string]string{}, nil)
+
+	h.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	spans := upstreamSpans(tracer)
+
+	assert.Equal(t, http.StatusAccepted, spans[ProtocolHTTP]["upstream.code"])
+	assert.Equal(t, 0, spans[ProtocolHTTP]["upstream.retries"])
+	assert.Equal(t, client.CircuitClosed, spans[ProtocolHTTP]["upstream.circuit"])
+
+	assert.Equal(t, int(codes.OK), spans[ProtocolGRPC]["upstream.code"])
+	assert.Equal(t, 0, spans[ProtocolGRPC]["upstream.retries"])
+	assert.Equal(t, client.CircuitClosed, spans[ProtocolGRPC]["upstream.circuit"])
+}
+
+func TestRequestAnnotatesUpstreamSpanRetriesWhenDetectingProtocol(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	h, c, gc := setupRequest(t, []string{"grpc://test.com"}, 0)
+	h.protocols = NewProtocolDetector()
+
+	c.On("Do", mock.Anything, mock.Anything).Return(0, nil, fmt.Errorf("connection reset"))
+	gc["grpc://test.com"].(*client.MockGRPC).On("Handle", mock.Anything, mock.Anything).Return(&api.Response{Message: `{"name": "upstream"}`}, map[string]string{}, nil)
+
+	h.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	spans := upstreamSpans(tracer)
+
+	assert.Equal(t, 0, spans[ProtocolHTTP]["upstream.retries"])
+	assert.Equal(t, 1, spans[ProtocolGRPC]["upstream.retries"])
+	assert.NotContains(t, spans[ProtocolGRPC], "upstream.circuit")
+}
+
+func TestRequestEchoesTrailers(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.echoTrailers = true
+	h.redactTrailers = []string{"authorization"}
+
+	s := httptest.NewServer(http.HandlerFunc(h.Handle))
+	defer s.Close()
+
+	// the body has an unknown length so the request is chunked and the
+	// trailers are sent after the body
+	body, pw := io.Pipe()
+	go func() {
+		pw.Write([]byte("request body"))
+		pw.Close()
+	}()
+
+	r, _ := http.NewRequest(http.MethodPost, s.URL, body)
+	r.Trailer = http.Header{}
+	r.Trailer.Set("X-Checksum", "abc123")
+	r.Trailer.Set("Authorization", "secret")
+
+	resp, err := http.DefaultClient.Do(r)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	mr := response.Response{}
+	d, _ := ioutil.ReadAll(resp.Body)
+	mr.FromJSON(d)
+
+	assert.Equal(t, "abc123", mr.Trailers["X-Checksum"])
+	assert.Equal(t, redactedValue, mr.Trailers["Authorization"])
+}
+
+func TestRequestDoesNotEchoTrailersWhenDisabled(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("body"))
+	r.Trailer = http.Header{"X-Checksum": []string{"abc123"}}
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, r)
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Empty(t, mr.Trailers)
+}
+
+func upstreamHostIs(host string) interface{} {
+	return mock.MatchedBy(func(r *http.Request) bool { return r.URL.Host == host })
+}
+
+func TestRequestReturnsPartialSuccessWhenSomeUpstreamsFail(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://ok.com", "http://fail.com"}, 0)
+	h.partialSuccess = true
+
+	c.On("Do", upstreamHostIs("ok.com"), mock.Anything).Return(http.StatusOK, []byte(`{"name": "ok", "code": 200}`), nil)
+	c.On("Do", upstreamHostIs("fail.com"), mock.Anything).Return(http.StatusServiceUnavailable, []byte(`{"name": "fail", "code": 503}`), fmt.Errorf("Boom"))
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, mr.PartialSuccess)
+	assert.Equal(t, http.StatusOK, mr.UpstreamCalls["http://ok.com"].Code)
+	assert.Equal(t, http.StatusServiceUnavailable, mr.UpstreamCalls["http://fail.com"].Code)
+	assert.Equal(t, "Boom", mr.UpstreamCalls["http://fail.com"].Error)
+}
+
+func TestRequestReturnsErrorWhenAllUpstreamsFailWithPartialSuccess(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://fail.com"}, 0)
+	h.partialSuccess = true
+
+	c.On("Do", mock.Anything, mock.Anything).Return(http.StatusServiceUnavailable, nil, fmt.Errorf("Boom"))
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.False(t, mr.PartialSuccess)
+}
+
+func TestRequestReturnsErrorWhenSomeUpstreamsFailWithoutPartialSuccess(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://ok.com", "http://fail.com"}, 0)
+
+	c.On("Do", upstreamHostIs("ok.com"), mock.Anything).Return(http.StatusOK, []byte(`{"name": "ok"}`), nil)
+	c.On("Do", upstreamHostIs("fail.com"), mock.Anything).Return(http.StatusServiceUnavailable, nil, fmt.Errorf("Boom"))
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+func TestRequestReturnsEarlyWhenClientCancels(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.duration = timing.NewRequestDuration(time.Second, time.Second, time.Second, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	st := time.Now()
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	assert.Less(t, int64(time.Since(st)), int64(500*time.Millisecond))
+	assert.Empty(t, rr.Body.String())
+}
+
+func TestRequestHoldsFileDescriptorsUntilComplete(t *testing.T) {
+	h, c, _ := setupRequest(t, []string{"http://test.com"}, 0)
+	h.fileAllocation = load.NewFileAllocation(3, 0)
+
+	open := 0
+	c.On("Do", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		open = h.fileAllocation.Open()
+	}).Return(http.StatusOK, []byte(`{"name": "upstream"}`), nil)
+
+	h.Handle(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, 3, open)
+	assert.Equal(t, 0, h.fileAllocation.Open())
+}
+
+func TestRequestPassesSmallBodyWithSizeErrors(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.sizeInjector = errors.NewSizeInjector(16, 1, http.StatusRequestEntityTooLarge)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("small")))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestRequestFailsLargeBodyWithSizeErrors(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.sizeInjector = errors.NewSizeInjector(16, 1, http.StatusRequestEntityTooLarge)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("x", 17))))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Equal(t, errors.ErrorRequestSize.Error(), mr.Error)
+}
+
+func TestRequestMeasuresChunkedBodyWithSizeErrors(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.sizeInjector = errors.NewSizeInjector(16, 1, http.StatusRequestEntityTooLarge)
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(strings.Repeat("x", 64)))
+	r.ContentLength = -1
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, r)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
+
+func TestRequestReturnsPageOfItems(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.pageSize = 10
+	h.pageTotal = 25
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/items?page=3", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Len(t, mr.Page.Items, 5)
+	assert.Equal(t, 25, mr.Page.Total)
+	assert.Equal(t, "/items?page=2", mr.Page.Prev)
+	assert.Empty(t, mr.Page.Next)
+}
+
+func TestRequestReturnsBadRequestForInvalidPage(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.pageSize = 10
+	h.pageTotal = 25
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/items?page=0", nil))
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
+
+func TestRequestDoesNotPaginateWithoutPage(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.pageSize = 10
+	h.pageTotal = 25
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Nil(t, mr.Page)
+}
+
+func TestRequestReportsTLSSessionResumption(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.reportTLS = true
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(h.Handle))
+	defer ts.Close()
+
+	// a new connection is made for each request, the session cache allows
+	// the second connection to resume the session of the first
+	tr := ts.Client().Transport.(*http.Transport)
+	tr.DisableKeepAlives = true
+	tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+
+	resumed := []bool{}
+	for i := 0; i < 2; i++ {
+		resp, err := ts.Client().Get(ts.URL)
+		assert.NoError(t, err)
+
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		mr := response.Response{}
+		assert.NoError(t, mr.FromJSON(data))
+		assert.NotNil(t, mr.TLS)
+		assert.NotEqual(t, "unknown", mr.TLS.Version)
+
+		resumed = append(resumed, mr.TLS.Resumed)
+	}
+
+	assert.Equal(t, []bool{false, true}, resumed)
+}
+
+func TestRequestReportsDatabaseQueries(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.queries = NewQueries(3, 0, timing.NewRequestDuration(time.Millisecond, 0, 0, 0), 0)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, &response.Queries{Count: 3, Duration: "3ms", Errors: 0}, mr.Queries)
+}
+
+func TestRequestAppliesBehaviourOfTenant(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.tenants = NewTenants("X-Tenant-ID", map[string]Tenant{
+		"acme":   {Duration: 20 * time.Millisecond, Body: "hello acme"},
+		"globex": {ErrorRate: 1, ErrorCode: http.StatusServiceUnavailable},
+	})
+
+	call := func(tenant string) (*httptest.ResponseRecorder, response.Response, time.Duration) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Tenant-ID", tenant)
+
+		st := time.Now()
+		rr := httptest.NewRecorder()
+		h.Handle(rr, r)
+
+		mr := response.Response{}
+		mr.FromJSON(rr.Body.Bytes())
+
+		return rr, mr, time.Since(st)
+	}
+
+	rr, mr, took := call("acme")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "acme", mr.Tenant)
+	assert.JSONEq(t, `"hello acme"`, string(mr.Body))
+	assert.GreaterOrEqual(t, int64(took), int64(20*time.Millisecond))
+
+	rr, mr, _ = call("globex")
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Equal(t, "globex", mr.Tenant)
+	assert.Equal(t, ErrTenantError.Error(), mr.Error)
+
+	rr, mr, _ = call("initech")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, DefaultTenant, mr.Tenant)
+	assert.JSONEq(t, `"hello world"`, string(mr.Body))
+}
+
+func TestRequestReturnsMessageOfRolloutCohort(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.rollout = NewRollout(0, "Hello New World", "X-User-ID", "X-Rollout-Cohort")
+
+	call := func(cohort string) response.Response {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Rollout-Cohort", cohort)
+
+		rr := httptest.NewRecorder()
+		h.Handle(rr, r)
+
+		mr := response.Response{}
+		mr.FromJSON(rr.Body.Bytes())
+
+		return mr
+	}
+
+	mr := call("")
+	assert.Equal(t, CohortOld, mr.Cohort)
+	assert.JSONEq(t, `"hello world"`, string(mr.Body))
+
+	mr = call(CohortNew)
+	assert.Equal(t, CohortNew, mr.Cohort)
+	assert.JSONEq(t, `"Hello New World"`, string(mr.Body))
+}
+
+func TestRequestRetriesUpstreamWhichFailsThenSucceeds(t *testing.T) {
+	tracer := mocktracer.New()
+	opentracing.SetGlobalTracer(tracer)
+	defer opentracing.SetGlobalTracer(opentracing.NoopTracer{})
+
+	u, _, _ := setupRequest(t, nil, 0)
+	u.errorInjector.SetFailThenSucceed(2)
+
+	ts := httptest.NewServer(http.HandlerFunc(u.Handle))
+	defer ts.Close()
+
+	h, _, _ := setupRequest(t, []string{ts.URL}, 0)
+	h.defaultClient = client.NewHTTP(false, false, time.Second, time.Second, false, client.SocketOptions{}, 0, nil, nil)
+	h.retries = NewRetries(3, 0)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, http.StatusOK, mr.UpstreamCalls[ts.URL].Code)
+	assert.Equal(t, 2, mr.UpstreamCalls[ts.URL].Retries)
+
+	// each attempt is traced with the number of retries made before it
+	retries := []interface{}{}
+	for _, s := range tracer.FinishedSpans() {
+		if s.OperationName == "call_upstream" {
+			retries = append(retries, s.Tag("upstream.retries"))
+		}
+	}
+	assert.Equal(t, []interface{}{0, 1, 2}, retries)
+}
+
+func TestRequestHedgesSlowUpstream(t *testing.T) {
+	u, _, _ := setupRequest(t, nil, 0)
+
+	// the first call to the upstream is slow, the hedged call is fast
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		u.Handle(rw, r)
+	}))
+	defer ts.Close()
+
+	h, _, _ := setupRequest(t, []string{ts.URL}, 0)
+	h.defaultClient = client.NewHTTP(false, false, 5*time.Second, 5*time.Second, false, client.SocketOptions{}, 0, nil, nil)
+	h.hedging = NewHedging(20 * time.Millisecond)
+
+	st := time.Now()
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Less(t, int64(time.Since(st)), int64(500*time.Millisecond))
+	assert.Equal(t, http.StatusOK, mr.UpstreamCalls[ts.URL].Code)
+	assert.True(t, mr.UpstreamCalls[ts.URL].Hedge.Fired)
+	assert.Equal(t, HedgeSecondary, mr.UpstreamCalls[ts.URL].Hedge.Winner)
+}
+
+// countingWriter records the size of each write to the response body
+type countingWriter struct {
+	*httptest.ResponseRecorder
+	writes []int
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	c.writes = append(c.writes, len(b))
+	return c.ResponseRecorder.Write(b)
+}
+
+func TestRequestWritesBodyInChunks(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.writeChunkSize = 16
+
+	rr := &countingWriter{ResponseRecorder: httptest.NewRecorder()}
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Greater(t, len(rr.writes), 1)
+	for _, n := range rr.writes[:len(rr.writes)-1] {
+		assert.Equal(t, 16, n)
+	}
+	assert.LessOrEqual(t, rr.writes[len(rr.writes)-1], 16)
+	assert.True(t, rr.Flushed)
+
+	mr := response.Response{}
+	assert.NoError(t, mr.FromJSON(rr.Body.Bytes()))
+	assert.Equal(t, strconv.Itoa(rr.Body.Len()), rr.Header().Get("Content-Length"))
+}
+
+func TestRequestWritesBodyAtOnceByDefault(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+
+	rr := &countingWriter{ResponseRecorder: httptest.NewRecorder()}
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, []int{rr.Body.Len()}, rr.writes)
+}
+
+func TestRequestReshapesResponseDuringSchemaMigration(t *testing.T) {
+	h, _, _ := setupRequest(t, nil, 0)
+	h.migration = response.NewMigration(time.Now().Add(-time.Second), time.Minute, []response.Transform{response.Rename("body", "payload")}, nil)
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	fields := map[string]json.RawMessage{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &fields))
+	assert.NotContains(t, fields, "body")
+	assert.JSONEq(t, `"hello world"`, string(fields["payload"]))
+}
+
+func TestRequestCallsHTTPSUpstreamWithOverriddenServerName(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(rw, `{"name": %q}`, r.TLS.ServerName)
+	}))
+	defer ts.Close()
+
+	host := strings.TrimPrefix(ts.URL, "https://")
+	c := client.NewHTTP(false, false, 10*time.Second, 1*time.Second, true, client.DefaultSocketOptions, 0, nil, map[string]string{host: "payments.internal"})
+
+	h, _, _ := setupRequest(t, []string{ts.URL}, 0)
+	h.defaultClient = c
+	h.protocols = NewProtocolDetector()
+
+	rr := httptest.NewRecorder()
+	h.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mr := response.Response{}
+	mr.FromJSON(rr.Body.Bytes())
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, http.StatusOK, mr.UpstreamCalls[ts.URL].Code)
+	assert.Equal(t, "payments.internal", mr.UpstreamCalls[ts.URL].Name)
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// ResourceStore models a REST resource store, the JSON object in the body of
+// a PUT request replaces the resource at the request path and the body of a
+// PATCH request is merged into it as a JSON merge patch (RFC 7386). GET
+// returns the stored resource. Requests for paths which have not been
+// written are passed to next
+type ResourceStore struct {
+	logger    *logging.Logger
+	name      string
+	size      int
+	mutex     sync.Mutex
+	resources map[string]map[string]interface{}
+	order     []string
+	next      http.HandlerFunc
+}
+
+// NewResourceStore creates a new ResourceStore which holds at most size
+// resources, when full the resource written least recently is removed
+func NewResourceStore(logger *logging.Logger, name string, size int, next http.HandlerFunc) *ResourceStore {
+	return &ResourceStore{
+		logger:    logger,
+		name:      name,
+		size:      size,
+		resources: map[string]map[string]interface{}{},
+		next:      next,
+	}
+}
+
+// Handle the request
+func (s *ResourceStore) Handle(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch:
+		s.write(rw, r)
+	case http.MethodGet, http.MethodHead:
+		s.mutex.Lock()
+		res, ok := s.resources[r.URL.Path]
+		data, _ := json.Marshal(res)
+		s.mutex.Unlock()
+
+		if !ok {
+			s.next(rw, r)
+			return
+		}
+
+		s.respond(rw, r, http.StatusOK, data, "")
+	default:
+		s.next(rw, r)
+	}
+}
+
+func (s *ResourceStore) write(rw http.ResponseWriter, r *http.Request) {
+	patch := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		s.respond(rw, r, http.StatusBadRequest, nil, "body must be a JSON object: "+err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	res, ok := s.resources[r.URL.Path]
+	if !ok || r.Method == http.MethodPut {
+		res = map[string]interface{}{}
+	}
+
+	res = mergePatch(res, patch)
+	s.store(r.URL.Path, res)
+	data, _ := json.Marshal(res)
+	s.mutex.Unlock()
+
+	s.logger.Log().Info("Stored resource", "method", r.Method, "path", r.URL.Path)
+
+	s.respond(rw, r, http.StatusOK, data, "")
+}
+
+// store saves the resource and removes the resource written least recently
+// when the store is full, the caller must hold the mutex
+func (s *ResourceStore) store(path string, res map[string]interface{}) {
+	for i, p := range s.order {
+		if p == path {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+
+	if len(s.order) >= s.size && len(s.order) > 0 {
+		delete(s.resources, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	s.resources[path] = res
+	s.order = append(s.order, path)
+}
+
+func (s *ResourceStore) respond(rw http.ResponseWriter, r *http.Request, code int, data json.RawMessage, message string) {
+	resp := &response.Response{
+		Name:  s.name,
+		Type:  "HTTP",
+		URI:   r.URL.String(),
+		Code:  code,
+		Body:  data,
+		Error: message,
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	writeResponse(rw, r, code, []byte(resp.ToJSON()))
+}
+
+// mergePatch applies a JSON merge patch to target, null values remove the
+// field and objects are merged recursively
+func mergePatch(target, patch map[string]interface{}) map[string]interface{} {
+	for k, v := range patch {
+		if v == nil {
+			delete(target, k)
+			continue
+		}
+
+		po, ok := v.(map[string]interface{})
+		if !ok {
+			target[k] = v
+			continue
+		}
+
+		to, ok := target[k].(map[string]interface{})
+		if !ok {
+			to = map[string]interface{}{}
+		}
+
+		target[k] = mergePatch(to, po)
+	}
+
+	return target
+}
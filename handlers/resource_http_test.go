@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupResourceStore(t *testing.T) *ResourceStore {
+	return NewResourceStore(
+		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
+		"test",
+		10,
+		func(rw http.ResponseWriter, r *http.Request) { fmt.Fprint(rw, "OK") },
+	)
+}
+
+func doResourceStore(s *ResourceStore, method, body string) (int, string) {
+	rr := httptest.NewRecorder()
+	s.Handle(rr, httptest.NewRequest(method, "/users/1", strings.NewReader(body)))
+
+	resp := &response.Response{}
+	resp.FromJSON(rr.Body.Bytes())
+
+	return rr.Code, string(resp.Body)
+}
+
+func TestResourceStorePatchMergesResource(t *testing.T) {
+	s := setupResourceStore(t)
+
+	doResourceStore(s, http.MethodPut, `{"name":"nic","address":{"city":"London","zip":"N1"},"age":40}`)
+
+	code, body := doResourceStore(s, http.MethodPatch, `{"address":{"city":"Bristol"},"age":null}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"name":"nic","address":{"city":"Bristol","zip":"N1"}}`, body)
+
+	_, body = doResourceStore(s, http.MethodGet, "")
+	assert.JSONEq(t, `{"name":"nic","address":{"city":"Bristol","zip":"N1"}}`, body)
+}
+
+func TestResourceStorePutReplacesResource(t *testing.T) {
+	s := setupResourceStore(t)
+
+	doResourceStore(s, http.MethodPut, `{"name":"nic","age":40}`)
+
+	code, body := doResourceStore(s, http.MethodPut, `{"name":"erik"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"name":"erik"}`, body)
+
+	_, body = doResourceStore(s, http.MethodGet, "")
+	assert.JSONEq(t, `{"name":"erik"}`, body)
+}
+
+func TestResourceStoreRejectsBodyWhichIsNotAnObject(t *testing.T) {
+	s := setupResourceStore(t)
+
+	code, _ := doResourceStore(s, http.MethodPatch, `[1,2]`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestResourceStorePassesUnknownPathsToNext(t *testing.T) {
+	s := setupResourceStore(t)
+
+	rr := httptest.NewRecorder()
+	s.Handle(rr, httptest.NewRequest(http.MethodGet, "/users/2", nil))
+	assert.Equal(t, "OK", rr.Body.String())
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// Retries retries failed upstream calls, the number of retries made is
+// reported on the upstream response
+type Retries struct {
+	count   int
+	backoff time.Duration
+}
+
+// NewRetries creates Retries which retry a failed call up to count times,
+// waiting backoff between attempts
+func NewRetries(count int, backoff time.Duration) *Retries {
+	return &Retries{count: count, backoff: backoff}
+}
+
+// Do calls the upstream until it succeeds, the retries are exhausted or ctx
+// is done. The response and error of the last attempt are returned, a nil
+// Retries calls the upstream once
+func (rt *Retries) Do(ctx context.Context, call func() (*response.Response, error)) (*response.Response, error) {
+	if rt == nil {
+		return call()
+	}
+
+	r, err := call()
+	for n := 1; n <= rt.count && err != nil; n++ {
+		if ctx.Err() != nil || sleepContext(ctx, rt.backoff) != nil {
+			break
+		}
+
+		r, err = call()
+		if r != nil {
+			r.Retries = n
+		}
+	}
+
+	return r, err
+}
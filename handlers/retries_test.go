@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func failingCall(failures int, attempts *int) func() (*response.Response, error) {
+	return func() (*response.Response, error) {
+		*attempts++
+		if *attempts <= failures {
+			return &response.Response{Code: 500}, fmt.Errorf("Boom")
+		}
+
+		return &response.Response{Code: 200}, nil
+	}
+}
+
+func TestRetriesRetriesUntilSuccess(t *testing.T) {
+	attempts := 0
+	r, err := NewRetries(3, 0).Do(context.Background(), failingCall(2, &attempts))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 200, r.Code)
+	assert.Equal(t, 2, r.Retries)
+}
+
+func TestRetriesReturnsLastErrorWhenExhausted(t *testing.T) {
+	attempts := 0
+	r, err := NewRetries(2, 0).Do(context.Background(), failingCall(5, &attempts))
+
+	assert.Error(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, 500, r.Code)
+	assert.Equal(t, 2, r.Retries)
+}
+
+func TestRetriesStopWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	_, err := NewRetries(3, 0).Do(ctx, failingCall(5, &attempts))
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestNilRetriesCallsOnce(t *testing.T) {
+	var rt *Retries
+
+	attempts := 0
+	_, err := rt.Do(context.Background(), failingCall(1, &attempts))
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+)
+
+const (
+	// CohortNew is reported for requests which receive the rolled out message
+	CohortNew = "new"
+	// CohortOld is reported for requests which receive the existing message
+	CohortOld = "old"
+)
+
+// Rollout models the percentage rollout of a feature flag, a fraction of
+// requests receive a new message and the rest the existing message. The
+// cohort of a request is stable for its identity, the value of the identity
+// header or the client address when the header is not set
+type Rollout struct {
+	percentage     float64
+	message        string
+	identityHeader string
+	cohortHeader   string
+}
+
+// NewRollout creates a Rollout which returns message for percentage of
+// request identities, requests can force their cohort by setting
+// cohortHeader to new or old
+func NewRollout(percentage float64, message, identityHeader, cohortHeader string) *Rollout {
+	return &Rollout{
+		percentage:     percentage,
+		message:        message,
+		identityHeader: identityHeader,
+		cohortHeader:   cohortHeader,
+	}
+}
+
+// Choose returns the message to respond with and the cohort of the request,
+// a nil Rollout always returns message and no cohort
+func (ro *Rollout) Choose(r *http.Request, message string) (string, string) {
+	if ro == nil {
+		return message, ""
+	}
+
+	cohort := ro.Cohort(r)
+	if cohort == CohortNew {
+		return ro.message, cohort
+	}
+
+	return message, cohort
+}
+
+// Cohort returns the cohort of a request, the cohort header takes precedence
+// over the hash of the request identity
+func (ro *Rollout) Cohort(r *http.Request) string {
+	switch r.Header.Get(ro.cohortHeader) {
+	case CohortNew:
+		return CohortNew
+	case CohortOld:
+		return CohortOld
+	}
+
+	id := r.Header.Get(ro.identityHeader)
+	if id == "" {
+		id = clientIP(r.RemoteAddr)
+	}
+
+	h := fnv.New32a()
+	fmt.Fprint(h, id)
+
+	// map the identity to a bucket in the range [0, 10000) so that
+	// percentages with two decimal places are honoured
+	if float64(h.Sum32()%10000) < ro.percentage*10000 {
+		return CohortNew
+	}
+
+	return CohortOld
+}
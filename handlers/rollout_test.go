@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRolloutAssignsPercentageOfIdentities(t *testing.T) {
+	ro := NewRollout(0.25, "new", "X-User-ID", "X-Rollout-Cohort")
+
+	n := 0
+	for i := 0; i < 10000; i++ {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("X-User-ID", fmt.Sprintf("user-%d", i))
+
+		if ro.Cohort(r) == CohortNew {
+			n++
+		}
+	}
+
+	assert.InDelta(t, 2500, n, 200)
+}
+
+func TestRolloutCohortIsStableForIdentity(t *testing.T) {
+	ro := NewRollout(0.5, "new", "X-User-ID", "X-Rollout-Cohort")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-User-ID", "user-1")
+	c := ro.Cohort(r)
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, c, ro.Cohort(r))
+	}
+}
+
+func TestRolloutCohortHeaderOverridesIdentity(t *testing.T) {
+	ro := NewRollout(0, "new", "X-User-ID", "X-Rollout-Cohort")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-User-ID", "user-1")
+
+	message, cohort := ro.Choose(r, "old")
+	assert.Equal(t, "old", message)
+	assert.Equal(t, CohortOld, cohort)
+
+	r.Header.Set("X-Rollout-Cohort", CohortNew)
+
+	message, cohort = ro.Choose(r, "old")
+	assert.Equal(t, "new", message)
+	assert.Equal(t, CohortNew, cohort)
+}
+
+func TestNilRolloutReturnsMessage(t *testing.T) {
+	var ro *Rollout
+
+	message, cohort := ro.Choose(httptest.NewRequest("GET", "/", nil), "old")
+	assert.Equal(t, "old", message)
+	assert.Empty(t, cohort)
+}
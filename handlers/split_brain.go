@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"math/rand"
+)
+
+const (
+	// ReplicaPrimary is reported when the configured message is returned
+	ReplicaPrimary = "primary"
+	// ReplicaStale is reported when the stale message is returned
+	ReplicaStale = "stale"
+)
+
+// SplitBrain models an inconsistent replica, a fraction of requests are
+// answered with a stale message in place of the configured message
+type SplitBrain struct {
+	rate       float64
+	message    string
+	randomFunc func() float64
+}
+
+// NewSplitBrain creates a SplitBrain which returns message for the given
+// rate of requests
+func NewSplitBrain(rate float64, message string) *SplitBrain {
+	return &SplitBrain{
+		rate:       rate,
+		message:    message,
+		randomFunc: rand.Float64,
+	}
+}
+
+// Choose returns the message to respond with and the replica which served
+// it, a nil SplitBrain always returns message and no replica
+func (s *SplitBrain) Choose(message string) (string, string) {
+	if s == nil {
+		return message, ""
+	}
+
+	if s.randomFunc() < s.rate {
+		return s.message, ReplicaStale
+	}
+
+	return message, ReplicaPrimary
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitBrainReturnsStaleMessageAtRate(t *testing.T) {
+	s := NewSplitBrain(0.3, "stale")
+	s.randomFunc = rand.New(rand.NewSource(1)).Float64
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		m, replica := s.Choose("fresh")
+		counts[replica]++
+
+		if replica == ReplicaStale {
+			assert.Equal(t, "stale", m)
+		} else {
+			assert.Equal(t, "fresh", m)
+		}
+	}
+
+	assert.InDelta(t, 3000, counts[ReplicaStale], 300)
+	assert.InDelta(t, 7000, counts[ReplicaPrimary], 300)
+}
+
+func TestSplitBrainNilReturnsMessage(t *testing.T) {
+	var s *SplitBrain
+
+	m, replica := s.Choose("fresh")
+
+	assert.Equal(t, "fresh", m)
+	assert.Empty(t, replica)
+}
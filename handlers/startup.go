@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// Startup models a slow initializing service, requests received before the
+// startup delay has passed are held until the service has started
+type Startup struct {
+	logger  *logging.Logger
+	next    http.HandlerFunc
+	started chan struct{}
+}
+
+// NewStartup creates a new Startup which starts after delay, requests are
+// passed to next once the service has started
+func NewStartup(logger *logging.Logger, delay time.Duration, next http.HandlerFunc) *Startup {
+	s := &Startup{
+		logger:  logger,
+		next:    next,
+		started: make(chan struct{}),
+	}
+
+	time.AfterFunc(delay, func() {
+		logger.Log().Info("Service started", "delay", delay)
+		close(s.started)
+	})
+
+	return s
+}
+
+// Started returns true when the startup delay has passed, a nil Startup is
+// always started
+func (s *Startup) Started() bool {
+	if s == nil {
+		return true
+	}
+
+	select {
+	case <-s.started:
+		return true
+	default:
+		return false
+	}
+}
+
+// Handle the request
+func (s *Startup) Handle(rw http.ResponseWriter, r *http.Request) {
+	if !s.Started() {
+		s.logger.Log().Debug("Holding request until the service has started")
+	}
+
+	select {
+	case <-s.started:
+	case <-r.Context().Done():
+		// the client gave up waiting
+		return
+	}
+
+	s.next(rw, r)
+}
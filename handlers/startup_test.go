@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupStartup(t *testing.T, delay time.Duration) *Startup {
+	return NewStartup(
+		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
+		delay,
+		func(rw http.ResponseWriter, r *http.Request) { fmt.Fprint(rw, "OK") },
+	)
+}
+
+func TestStartupHoldsRequestsUntilDelayElapsed(t *testing.T) {
+	s := setupStartup(t, 100*time.Millisecond)
+	assert.False(t, s.Started())
+
+	rr := httptest.NewRecorder()
+	st := time.Now()
+	s.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.GreaterOrEqual(t, int64(time.Since(st)), int64(90*time.Millisecond))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "OK", rr.Body.String())
+	assert.True(t, s.Started())
+}
+
+func TestStartupReleasesRequestWhenClientCancels(t *testing.T) {
+	s := setupStartup(t, 10*time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	rr := httptest.NewRecorder()
+	s.Handle(rr, httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx))
+
+	assert.Empty(t, rr.Body.String())
+}
+
+func TestNilStartupIsStarted(t *testing.T) {
+	var s *Startup
+
+	assert.True(t, s.Started())
+}
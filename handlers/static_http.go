@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/nicholasjackson/fake-service/logging"
+)
+
+// Static serves a directory of static files under a path prefix, index.html
+// is served for a directory and the content type is set from the file
+// extension
+type Static struct {
+	logger *logging.Logger
+	prefix string
+	files  http.Handler
+}
+
+// NewStatic creates a Static handler which serves the files in dir under the
+// path prefix e.g. /static/
+func NewStatic(logger *logging.Logger, prefix, dir string) *Static {
+	prefix = "/" + strings.Trim(prefix, "/") + "/"
+
+	return &Static{
+		logger: logger,
+		prefix: prefix,
+		files:  http.StripPrefix(strings.TrimSuffix(prefix, "/"), http.FileServer(http.Dir(dir))),
+	}
+}
+
+// Prefix returns the path prefix the files are served under
+func (s *Static) Prefix() string {
+	return s.prefix
+}
+
+// Handle the request
+func (s *Static) Handle(rw http.ResponseWriter, r *http.Request) {
+	hq := s.logger.CallStaticHTTP(r.URL.Path)
+	defer hq.Finished()
+
+	s.files.ServeHTTP(rw, r)
+}
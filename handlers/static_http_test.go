@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupStatic(t *testing.T) *http.ServeMux {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>index</h1>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "style.css"), []byte("h1 {}"), 0644)
+
+	s := NewStatic(logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil), "static", dir)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.Prefix(), s.Handle)
+	mux.HandleFunc("/", func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("dynamic"))
+	})
+
+	return mux
+}
+
+func TestStaticServesFileWithContentType(t *testing.T) {
+	mux := setupStatic(t)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/static/style.css", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "text/css")
+	assert.Equal(t, "h1 {}", rr.Body.String())
+}
+
+func TestStaticServesIndexForDirectory(t *testing.T) {
+	mux := setupStatic(t)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/static/", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "text/html")
+	assert.Equal(t, "<h1>index</h1>", rr.Body.String())
+}
+
+func TestStaticReturnsNotFoundForMissingFile(t *testing.T) {
+	mux := setupStatic(t)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/static/missing.js", nil))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestStaticDoesNotServeDynamicPaths(t *testing.T) {
+	mux := setupStatic(t)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api", nil))
+
+	assert.Equal(t, "dynamic", rr.Body.String())
+}
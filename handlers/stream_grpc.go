@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/grpc/api"
+)
+
+// StreamServer implements the gRPC StreamService, messages are sent as fast
+// as the client reads them. gRPC flow control blocks Send once the client
+// stops reading and the time spent blocked is reported in the final message
+// to show the backpressure applied by the client
+type StreamServer struct {
+	logger hclog.Logger
+	count  int
+	size   int
+}
+
+// NewStreamServer creates a StreamServer which sends count messages with a
+// payload of size bytes
+func NewStreamServer(count, size int, logger hclog.Logger) *StreamServer {
+	return &StreamServer{logger: logger, count: count, size: size}
+}
+
+// Stream sends the messages followed by a final message reporting the time
+// spent blocked
+func (s *StreamServer) Stream(in *api.StreamRequest, stream api.StreamService_StreamServer) error {
+	count := s.count
+	if in.Count > 0 {
+		count = int(in.Count)
+	}
+
+	payload := make([]byte, s.size)
+
+	var blocked time.Duration
+	for i := 1; i <= count; i++ {
+		st := time.Now()
+		if err := stream.Send(&api.StreamMessage{Sequence: int64(i), Payload: payload}); err != nil {
+			s.logger.Info("Stream ended by client", "sent", i-1, "error", err)
+			return err
+		}
+
+		blocked += time.Since(st)
+	}
+
+	s.logger.Info("Stream finished", "messages", count, "blocked", blocked)
+
+	return stream.Send(&api.StreamMessage{Sequence: int64(count + 1), Blocked: blocked.String(), Final: true})
+}
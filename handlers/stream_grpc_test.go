@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/grpc/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func setupStreamServer(t *testing.T, count, size int) api.StreamServiceClient {
+	lis := bufconn.Listen(1024 * 1024)
+	s := grpc.NewServer()
+	api.RegisterStreamServiceServer(s, NewStreamServer(count, size, hclog.NewNullLogger()))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial(
+		"bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return api.NewStreamServiceClient(conn)
+}
+
+// readStream reads the stream waiting delay before each message and returns
+// the number of messages and the final message
+func readStream(t *testing.T, c api.StreamServiceClient, delay time.Duration) (int, *api.StreamMessage) {
+	stream, err := c.Stream(context.Background(), &api.StreamRequest{})
+	require.NoError(t, err)
+
+	n := 0
+	var final *api.StreamMessage
+	for {
+		time.Sleep(delay)
+
+		m, err := stream.Recv()
+		if err == io.EOF {
+			return n, final
+		}
+		require.NoError(t, err)
+
+		if m.Final {
+			final = m
+			continue
+		}
+
+		n++
+	}
+}
+
+func TestStreamReportsTimeBlockedBySlowClient(t *testing.T) {
+	c := setupStreamServer(t, 20, 256*1024)
+
+	n, final := readStream(t, c, 20*time.Millisecond)
+	assert.Equal(t, 20, n)
+	require.NotNil(t, final)
+
+	blocked, err := time.ParseDuration(final.Blocked)
+	assert.NoError(t, err)
+	assert.Greater(t, int64(blocked), int64(100*time.Millisecond))
+}
+
+func TestStreamUsesRequestedCount(t *testing.T) {
+	c := setupStreamServer(t, 20, 16)
+
+	stream, err := c.Stream(context.Background(), &api.StreamRequest{Count: 3})
+	require.NoError(t, err)
+
+	seq := []int64{}
+	for {
+		m, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		seq = append(seq, m.Sequence)
+	}
+
+	assert.Equal(t, []int64{1, 2, 3, 4}, seq)
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTenant is reported for requests without a configured tenant
+const DefaultTenant = "default"
+
+// ErrTenantError is returned for requests which fail with the error rate of
+// their tenant
+var ErrTenantError = fmt.Errorf("Service error injected for tenant")
+
+// Tenant is the behaviour of the service for the requests of a tenant
+type Tenant struct {
+	// Duration replaces the duration of the request when set
+	Duration time.Duration `yaml:"duration"`
+	// ErrorRate is the decimal percentage of requests which fail, errors
+	// are injected in addition to ERROR_RATE
+	ErrorRate float64 `yaml:"error_rate"`
+	// ErrorCode is the code returned for errors, default 500
+	ErrorCode int `yaml:"error_code"`
+	// Body replaces the message of the response when set
+	Body string `yaml:"body"`
+}
+
+// Tenants configures the behaviour of the service for each tenant, the
+// tenant of a request is identified by the value of header. Requests from
+// unknown tenants use the default behaviour
+type Tenants struct {
+	header     string
+	tenants    map[string]Tenant
+	randomFunc func() float64
+}
+
+// NewTenants creates Tenants from a map of tenant ID to behaviour
+func NewTenants(header string, tenants map[string]Tenant) *Tenants {
+	return &Tenants{header: header, tenants: tenants, randomFunc: rand.Float64}
+}
+
+// LoadTenants reads the behaviour of each tenant from a YAML or JSON file
+// which maps tenant ID to behaviour
+func LoadTenants(path, header string) (*Tenants, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := map[string]Tenant{}
+	err = yaml.Unmarshal(d, &tenants)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse tenants: %s", err)
+	}
+
+	return NewTenants(header, tenants), nil
+}
+
+// Resolve returns the ID and behaviour of the tenant of a request, the
+// DefaultTenant and nil are returned for an unknown tenant. A nil Tenants
+// returns an empty ID
+func (t *Tenants) Resolve(h http.Header) (string, *Tenant) {
+	if t == nil {
+		return "", nil
+	}
+
+	id := h.Get(t.header)
+	tenant, ok := t.tenants[id]
+	if !ok {
+		return DefaultTenant, nil
+	}
+
+	return id, &tenant
+}
+
+// Error returns an error for the request of tenant, nil is returned when the
+// request does not fail or the tenant is unknown
+func (t *Tenants) Error(tenant *Tenant) *errors.Response {
+	if tenant == nil || t.randomFunc() >= tenant.ErrorRate {
+		return nil
+	}
+
+	code := tenant.ErrorCode
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+
+	return &errors.Response{Error: ErrTenantError, Code: code}
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTenantsParsesFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tenants")
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "tenants.yaml")
+	ioutil.WriteFile(f, []byte("acme:\n  duration: 100ms\n  body: acme\nglobex:\n  error_rate: 0.5\n  error_code: 503\n"), 0644)
+
+	tn, err := LoadTenants(f, "X-Tenant-ID")
+	assert.NoError(t, err)
+
+	h := http.Header{}
+	h.Set("X-Tenant-ID", "acme")
+	id, tenant := tn.Resolve(h)
+	assert.Equal(t, "acme", id)
+	assert.Equal(t, &Tenant{Duration: 100 * time.Millisecond, Body: "acme"}, tenant)
+
+	h.Set("X-Tenant-ID", "globex")
+	_, tenant = tn.Resolve(h)
+	assert.Equal(t, &Tenant{ErrorRate: 0.5, ErrorCode: 503}, tenant)
+}
+
+func TestTenantsResolvesUnknownTenantToDefault(t *testing.T) {
+	tn := NewTenants("X-Tenant-ID", map[string]Tenant{"acme": {}})
+
+	h := http.Header{}
+	h.Set("X-Tenant-ID", "initech")
+
+	id, tenant := tn.Resolve(h)
+	assert.Equal(t, DefaultTenant, id)
+	assert.Nil(t, tenant)
+	assert.Nil(t, tn.Error(tenant))
+}
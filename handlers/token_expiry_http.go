@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+// ErrorTokenExpired is returned for requests with a bearer token which has
+// outlived its lifetime
+const ErrorTokenExpired = "token expired"
+
+// TokenExpiry models short lived authentication tokens, a bearer token is
+// valid for the lifetime from the first request which presents it. Requests
+// with an expired token are rejected with 401 until a new token is
+// presented. Requests without a bearer token are passed to next
+type TokenExpiry struct {
+	logger    *logging.Logger
+	name      string
+	lifetime  time.Duration
+	size      int
+	now       func() time.Time
+	mutex     sync.Mutex
+	firstSeen map[string]time.Time
+	order     []string
+	next      http.HandlerFunc
+}
+
+// NewTokenExpiry creates a new TokenExpiry which remembers at most size
+// tokens, when full the token first seen longest ago is forgotten
+func NewTokenExpiry(logger *logging.Logger, name string, lifetime time.Duration, size int, next http.HandlerFunc) *TokenExpiry {
+	return &TokenExpiry{
+		logger:    logger,
+		name:      name,
+		lifetime:  lifetime,
+		size:      size,
+		now:       time.Now,
+		firstSeen: map[string]time.Time{},
+		next:      next,
+	}
+}
+
+// Handle the request
+func (t *TokenExpiry) Handle(rw http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r.Header.Get("Authorization"))
+	if token == "" || !t.expired(token) {
+		t.next(rw, r)
+		return
+	}
+
+	t.logger.Log().Info("Rejecting request with expired token", "lifetime", t.lifetime)
+
+	resp := &response.Response{
+		Name:  t.name,
+		Type:  "HTTP",
+		URI:   r.URL.String(),
+		Code:  http.StatusUnauthorized,
+		Error: ErrorTokenExpired,
+	}
+
+	rw.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="token expired"`)
+	rw.Header().Set("Content-Type", "application/json")
+	writeResponse(rw, r, http.StatusUnauthorized, []byte(resp.ToJSON()))
+}
+
+// expired records the first use of token and returns true when its lifetime
+// has passed
+func (t *TokenExpiry) expired(token string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := t.now()
+	seen, ok := t.firstSeen[token]
+	if !ok {
+		if len(t.order) >= t.size && len(t.order) > 0 {
+			delete(t.firstSeen, t.order[0])
+			t.order = t.order[1:]
+		}
+
+		t.firstSeen[token] = now
+		t.order = append(t.order, token)
+
+		return false
+	}
+
+	return !now.Before(seen.Add(t.lifetime))
+}
+
+// bearerToken returns the token of a bearer Authorization header, an empty
+// string is returned for any other scheme
+func bearerToken(authorization string) string {
+	parts := strings.SplitN(authorization, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(parts[1])
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTokenExpiry(t *testing.T, size int) (*TokenExpiry, *time.Time) {
+	te := NewTokenExpiry(
+		logging.NewLogger(&logging.NullMetrics{}, hclog.Default(), nil),
+		"test",
+		time.Minute,
+		size,
+		func(rw http.ResponseWriter, r *http.Request) { fmt.Fprint(rw, "OK") },
+	)
+
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	te.now = func() time.Time { return now }
+
+	return te, &now
+}
+
+func callWithToken(te *TokenExpiry, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rr := httptest.NewRecorder()
+	te.Handle(rr, r)
+
+	return rr
+}
+
+func TestTokenExpiryRejectsTokenAfterLifetime(t *testing.T) {
+	te, now := setupTokenExpiry(t, 10)
+
+	rr := callWithToken(te, "abc")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	*now = now.Add(59 * time.Second)
+	rr = callWithToken(te, "abc")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	*now = now.Add(time.Second)
+	rr = callWithToken(te, "abc")
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Header().Get("WWW-Authenticate"), "invalid_token")
+
+	resp := &response.Response{}
+	assert.NoError(t, resp.FromJSON(rr.Body.Bytes()))
+	assert.Equal(t, ErrorTokenExpired, resp.Error)
+
+	// a new token is valid for its own lifetime
+	rr = callWithToken(te, "def")
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestTokenExpiryPassesRequestsWithoutToken(t *testing.T) {
+	te, now := setupTokenExpiry(t, 10)
+
+	rr := callWithToken(te, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	*now = now.Add(time.Hour)
+	rr = callWithToken(te, "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestTokenExpiryForgetsOldestTokenWhenFull(t *testing.T) {
+	te, now := setupTokenExpiry(t, 1)
+
+	callWithToken(te, "abc")
+	callWithToken(te, "def")
+
+	// abc was forgotten so it is treated as a new token
+	*now = now.Add(time.Hour)
+	rr := callWithToken(te, "abc")
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Tracing reports the tracing configuration of the service
+type Tracing struct {
+	stats TracingStats
+}
+
+// TracingStats is the response returned from the tracing handler
+type TracingStats struct {
+	// Enabled is true when spans are exported to a tracing collector
+	Enabled bool `json:"enabled"`
+	// SampleRate is the fraction of requests which export spans
+	SampleRate float64 `json:"sample_rate"`
+}
+
+// NewTracing creates a new tracing handler
+func NewTracing(enabled bool, sampleRate float64) *Tracing {
+	return &Tracing{stats: TracingStats{Enabled: enabled, SampleRate: sampleRate}}
+}
+
+// Handle the stats request
+func (t *Tracing) Handle(rw http.ResponseWriter, r *http.Request) {
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(t.stats)
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracingReportsSampleRate(t *testing.T) {
+	rr := httptest.NewRecorder()
+	NewTracing(true, 0.25).Handle(rr, httptest.NewRequest(http.MethodGet, "/stats/tracing", nil))
+
+	s := TracingStats{}
+	err := json.Unmarshal(rr.Body.Bytes(), &s)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.True(t, s.Enabled)
+	assert.Equal(t, 0.25, s.SampleRate)
+}
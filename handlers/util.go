@@ -1,35 +1,94 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/nicholasjackson/fake-service/client"
+	"github.com/nicholasjackson/fake-service/errors"
 	"github.com/nicholasjackson/fake-service/grpc/api"
+	"github.com/nicholasjackson/fake-service/load"
 	"github.com/nicholasjackson/fake-service/logging"
 	"github.com/nicholasjackson/fake-service/response"
 	"github.com/nicholasjackson/fake-service/worker"
 	opentracing "github.com/opentracing/opentracing-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
 const timeFormat = "2006-01-02T15:04:05.000000"
 
-func workerHTTP(ctx opentracing.SpanContext, uri string, defaultClient client.HTTP, pr *http.Request, l *logging.Logger) (*response.Response, error) {
+// upstreamCall annotates the spans of the attempts to call an upstream, an
+// upstream is attempted more than once when it is retried or its protocol is
+// detected
+type upstreamCall struct {
+	circuit string
+	// retries is the number of times the call was retried before this attempt
+	retries  int
+	attempts int
+}
+
+// annotate adds the protocol, retries, circuit state and response code of an
+// attempt to its span, when u is nil only the protocol and code are added
+func (u *upstreamCall) annotate(span opentracing.Span, protocol string, code int) {
+	span.SetTag("upstream.protocol", protocol)
+	span.SetTag("upstream.code", code)
+
+	if u == nil {
+		return
+	}
+
+	u.attempts++
+	span.SetTag("upstream.retries", u.retries+u.attempts-1)
+
+	if u.circuit != "" {
+		span.SetTag("upstream.circuit", u.circuit)
+	}
+}
+
+func workerHTTP(ctx opentracing.SpanContext, uri string, defaultClient client.HTTP, pr *http.Request, l *logging.Logger, prepare func(*http.Request), deadline context.Context, rawBodyLimit int, call *upstreamCall) (*response.Response, error) {
 	httpReq, _ := http.NewRequest("GET", uri, nil)
+	httpReq = setDeadline(httpReq, deadline)
+	if prepare != nil {
+		prepare(httpReq)
+	}
 
 	hr := l.CallHTTPUpstream(pr, httpReq, ctx)
 	defer hr.Finished()
 
 	code, resp, headers, cookies, err := defaultClient.Do(httpReq, pr)
 
+	r := &response.Response{}
+
+	// a truncated body is reported on the response but is not an error,
+	// the partial body is unlikely to be valid JSON
+	if te, ok := err.(*client.TruncatedError); ok {
+		l.Log().Warn("Upstream response truncated", "uri", uri, "limit", te.Limit)
+
+		r.Truncated = true
+		r.BytesRead = int64(len(resp))
+		err = nil
+	}
+
 	hr.SetMetadata("response", strconv.Itoa(code))
 	hr.SetError(err)
-
-	r := &response.Response{}
+	call.annotate(hr.Span, ProtocolHTTP, code)
 
 	if resp != nil {
 		jsonerr := r.FromJSON(resp)
@@ -39,6 +98,13 @@ func workerHTTP(ctx opentracing.SpanContext, uri string, defaultClient client.HT
 			// upstream
 			// in this instance create a blank response with the error
 			l.Log().Error("Unable to read response JSON", "error", jsonerr)
+
+			// wrap the start of the raw body so the caller can see what the
+			// upstream returned
+			if rawBodyLimit > 0 {
+				r.NonJSON = true
+				r.RawBody = truncateBody(resp, rawBodyLimit)
+			}
 		}
 	}
 
@@ -55,10 +121,33 @@ func workerHTTP(ctx opentracing.SpanContext, uri string, defaultClient client.HT
 	return r, err
 }
 
-func workerGRPC(ctx opentracing.SpanContext, uri string, grpcClients map[string]client.GRPC, l *logging.Logger) (*response.Response, error) {
+func workerGRPC(ctx opentracing.SpanContext, uri string, grpcClients map[string]client.GRPC, l *logging.Logger, deadline context.Context, call *upstreamCall) (*response.Response, error) {
 	hr, outCtx := l.CallGRCPUpstream(uri, ctx)
 	defer hr.Finished()
 
+	// the gRPC deadline is propagated to the upstream with the call
+	if deadline != nil {
+		if d, ok := deadline.Deadline(); ok {
+			var cancel context.CancelFunc
+			outCtx, cancel = context.WithDeadline(outCtx, d)
+			defer cancel()
+		}
+
+		// abandon the call when the deadline context is cancelled e.g. when
+		// a hedged request has already responded
+		var cancel context.CancelFunc
+		outCtx, cancel = context.WithCancel(outCtx)
+		defer cancel()
+
+		go func() {
+			select {
+			case <-deadline.Done():
+				cancel()
+			case <-outCtx.Done():
+			}
+		}()
+	}
+
 	c := grpcClients[uri]
 	resp, headers, err := c.Handle(outCtx, &api.Nil{})
 
@@ -67,6 +156,14 @@ func workerGRPC(ctx opentracing.SpanContext, uri string, grpcClients map[string]
 		r.Error = err.Error()
 		hr.SetError(err) // set the error for logging
 
+		switch err.(type) {
+		case *client.ConnectError, *client.DNSError:
+			// the connection could not be established, reported with the
+			// same code as an HTTP upstream
+			r.Code = -1
+			hr.SetMetadata("ResponseCode", strconv.Itoa(r.Code))
+		}
+
 		if s, ok := status.FromError(err); ok {
 			r.Code = int(s.Code())
 			hr.SetMetadata("ResponseCode", strconv.Itoa(r.Code)) // set the response code for logging
@@ -92,6 +189,8 @@ func workerGRPC(ctx opentracing.SpanContext, uri string, grpcClients map[string]
 		}
 	}
 
+	call.annotate(hr.Span, ProtocolGRPC, int(status.Code(err)))
+
 	// set the local URI for the upstream
 	r.URI = uri
 	r.Type = "gRPC"
@@ -105,6 +204,237 @@ func workerGRPC(ctx opentracing.SpanContext, uri string, grpcClients map[string]
 	return r, nil
 }
 
+// repeatURIs returns uris repeated count times, a count less than 2 returns
+// uris unchanged
+func repeatURIs(uris []string, count int) []string {
+	if count < 2 {
+		return uris
+	}
+
+	repeated := make([]string, 0, len(uris)*count)
+	for n := 0; n < count; n++ {
+		repeated = append(repeated, uris...)
+	}
+
+	return repeated
+}
+
+// retryAfterSeconds formats d as the whole seconds of a Retry-After header,
+// partial seconds are rounded up
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// sleepContext sleeps for d or until ctx is done, the error of ctx is
+// returned when the sleep is interrupted
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// truncateBody returns the first limit bytes of body as a string
+func truncateBody(body []byte, limit int) string {
+	if len(body) > limit {
+		body = body[:limit]
+	}
+
+	return string(body)
+}
+
+// varyETag generates an ETag for the response content and the values of the
+// headers the response varies by
+func varyETag(name, message string, headers []string, values map[string]string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n", name, message)
+
+	for _, k := range headers {
+		fmt.Fprintf(h, "%s=%s\n", strings.ToLower(k), values[k])
+	}
+
+	return fmt.Sprintf(`"%x"`, h.Sum(nil))
+}
+
+// requestVariant returns a variant in the range [0, variants) derived from a
+// stable hash of the request path and query, query parameters are sorted so
+// that their order does not change the variant
+func requestVariant(u *url.URL, variants int) int {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%s?%s", u.Path, u.Query().Encode())
+
+	return int(h.Sum32() % uint32(variants))
+}
+
+// clientIP returns the IP address from the remote address of a request
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+
+	return host
+}
+
+// tlsConnection returns the TLS version of a connection and whether the
+// session was resumed, nil is returned for a connection without TLS
+func tlsConnection(cs *tls.ConnectionState) *response.TLS {
+	if cs == nil {
+		return nil
+	}
+
+	version := "unknown"
+	switch cs.Version {
+	case tls.VersionTLS10:
+		version = "TLS 1.0"
+	case tls.VersionTLS11:
+		version = "TLS 1.1"
+	case tls.VersionTLS12:
+		version = "TLS 1.2"
+	case tls.VersionTLS13:
+		version = "TLS 1.3"
+	}
+
+	return &response.TLS{Version: version, Resumed: cs.DidResume}
+}
+
+// SchemaVersionHeader is the request header or gRPC metadata key which sets
+// the schema version of the response, HTTP requests can also use the
+// schema_version query parameter
+const SchemaVersionHeader = "Accept-Version"
+
+// httpSchemaVersion returns the schema version requested by an HTTP request
+func httpSchemaVersion(r *http.Request) string {
+	if v := r.Header.Get(SchemaVersionHeader); v != "" {
+		return v
+	}
+
+	return r.URL.Query().Get("schema_version")
+}
+
+// grpcMetadata returns the first value of key in the metadata of a gRPC
+// request
+func grpcMetadata(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if v := md.Get(key); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+const (
+	// LoadCPUHeader is the request header or gRPC metadata key which sets the
+	// milliseconds of CPU spent on a single request
+	LoadCPUHeader = "X-Fake-CPU-Ms"
+	// LoadMemoryHeader is the request header or gRPC metadata key which sets
+	// the megabytes of memory held for a single request
+	LoadMemoryHeader = "X-Fake-Mem-MB"
+)
+
+// generateRequestLoad generates the CPU and memory load requested by the
+// values of the load headers, when rl is nil or no load is requested no load
+// is generated. The memory is held until the returned function is called
+func generateRequestLoad(rl *load.RequestLoad, cpuMs, memoryMB string) (*response.RequestLoad, load.Finished) {
+	if rl == nil || (cpuMs == "" && memoryMB == "") {
+		return nil, func() {}
+	}
+
+	// invalid values are treated as no load
+	ms, _ := strconv.Atoi(cpuMs)
+	mb, _ := strconv.Atoi(memoryMB)
+
+	cpu, mb := rl.Clamp(time.Duration(ms)*time.Millisecond, mb)
+
+	return &response.RequestLoad{CPU: cpu.String(), MemoryMB: mb}, rl.Generate(cpu, mb)
+}
+
+// grpcCompression returns the compression of the request read from the
+// transport stream of the call. The response uses the compression of the
+// request unless the server always compresses responses with gzip
+func grpcCompression(ctx context.Context, forced bool) *response.Compression {
+	c := &response.Compression{Request: encoding.Identity, Response: encoding.Identity}
+
+	if s, ok := grpc.ServerTransportStreamFromContext(ctx).(interface{ RecvCompress() string }); ok && s.RecvCompress() != "" {
+		c.Request = s.RecvCompress()
+	}
+
+	// the server responds with the request compression when it has a
+	// compressor for it
+	if c.Request != encoding.Identity && encoding.GetCompressor(c.Request) != nil {
+		c.Response = c.Request
+	}
+
+	if forced {
+		c.Response = gzip.Name
+	}
+
+	return c
+}
+
+// redactedValue replaces the value of any redacted header or metadata
+const redactedValue = "[REDACTED]"
+
+// echoMetadata returns the incoming gRPC metadata, the value of any key in
+// redact is masked
+func echoMetadata(ctx context.Context, redact []string) map[string]string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	echo := map[string]string{}
+	for k, v := range md {
+		echo[k] = strings.Join(v, ",")
+	}
+
+	for _, k := range redact {
+		k = strings.ToLower(k)
+		if _, ok := echo[k]; ok {
+			echo[k] = redactedValue
+		}
+	}
+
+	return echo
+}
+
+// echoTrailers reads the request body to the end so that the trailers are
+// received and returns them, the value of any trailer in redact is masked
+func echoTrailers(r *http.Request, redact []string) map[string]string {
+	if len(r.Trailer) == 0 {
+		return nil
+	}
+
+	io.Copy(ioutil.Discard, r.Body)
+
+	echo := map[string]string{}
+	for k, v := range r.Trailer {
+		echo[k] = strings.Join(v, ",")
+	}
+
+	for _, k := range redact {
+		k = http.CanonicalHeaderKey(k)
+		if _, ok := echo[k]; ok {
+			echo[k] = redactedValue
+		}
+	}
+
+	return echo
+}
+
 func processResponses(responses []worker.Done) []byte {
 	respLines := []string{}
 
@@ -123,40 +453,78 @@ func processResponses(responses []worker.Done) []byte {
 	return []byte(strings.Join(respLines, "\n"))
 }
 
-// Get a list of non loopback Ip addresses
-// realistically this is not going to change to cache
-var ipAddresses []string
+// writeResponse writes data with its Content-Length, the body is not written
+// for a HEAD request so that the headers match the equivalent GET
+func writeResponse(rw http.ResponseWriter, r *http.Request, code int, data []byte) {
+	writeResponseChunks(rw, r, code, data, 0)
+}
+
+// writeResponseChunks writes the response body in chunks of at most
+// chunkSize bytes, each chunk is flushed so that it leaves the server in a
+// separate write. A chunkSize of 0 writes the body at once
+func writeResponseChunks(rw http.ResponseWriter, r *http.Request, code int, data []byte, chunkSize int) {
+	rw.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	rw.WriteHeader(code)
 
-func getIPInfo() []string {
-	if len(ipAddresses) > 0 {
-		return ipAddresses
+	if r.Method == http.MethodHead {
+		return
 	}
 
-	ips := []string{}
+	writeChunks(rw, data, chunkSize)
+}
 
-	ifaces, _ := net.Interfaces()
-	for _, i := range ifaces {
-		addrs, _ := i.Addrs()
-		// handle err
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
+// writeChunks writes data to w in chunks of at most size bytes, flushing w
+// after each chunk when it is an http.Flusher
+func writeChunks(w io.Writer, data []byte, size int) error {
+	if size <= 0 {
+		size = len(data)
+	}
 
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
+	f, _ := w.(http.Flusher)
+	for len(data) > 0 {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
 
-			// ignore localhost
-			if !ip.IsLoopback() && !ip.IsMulticast() && ip.To4() != nil {
-				ips = append(ips, ip.String())
-			}
-			// process IP address
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+
+		if f != nil {
+			f.Flush()
 		}
+
+		data = data[n:]
+	}
+
+	return nil
+}
+
+// injectError returns the injected error for the request, when i is nil no
+// errors are injected
+func injectError(i *errors.Injector) *errors.Response {
+	if i == nil {
+		return nil
+	}
+
+	return i.Do()
+}
+
+// injectSizeError returns the injected error for a request with a large body,
+// when the Content-Length is not known the body is read up to the limit to
+// measure it and replaced so that it can still be read by the handler
+func injectSizeError(s *errors.SizeInjector, r *http.Request) *errors.Response {
+	if s == nil {
+		return nil
+	}
+
+	size := r.ContentLength
+	if size < 0 && r.Body != nil {
+		d, _ := ioutil.ReadAll(io.LimitReader(r.Body, s.Limit()+1))
+		r.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(d), r.Body))
+		size = int64(len(d))
 	}
 
-	// cache the result
-	ipAddresses = ips
-	return ips
+	return s.Do(size)
 }
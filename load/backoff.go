@@ -0,0 +1,61 @@
+package load
+
+// SetMemoryBackoff reduces the memory generated when the process nears
+// limit so that a long running demo is not killed for running out of memory.
+// When the memory used by the process reaches high, a fraction of limit, the
+// memory held is reduced by the amount over low every tick until the memory
+// used falls to low, the memory target is then restored. A limit of 0
+// disables the backoff
+func (g *NodeGenerator) SetMemoryBackoff(limit uint64, high, low float64) {
+	g.memoryLimit = limit
+	g.memoryHighWater = high
+	g.memoryLowWater = low
+}
+
+// backoff returns the memory to hold for target, target is reduced while the
+// process is above the high water mark until it falls below the low water
+// mark
+func (g *NodeGenerator) backoff(target int) int {
+	if g.memoryLimit == 0 || g.memoryHighWater <= 0 {
+		return target
+	}
+
+	used, err := g.memoryUsage()
+	if err != nil {
+		g.logger.Debug("Unable to read memory usage", "error", err)
+		return target
+	}
+
+	high := uint64(float64(g.memoryLimit) * g.memoryHighWater)
+	low := uint64(float64(g.memoryLimit) * g.memoryLowWater)
+
+	switch {
+	case !g.backingOff && used >= high:
+		g.backingOff = true
+		g.logger.Warn("Memory above high water mark, reducing memory load", "used_MB", bToMb(used), "high_MB", bToMb(high), "low_MB", bToMb(low))
+	case g.backingOff && used <= low:
+		g.backingOff = false
+		g.logger.Info("Memory below low water mark, restoring memory load", "used_MB", bToMb(used), "low_MB", bToMb(low))
+	}
+
+	if !g.backingOff {
+		return target
+	}
+
+	// release the memory over the low water mark, the target is never
+	// increased while backing off
+	reduced := g.heldBytes
+	if used > low {
+		reduced -= int(used - low)
+	}
+
+	if reduced < 0 {
+		reduced = 0
+	}
+
+	if reduced < target {
+		return reduced
+	}
+
+	return target
+}
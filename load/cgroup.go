@@ -0,0 +1,59 @@
+package load
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cgroupUnlimited is the smallest cgroup v1 memory limit treated as no
+// limit, an unlimited cgroup v1 reports a limit close to the max int64
+const cgroupUnlimited = uint64(1) << 60
+
+// parseCgroupMemoryLimit returns the memory limit in bytes from the contents
+// of a cgroup v2 memory.max or cgroup v1 memory.limit_in_bytes file, 0 is
+// returned when the cgroup has no limit
+func parseCgroupMemoryLimit(data []byte) (uint64, error) {
+	v := strings.TrimSpace(string(data))
+	if v == "max" {
+		return 0, nil
+	}
+
+	limit, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cgroup memory limit: %q", v)
+	}
+
+	if limit >= cgroupUnlimited {
+		return 0, nil
+	}
+
+	return limit, nil
+}
+
+// parseCgroupCPUQuota returns the number of CPUs the cgroup may use from the
+// contents of a cgroup v2 cpu.max file, the quota and period in
+// microseconds. 0 is returned when the cgroup has no quota
+func parseCgroupCPUQuota(data []byte) (float64, error) {
+	f := strings.Fields(string(data))
+	if len(f) != 2 {
+		return 0, fmt.Errorf("invalid cgroup CPU quota: %q", string(data))
+	}
+
+	// cgroup v1 reports no quota as -1
+	if f[0] == "max" || f[0] == "-1" {
+		return 0, nil
+	}
+
+	quota, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cgroup CPU quota: %q", f[0])
+	}
+
+	period, err := strconv.ParseFloat(f[1], 64)
+	if err != nil || period <= 0 {
+		return 0, fmt.Errorf("invalid cgroup CPU period: %q", f[1])
+	}
+
+	return quota / period, nil
+}
@@ -0,0 +1,68 @@
+package load
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// cgroupMemoryFiles are the cgroup v2 and v1 files containing the memory
+// limit of the container
+var cgroupMemoryFiles = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// cgroup v2 has a single file containing the CPU quota and period of the
+// container, cgroup v1 has a file for each
+const (
+	cgroupCPUFile         = "/sys/fs/cgroup/cpu.max"
+	cgroupV1CPUQuotaFile  = "/sys/fs/cgroup/cpu/cpu.cfs_quota_us"
+	cgroupV1CPUPeriodFile = "/sys/fs/cgroup/cpu/cpu.cfs_period_us"
+)
+
+// CgroupMemoryLimit returns the memory limit in bytes of the cgroup the
+// process is running in, 0 is returned when the cgroup has no limit
+func CgroupMemoryLimit() (uint64, error) {
+	return cgroupMemoryLimit(cgroupMemoryFiles)
+}
+
+// cgroupMemoryLimit returns the limit from the first of files which exists
+func cgroupMemoryLimit(files []string) (uint64, error) {
+	for _, f := range files {
+		d, err := ioutil.ReadFile(f)
+		if err != nil {
+			continue
+		}
+
+		return parseCgroupMemoryLimit(d)
+	}
+
+	return 0, fmt.Errorf("no cgroup memory limit file found")
+}
+
+// CgroupCPUQuota returns the number of CPUs the cgroup the process is running
+// in may use, 0 is returned when the cgroup has no quota
+func CgroupCPUQuota() (float64, error) {
+	return cgroupCPUQuota(cgroupCPUFile, cgroupV1CPUQuotaFile, cgroupV1CPUPeriodFile)
+}
+
+// cgroupCPUQuota returns the quota from the cgroup v2 file when it exists
+// otherwise from the cgroup v1 quota and period files
+func cgroupCPUQuota(file, v1QuotaFile, v1PeriodFile string) (float64, error) {
+	if d, err := ioutil.ReadFile(file); err == nil {
+		return parseCgroupCPUQuota(d)
+	}
+
+	quota, err := ioutil.ReadFile(v1QuotaFile)
+	if err != nil {
+		return 0, fmt.Errorf("no cgroup CPU quota file found")
+	}
+
+	period, err := ioutil.ReadFile(v1PeriodFile)
+	if err != nil {
+		return 0, fmt.Errorf("no cgroup CPU period file found")
+	}
+
+	return parseCgroupCPUQuota([]byte(strings.TrimSpace(string(quota)) + " " + strings.TrimSpace(string(period))))
+}
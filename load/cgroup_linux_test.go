@@ -0,0 +1,75 @@
+package load
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCgroupMemoryLimitReadsFirstExistingFile(t *testing.T) {
+	limit, err := cgroupMemoryLimit([]string{"testdata/missing", "testdata/memory.max", "testdata/memory.limit_in_bytes"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(256*1024*1024), limit)
+}
+
+func TestCgroupMemoryLimitReturnsZeroWhenUnlimited(t *testing.T) {
+	limit, err := cgroupMemoryLimit([]string{"testdata/memory.max.unlimited"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), limit)
+
+	limit, err = cgroupMemoryLimit([]string{"testdata/memory.limit_in_bytes"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), limit)
+}
+
+func TestCgroupMemoryLimitReturnsErrorWithoutFiles(t *testing.T) {
+	_, err := cgroupMemoryLimit([]string{"testdata/missing"})
+
+	assert.Error(t, err)
+}
+
+func TestGeneratorMemoryClampedToCgroupLimit(t *testing.T) {
+	limit, err := cgroupMemoryLimit([]string{"testdata/memory.max"})
+	assert.NoError(t, err)
+
+	// 1GB with 50% variance can allocate 1.5GB
+	g := NewGenerator(0, 0, 1024*1024*1024, 50, hclog.NewNullLogger())
+	g.ClampMemory(limit, 0.75)
+
+	// 75% of 256MB is 192MB, the maximum allocation including variance
+	assert.Equal(t, 128*1024*1024, g.memoryBytes)
+}
+
+func TestCgroupCPUQuotaReadsCgroupV2File(t *testing.T) {
+	quota, err := cgroupCPUQuota("testdata/cpu.max", "testdata/cpu.cfs_quota_us", "testdata/cpu.cfs_period_us")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, quota)
+}
+
+func TestCgroupCPUQuotaReadsCgroupV1Files(t *testing.T) {
+	quota, err := cgroupCPUQuota("testdata/missing", "testdata/cpu.cfs_quota_us", "testdata/cpu.cfs_period_us")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, quota)
+}
+
+func TestCgroupCPUQuotaReturnsZeroWhenUnlimited(t *testing.T) {
+	quota, err := cgroupCPUQuota("testdata/cpu.max.unlimited", "testdata/missing", "testdata/missing")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, quota)
+}
+
+func TestGeneratorCPUClampedToCgroupQuota(t *testing.T) {
+	quota, err := cgroupCPUQuota("testdata/cpu.max", "testdata/missing", "testdata/missing")
+	assert.NoError(t, err)
+
+	g := NewGenerator(8, 100, 0, 0, hclog.NewNullLogger())
+	g.ClampCPU(quota)
+
+	// 1.5 CPUs is rounded down to a single core
+	assert.Equal(t, 1.0, g.cpuCoresCount)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package load
+
+import "fmt"
+
+// CgroupMemoryLimit returns the memory limit in bytes of the cgroup the
+// process is running in, cgroups are only supported on Linux
+func CgroupMemoryLimit() (uint64, error) {
+	return 0, fmt.Errorf("cgroup memory limit is not supported on this platform")
+}
+
+// CgroupCPUQuota returns the number of CPUs the cgroup the process is running
+// in may use, cgroups are only supported on Linux
+func CgroupCPUQuota() (float64, error) {
+	return 0, fmt.Errorf("cgroup CPU quota is not supported on this platform")
+}
@@ -0,0 +1,50 @@
+package load
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCgroupMemoryLimitReturnsErrorForInvalidData(t *testing.T) {
+	_, err := parseCgroupMemoryLimit([]byte("lots"))
+
+	assert.Error(t, err)
+}
+
+func TestClampMemoryDoesNotChangeMemoryWithinLimit(t *testing.T) {
+	g := NewGenerator(0, 0, 100, 0, hclog.NewNullLogger())
+	g.ClampMemory(1000, 0.5)
+
+	assert.Equal(t, 100, g.memoryBytes)
+}
+
+func TestClampMemoryIgnoresUnlimitedCgroup(t *testing.T) {
+	g := NewGenerator(0, 0, 100, 0, hclog.NewNullLogger())
+	g.ClampMemory(0, 0.5)
+
+	assert.Equal(t, 100, g.memoryBytes)
+}
+
+func TestParseCgroupCPUQuotaReturnsErrorForInvalidData(t *testing.T) {
+	_, err := parseCgroupCPUQuota([]byte("lots"))
+	assert.Error(t, err)
+
+	_, err = parseCgroupCPUQuota([]byte("100000 0"))
+	assert.Error(t, err)
+}
+
+func TestClampCPUDoesNotChangeCoresWithinQuota(t *testing.T) {
+	g := NewGenerator(2, 100, 0, 0, hclog.NewNullLogger())
+	g.ClampCPU(4)
+
+	assert.Equal(t, 2.0, g.cpuCoresCount)
+}
+
+func TestClampCPUIgnoresUnlimitedCgroup(t *testing.T) {
+	g := NewGenerator(8, 100, 0, 0, hclog.NewNullLogger())
+	g.ClampCPU(0)
+
+	assert.Equal(t, 8.0, g.cpuCoresCount)
+}
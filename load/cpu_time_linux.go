@@ -0,0 +1,16 @@
+package load
+
+import (
+	"syscall"
+	"time"
+)
+
+// ProcessCPUTime returns the user and system CPU time consumed by the process
+func ProcessCPUTime() (time.Duration, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, err
+	}
+
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano()), nil
+}
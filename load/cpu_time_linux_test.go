@@ -0,0 +1,18 @@
+package load
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessCPUTimeIncreasesWithWork(t *testing.T) {
+	before, err := ProcessCPUTime()
+	assert.NoError(t, err)
+
+	DoWork(10000)
+
+	after, err := ProcessCPUTime()
+	assert.NoError(t, err)
+	assert.Greater(t, int64(after), int64(before))
+}
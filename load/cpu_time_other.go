@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package load
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProcessCPUTime returns the CPU time consumed by the process, reading
+// process CPU time from the OS is currently only supported on Linux
+func ProcessCPUTime() (time.Duration, error) {
+	return 0, fmt.Errorf("process CPU time is not supported on this platform")
+}
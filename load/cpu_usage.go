@@ -0,0 +1,96 @@
+package load
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// CPUUsage measures the CPU used by the process as a percentage of the cores
+// available to it, the usage is sampled every interval in the background
+type CPUUsage struct {
+	logger   hclog.Logger
+	cores    float64
+	interval time.Duration
+	cpuTime  func() (time.Duration, error)
+	now      func() time.Time
+	mutex    sync.Mutex
+	lastCPU  time.Duration
+	lastAt   time.Time
+	usage    float64
+	stop     chan struct{}
+}
+
+// NewCPUUsage creates a CPUUsage which measures usage over cores every
+// interval, usage of all cores is 100
+func NewCPUUsage(cores float64, interval time.Duration, logger hclog.Logger) *CPUUsage {
+	return &CPUUsage{
+		logger:   logger,
+		cores:    cores,
+		interval: interval,
+		cpuTime:  ProcessCPUTime,
+		now:      time.Now,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start samples the usage every interval in the background until Stop is
+// called
+func (c *CPUUsage) Start() {
+	c.logger.Info("Measuring CPU usage", "cores", c.cores, "interval", c.interval)
+
+	go func() {
+		t := time.NewTicker(c.interval)
+		defer t.Stop()
+
+		for {
+			if err := c.Sample(); err != nil {
+				c.logger.Warn("Unable to measure CPU usage", "error", err)
+			}
+
+			select {
+			case <-t.C:
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the sampling
+func (c *CPUUsage) Stop() {
+	close(c.stop)
+}
+
+// Sample measures the usage since the previous sample, the first sample only
+// records the CPU time
+func (c *CPUUsage) Sample() error {
+	cpu, err := c.cpuTime()
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	if !c.lastAt.IsZero() && now.After(c.lastAt) {
+		used := float64(cpu-c.lastCPU) / float64(now.Sub(c.lastAt))
+		c.usage = math.Min(100, used/c.cores*100)
+	}
+
+	c.lastCPU = cpu
+	c.lastAt = now
+
+	return nil
+}
+
+// Usage returns the percentage of the cores used at the last sample
+func (c *CPUUsage) Usage() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.usage
+}
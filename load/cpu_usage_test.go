@@ -0,0 +1,33 @@
+package load
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCPUUsageMeasuresUsageOfCores(t *testing.T) {
+	cpu := time.Duration(0)
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	c := NewCPUUsage(2, time.Second, hclog.NewNullLogger())
+	c.cpuTime = func() (time.Duration, error) { return cpu, nil }
+	c.now = func() time.Time { return now }
+
+	assert.NoError(t, c.Sample())
+	assert.Equal(t, 0.0, c.Usage())
+
+	// one second of CPU in a second is half of two cores
+	cpu += time.Second
+	now = now.Add(time.Second)
+	assert.NoError(t, c.Sample())
+	assert.InDelta(t, 50, c.Usage(), 0.001)
+
+	// the usage is measured since the previous sample
+	cpu += 100 * time.Millisecond
+	now = now.Add(time.Second)
+	assert.NoError(t, c.Sample())
+	assert.InDelta(t, 5, c.Usage(), 0.001)
+}
@@ -0,0 +1,91 @@
+package load
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileAllocation opens a fixed number of file descriptors for each request
+// which are held until the request completes, concurrent requests drive up
+// the number of open descriptors to model descriptor exhaustion. The total
+// number of descriptors held across all requests is limited to max
+type FileAllocation struct {
+	count int
+	max   int
+	open  int
+	mutex sync.Mutex
+}
+
+// NewFileAllocation creates a FileAllocation which opens count descriptors
+// for each request and holds at most max descriptors
+func NewFileAllocation(count, max int) *FileAllocation {
+	return &FileAllocation{count: count, max: max}
+}
+
+// Allocate opens the descriptors for a request, the descriptors are closed
+// when the returned function is called. Fewer descriptors are opened when
+// the max has been reached, an error is returned when a descriptor can not
+// be opened, any descriptors which were opened are still held until the
+// returned function is called. A nil FileAllocation does not open any
+// descriptors
+func (a *FileAllocation) Allocate() (Finished, error) {
+	if a == nil || a.count == 0 {
+		return func() {}, nil
+	}
+
+	n := a.reserve()
+
+	files := make([]*os.File, 0, n)
+	var err error
+	for i := 0; i < n; i++ {
+		var f *os.File
+		f, err = os.Open(os.DevNull)
+		if err != nil {
+			err = fmt.Errorf("unable to open file descriptor %d of %d: %s", i+1, n, err)
+			break
+		}
+
+		files = append(files, f)
+	}
+
+	// release the reservation for any descriptors which failed to open
+	a.release(n - len(files))
+
+	return func() {
+		for _, f := range files {
+			f.Close()
+		}
+
+		a.release(len(files))
+	}, err
+}
+
+// Open returns the number of descriptors currently held by requests
+func (a *FileAllocation) Open() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.open
+}
+
+// reserve reserves up to count descriptors without exceeding max
+func (a *FileAllocation) reserve() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	n := a.count
+	if a.max > 0 && a.open+n > a.max {
+		n = a.max - a.open
+	}
+
+	a.open += n
+	return n
+}
+
+func (a *FileAllocation) release(n int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.open -= n
+}
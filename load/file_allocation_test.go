@@ -0,0 +1,43 @@
+package load
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileAllocationHoldsDescriptorsUntilFinished(t *testing.T) {
+	a := NewFileAllocation(5, 100)
+
+	finished, err := a.Allocate()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, a.Open())
+
+	finished()
+	assert.Equal(t, 0, a.Open())
+}
+
+func TestFileAllocationLimitsDescriptorsToMax(t *testing.T) {
+	a := NewFileAllocation(5, 8)
+
+	first, err := a.Allocate()
+	assert.NoError(t, err)
+
+	second, err := a.Allocate()
+	assert.NoError(t, err)
+	assert.Equal(t, 8, a.Open())
+
+	first()
+	assert.Equal(t, 3, a.Open())
+
+	second()
+	assert.Equal(t, 0, a.Open())
+}
+
+func TestFileAllocationNilDoesNotOpenDescriptors(t *testing.T) {
+	var a *FileAllocation
+
+	finished, err := a.Allocate()
+	assert.NoError(t, err)
+	finished()
+}
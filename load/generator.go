@@ -1,8 +1,10 @@
 package load
 
 import (
+	"math"
 	"math/rand"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
@@ -23,11 +25,38 @@ type Generator struct {
 	memoryVariance int
 	running        bool
 	finished       chan struct{}
+	mutex          sync.Mutex
 }
 
 // NewGenerator creates a new load generator that can create artificial memory and cpu pressure
 func NewGenerator(cores, percentage float64, memoryBytes, memoryVariance int, logger hclog.Logger) *Generator {
-	return &Generator{logger, cores, percentage, memoryBytes, memoryVariance, false, nil}
+	return &Generator{
+		logger:         logger,
+		cpuCoresCount:  cores,
+		cpuPercentage:  percentage,
+		memoryBytes:    memoryBytes,
+		memoryVariance: memoryVariance,
+	}
+}
+
+// SetLoad changes the CPU and memory load generated for each request while
+// the service is running
+func (g *Generator) SetLoad(cores, percentage float64, memoryBytes int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.cpuCoresCount = cores
+	g.cpuPercentage = percentage
+	g.memoryBytes = memoryBytes
+}
+
+// Load returns the CPU cores, CPU percentage, and memory in bytes generated
+// for each request
+func (g *Generator) Load() (float64, float64, int) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.cpuCoresCount, g.cpuPercentage, g.memoryBytes
 }
 
 // Generate load for the request
@@ -129,7 +158,9 @@ func (g *Generator) generateMemory() {
 		var m runtime.MemStats
 		runtime.ReadMemStats(&m)
 
-		g.logger.Info("Allocated memory", "MB", bToMb(m.Alloc), "mem", memLen)
+		rss, _ := ProcessRSS()
+
+		g.logger.Info("Allocated memory", "MB", bToMb(m.Alloc), "RSS_MB", bToMb(rss), "mem", memLen)
 
 		// block until signal to complete load generation is received
 		// mem should be deallocated when this function completes and will be
@@ -148,3 +179,48 @@ func (g *Generator) generateMemory() {
 func bToMb(b uint64) uint64 {
 	return b / 1024 / 1024
 }
+
+// ClampMemory reduces the memory allocated per request so that the largest
+// allocation, including the variance, is no more than fraction of limit. A
+// limit of 0 is treated as no limit
+func (g *Generator) ClampMemory(limit uint64, fraction float64) {
+	g.logger.Info("Detected cgroup memory limit", "MB", bToMb(limit))
+
+	if limit == 0 || g.memoryBytes == 0 {
+		return
+	}
+
+	allowed := float64(limit) * fraction
+	growth := 1 + float64(g.memoryVariance)/100
+
+	if float64(g.memoryBytes)*growth <= allowed {
+		return
+	}
+
+	clamped := int(allowed / growth)
+	g.logger.Warn("Clamping memory to cgroup limit", "memory", g.memoryBytes, "clamped", clamped, "fraction", fraction)
+
+	g.memoryBytes = clamped
+}
+
+// ClampCPU reduces the cores CPU load is generated over to the CPU quota of
+// the cgroup, GOMAXPROCS is set to the cores so load beyond the quota would
+// be throttled and skew the latency of the service. The quota is rounded
+// down to whole cores with a minimum of 1, a quota of 0 is treated as no
+// limit
+func (g *Generator) ClampCPU(quota float64) {
+	g.logger.Info("Detected cgroup CPU quota", "cores", quota)
+
+	if quota == 0 {
+		return
+	}
+
+	clamped := math.Max(1, math.Floor(quota))
+	if g.cpuCoresCount <= clamped {
+		return
+	}
+
+	g.logger.Warn("Clamping CPU cores to cgroup quota", "cores", g.cpuCoresCount, "clamped", clamped, "quota", quota)
+
+	g.cpuCoresCount = clamped
+}
@@ -0,0 +1,46 @@
+package load
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadMemoryReplay reads a recorded memory series from the file at path, the
+// file contains one memory target in bytes per line, blank lines and lines
+// starting with # are ignored
+func LoadMemoryReplay(path string) ([]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	series := []int{}
+	line := 0
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line++
+
+		v := strings.TrimSpace(s.Text())
+		if v == "" || strings.HasPrefix(v, "#") {
+			continue
+		}
+
+		b, err := strconv.Atoi(v)
+		if err != nil || b < 0 {
+			return nil, fmt.Errorf("invalid memory value %q on line %d of %s", v, line, path)
+		}
+
+		series = append(series, b)
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
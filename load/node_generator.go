@@ -5,6 +5,7 @@ import (
 	"math"
 	"math/rand"
 	"runtime"
+	"sync/atomic"
 	"time"
 
 	"github.com/hashicorp/go-hclog"
@@ -27,9 +28,24 @@ type NodeGenerator struct {
 	memoryVariance       int // variance in percent
 	memoryVarianceFun    string
 	memoryVariancePeriod int
-	running              bool
+	memoryReplay         []int          // recorded per tick memory targets in bytes
+	memoryReplayLoop     bool           // restart the replay when the end is reached
+	cpuStartSpread       time.Duration  // window over which the CPU goroutines are started
+	cpuStarted           func(core int) // called when a CPU goroutine starts, used for testing
+	memoryTouchStride    int            // bytes between writes when touching memory, 0 disables touching
+	memoryTouchMode      string         // touch new memory once or re-touch it every tick
+	memory               []byte         // memory held between ticks when it is touched
+	touched              int64          // number of writes made touching memory
+	running              int32          // set to 1 while load is generated, accessed atomically
 	state                *NodeGeneratorState
 	finished             chan struct{}
+	memoryLimit          uint64                 // memory ceiling in bytes the backoff water marks are fractions of
+	memoryHighWater      float64                // fraction of memoryLimit above which the memory target is reduced
+	memoryLowWater       float64                // fraction of memoryLimit below which the memory target is restored
+	memoryUsage          func() (uint64, error) // returns the memory used by the process
+	backingOff           bool                   // true while the memory target is reduced
+	heldBytes            int                    // size of the memory held in the last tick
+	memoryTouchWorkers   int                    // goroutines which touch the held memory in parallel
 }
 
 type NodeGeneratorState struct {
@@ -40,12 +56,17 @@ type NodeGeneratorState struct {
 	startTime        time.Time // time the NodeGenerator was started
 	lastTickTime     time.Time // time the last tick started
 	ticksPerPeriod   int       // number of ticks that fit in the given period based on TICK_DURATION
+	replayIndex      int       // position in the memory replay series
 }
 
 const TICK_INTERVAL = 500 * time.Millisecond
 
 // NewGenerator creates a new load generator that can create artificial memory and cpu pressure
-func NewNodeGenerator(cores, percentage float64, memoryMBytes, memoryVariance int, memoryVarianceFun string, memoryVariancePeriod int, logger hclog.Logger) *NodeGenerator {
+// when memoryReplay is not empty the recorded series overrides the variance function,
+// cpuStartSpread staggers the start of each CPU goroutine evenly over the given window,
+// when memoryTouchStride is greater than 0 a byte is written every stride bytes so that
+// the memory is resident, memoryTouchMode is either once or continuous
+func NewNodeGenerator(cores, percentage float64, memoryMBytes, memoryVariance int, memoryVarianceFun string, memoryVariancePeriod int, memoryReplay []int, memoryReplayLoop bool, cpuStartSpread time.Duration, memoryTouchStride int, memoryTouchMode string, logger hclog.Logger) *NodeGenerator {
 	return &NodeGenerator{
 		logger,
 		cores,
@@ -54,7 +75,15 @@ func NewNodeGenerator(cores, percentage float64, memoryMBytes, memoryVariance in
 		memoryVariance,
 		memoryVarianceFun,
 		memoryVariancePeriod,
-		false,
+		memoryReplay,
+		memoryReplayLoop,
+		cpuStartSpread,
+		nil,
+		memoryTouchStride,
+		memoryTouchMode,
+		nil,
+		0,
+		0,
 		&NodeGeneratorState{
 			memoryMBytes * int(math.Pow(2, 20)),
 			math.Pow(2, 20) * float64(memoryMBytes*memoryVariance) / 100,
@@ -63,8 +92,16 @@ func NewNodeGenerator(cores, percentage float64, memoryMBytes, memoryVariance in
 			time.Now(),
 			time.Now(),
 			int(time.Duration(memoryVariancePeriod) * time.Second / TICK_INTERVAL),
+			0,
 		},
 		nil,
+		0,
+		0,
+		0,
+		ProcessRSS,
+		false,
+		0,
+		1,
 	}
 }
 
@@ -72,7 +109,7 @@ func NewNodeGenerator(cores, percentage float64, memoryMBytes, memoryVariance in
 func (g *NodeGenerator) Generate() Finished {
 	// this needs to be a buffered channel or the return function will block and leak
 	g.finished = make(chan struct{}, 2)
-	g.running = true
+	atomic.StoreInt32(&g.running, 1)
 
 	// generate the memory first to ensure that the CPU consumption
 	// does not block memory creation
@@ -83,7 +120,7 @@ func (g *NodeGenerator) Generate() Finished {
 		// call finished twice for memory and CPU
 		g.finished <- struct{}{}
 		g.finished <- struct{}{}
-		g.running = false
+		atomic.StoreInt32(&g.running, 0)
 	}
 }
 
@@ -94,7 +131,7 @@ func (g *NodeGenerator) generateCPU() {
 	}
 
 	go func() {
-		g.logger.Info("Generating CPU Load", "cores", g.cpuCoresCount, "percentage", g.cpuPercentage)
+		g.logger.Info("Generating CPU Load", "cores", g.cpuCoresCount, "percentage", g.cpuPercentage, "start_spread", g.cpuStartSpread)
 
 		runtime.GOMAXPROCS(int(g.cpuCoresCount))
 
@@ -110,10 +147,18 @@ func (g *NodeGenerator) generateCPU() {
 		runMicrosecond := unitHundredsOfMicrosecond * g.cpuPercentage
 		sleepMicrosecond := unitHundredsOfMicrosecond*100 - runMicrosecond
 		for i := 0; i < int(g.cpuCoresCount); i++ {
-			go func() {
+			go func(core int) {
+				// stagger the start so utilization ramps up rather than
+				// stepping to the full load
+				time.Sleep(g.cpuStartDelay(core))
+
+				if g.cpuStarted != nil {
+					g.cpuStarted(core)
+				}
+
 				runtime.LockOSThread()
 				// endless loop
-				for g.running {
+				for g.isRunning() {
 					begin := time.Now()
 					for {
 						// run 100%
@@ -124,7 +169,7 @@ func (g *NodeGenerator) generateCPU() {
 					// sleep
 					time.Sleep(time.Duration(sleepMicrosecond) * time.Microsecond)
 				}
-			}()
+			}(i)
 		}
 
 		// block until signal to complete load generation is received
@@ -132,32 +177,61 @@ func (g *NodeGenerator) generateCPU() {
 	}()
 }
 
+// isRunning returns true until the load generation is finished, it is read by
+// the load goroutines while the finish func writes it
+func (g *NodeGenerator) isRunning() bool {
+	return atomic.LoadInt32(&g.running) == 1
+}
+
+// cpuStartDelay returns the delay before the given CPU goroutine starts, the
+// goroutines are spaced evenly over cpuStartSpread
+func (g *NodeGenerator) cpuStartDelay(core int) time.Duration {
+	if g.cpuStartSpread <= 0 || g.cpuCoresCount < 1 {
+		return 0
+	}
+
+	return time.Duration(core) * g.cpuStartSpread / time.Duration(int(g.cpuCoresCount))
+}
+
 func (g *NodeGenerator) generateVaryingMemory() {
 	delta := g.getVarianceFuncByName()
 
 	go func() {
 		g.state.startTime = time.Now()
-		for g.running {
+		for g.isRunning() {
 			g.state.lastTickTime = time.Now()
 
-			newMemLen := g.state.currentBytes + delta(g)
-
-			mem := make([]byte, 0, newMemLen)
-			_ = mem
+			newMemLen := g.backoff(g.nextMemory(delta))
+			g.holdMemory(newMemLen)
+			g.heldBytes = newMemLen
 
 			// print the memory consumption
 			var m runtime.MemStats
 			runtime.ReadMemStats(&m)
-			g.state.currentBytes = newMemLen
-			g.logger.Debug("Allocated memory", "MB", bToMb(m.Alloc), "mem", newMemLen)
-			g.tick()
+			rss, _ := ProcessRSS()
+
+			g.logger.Debug("Allocated memory", "MB", bToMb(m.Alloc), "RSS_MB", bToMb(rss), "mem", newMemLen)
 			time.Sleep(TICK_INTERVAL - time.Since(g.state.lastTickTime)) // it's fast, but not free.
 		}
+
+		// release the held memory
+		g.memory = nil
+
 		// block until signal to complete load generation is received
 		<-g.finished
 	}()
 }
 
+// nextMemory calculates the memory for the current tick and advances the state
+func (g *NodeGenerator) nextMemory(delta varianceFunc) int {
+	newMemLen := g.state.currentBytes + delta(g)
+
+	g.state.currentBytes = newMemLen
+	g.tick()
+
+	return newMemLen
+}
+
 func varianceLinear(g *NodeGenerator) int {
 	lVal := 2*g.linearX()*g.state.maxVarianceBytes - g.state.maxVarianceBytes
 	delta := int(lVal)
@@ -204,8 +278,38 @@ func varianceSineWave(g *NodeGenerator) int {
 	return delta
 }
 
+// varianceReplay returns the delta required to reach the next recorded target,
+// once the series is exhausted it either loops or holds the last value
+func varianceReplay(g *NodeGenerator) int {
+	if g.state.replayIndex >= len(g.memoryReplay) {
+		if !g.memoryReplayLoop {
+			return 0
+		}
+
+		g.state.replayIndex = 0
+	}
+
+	target := g.memoryReplay[g.state.replayIndex]
+	g.state.replayIndex++
+
+	delta := target - g.state.currentBytes
+
+	g.logger.Debug(
+		"varianceReplay",
+		"Index", g.state.replayIndex-1,
+		"target", bytesToMiBString(target),
+		"delta", bytesToMiBString(delta),
+	)
+
+	return delta
+}
+
 func (g *NodeGenerator) getVarianceFuncByName() varianceFunc {
 	varianceZero := func(_ *NodeGenerator) int { return 0 }
+	if len(g.memoryReplay) > 0 {
+		return varianceReplay
+	}
+
 	if g.memoryVariance == 0 {
 		return varianceZero
 	}
@@ -0,0 +1,218 @@
+package load
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupNodeGenerator(t *testing.T, replay []int, loop bool) *NodeGenerator {
+	return NewNodeGenerator(0, 0, 1, 0, "linear", 1, replay, loop, 0, 0, "", hclog.NewNullLogger())
+}
+
+func TestNodeGeneratorReplaysRecordedSeries(t *testing.T) {
+	series := []int{2048, 4096, 1024}
+	g := setupNodeGenerator(t, series, false)
+	delta := g.getVarianceFuncByName()
+
+	got := []int{}
+	for i := 0; i < 5; i++ {
+		got = append(got, g.nextMemory(delta))
+	}
+
+	// after the series ends the last value is held
+	assert.Equal(t, []int{2048, 4096, 1024, 1024, 1024}, got)
+}
+
+func TestNodeGeneratorLoopsRecordedSeries(t *testing.T) {
+	series := []int{2048, 4096, 1024}
+	g := setupNodeGenerator(t, series, true)
+	delta := g.getVarianceFuncByName()
+
+	got := []int{}
+	for i := 0; i < 5; i++ {
+		got = append(got, g.nextMemory(delta))
+	}
+
+	assert.Equal(t, []int{2048, 4096, 1024, 2048, 4096}, got)
+}
+
+func TestLoadMemoryReplayParsesFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "replay")
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "trace.txt")
+	ioutil.WriteFile(f, []byte("# recorded trace\n1024\n\n2048\n"), 0644)
+
+	s, err := LoadMemoryReplay(f)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1024, 2048}, s)
+}
+
+func TestLoadMemoryReplayReturnsErrorForInvalidValue(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "replay")
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "trace.txt")
+	ioutil.WriteFile(f, []byte("1024\nabc\n"), 0644)
+
+	_, err := LoadMemoryReplay(f)
+	assert.Error(t, err)
+}
+
+func TestNodeGeneratorStaggersCPUStart(t *testing.T) {
+	g := NewNodeGenerator(4, 1, 0, 0, "linear", 1, nil, false, 120*time.Millisecond, 0, "", hclog.NewNullLogger())
+
+	st := time.Now()
+	started := make(chan time.Duration, 4)
+	g.cpuStarted = func(core int) {
+		started <- time.Since(st)
+	}
+
+	finished := g.Generate()
+	defer finished()
+
+	times := []time.Duration{}
+	for i := 0; i < 4; i++ {
+		times = append(times, <-started)
+	}
+
+	// the first goroutine starts immediately and the last after 3/4 of the spread
+	assert.Less(t, int64(times[0]), int64(30*time.Millisecond))
+	assert.GreaterOrEqual(t, int64(times[3]), int64(90*time.Millisecond))
+	assert.Less(t, int64(times[3]), int64(250*time.Millisecond))
+}
+
+func TestNodeGeneratorSmallerTouchStrideWritesMorePages(t *testing.T) {
+	large := NewNodeGenerator(0, 0, 0, 0, "", 1, nil, false, 0, 64*1024, TouchModeOnce, hclog.NewNullLogger())
+	small := NewNodeGenerator(0, 0, 0, 0, "", 1, nil, false, 0, 4096, TouchModeOnce, hclog.NewNullLogger())
+
+	large.holdMemory(1024 * 1024)
+	small.holdMemory(1024 * 1024)
+
+	assert.Equal(t, int64(16), large.touched)
+	assert.Equal(t, int64(256), small.touched)
+}
+
+func TestNodeGeneratorRetouchesMemoryInContinuousMode(t *testing.T) {
+	once := NewNodeGenerator(0, 0, 0, 0, "", 1, nil, false, 0, 4096, TouchModeOnce, hclog.NewNullLogger())
+	continuous := NewNodeGenerator(0, 0, 0, 0, "", 1, nil, false, 0, 4096, TouchModeContinuous, hclog.NewNullLogger())
+
+	for i := 0; i < 3; i++ {
+		once.holdMemory(1024 * 1024)
+		continuous.holdMemory(1024 * 1024)
+	}
+
+	assert.Equal(t, int64(256), once.touched)
+	assert.Equal(t, int64(768), continuous.touched)
+}
+
+func TestNodeGeneratorDoesNotTouchMemoryWhenDisabled(t *testing.T) {
+	g := NewNodeGenerator(0, 0, 0, 0, "", 1, nil, false, 0, 0, TouchModeOnce, hclog.NewNullLogger())
+
+	g.holdMemory(1024 * 1024)
+
+	assert.Equal(t, int64(0), g.touched)
+	assert.Nil(t, g.memory)
+}
+
+func TestNodeGeneratorBacksOffMemoryBetweenWaterMarks(t *testing.T) {
+	g := setupNodeGenerator(t, nil, false)
+	g.SetMemoryBackoff(1000, 0.9, 0.5)
+
+	var used uint64
+	g.memoryUsage = func() (uint64, error) { return used, nil }
+
+	// below the high water mark the target is held
+	used = 800
+	g.heldBytes = 600
+	assert.Equal(t, 600, g.backoff(600))
+	assert.False(t, g.backingOff)
+
+	// above the high water mark the memory over the low water mark is
+	// released
+	used = 950
+	assert.Equal(t, 150, g.backoff(600))
+	assert.True(t, g.backingOff)
+
+	// between the marks the target stays reduced
+	g.heldBytes = 150
+	used = 600
+	assert.Equal(t, 50, g.backoff(600))
+	assert.True(t, g.backingOff)
+
+	// below the low water mark the target is restored
+	g.heldBytes = 50
+	used = 450
+	assert.Equal(t, 600, g.backoff(600))
+	assert.False(t, g.backingOff)
+}
+
+func TestNodeGeneratorWithoutLimitDoesNotBackOff(t *testing.T) {
+	g := setupNodeGenerator(t, nil, false)
+	g.memoryUsage = func() (uint64, error) { return 1 << 40, nil }
+
+	assert.Equal(t, 600, g.backoff(600))
+}
+
+func TestNodeGeneratorTouchWorkersCoverFullAllocation(t *testing.T) {
+	g := NewNodeGenerator(0, 0, 0, 0, "", 1, nil, false, 0, 4096, TouchModeOnce, hclog.NewNullLogger())
+	g.memoryTouchWorkers = 3
+
+	// the allocation does not divide evenly between the workers
+	g.holdMemory(1024*1024 + 100)
+
+	assert.Equal(t, int64(257), g.touched)
+
+	wrong := 0
+	for i, b := range g.memory {
+		if (i%4096 == 0) != (b == 1) {
+			wrong++
+		}
+	}
+	assert.Equal(t, 0, wrong)
+}
+
+func TestNodeGeneratorTouchWorkersAreCapped(t *testing.T) {
+	g := NewNodeGenerator(float64(runtime.NumCPU()), 0, 0, 0, "", 1, nil, false, 0, 4096, TouchModeOnce, hclog.NewNullLogger())
+	g.SetMemoryTouchWorkers(64)
+
+	assert.Equal(t, 1, g.memoryTouchWorkers)
+}
+
+func TestNodeGeneratorTouchWorkersTouchLargeAllocationFaster(t *testing.T) {
+	if runtime.NumCPU() < 2 {
+		t.Skip("parallel touch requires more than one CPU")
+	}
+
+	single := NewNodeGenerator(0, 0, 0, 0, "", 1, nil, false, 0, 1, TouchModeContinuous, hclog.NewNullLogger())
+	parallel := NewNodeGenerator(0, 0, 0, 0, "", 1, nil, false, 0, 1, TouchModeContinuous, hclog.NewNullLogger())
+	parallel.SetMemoryTouchWorkers(runtime.NumCPU())
+
+	size := 64 * 1024 * 1024
+	single.holdMemory(size)
+	parallel.holdMemory(size)
+
+	// the fastest of several re-touches reduces the effect of other load
+	fastest := func(g *NodeGenerator) time.Duration {
+		best := time.Duration(math.MaxInt64)
+		for i := 0; i < 3; i++ {
+			st := time.Now()
+			g.holdMemory(size)
+			if d := time.Since(st); d < best {
+				best = d
+			}
+		}
+
+		return best
+	}
+
+	assert.Less(t, int64(fastest(parallel)), int64(fastest(single)))
+}
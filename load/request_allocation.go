@@ -0,0 +1,62 @@
+package load
+
+import (
+	"context"
+	"os"
+	"runtime/pprof"
+	"sync/atomic"
+)
+
+// RequestAllocation allocates a fixed amount of memory for each request from
+// a dedicated function, the allocation is attributed to allocateForRequest
+// in heap profiles and the CPU spent allocating carries pprof labels
+type RequestAllocation struct {
+	size int
+	held int64 // bytes currently held by requests
+}
+
+// NewRequestAllocation creates a RequestAllocation which allocates sizeMB
+// megabytes for each request
+func NewRequestAllocation(sizeMB int) *RequestAllocation {
+	return &RequestAllocation{size: sizeMB * 1024 * 1024}
+}
+
+// Allocate allocates the memory for a request to route, the memory is held
+// until the returned function is called. A nil RequestAllocation does not
+// allocate any memory
+func (a *RequestAllocation) Allocate(ctx context.Context, route string) Finished {
+	if a == nil || a.size == 0 {
+		return func() {}
+	}
+
+	var mem []byte
+	pprof.Do(ctx, pprof.Labels("allocation", "request", "route", route), func(context.Context) {
+		mem = allocateForRequest(a.size)
+	})
+
+	atomic.AddInt64(&a.held, int64(len(mem)))
+
+	return func() {
+		atomic.AddInt64(&a.held, -int64(len(mem)))
+		mem = nil
+	}
+}
+
+// HeldBytes returns the number of bytes currently held by requests
+func (a *RequestAllocation) HeldBytes() int64 {
+	return atomic.LoadInt64(&a.held)
+}
+
+// allocateForRequest is not inlined so that it appears as the allocation
+// site in heap profiles
+//
+//go:noinline
+func allocateForRequest(size int) []byte {
+	// write to every page so that the allocation is resident
+	mem := make([]byte, size)
+	for i := 0; i < len(mem); i += os.Getpagesize() {
+		mem[i] = 1
+	}
+
+	return mem
+}
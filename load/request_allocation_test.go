@@ -0,0 +1,34 @@
+package load
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestAllocationHoldsMemoryUntilFinished(t *testing.T) {
+	a := NewRequestAllocation(4)
+
+	before := runtime.MemStats{}
+	runtime.ReadMemStats(&before)
+
+	finished := a.Allocate(context.Background(), "/")
+
+	after := runtime.MemStats{}
+	runtime.ReadMemStats(&after)
+
+	assert.Equal(t, int64(4*1024*1024), a.HeldBytes())
+	assert.GreaterOrEqual(t, after.TotalAlloc-before.TotalAlloc, uint64(4*1024*1024))
+
+	finished()
+	assert.Equal(t, int64(0), a.HeldBytes())
+}
+
+func TestRequestAllocationNilDoesNotAllocate(t *testing.T) {
+	var a *RequestAllocation
+
+	finished := a.Allocate(context.Background(), "/")
+	finished()
+}
@@ -0,0 +1,76 @@
+package load
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// RequestLoad generates a CPU and memory cost for a single request, the cost
+// is requested by the caller and clamped to the configured maximums
+type RequestLoad struct {
+	maxCPU      time.Duration
+	maxMemoryMB int
+	held        int64 // bytes currently held by requests
+}
+
+// NewRequestLoad creates a RequestLoad which spins the CPU for at most maxCPU
+// and allocates at most maxMemoryMB megabytes for each request
+func NewRequestLoad(maxCPU time.Duration, maxMemoryMB int) *RequestLoad {
+	return &RequestLoad{maxCPU: maxCPU, maxMemoryMB: maxMemoryMB}
+}
+
+// Clamp limits the requested cost to the configured maximums
+func (r *RequestLoad) Clamp(cpu time.Duration, memoryMB int) (time.Duration, int) {
+	if cpu < 0 {
+		cpu = 0
+	}
+
+	if cpu > r.maxCPU {
+		cpu = r.maxCPU
+	}
+
+	if memoryMB < 0 {
+		memoryMB = 0
+	}
+
+	if memoryMB > r.maxMemoryMB {
+		memoryMB = r.maxMemoryMB
+	}
+
+	return cpu, memoryMB
+}
+
+// Generate allocates memoryMB megabytes and spins the CPU for cpu, the values
+// must already be clamped. The memory is held until the returned function is
+// called
+func (r *RequestLoad) Generate(cpu time.Duration, memoryMB int) Finished {
+	size := memoryMB * 1024 * 1024
+
+	// write to every page so that the allocation is resident
+	mem := make([]byte, size)
+	for i := 0; i < len(mem); i += os.Getpagesize() {
+		mem[i] = 1
+	}
+
+	atomic.AddInt64(&r.held, int64(size))
+
+	spin(cpu)
+
+	return func() {
+		mem = nil
+		atomic.AddInt64(&r.held, -int64(size))
+	}
+}
+
+// HeldBytes returns the number of bytes currently held by requests
+func (r *RequestLoad) HeldBytes() int64 {
+	return atomic.LoadInt64(&r.held)
+}
+
+// spin keeps the calling goroutine busy for d
+func spin(d time.Duration) {
+	st := time.Now()
+	for time.Since(st) < d {
+	}
+}
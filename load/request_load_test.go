@@ -0,0 +1,40 @@
+package load
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLoadClampsToMaximums(t *testing.T) {
+	r := NewRequestLoad(100*time.Millisecond, 10)
+
+	cpu, mem := r.Clamp(time.Second, 100)
+	assert.Equal(t, 100*time.Millisecond, cpu)
+	assert.Equal(t, 10, mem)
+
+	cpu, mem = r.Clamp(-time.Second, -1)
+	assert.Equal(t, time.Duration(0), cpu)
+	assert.Equal(t, 0, mem)
+}
+
+func TestRequestLoadHoldsMemoryUntilFinished(t *testing.T) {
+	r := NewRequestLoad(time.Second, 10)
+
+	finished := r.Generate(0, 5)
+	assert.Equal(t, int64(5*1024*1024), r.HeldBytes())
+
+	finished()
+	assert.Equal(t, int64(0), r.HeldBytes())
+}
+
+func TestRequestLoadSpinsCPU(t *testing.T) {
+	r := NewRequestLoad(time.Second, 10)
+
+	st := time.Now()
+	finished := r.Generate(20*time.Millisecond, 0)
+	defer finished()
+
+	assert.GreaterOrEqual(t, int64(time.Since(st)), int64(20*time.Millisecond))
+}
@@ -0,0 +1,82 @@
+package load
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// RSSReporter publishes the resident set size of the process every interval,
+// reading the RSS is only supported on Linux
+type RSSReporter struct {
+	logger   hclog.Logger
+	interval time.Duration
+	report   func(rssBytes uint64)
+	rss      func() (uint64, error)
+	stop     chan struct{}
+}
+
+// NewRSSReporter creates an RSSReporter which calls report with the RSS in
+// bytes every interval
+func NewRSSReporter(interval time.Duration, report func(rssBytes uint64), logger hclog.Logger) *RSSReporter {
+	return &RSSReporter{
+		logger:   logger,
+		interval: interval,
+		report:   report,
+		rss:      ProcessRSS,
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start reports the RSS every interval in the background until Stop is
+// called, nothing is reported when the RSS can not be read on this platform
+func (r *RSSReporter) Start() {
+	if _, err := r.rss(); err != nil {
+		r.logger.Warn("Unable to read the process RSS, it will not be reported", "error", err)
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(r.interval)
+		defer t.Stop()
+
+		for {
+			rss, err := r.rss()
+			if err != nil {
+				r.logger.Warn("Unable to read the process RSS", "error", err)
+			} else {
+				r.report(rss)
+			}
+
+			select {
+			case <-t.C:
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the reporting
+func (r *RSSReporter) Stop() {
+	close(r.stop)
+}
+
+// parseStatm returns the resident set size in bytes from the contents of a
+// /proc/[pid]/statm file, the second field is the number of resident pages
+func parseStatm(data []byte, pageSize int) (uint64, error) {
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("invalid statm format: %q", string(data))
+	}
+
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid resident page count: %s", err)
+	}
+
+	return pages * uint64(pageSize), nil
+}
@@ -0,0 +1,19 @@
+package load
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// ProcessRSS returns the resident set size of the process in bytes as
+// reported by the OS, unlike runtime.MemStats this includes all memory
+// resident for the process not just the Go heap. RSS is only supported on
+// Linux, other platforms return an error
+func ProcessRSS() (uint64, error) {
+	d, err := ioutil.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, err
+	}
+
+	return parseStatm(d, os.Getpagesize())
+}
@@ -0,0 +1,31 @@
+package load
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatmReturnsResidentBytes(t *testing.T) {
+	d, err := ioutil.ReadFile("testdata/statm")
+	assert.NoError(t, err)
+
+	rss, err := parseStatm(d, 4096)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2618*4096), rss)
+}
+
+func TestParseStatmReturnsErrorForInvalidData(t *testing.T) {
+	_, err := parseStatm([]byte("352134"), 4096)
+
+	assert.Error(t, err)
+}
+
+func TestProcessRSSReturnsValue(t *testing.T) {
+	rss, err := ProcessRSS()
+
+	assert.NoError(t, err)
+	assert.Greater(t, rss, uint64(0))
+}
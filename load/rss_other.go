@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package load
+
+import "fmt"
+
+// ProcessRSS returns the resident set size of the process in bytes, reading
+// process memory from the OS is only supported on Linux so an error is always
+// returned
+func ProcessRSS() (uint64, error) {
+	return 0, fmt.Errorf("process RSS is not supported on this platform")
+}
@@ -0,0 +1,38 @@
+package load
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRSSReporterReportsRSSUntilStopped(t *testing.T) {
+	reported := make(chan uint64, 10)
+
+	r := NewRSSReporter(time.Millisecond, func(rss uint64) { reported <- rss }, hclog.NewNullLogger())
+	r.rss = func() (uint64, error) { return 4096, nil }
+	r.Start()
+
+	select {
+	case rss := <-reported:
+		assert.Equal(t, uint64(4096), rss)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the RSS to be reported")
+	}
+
+	r.Stop()
+}
+
+func TestRSSReporterDoesNotStartWhenRSSIsUnsupported(t *testing.T) {
+	reported := 0
+
+	r := NewRSSReporter(time.Millisecond, func(rss uint64) { reported++ }, hclog.NewNullLogger())
+	r.rss = func() (uint64, error) { return 0, fmt.Errorf("process RSS is not supported on this platform") }
+	r.Start()
+
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, reported)
+}
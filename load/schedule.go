@@ -0,0 +1,85 @@
+package load
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// Schedule runs a NodeGenerator in periodic bursts independent of request
+// traffic to model a noisy neighbor. A burst starts at every multiple of
+// interval on the wall clock and runs for duration, each burst uses a new
+// generator so that the load of one burst never overlaps the next
+type Schedule struct {
+	logger    hclog.Logger
+	generator func() *NodeGenerator
+	interval  time.Duration
+	duration  time.Duration
+	active    bool
+	stop      chan struct{}
+	mutex     sync.Mutex
+}
+
+// NewSchedule creates a Schedule which runs a generator created by generator
+// for duration every interval. The next burst is scheduled when a burst ends
+// so duration must be shorter than interval, otherwise every other burst is
+// skipped
+func NewSchedule(interval, duration time.Duration, generator func() *NodeGenerator, logger hclog.Logger) *Schedule {
+	return &Schedule{
+		logger:    logger,
+		generator: generator,
+		interval:  interval,
+		duration:  duration,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start runs the schedule in the background until Stop is called
+func (s *Schedule) Start() {
+	go func() {
+		for {
+			now := time.Now()
+			next := now.Truncate(s.interval).Add(s.interval)
+
+			select {
+			case <-time.After(next.Sub(now)):
+			case <-s.stop:
+				return
+			}
+
+			s.logger.Info("Starting noisy neighbor load", "duration", s.duration)
+			s.setActive(true)
+			finished := s.generator().Generate()
+
+			select {
+			case <-time.After(s.duration):
+			case <-s.stop:
+			}
+
+			finished()
+			s.setActive(false)
+			s.logger.Info("Stopped noisy neighbor load")
+		}
+	}()
+}
+
+// Stop ends the schedule and any running burst
+func (s *Schedule) Stop() {
+	close(s.stop)
+}
+
+// Active returns true while a burst of load is running
+func (s *Schedule) Active() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.active
+}
+
+func (s *Schedule) setActive(a bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.active = a
+}
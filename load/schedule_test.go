@@ -0,0 +1,50 @@
+package load
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+// waitForActive polls the schedule until it reports the given state and
+// returns the time the state was observed
+func waitForActive(t *testing.T, s *Schedule, active bool) time.Time {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if s.Active() == active {
+			return time.Now()
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("schedule did not become active=%t", active)
+	return time.Time{}
+}
+
+func TestScheduleRunsLoadInBursts(t *testing.T) {
+	var bursts int32
+	s := NewSchedule(200*time.Millisecond, 100*time.Millisecond, func() *NodeGenerator {
+		atomic.AddInt32(&bursts, 1)
+		return NewNodeGenerator(0, 0, 1, 0, "linear", 1, nil, false, 0, 0, "", hclog.NewNullLogger())
+	}, hclog.NewNullLogger())
+
+	s.Start()
+	defer s.Stop()
+
+	assert.False(t, s.Active())
+
+	for i := 1; i <= 2; i++ {
+		on := waitForActive(t, s, true)
+		assert.Equal(t, int32(i), atomic.LoadInt32(&bursts))
+
+		// bursts start on a multiple of the interval
+		assert.Less(t, int64(on.Sub(on.Truncate(200*time.Millisecond))), int64(30*time.Millisecond))
+
+		off := waitForActive(t, s, false)
+		assert.InDelta(t, float64(100*time.Millisecond), float64(off.Sub(on)), float64(30*time.Millisecond))
+	}
+}
@@ -0,0 +1,86 @@
+package load
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// SizeClassAllocation allocates many objects of the same size every tick to
+// stress a single size class of the Go allocator. A size just over a size
+// class boundary, e.g. 1025 bytes, is rounded up to the next class (1152
+// bytes) and the difference is wasted as internal fragmentation. The objects
+// of a tick are held until the next tick replaces them
+type SizeClassAllocation struct {
+	logger      hclog.Logger
+	size        int
+	count       int
+	held        [][]byte
+	allocations int64
+	stop        chan struct{}
+}
+
+// NewSizeClassAllocation creates a SizeClassAllocation which allocates count
+// objects of size bytes every tick
+func NewSizeClassAllocation(size, count int, logger hclog.Logger) *SizeClassAllocation {
+	return &SizeClassAllocation{
+		logger: logger,
+		size:   size,
+		count:  count,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start allocates every TICK_INTERVAL in the background until Stop is called
+func (a *SizeClassAllocation) Start() {
+	a.logger.Info("Allocating size class", "size", a.size, "count", a.count)
+
+	go func() {
+		t := time.NewTicker(TICK_INTERVAL)
+		defer t.Stop()
+
+		for {
+			a.Tick()
+
+			select {
+			case <-t.C:
+			case <-a.stop:
+				a.held = nil
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the allocation and releases the held objects
+func (a *SizeClassAllocation) Stop() {
+	close(a.stop)
+}
+
+// Tick replaces the held objects with count new objects
+func (a *SizeClassAllocation) Tick() {
+	held := make([][]byte, a.count)
+	for i := range held {
+		held[i] = allocateSizeClass(a.size)
+	}
+
+	a.held = held
+	atomic.AddInt64(&a.allocations, int64(a.count))
+}
+
+// Allocations returns the total number of objects allocated
+func (a *SizeClassAllocation) Allocations() int64 {
+	return atomic.LoadInt64(&a.allocations)
+}
+
+// allocateSizeClass is not inlined so that the compiler can not place the
+// object on the stack
+//
+//go:noinline
+func allocateSizeClass(size int) []byte {
+	mem := make([]byte, size)
+	mem[0] = 1
+
+	return mem
+}
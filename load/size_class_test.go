@@ -0,0 +1,32 @@
+package load
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSizeClassAllocationAllocatesCountObjectsOfSize(t *testing.T) {
+	a := NewSizeClassAllocation(1025, 100, hclog.NewNullLogger())
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	a.Tick()
+	runtime.ReadMemStats(&after)
+
+	assert.Equal(t, int64(100), a.Allocations())
+	assert.Len(t, a.held, 100)
+	for _, m := range a.held {
+		assert.Len(t, m, 1025)
+	}
+
+	// the objects are rounded up to the 1152 byte size class
+	assert.GreaterOrEqual(t, after.Mallocs-before.Mallocs, uint64(100))
+	assert.GreaterOrEqual(t, after.TotalAlloc-before.TotalAlloc, uint64(100*1152))
+
+	a.Tick()
+	assert.Equal(t, int64(200), a.Allocations())
+	assert.Len(t, a.held, 100)
+}
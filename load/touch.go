@@ -0,0 +1,107 @@
+package load
+
+import (
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// TouchModeOnce writes to memory once when it is allocated
+	TouchModeOnce = "once"
+	// TouchModeContinuous re-writes memory every tick so pages stay resident
+	// under memory pressure rather than being swapped out
+	TouchModeContinuous = "continuous"
+)
+
+// SetMemoryTouchWorkers divides the held memory between workers goroutines
+// which touch it in parallel, so that touching a large allocation does not
+// block the tick for long. The workers are capped to the cores not used by
+// the CPU load so that touching does not starve the CPU generator, there is
+// always at least one worker
+func (g *NodeGenerator) SetMemoryTouchWorkers(workers int) {
+	max := runtime.NumCPU() - int(math.Ceil(g.cpuCoresCount))
+	if max < 1 {
+		max = 1
+	}
+
+	if workers > max {
+		g.logger.Warn("Capping memory touch workers to the cores not used for CPU load", "workers", workers, "capped", max)
+		workers = max
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	g.memoryTouchWorkers = workers
+}
+
+// holdMemory allocates memory of the given size, when touching is disabled
+// the allocation is not written to and the OS may not back it with pages
+func (g *NodeGenerator) holdMemory(size int) {
+	if size < 0 {
+		size = 0
+	}
+
+	if g.memoryTouchStride <= 0 {
+		mem := make([]byte, 0, size)
+		_ = mem
+		return
+	}
+
+	// only allocate when the size changes so touched pages are kept
+	if len(g.memory) != size {
+		g.memory = make([]byte, size)
+		g.touch()
+		return
+	}
+
+	if g.memoryTouchMode == TouchModeContinuous {
+		g.touch()
+	}
+}
+
+// touch writes a byte every memoryTouchStride bytes of the held memory, the
+// memory is divided into a contiguous part for each worker
+func (g *NodeGenerator) touch() {
+	workers := g.memoryTouchWorkers
+	if workers <= 1 {
+		touchRange(g.memory, g.memoryTouchStride, &g.touched)
+		return
+	}
+
+	// round each part up to a whole number of strides so that the bytes
+	// touched are the same as a single worker
+	strides := (len(g.memory) + g.memoryTouchStride - 1) / g.memoryTouchStride
+	part := (strides + workers - 1) / workers * g.memoryTouchStride
+
+	var wg sync.WaitGroup
+	for start := 0; start < len(g.memory); start += part {
+		end := start + part
+		if end > len(g.memory) {
+			end = len(g.memory)
+		}
+
+		wg.Add(1)
+		go func(mem []byte) {
+			defer wg.Done()
+			touchRange(mem, g.memoryTouchStride, &g.touched)
+		}(g.memory[start:end])
+	}
+
+	wg.Wait()
+}
+
+// touchRange writes a byte every stride bytes of mem and adds the number of
+// writes to touched
+func touchRange(mem []byte, stride int, touched *int64) {
+	var n int64
+	for i := 0; i < len(mem); i += stride {
+		mem[i]++
+		n++
+	}
+
+	atomic.AddInt64(touched, n)
+}
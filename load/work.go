@@ -0,0 +1,19 @@
+package load
+
+import (
+	"crypto/sha256"
+	"time"
+)
+
+// DoWork performs the given number of CPU bound work units, each unit is a
+// single SHA256 hash of the previous result, the elapsed time is returned
+func DoWork(units int) time.Duration {
+	st := time.Now()
+
+	sum := sha256.Sum256([]byte("fake-service"))
+	for i := 0; i < units; i++ {
+		sum = sha256.Sum256(sum[:])
+	}
+
+	return time.Since(st)
+}
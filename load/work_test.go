@@ -0,0 +1,14 @@
+package load
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoWorkElapsedTimeScalesWithUnits(t *testing.T) {
+	small := DoWork(1000)
+	large := DoWork(100000)
+
+	assert.Greater(t, int64(large), int64(small))
+}
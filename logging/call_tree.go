@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/nicholasjackson/fake-service/response"
+)
+
+const (
+	// CallTreeJSON logs the upstream call tree as indented JSON
+	CallTreeJSON = "json"
+	// CallTreeFields logs a field for every upstream call in the tree
+	CallTreeFields = "fields"
+)
+
+// SetCallTreeFormat logs the full upstream call tree in the given format when
+// an upstream call fails, an empty format disables logging the tree
+func (l *Logger) SetCallTreeFormat(format string) {
+	l.callTreeFormat = format
+}
+
+// UpstreamCallTree logs the responses of the upstream calls and their
+// upstreams when an upstream call has failed
+func (l *Logger) UpstreamCallTree(upstreams map[string]response.Response) {
+	switch l.callTreeFormat {
+	case CallTreeJSON:
+		d, _ := json.MarshalIndent(upstreams, "", "  ")
+		l.log.Error("Upstream call failed", "call_tree", string(d))
+	case CallTreeFields:
+		l.log.Error("Upstream call failed", callTreeFields("", upstreams)...)
+	}
+}
+
+// callTreeFields returns a field for each upstream call, the key is the path
+// of URIs to the call
+func callTreeFields(parent string, upstreams map[string]response.Response) []interface{} {
+	uris := []string{}
+	for k := range upstreams {
+		uris = append(uris, k)
+	}
+	sort.Strings(uris)
+
+	fields := []interface{}{}
+	for _, uri := range uris {
+		u := upstreams[uri]
+
+		path := uri
+		if parent != "" {
+			path = parent + " > " + uri
+		}
+
+		v := fmt.Sprintf("code=%d", u.Code)
+		if u.Error != "" {
+			v = fmt.Sprintf("%s error=%q", v, u.Error)
+		}
+
+		fields = append(fields, path, v)
+		fields = append(fields, callTreeFields(path, u.UpstreamCalls)...)
+	}
+
+	return fields
+}
@@ -0,0 +1,37 @@
+package logging
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpstreamCallTreeLogsFieldForEachCall(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewLogger(&NullMetrics{}, hclog.New(&hclog.LoggerOptions{Output: buf}), nil)
+	l.SetCallTreeFormat(CallTreeFields)
+
+	l.UpstreamCallTree(map[string]response.Response{
+		"http://api": {
+			Code: 500,
+			UpstreamCalls: map[string]response.Response{
+				"http://db": {Code: 503, Error: "db down"},
+			},
+		},
+	})
+
+	assert.Contains(t, buf.String(), "http://api=code=500")
+	assert.Contains(t, buf.String(), `http://api > http://db="code=503 error="db down""`)
+}
+
+func TestUpstreamCallTreeIsNotLoggedWhenDisabled(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewLogger(&NullMetrics{}, hclog.New(&hclog.LoggerOptions{Output: buf}), nil)
+
+	l.UpstreamCallTree(map[string]response.Response{"http://api": {Code: 500}})
+
+	assert.Empty(t, buf.String())
+}
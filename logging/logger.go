@@ -20,6 +20,8 @@ type Logger struct {
 	metrics        Metrics
 	log            hclog.Logger
 	getSpanDetails tracing.SpanDetailsFunc
+	pathNormalizer *PathNormalizer
+	callTreeFormat string
 }
 
 func NewLogger(m Metrics, l hclog.Logger, sdf tracing.SpanDetailsFunc) *Logger {
@@ -30,6 +32,12 @@ func NewLogger(m Metrics, l hclog.Logger, sdf tracing.SpanDetailsFunc) *Logger {
 	}
 }
 
+// SetPathNormalizer labels HTTP request metrics with the method and the
+// normalized request path
+func (l *Logger) SetPathNormalizer(p *PathNormalizer) {
+	l.pathNormalizer = p
+}
+
 // LogProcess is returned from a logging function
 type LogProcess struct {
 	finished func(err error, meta map[string]string)
@@ -143,7 +151,13 @@ func (l *Logger) HandleHTTPRequest(r *http.Request) *LogProcess {
 			)
 
 			serverSpan.Finish()
-			l.metrics.Timing("handle.request.http", dur, getTags(err, meta))
+
+			tags := getTags(err, meta)
+			if l.pathNormalizer != nil {
+				tags = append(tags, "method:"+r.Method, "path:"+l.pathNormalizer.Normalize(r.URL.Path))
+			}
+
+			l.metrics.Timing("handle.request.http", dur, tags)
 		},
 		Span: serverSpan,
 	}
@@ -326,6 +340,7 @@ func (l *Logger) CallHTTPUpstream(parentRequest *http.Request, upstreamRequest *
 			l.metrics.Timing("upstream.request.http", te.Sub(st), getTags(err, meta))
 			clientSpan.Finish()
 		},
+		Span: clientSpan,
 	}
 }
 
@@ -392,6 +407,7 @@ func (l *Logger) CallGRCPUpstream(uri string, ctx opentracing.SpanContext) (*Log
 			l.metrics.Timing("upstream.request.grpc", te.Sub(st), getTags(err, meta))
 			clientSpan.Finish()
 		},
+		Span: clientSpan,
 	}, outCtx
 }
 
@@ -407,6 +423,19 @@ func (l *Logger) CallHealthHTTP() *LogProcess {
 	}
 }
 
+// CallStaticHTTP logs a request for a static file
+func (l *Logger) CallStaticHTTP(path string) *LogProcess {
+	st := time.Now()
+	l.log.Debug("Handling static file request", "path", path)
+
+	return &LogProcess{
+		finished: func(err error, meta map[string]string) {
+			te := time.Now()
+			l.metrics.Timing("handle.static.http", te.Sub(st), getTags(err, meta))
+		},
+	}
+}
+
 func (l *Logger) CallReadyHTTP() *LogProcess {
 	st := time.Now()
 	l.log.Info("Handling ready request")
@@ -419,6 +448,39 @@ func (l *Logger) CallReadyHTTP() *LogProcess {
 	}
 }
 
+// ConnectionsChanged records the number of open inbound connections
+func (l *Logger) ConnectionsChanged(open int64) {
+	l.log.Debug("Open connections changed", "open", open)
+	l.metrics.Gauge("server.connections.open", float64(open), nil)
+}
+
+// UpstreamQueueStats records the saturation of the upstream worker queue
+func (l *Logger) UpstreamQueueStats(maxDepth int, waits []time.Duration, dropped int) {
+	l.log.Debug("Upstream worker queue", "max_depth", maxDepth, "dropped", dropped)
+	l.metrics.Gauge("upstream.worker.queue.depth", float64(maxDepth), nil)
+
+	for _, w := range waits {
+		l.metrics.Timing("upstream.worker.queue.wait", w, nil)
+	}
+
+	for i := 0; i < dropped; i++ {
+		l.metrics.Increment("upstream.worker.queue.dropped", nil)
+	}
+}
+
+// LoadGenerator records the CPU and memory load generated by the load
+// generator for each request
+func (l *Logger) LoadGenerator(cores, percentage float64, memoryBytes int) {
+	l.metrics.Gauge("load.cpu.cores", cores, nil)
+	l.metrics.Gauge("load.cpu.percentage", percentage, nil)
+	l.metrics.Gauge("load.memory.bytes", float64(memoryBytes), nil)
+}
+
+// ProcessMemory records the resident set size of the process
+func (l *Logger) ProcessMemory(rssBytes uint64) {
+	l.metrics.Gauge("process.memory.rss.bytes", float64(rssBytes), nil)
+}
+
 // formatRequest generates ascii representation of a request
 func formatRequest(r *http.Request) string {
 	// Create return string
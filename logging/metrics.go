@@ -10,6 +10,7 @@ import (
 type Metrics interface {
 	Timing(name string, duration time.Duration, tags []string)
 	Increment(name string, tags []string)
+	Gauge(name string, value float64, tags []string)
 }
 
 type NullMetrics struct {
@@ -17,6 +18,7 @@ type NullMetrics struct {
 
 func (s *NullMetrics) Timing(name string, duration time.Duration, tags []string) {}
 func (s *NullMetrics) Increment(name string, tags []string)                      {}
+func (s *NullMetrics) Gauge(name string, value float64, tags []string)           {}
 
 type StatsDMetrics struct {
 	c *statsd.Client
@@ -41,3 +43,7 @@ func (s *StatsDMetrics) Timing(name string, duration time.Duration, tags []strin
 func (s *StatsDMetrics) Increment(name string, tags []string) {
 	s.c.Incr(name, tags, 1)
 }
+
+func (s *StatsDMetrics) Gauge(name string, value float64, tags []string) {
+	s.c.Gauge(name, value, tags, 1)
+}
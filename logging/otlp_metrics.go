@@ -0,0 +1,347 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const (
+	otlpScopeName = "github.com/nicholasjackson/fake-service"
+	// otlpCumulative is the OTLP aggregation temporality of cumulative data
+	otlpCumulative = 2
+)
+
+// otlpBounds are the default OpenTelemetry histogram bucket boundaries in
+// milliseconds
+var otlpBounds = []float64{0, 5, 10, 25, 50, 75, 100, 250, 500, 750, 1000, 2500, 5000, 7500, 10000}
+
+type otlpKind int
+
+const (
+	otlpCounter otlpKind = iota
+	otlpGauge
+	otlpHistogram
+)
+
+// otlpSeries is the aggregated value of a metric for a set of attributes
+type otlpSeries struct {
+	name    string
+	kind    otlpKind
+	attrs   []otlpAttribute
+	count   int64
+	sum     float64
+	value   float64
+	buckets []int64
+}
+
+// OTLPMetrics is a Metrics implementation which aggregates metrics in memory
+// and periodically exports them to an OpenTelemetry collector using the OTLP
+// HTTP JSON protocol. Timings are recorded as histograms in milliseconds,
+// the count of a histogram is the number of requests
+type OTLPMetrics struct {
+	serviceName string
+	endpoint    string
+	client      *http.Client
+	start       time.Time
+	series      map[string]*otlpSeries
+	mutex       sync.Mutex
+	done        chan struct{}
+}
+
+// NewOTLPMetrics creates OTLPMetrics which export to the collector at
+// endpoint e.g. http://localhost:4318 once started
+func NewOTLPMetrics(serviceName, endpoint string) *OTLPMetrics {
+	return &OTLPMetrics{
+		serviceName: serviceName,
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/v1/metrics",
+		client:      &http.Client{Timeout: 10 * time.Second},
+		start:       time.Now(),
+		series:      map[string]*otlpSeries{},
+		done:        make(chan struct{}),
+	}
+}
+
+// Start exports the metrics every interval until Stop is called, failed
+// exports are logged and retried at the next interval
+func (m *OTLPMetrics) Start(interval time.Duration, l hclog.Logger) {
+	t := time.NewTicker(interval)
+
+	go func() {
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				if err := m.Export(); err != nil {
+					l.Error("Unable to export metrics", "endpoint", m.endpoint, "error", err)
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the periodic export
+func (m *OTLPMetrics) Stop() {
+	close(m.done)
+}
+
+// Timing records the duration in the histogram name
+func (m *OTLPMetrics) Timing(name string, duration time.Duration, tags []string) {
+	ms := float64(duration) / float64(time.Millisecond)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s := m.get(name, otlpHistogram, tags)
+	s.count++
+	s.sum += ms
+
+	i := sort.SearchFloat64s(otlpBounds, ms)
+	s.buckets[i]++
+}
+
+// Increment adds one to the counter name
+func (m *OTLPMetrics) Increment(name string, tags []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.get(name, otlpCounter, tags).count++
+}
+
+// Gauge sets the gauge name to value
+func (m *OTLPMetrics) Gauge(name string, value float64, tags []string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.get(name, otlpGauge, tags).value = value
+}
+
+// Export sends the current value of all metrics to the collector
+func (m *OTLPMetrics) Export() error {
+	d, err := json.Marshal(m.collect())
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.client.Post(m.endpoint, "application/json", bytes.NewReader(d))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OTLP collector returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// get returns the series for name and tags, creating it when it does not
+// exist, the caller must hold the mutex
+func (m *OTLPMetrics) get(name string, kind otlpKind, tags []string) *otlpSeries {
+	sorted := append([]string{}, tags...)
+	sort.Strings(sorted)
+
+	key := name + "|" + strings.Join(sorted, ",")
+	if s, ok := m.series[key]; ok {
+		return s
+	}
+
+	s := &otlpSeries{name: name, kind: kind, attrs: tagsToAttributes(sorted)}
+	if kind == otlpHistogram {
+		s.buckets = make([]int64, len(otlpBounds)+1)
+	}
+
+	m.series[key] = s
+	return s
+}
+
+// collect reads the aggregated metrics as an OTLP export request
+func (m *OTLPMetrics) collect() *otlpExport {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	start := strconv.FormatInt(m.start.UnixNano(), 10)
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	// group the series into a metric for each name
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	metrics := []otlpMetric{}
+	index := map[string]int{}
+
+	for _, k := range keys {
+		s := m.series[k]
+
+		i, ok := index[s.name]
+		if !ok {
+			i = len(metrics)
+			index[s.name] = i
+
+			om := otlpMetric{Name: s.name}
+			switch s.kind {
+			case otlpCounter:
+				om.Sum = &otlpSum{AggregationTemporality: otlpCumulative, IsMonotonic: true}
+			case otlpGauge:
+				om.Gauge = &otlpGaugeData{}
+			case otlpHistogram:
+				om.Unit = "ms"
+				om.Histogram = &otlpHistogramData{AggregationTemporality: otlpCumulative}
+			}
+
+			metrics = append(metrics, om)
+		}
+
+		om := &metrics[i]
+		switch {
+		case om.Sum != nil:
+			om.Sum.DataPoints = append(om.Sum.DataPoints, otlpNumberPoint{
+				Attributes:        s.attrs,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      now,
+				AsInt:             strconv.FormatInt(s.count, 10),
+			})
+		case om.Gauge != nil:
+			v := s.value
+			om.Gauge.DataPoints = append(om.Gauge.DataPoints, otlpNumberPoint{
+				Attributes:   s.attrs,
+				TimeUnixNano: now,
+				AsDouble:     &v,
+			})
+		case om.Histogram != nil:
+			buckets := make([]string, len(s.buckets))
+			for n, b := range s.buckets {
+				buckets[n] = strconv.FormatInt(b, 10)
+			}
+
+			om.Histogram.DataPoints = append(om.Histogram.DataPoints, otlpHistogramPoint{
+				Attributes:        s.attrs,
+				StartTimeUnixNano: start,
+				TimeUnixNano:      now,
+				Count:             strconv.FormatInt(s.count, 10),
+				Sum:               s.sum,
+				BucketCounts:      buckets,
+				ExplicitBounds:    otlpBounds,
+			})
+		}
+	}
+
+	return &otlpExport{
+		ResourceMetrics: []otlpResourceMetrics{
+			{
+				Resource: otlpResource{
+					Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: m.serviceName}}},
+				},
+				ScopeMetrics: []otlpScopeMetrics{
+					{Scope: otlpScope{Name: otlpScopeName}, Metrics: metrics},
+				},
+			},
+		},
+	}
+}
+
+// tagsToAttributes converts tags in the form key:value to OTLP attributes
+func tagsToAttributes(tags []string) []otlpAttribute {
+	attrs := []otlpAttribute{}
+	for _, t := range tags {
+		parts := strings.SplitN(t, ":", 2)
+		a := otlpAttribute{Key: parts[0]}
+		if len(parts) == 2 {
+			a.Value.StringValue = parts[1]
+		}
+
+		attrs = append(attrs, a)
+	}
+
+	return attrs
+}
+
+// the following types are the JSON encoding of an OTLP metrics export
+// request, 64 bit integers are encoded as strings
+
+type otlpExport struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name      string             `json:"name"`
+	Unit      string             `json:"unit,omitempty"`
+	Sum       *otlpSum           `json:"sum,omitempty"`
+	Gauge     *otlpGaugeData     `json:"gauge,omitempty"`
+	Histogram *otlpHistogramData `json:"histogram,omitempty"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpGaugeData struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlpAttribute `json:"attributes"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsInt             string          `json:"asInt,omitempty"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+}
+
+type otlpHistogramData struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpAttribute `json:"attributes"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func findOTLPMetric(e *otlpExport, name string) *otlpMetric {
+	for _, m := range e.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		if m.Name == name {
+			return &m
+		}
+	}
+
+	return nil
+}
+
+func TestOTLPMetricsRecordsRequestHistogram(t *testing.T) {
+	m := NewOTLPMetrics("test", "")
+
+	m.Timing("handle.request.http", 3*time.Millisecond, []string{"response:200"})
+	m.Timing("handle.request.http", 30*time.Millisecond, []string{"response:200"})
+	m.Timing("handle.request.http", 20*time.Second, []string{"response:500", "error:true"})
+
+	h := findOTLPMetric(m.collect(), "handle.request.http")
+	assert.NotNil(t, h)
+	assert.NotNil(t, h.Histogram)
+	assert.Equal(t, "ms", h.Unit)
+	assert.Len(t, h.Histogram.DataPoints, 2)
+
+	// the data points are ordered by their tags
+	failed := h.Histogram.DataPoints[0]
+	assert.Equal(t, "1", failed.Count)
+	assert.Equal(t, "1", failed.BucketCounts[len(otlpBounds)])
+
+	ok := h.Histogram.DataPoints[1]
+	assert.Equal(t, "2", ok.Count)
+	assert.Equal(t, 33.0, ok.Sum)
+	assert.Equal(t, "1", ok.BucketCounts[1])
+	assert.Equal(t, "1", ok.BucketCounts[4])
+	assert.Equal(t, []otlpAttribute{{Key: "response", Value: otlpValue{StringValue: "200"}}}, ok.Attributes)
+}
+
+func TestOTLPMetricsRecordsCountersAndGauges(t *testing.T) {
+	m := NewOTLPMetrics("test", "")
+	l := NewLogger(m, hclog.NewNullLogger(), nil)
+
+	l.LoadGenerator(2, 50, 1024)
+	l.UpstreamQueueStats(3, nil, 2)
+
+	e := m.collect()
+
+	c := findOTLPMetric(e, "upstream.worker.queue.dropped")
+	assert.NotNil(t, c)
+	assert.True(t, c.Sum.IsMonotonic)
+	assert.Equal(t, "2", c.Sum.DataPoints[0].AsInt)
+
+	g := findOTLPMetric(e, "load.cpu.cores")
+	assert.NotNil(t, g)
+	assert.Equal(t, 2.0, *g.Gauge.DataPoints[0].AsDouble)
+
+	g = findOTLPMetric(e, "load.memory.bytes")
+	assert.NotNil(t, g)
+	assert.Equal(t, 1024.0, *g.Gauge.DataPoints[0].AsDouble)
+}
+
+func TestOTLPMetricsExportsJSONToCollector(t *testing.T) {
+	var path string
+	var body otlpExport
+
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		d, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(d, &body)
+	}))
+	defer s.Close()
+
+	m := NewOTLPMetrics("test", s.URL)
+	m.Increment("service.started", nil)
+
+	err := m.Export()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/v1/metrics", path)
+	assert.Equal(t, "test", body.ResourceMetrics[0].Resource.Attributes[0].Value.StringValue)
+	assert.NotNil(t, findOTLPMetric(&body, "service.started"))
+}
+
+// logLines sends each line written by a logger to the channel
+type logLines chan string
+
+func (l logLines) Write(p []byte) (int, error) {
+	select {
+	case l <- string(p):
+	default:
+	}
+
+	return len(p), nil
+}
+
+func TestOTLPMetricsLogsFailedExportsUntilStopped(t *testing.T) {
+	var exports int32
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&exports, 1)
+		rw.WriteHeader(http.StatusBadRequest)
+	}))
+	defer s.Close()
+
+	lines := make(logLines, 10)
+	m := NewOTLPMetrics("test", s.URL)
+	m.Start(10*time.Millisecond, hclog.New(&hclog.LoggerOptions{Output: lines}))
+
+	select {
+	case l := <-lines:
+		assert.Contains(t, l, "Unable to export metrics")
+		assert.Contains(t, l, "status 400")
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the failed export to be logged")
+	}
+
+	m.Stop()
+	time.Sleep(50 * time.Millisecond)
+	n := atomic.LoadInt32(&exports)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, n, atomic.LoadInt32(&exports))
+}
@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// OtherPath is the label used for any path once the maximum number of
+// distinct paths has been reached
+const OtherPath = ":other"
+
+// pathRule replaces a path segment matching pattern with replacement
+type pathRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// PathNormalizer converts request paths into metric labels, high cardinality
+// segments such as IDs are collapsed using the configured rules and once
+// maxPaths distinct paths have been seen any new path is labelled OtherPath
+type PathNormalizer struct {
+	rules    []pathRule
+	maxPaths int
+
+	mutex sync.Mutex
+	seen  map[string]struct{}
+}
+
+// NewPathNormalizer creates a PathNormalizer from a semicolon separated list
+// of rules in the format regex=replacement, e.g. ^[0-9]+$=:id. The regex is
+// matched against each path segment
+func NewPathNormalizer(rules string, maxPaths int) (*PathNormalizer, error) {
+	p := &PathNormalizer{maxPaths: maxPaths, seen: map[string]struct{}{}}
+
+	for _, r := range strings.Split(rules, ";") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+
+		// replacements do not contain = so split on the last to allow = in
+		// the regex
+		i := strings.LastIndex(r, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid path rule %s, expected regex=replacement", r)
+		}
+
+		re, err := regexp.Compile(r[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid path rule %s: %s", r, err)
+		}
+
+		p.rules = append(p.rules, pathRule{re, r[i+1:]})
+	}
+
+	return p, nil
+}
+
+// Normalize returns the metric label for the path
+func (p *PathNormalizer) Normalize(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		for _, r := range p.rules {
+			if r.pattern.MatchString(s) {
+				segments[i] = r.replacement
+				break
+			}
+		}
+	}
+
+	n := strings.Join(segments, "/")
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, ok := p.seen[n]; ok {
+		return n
+	}
+
+	if p.maxPaths > 0 && len(p.seen) >= p.maxPaths {
+		return OtherPath
+	}
+
+	p.seen[n] = struct{}{}
+
+	return n
+}
@@ -0,0 +1,36 @@
+package logging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPathNormalizerReplacesMatchingSegments(t *testing.T) {
+	p, err := NewPathNormalizer(`^[0-9]+$=:id;^[0-9a-f]{8}-[0-9a-f-]{27}$=:uuid`, 10)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/", p.Normalize("/"))
+	assert.Equal(t, "/orders/:id", p.Normalize("/orders/123"))
+	assert.Equal(t, "/orders/:id/items/:id", p.Normalize("/orders/123/items/4"))
+	assert.Equal(t, "/users/:uuid", p.Normalize("/users/0b7c2f8e-4f1a-4b6e-9c3d-1a2b3c4d5e6f"))
+	assert.Equal(t, "/orders/latest", p.Normalize("/orders/latest"))
+}
+
+func TestPathNormalizerCollapsesPathsOverCardinalityLimit(t *testing.T) {
+	p, err := NewPathNormalizer(`^[0-9]+$=:id`, 2)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "/a", p.Normalize("/a"))
+	assert.Equal(t, "/b/:id", p.Normalize("/b/1"))
+	assert.Equal(t, OtherPath, p.Normalize("/c"))
+
+	// paths already seen keep their label
+	assert.Equal(t, "/b/:id", p.Normalize("/b/2"))
+}
+
+func TestPathNormalizerReturnsErrorForInvalidRule(t *testing.T) {
+	_, err := NewPathNormalizer(`[0-9=:id`, 2)
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestTimingPrefix is the prefix of the timings recorded for each request
+// handled by the service
+const requestTimingPrefix = "handle.request."
+
+// WarmUpMetrics is a Metrics implementation which discards the request
+// timings of the first requests after startup, warm-up requests are slowed
+// by cold caches and connection setup and would skew the latency histogram.
+// All other metrics are passed to the wrapped Metrics unchanged
+type WarmUpMetrics struct {
+	next     Metrics
+	requests int
+	until    time.Time
+	count    int
+	now      func() time.Time
+	mutex    sync.Mutex
+}
+
+// NewWarmUpMetrics creates WarmUpMetrics which discard the timings of the
+// first requests and of any request completed within duration of startup
+func NewWarmUpMetrics(next Metrics, requests int, duration time.Duration) *WarmUpMetrics {
+	return &WarmUpMetrics{
+		next:     next,
+		requests: requests,
+		until:    time.Now().Add(duration),
+		now:      time.Now,
+	}
+}
+
+// Timing records the duration unless it is a request timing during warm-up
+func (m *WarmUpMetrics) Timing(name string, duration time.Duration, tags []string) {
+	if strings.HasPrefix(name, requestTimingPrefix) && m.warmingUp() {
+		return
+	}
+
+	m.next.Timing(name, duration, tags)
+}
+
+// Increment adds one to the counter name
+func (m *WarmUpMetrics) Increment(name string, tags []string) {
+	m.next.Increment(name, tags)
+}
+
+// Gauge sets the gauge name to value
+func (m *WarmUpMetrics) Gauge(name string, value float64, tags []string) {
+	m.next.Gauge(name, value, tags)
+}
+
+// warmingUp counts a request and returns true when it is part of the warm-up
+func (m *WarmUpMetrics) warmingUp() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.count < m.requests {
+		m.count++
+		return true
+	}
+
+	return m.now().Before(m.until)
+}
@@ -0,0 +1,58 @@
+package logging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func histogramCount(m *OTLPMetrics, name string) string {
+	h := findOTLPMetric(m.collect(), name)
+	if h == nil {
+		return "0"
+	}
+
+	return h.Histogram.DataPoints[0].Count
+}
+
+func TestWarmUpMetricsDiscardsFirstRequests(t *testing.T) {
+	o := NewOTLPMetrics("test", "")
+	m := NewWarmUpMetrics(o, 3, 0)
+
+	for i := 0; i < 3; i++ {
+		m.Timing("handle.request.http", time.Millisecond, []string{"response:200"})
+	}
+	assert.Equal(t, "0", histogramCount(o, "handle.request.http"))
+
+	m.Timing("handle.request.http", time.Millisecond, []string{"response:200"})
+	m.Timing("handle.request.http", time.Millisecond, []string{"response:200"})
+	assert.Equal(t, "2", histogramCount(o, "handle.request.http"))
+}
+
+func TestWarmUpMetricsDiscardsRequestsWithinDuration(t *testing.T) {
+	now := time.Now()
+
+	o := NewOTLPMetrics("test", "")
+	m := NewWarmUpMetrics(o, 0, 10*time.Second)
+	m.until = now.Add(10 * time.Second)
+	m.now = func() time.Time { return now }
+
+	m.Timing("handle.request.grpc", time.Millisecond, []string{"response:0"})
+	assert.Equal(t, "0", histogramCount(o, "handle.request.grpc"))
+
+	now = now.Add(10 * time.Second)
+	m.Timing("handle.request.grpc", time.Millisecond, []string{"response:0"})
+	assert.Equal(t, "1", histogramCount(o, "handle.request.grpc"))
+}
+
+func TestWarmUpMetricsPassesOtherMetrics(t *testing.T) {
+	o := NewOTLPMetrics("test", "")
+	m := NewWarmUpMetrics(o, 3, 0)
+
+	m.Timing("upstream.request.http", time.Millisecond, []string{"response:200"})
+	m.Increment("service.started", nil)
+
+	assert.Equal(t, "1", histogramCount(o, "upstream.request.http"))
+	assert.NotNil(t, findOTLPMetric(o.collect(), "service.started"))
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"runtime"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/gobuffalo/packr/v2"
@@ -21,8 +25,12 @@ import (
 	"github.com/nicholasjackson/fake-service/handlers"
 	"github.com/nicholasjackson/fake-service/load"
 	"github.com/nicholasjackson/fake-service/logging"
+	"github.com/nicholasjackson/fake-service/response"
+	"github.com/nicholasjackson/fake-service/scenario"
 	"github.com/nicholasjackson/fake-service/timing"
 	"github.com/nicholasjackson/fake-service/tracing"
+	"github.com/nicholasjackson/fake-service/traffic"
+	"github.com/nicholasjackson/fake-service/worker"
 
 	cors "github.com/gorilla/handlers"
 
@@ -30,81 +38,256 @@ import (
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
-	//"net/http/pprof"
+	"net/http/pprof"
 )
 
-var upstreamURIs = env.String("UPSTREAM_URIS", false, "", "Comma separated URIs of the upstream services to call")
+var upstreamURIs = env.String("UPSTREAM_URIS", false, "", "Comma separated URIs of the upstream services to call, URIs can contain environment placeholders e.g. http://{{env \"BACKEND_HOST\"}}/api")
 var upstreamAllowInsecure = env.Bool("UPSTREAM_ALLOW_INSECURE", false, false, "Allow calls to upstream servers, ignoring TLS certificate validation")
+var upstreamServerNames = env.String("UPSTREAM_TLS_SERVER_NAMES", false, "", "Semicolon separated TLS server names (SNI) sent to upstreams, format uri=name e.g. https://10.0.0.5:8443=payments.internal, the upstream certificate is verified against the name, gRPC upstreams with a server name are called using TLS")
+var circuitBreakerThreshold = env.Int("CIRCUIT_BREAKER_THRESHOLD", false, 0, "Number of consecutive failures after which calls to an upstream are stopped, the state is reported at /stats/circuits, default 0 is disabled")
+var circuitBreakerOpenDuration = env.Duration("CIRCUIT_BREAKER_OPEN_DURATION", false, 10*time.Second, "Duration an upstream circuit stays open before a probe call is allowed")
+var upstreamTransforms = env.String("UPSTREAM_TRANSFORMS", false, "", "Semicolon separated transforms applied to upstream responses, format uri=transform[|transform] e.g. http://localhost:9091=strip:headers,cookies|rename:body:payload, supported transforms are strip:field[,field], rename:from:to, wrap:field")
+var upstreamPipelineMode = env.String("UPSTREAM_PIPELINE_MODE", false, "", "When set upstreams are called one at a time and UPSTREAM_PIPELINE_FIELD of each response is passed to the next upstream [header, body], default disabled")
+var upstreamPipelineField = env.String("UPSTREAM_PIPELINE_FIELD", false, "body", "Top level field of the upstream response passed to the next upstream in the pipeline")
+var upstreamPipelineHeader = env.String("UPSTREAM_PIPELINE_HEADER", false, "X-Pipeline-Data", "Header used to pass data to the next upstream when UPSTREAM_PIPELINE_MODE is header")
+var upstreamProtocolDetect = env.Bool("UPSTREAM_PROTOCOL_DETECT", false, false, "When true upstreams are probed with HTTP then gRPC and the working protocol is remembered for each host, regardless of the URI scheme")
+var upstreamBudget = env.Duration("UPSTREAM_BUDGET", false, 0*time.Second, "Total time allowed for the upstream calls of a request, upstreams not called before the budget or the deadline of the caller expires are skipped, default 0 is no budget [1s,100ms]")
+var upstreamRetries = env.Int("UPSTREAM_RETRIES", false, 0, "Number of times a failed upstream call is retried, the retries made are added to the upstream response, default 0 is no retries")
+var upstreamRetryBackoff = env.Duration("UPSTREAM_RETRY_BACKOFF", false, 0*time.Second, "Time to wait between retries of a failed upstream call [1s,100ms]")
+var upstreamHedgeDelay = env.Duration("UPSTREAM_HEDGE_DELAY", false, 0*time.Second, "When set a second request is sent to an upstream which has not responded within this time, the first response is used and the other cancelled, default 0 disables hedging [100ms]")
+var upstreamReportDeadline = env.Bool("UPSTREAM_REPORT_DEADLINE", false, false, "When true the time remaining before the request deadline when each upstream was called is added to the upstream response, shows how the deadline shrinks down the call tree")
+var upstreamRawBodyLimit = env.Int("UPSTREAM_RAW_BODY_LIMIT", false, 1024, "Maximum number of bytes of a non JSON upstream response, e.g. an HTML error page, added to the upstream response, 0 does not add the body")
+var headUpstreams = env.Bool("HEAD_CALL_UPSTREAMS", false, true, "When false HEAD requests do not call the upstreams, the response headers are computed without the upstream calls")
+var upstreamStats = env.Bool("UPSTREAM_STATS", false, false, "When true the response includes a summary of the upstream latencies, count, min, max, mean, p50, p95 and the slowest upstream")
+var upstreamWeights = env.String("UPSTREAM_WEIGHTS", false, "", "Semicolon separated weights of upstream durations in the reported critical path, format uri=weight e.g. http://localhost:9091=0 models an async dependency, upstreams without a weight have a weight of 1")
+var mirrorURI = env.String("MIRROR_URI", false, "", "URI of a shadow upstream which will asynchronously receive a copy of requests, the shadow response is ignored")
+var mirrorRate = env.Float64("MIRROR_RATE", false, 1.0, "Decimal percentage of requests which are mirrored to MIRROR_URI. e.g. 0.1 = 10% of all requests will be mirrored")
 var upstreamWorkers = env.Int("UPSTREAM_WORKERS", false, 1, "Number of parallel workers for calling upstreams, default is 1 which is sequential operation")
+var upstreamFailureMode = env.String("UPSTREAM_FAILURE_MODE", false, "all-or-nothing", "Response when an upstream call fails, all-or-nothing returns an error, partial returns success when at least one upstream succeeds and reports the status of each upstream [all-or-nothing|partial]")
+var upstreamRepeat = env.Int("UPSTREAM_REPEAT", false, 1, "Number of times each upstream is called for a request, the repeated responses are grouped under the upstream")
+var upstreamWorkerQueueSize = env.Int("UPSTREAM_WORKER_QUEUE_SIZE", false, 0, "Maximum number of upstream calls waiting for a worker, calls which overflow the queue are dropped, default 0 is unbounded")
+var upstreamHealthCheckInterval = env.Duration("UPSTREAM_HEALTH_CHECK_INTERVAL", false, 0*time.Second, "Interval between health checks of the upstreams, requests are only sent to upstreams passing their health checks and unhealthy upstreams are reported as skipped, default 0 disables health checks")
+var upstreamHealthCheckThreshold = env.Int("UPSTREAM_HEALTH_CHECK_THRESHOLD", false, 3, "Number of consecutive failed health checks before an upstream is unhealthy")
+var upstreamHealthCheckPath = env.String("UPSTREAM_HEALTH_CHECK_PATH", false, "/ready", "Path of the HTTP health check of the upstreams, gRPC upstreams are checked by calling the service")
+var upstreamDispatchOrder = env.String("UPSTREAM_DISPATCH_ORDER", false, "sequential", "Order upstreams are dispatched to the workers [sequential, random], random shuffles the upstreams for each request in a sequence determined by UPSTREAM_DISPATCH_SEED")
+var upstreamDispatchSeed = env.Int("UPSTREAM_DISPATCH_SEED", false, 0, "Seed of the random dispatch order, requests are dispatched in the same sequence for the same seed, default 0 uses the current time")
+var upstreamWorkerQueueReport = env.Bool("UPSTREAM_WORKER_QUEUE_REPORT", false, false, "When true the upstream worker queue depth, wait time, and dropped calls are added to the response")
 
 var serviceType = env.String("SERVER_TYPE", false, "http", "Service type: [http or grpc], default:http. Determines the type of service HTTP or gRPC")
 var message = env.String("MESSAGE", false, "Hello World", "Message to be returned from service")
+var messageFile = env.String("MESSAGE_FILE", false, "", "Path to a file containing the message to be returned from service, the file is re-read when it changes and overrides MESSAGE")
+var messageTemplate = env.Bool("MESSAGE_TEMPLATE", false, false, "When true the message is rendered as a Go template with the request .Path, .Headers, and .RequestID, the functions {{uuid}}, {{now}}, and {{randInt min max}} generate fresh values for each response")
 var name = env.String("NAME", false, "Service", "Name of the service")
+var namePool = env.String("NAME_POOL", false, "", "Comma separated list of names, when set the response name is chosen at random from the pool for each request")
+var clockSkew = env.Duration("CLOCK_SKEW", false, 0, "Offset added to the start and end times reported in the response to simulate clock skew e.g. -2s, the reported duration is not affected")
+var splitBrainRate = env.Float64("SPLIT_BRAIN_RATE", false, 0.0, "Decimal percentage of requests answered with SPLIT_BRAIN_MESSAGE to simulate a stale replica, the replica which served the request is added to the response")
+var splitBrainMessage = env.String("SPLIT_BRAIN_MESSAGE", false, "Stale World", "Message returned by the stale replica when SPLIT_BRAIN_RATE is set")
+var rolloutMessage = env.String("ROLLOUT_MESSAGE", false, "", "Message returned to the new cohort of a feature rollout, the cohort of the request is added to the response, HTTP only")
+var rolloutPercentage = env.Float64("ROLLOUT_PERCENTAGE", false, 0.0, "Decimal percentage of request identities which receive ROLLOUT_MESSAGE")
+var rolloutIdentityHeader = env.String("ROLLOUT_IDENTITY_HEADER", false, "X-User-ID", "Request header which identifies the caller for a stable rollout cohort, the client address is used when the header is not set")
+var rolloutCohortHeader = env.String("ROLLOUT_COHORT_HEADER", false, "X-Rollout-Cohort", "Request header which forces the cohort of a request to new or old")
+var responseSchemaVersion = env.Int("RESPONSE_SCHEMA_VERSION", false, 0, "Schema version of responses when the request does not set the Accept-Version header or schema_version query parameter, 1 returns upstream_calls as a list, default 0 is the latest version")
+var schemaMigrationDuring = env.String("SCHEMA_MIGRATION_DURING", false, "", "Transforms applied to the response during the schema migration window to simulate a rolling schema change e.g. strip:body, same format as UPSTREAM_TRANSFORMS")
+var schemaMigrationAfter = env.String("SCHEMA_MIGRATION_AFTER", false, "", "Transforms applied to the response once the schema migration window has passed, the new schema e.g. rename:body:payload, same format as UPSTREAM_TRANSFORMS")
+var schemaMigrationDelay = env.Duration("SCHEMA_MIGRATION_DELAY", false, 0*time.Second, "Time after the service starts when the schema migration window opens")
+var schemaMigrationWindow = env.Duration("SCHEMA_MIGRATION_WINDOW", false, 1*time.Minute, "Duration of the schema migration window")
+var responseEnvelope = env.Bool("RESPONSE_ENVELOPE", false, false, "When true the response is wrapped in RESPONSE_ENVELOPE_TEMPLATE to emulate an API gateway")
+var responseEnvelopeTemplate = env.String("RESPONSE_ENVELOPE_TEMPLATE", false, handlers.DefaultEnvelopeTemplate, "Go template of the response envelope, .Response is the JSON response, .RequestID, .Path, .Code, and .TookMS describe the request")
+var paginationTotal = env.Int("PAGINATION_TOTAL", false, 0, "Number of synthetic items returned by a GET request with a page query parameter e.g. ?page=2, the response contains a page of items with next and prev links, default 0 disables pagination")
+var paginationPageSize = env.Int("PAGINATION_PAGE_SIZE", false, 10, "Number of items in each page of PAGINATION_TOTAL items")
+var responseVariants = env.Int("RESPONSE_VARIANTS", false, 0, "Number of response variants, when greater than 0 the response contains a variant derived from a stable hash of the request path and query, default 0 is disabled")
+var responseVaryHeaders = env.String("RESPONSE_VARY_HEADERS", false, "", "Comma separated list of request headers the response varies by, sets the Vary header and a distinct ETag per value e.g. Accept-Language")
+var echoGRPCMetadata = env.Bool("ECHO_GRPC_METADATA", false, false, "When true the metadata sent with a gRPC request is echoed in the response")
+var echoHTTPTrailers = env.Bool("ECHO_HTTP_TRAILERS", false, false, "When true the trailers sent after the body of an HTTP request are echoed in the response")
+var echoRedact = env.String("ECHO_REDACT", false, "authorization,cookie", "Comma separated list of metadata keys or trailers whose values are redacted when echoed")
+var responseOmitFields = env.String("RESPONSE_OMIT_FIELDS", false, "", "Comma separated list of response fields to omit, simulates partial responses, e.g. duration,ip_addresses")
+var responseWriteChunkSize = env.Int("RESPONSE_WRITE_CHUNK_SIZE", false, 0, "Size in bytes of each write of the response body, each chunk is flushed to the connection separately, default 0 writes the body at once, HTTP only")
 
 var listenAddress = env.String("LISTEN_ADDR", false, "0.0.0.0:9090", "IP address and port to bind service to")
+var controlAddress = env.String("CONTROL_GRPC_ADDR", false, "", "IP address and port of the gRPC control service used to change the error rate, latency, and load at runtime e.g. 0.0.0.0:9091, default is disabled")
+var controlToken = env.String("CONTROL_GRPC_TOKEN", false, "", "Bearer token which must be sent in the authorization metadata of calls to the gRPC control service, default does not require a token")
+var ipInterfaces = env.String("IP_INTERFACES", false, "", "Comma separated list of network interfaces whose addresses are reported in the response, default is all interfaces")
+var ipExcludeLinkLocal = env.Bool("IP_EXCLUDE_LINK_LOCAL", false, false, "When true link-local addresses are not reported in the response")
+var ipPreferCIDR = env.String("IP_PREFER_CIDR", false, "", "When set only the addresses in the CIDR are reported in the response e.g. 10.0.0.0/8, all addresses are reported when none are in the CIDR")
 
 var allowedOrigins = env.String("ALLOWED_ORIGINS", false, "*", "Comma separated list of allowed origins for CORS requests")
 var allowedHeaders = env.String("ALLOWED_HEADERS", false, "Accept,Accept-Language,Content-Language,Origin,Content-Type", "Comma separated list of allowed headers for cors requests")
+var optionsCapabilities = env.Bool("OPTIONS_CAPABILITIES", false, false, "When true OPTIONS requests which are not CORS preflight requests return a JSON document describing the configured behaviour of the service")
 var allowCredentials = env.Bool("ALLOW_CREDENTIALS", false, false, "Are credentials allowed for CORS requests")
 
 // Upstream client configuration
 var upstreamClientKeepAlives = env.Bool("HTTP_CLIENT_KEEP_ALIVES", false, false, "Enable HTTP connection keep alives for upstream calls, also enables the HTTP servers handling of keep alives.")
 var upstreamAppendRequest = env.Bool("HTTP_CLIENT_APPEND_REQUEST", false, true, "When true the path, querystring, and any headers sent to the service will be appended to any upstream calls")
 var upstreamRequestTimeout = env.Duration("HTTP_CLIENT_REQUEST_TIMEOUT", false, 30*time.Second, "Max time to wait before timeout for upstream requests, default 30s")
+var upstreamConnectTimeout = env.Duration("HTTP_CLIENT_CONNECT_TIMEOUT", false, 30*time.Second, "Max time to wait for a connection to an upstream to be established, default 30s")
+var upstreamMaxResponseBytes = env.Int("HTTP_CLIENT_MAX_RESPONSE_BYTES", false, 10*1024*1024, "Maximum size in bytes of an upstream response body, larger responses are truncated and reported as truncated, 0 is unlimited, default 10MB")
+var proxyProtocol = env.Bool("PROXY_PROTOCOL", false, false, "When true the HTTP listener reads the PROXY protocol v1 or v2 header sent by load balancers and the original client address is added to the response")
+var socketNoDelay = env.Bool("SOCKET_TCP_NODELAY", false, true, "When true Nagle's algorithm is disabled (TCP_NODELAY) for inbound and upstream connections, setting false shows the latency impact of Nagle's algorithm")
+var socketSendBuffer = env.Int("SOCKET_SEND_BUFFER", false, 0, "Size in bytes of the socket send buffer for inbound and upstream connections, default 0 uses the OS default")
+var socketReceiveBuffer = env.Int("SOCKET_RECEIVE_BUFFER", false, 0, "Size in bytes of the socket receive buffer for inbound and upstream connections, default 0 uses the OS default")
+var dnsServer = env.String("DNS_SERVER", false, "", "Address of the DNS server used to resolve upstream hosts e.g. 10.0.0.2:53, default uses the system resolver")
+var dnsCacheTTL = env.Duration("DNS_CACHE_TTL", false, 0*time.Second, "Duration resolved upstream addresses are cached for, default 0 disables the cache")
+var dnsDelay = env.Duration("DNS_DELAY", false, 0*time.Second, "Delay added to every upstream DNS lookup which is not cached [1s,100ms]")
+var dnsFailureRate = env.Float64("DNS_FAILURE_RATE", false, 0.0, "Decimal percentage of upstream DNS lookups which fail. e.g. 0.1 = 10% of lookups will fail")
+var grpcMaxMessageSize = env.Int("GRPC_MAX_MESSAGE_SIZE", false, 4*1024*1024, "Maximum size in bytes of gRPC messages received by the server and upstream clients, default 4MB, the size of sent messages is not limited")
+var grpcClientCompression = env.String("GRPC_CLIENT_COMPRESSION", false, "", "Compression used for gRPC requests to upstreams e.g. gzip, default is no compression")
+var grpcServerCompression = env.Bool("GRPC_SERVER_COMPRESSION", false, false, "When true gRPC responses are always gzip compressed, when false responses use the same compression as the request")
+var grpcRateLimitInterceptor = env.Bool("GRPC_RATE_LIMIT_INTERCEPTOR", false, false, "When true RATE_LIMIT is enforced for gRPC services by an interceptor which returns ResourceExhausted with a RetryInfo detail in place of RATE_LIMIT_CODE")
+var grpcStreamMessages = env.Int("GRPC_STREAM_MESSAGES", false, 100, "Number of messages sent by the StreamService when the request does not set a count, the final message reports the time blocked by flow control")
+var grpcStreamMessageSize = env.Int("GRPC_STREAM_MESSAGE_SIZE", false, 64*1024, "Size in bytes of the payload of each message sent by the StreamService, larger messages fill the flow control window sooner")
+var grpcReportCompression = env.Bool("GRPC_REPORT_COMPRESSION", false, false, "When true the compression of the gRPC request and response is added to the response")
+
+// Database query simulation
+var dbQueries = env.Int("DB_QUERIES", false, 0, "Number of simulated database queries made by each request, the queries are reported in the response, default 0 is disabled")
+var dbQueriesVariance = env.Int("DB_QUERIES_VARIANCE", false, 0, "Maximum number of extra queries made by a request, each request makes a random number of extra queries up to this value")
+var dbQuery50Percentile = env.Duration("DB_QUERY_50_PERCENTILE", false, 1*time.Millisecond, "Median duration of a simulated database query")
+var dbQuery90Percentile = env.Duration("DB_QUERY_90_PERCENTILE", false, 0*time.Millisecond, "90 percentile duration of a simulated database query, if no value is set, will use value from DB_QUERY_50_PERCENTILE")
+var dbQuery99Percentile = env.Duration("DB_QUERY_99_PERCENTILE", false, 0*time.Millisecond, "99 percentile duration of a simulated database query, if no value is set, will use value from DB_QUERY_90_PERCENTILE")
+var dbQueryErrorRate = env.Float64("DB_QUERY_ERROR_RATE", false, 0.0, "Decimal percentage of simulated database queries which fail, failed queries are counted in the response but do not fail the request")
 
 // Service timing
 var timing50Percentile = env.Duration("TIMING_50_PERCENTILE", false, time.Duration(0*time.Millisecond), "Median duration for a request")
 var timing90Percentile = env.Duration("TIMING_90_PERCENTILE", false, time.Duration(0*time.Millisecond), "90 percentile duration for a request, if no value is set, will use value from TIMING_50_PERCENTILE")
 var timing99Percentile = env.Duration("TIMING_99_PERCENTILE", false, time.Duration(0*time.Millisecond), "99 percentile duration for a request, if no value is set, will use value from TIMING_90_PERCENTILE")
+var timingTailRate = env.Float64("TIMING_TAIL_RATE", false, 0.0, "Decimal percentage of requests which will have TIMING_TAIL_DELAY added to their duration. e.g. 0.01 = 1% of all requests will be slow")
+var timingTailDelay = env.Duration("TIMING_TAIL_DELAY", false, 0*time.Second, "Additional delay added to requests selected by TIMING_TAIL_RATE [1s,100ms]")
+var timingCorrelation = env.Float64("TIMING_CORRELATION", false, 0, "Correlation coefficient between the durations of consecutive requests [0,1), each duration is blended with the previous duration so slow periods cluster, default 0 makes every duration independent")
 var timingVariance = env.Int("TIMING_VARIANCE", false, 0, "Percentage variance for each request, every request will vary by a random amount to a maximum of a percentage of the total request time")
 
 // performance testing flags
 // these flags allow the user to inject faults into the service for testing purposes
 var errorRate = env.Float64("ERROR_RATE", false, 0.0, "Decimal percentage of request where handler will report an error. e.g. 0.1 = 10% of all requests will result in an error")
-var errorType = env.String("ERROR_TYPE", false, "http_error", "Type of error [http_error, delay]")
+var errorType = env.String("ERROR_TYPE", false, "http_error", "Type of error [http_error, delay, content_length]")
 var errorCode = env.Int("ERROR_CODE", false, http.StatusInternalServerError, "Error code to return on error")
 var errorDelay = env.Duration("ERROR_DELAY", false, 0*time.Second, "Error delay [1s,100ms]")
+var errorContentLengthOffset = env.Int("ERROR_CONTENT_LENGTH_OFFSET", false, 10, "Bytes added to the declared Content-Length for the content_length error type, negative values declare a shorter length than the body")
+var errorEveryN = env.Int("ERROR_EVERY_N", false, 0, "When set exactly every Nth request returns an error, e.g. 3 fails every third request, overrides ERROR_RATE")
+var errorEveryNCode = env.Int("ERROR_EVERY_N_CODE", false, http.StatusInternalServerError, "Error code to return for ERROR_EVERY_N errors")
+var errorFailThenSucceed = env.Int("ERROR_FAIL_THEN_SUCCEED", false, 0, "When set the service fails this number of consecutive requests with ERROR_CODE then one succeeds and the pattern repeats, a caller with UPSTREAM_RETRIES of at least this number always succeeds, overrides ERROR_RATE")
+var errorCodeDistribution = env.String("ERROR_CODE_DISTRIBUTION", false, "", "Semicolon separated weights of the response codes returned by the service, format code=weight e.g. 200=80;500=10;503=5;429=5, codes below 400 return a normal response. Replaces ERROR_RATE")
+var errorRequestSizeLimit = env.Int("ERROR_REQUEST_SIZE_LIMIT", false, 0, "Size in bytes of the request body above which requests fail with ERROR_REQUEST_SIZE_CODE, models a service which fails on large inputs, default 0 is disabled")
+var errorRequestSizeRate = env.Float64("ERROR_REQUEST_SIZE_RATE", false, 1, "Decimal percentage of requests larger than ERROR_REQUEST_SIZE_LIMIT which fail, e.g. 1 = all large requests fail")
+var errorRequestSizeCode = env.Int("ERROR_REQUEST_SIZE_CODE", false, http.StatusInternalServerError, "Error code to return for requests larger than ERROR_REQUEST_SIZE_LIMIT")
+var tenantFile = env.String("TENANT_FILE", false, "", "Path to a YAML or JSON file mapping tenant IDs to their duration, error_rate, error_code, and body, requests from unknown tenants use the default behaviour, HTTP only")
+var tenantHeader = env.String("TENANT_HEADER", false, "X-Tenant-ID", "Request header which identifies the tenant of a request")
+var scenarioFile = env.String("SCENARIO_FILE", false, "", "Path to a YAML or JSON file defining a timeline of phases with error rates and delays, while running the scenario overrides ERROR_RATE")
+
+// degrade the service as errors accumulate
+var degradedErrorThreshold = env.Int("DEGRADED_ERROR_THRESHOLD", false, 0, "Number of errors within DEGRADED_WINDOW after which requests are slowed down, 0 disables degraded mode")
+var degradedWindow = env.Duration("DEGRADED_WINDOW", false, 60*time.Second, "Window in which errors are counted for degraded mode, the delay is reset when the window expires")
+var degradedDelayStep = env.Duration("DEGRADED_DELAY_STEP", false, 100*time.Millisecond, "Delay added to requests for each error over DEGRADED_ERROR_THRESHOLD")
 
 // rate limit request to the service
 var rateLimitRPS = env.Float64("RATE_LIMIT", false, 0.0, "Rate in req/second after which service will return an error code")
 var rateLimitCode = env.Int("RATE_LIMIT_CODE", false, 503, "Code to return when service call is rate limited")
+var rateLimitRetryAfterMin = env.Duration("RATE_LIMIT_RETRY_AFTER_MIN", false, 1*time.Second, "Minimum Retry-After returned with a rate limited response")
+var rateLimitRetryAfterMax = env.Duration("RATE_LIMIT_RETRY_AFTER_MAX", false, 30*time.Second, "Maximum Retry-After returned with a rate limited response, the Retry-After grows with the requests over the limit, 0 disables Retry-After")
+var quotaLimit = env.Int("QUOTA_LIMIT", false, 0, "Number of requests allowed in each QUOTA_WINDOW, further requests return 429 with X-RateLimit headers until the window resets, default 0 is disabled")
+var quotaWindow = env.Duration("QUOTA_WINDOW", false, 1*time.Minute, "Window in which QUOTA_LIMIT requests are allowed, windows start at multiples of the duration on the wall clock, must be greater than 0")
+var tokenLifetime = env.Duration("TOKEN_LIFETIME", false, 0*time.Second, "Time a bearer token is valid for from the first request which presents it, further requests with the token return 401 token expired until a new token is presented, default 0 is disabled, HTTP only")
+var tokenStoreSize = env.Int("TOKEN_STORE_SIZE", false, 1000, "Maximum number of bearer tokens remembered for TOKEN_LIFETIME, the token first seen longest ago is forgotten when full")
+var idempotencyTTL = env.Duration("IDEMPOTENCY_TTL", false, 0*time.Second, "Time the response to a request with an Idempotency-Key header is cached for, repeated requests with the key return the cached response without being processed, a key reused for a different method or path returns 422 and a repeat while the first request is in progress returns 409, default 0 is disabled, HTTP only")
+var idempotencyStoreSize = env.Int("IDEMPOTENCY_STORE_SIZE", false, 1000, "Maximum number of responses cached for IDEMPOTENCY_TTL, the response cached longest ago is removed when full")
+var loadShedCPUThreshold = env.Float64("LOAD_SHED_CPU_THRESHOLD", false, 0, "Percentage of the available CPU above which the service sheds load, requests are rejected with 503 in proportion to how far the CPU usage is over the threshold, default 0 is disabled, HTTP only")
+var loadShedInterval = env.Duration("LOAD_SHED_INTERVAL", false, 1*time.Second, "Interval between measurements of the CPU usage for LOAD_SHED_CPU_THRESHOLD")
 
 // process load generation
 var processLoadCPUCores = env.Float64("PROCESS_LOAD_CPU_CORES", false, -1, "Number of cores to generate fake CPU load over, by default fake-service will use all cores")
+var processLoadCPUStartSpread = env.Duration("PROCESS_LOAD_CPU_START_SPREAD", false, 0, "Window over which the start of each CPU load goroutine is staggered so utilization ramps up smoothly, e.g. 10s, default starts all at once")
+var processLoadMemoryTouchStride = env.Int("PROCESS_LOAD_MEMORY_TOUCH_STRIDE", false, 0, "Bytes between writes when touching the process memory so that it is resident, smaller strides use more CPU, e.g. 4096 touches every page, default 0 does not touch memory")
+var processLoadMemoryTouchMode = env.String("PROCESS_LOAD_MEMORY_TOUCH_MODE", false, "once", "When memory is touched [once, continuous], continuous re-touches the memory every tick so pages are not swapped out")
+var processLoadMemoryTouchWorkers = env.Int("PROCESS_LOAD_MEMORY_TOUCH_WORKERS", false, 1, "Number of goroutines which touch the process memory in parallel, capped to the cores not used by PROCESS_LOAD_CPU_CORES")
 var processLoadCPUPercentage = env.Float64("PROCESS_LOAD_CPU_PERCENTAGE", false, 0, "Percentage of CPU cores to consume as a percentage. I.e: 50, 50% load for LOAD_CPU_CORES. If LOAD_CPU_ALLOCATED is not specified CPU percentage is based on the Total CPU available")
 
 var processLoadMemoryAllocated = env.Int("PROCESS_LOAD_MEMORY", false, 0, "Memory in mebibytes (MiB) consumed by the process")
 var processLoadMemoryVariance = env.Int("PROCESS_LOAD_MEMORY_VARIANCE", false, 0, "Percentage variance of the memory consumed per tick, i.e with a value of 50 = 50%, and given a PROCESS_LOAD_MEMORY of 1024 bytes, actual consumption per tick would be in the range 516 - 1540 bytes")
 var processLoadMemoryVarianceFunction = env.String("PROCESS_LOAD_MEMORY_VARIANCE_FUNCTION", false, "linear", "Function used to vary memory over time. Valid values: random")
 var processLoadMemoryVariancePeriod = env.Int("PROCESS_LOAD_MEMORY_VARIANCE_PERIOD", false, 1, "Period for periodic variance functions in seconds. Valid values: random")
+var processLoadMemoryReplayFile = env.String("PROCESS_LOAD_MEMORY_REPLAY_FILE", false, "", "File containing a recorded series of per tick memory targets in bytes, one per line, overrides PROCESS_LOAD_MEMORY_VARIANCE_FUNCTION")
+var noisyNeighborInterval = env.Duration("NOISY_NEIGHBOR_INTERVAL", false, 0*time.Second, "Interval between bursts of background CPU and memory load which model a noisy neighbor, bursts start at multiples of the interval on the wall clock, default 0 disables the bursts")
+var noisyNeighborDuration = env.Duration("NOISY_NEIGHBOR_DURATION", false, 10*time.Second, "Duration of each noisy neighbor burst, must be shorter than NOISY_NEIGHBOR_INTERVAL")
+var noisyNeighborCPUCores = env.Float64("NOISY_NEIGHBOR_CPU_CORES", false, 0, "Number of cores loaded during a noisy neighbor burst, default 0 generates no CPU load")
+var noisyNeighborCPUPercentage = env.Float64("NOISY_NEIGHBOR_CPU_PERCENTAGE", false, 0, "Percentage of each core consumed during a noisy neighbor burst")
+var noisyNeighborMemory = env.Int("NOISY_NEIGHBOR_MEMORY", false, 0, "Memory in mebibytes (MiB) held during a noisy neighbor burst")
+var processLoadMemoryHighWater = env.Float64("PROCESS_LOAD_MEMORY_HIGH_WATER", false, 0, "Fraction of the memory limit used by the process above which the process memory load is reduced to avoid running out of memory, e.g. 0.9, default 0 disables the backoff")
+var processLoadMemoryLowWater = env.Float64("PROCESS_LOAD_MEMORY_LOW_WATER", false, 0.7, "Fraction of the memory limit the process memory is reduced to once PROCESS_LOAD_MEMORY_HIGH_WATER is reached, the memory load is restored below this mark")
+var processLoadMemoryLimit = env.Int("PROCESS_LOAD_MEMORY_LIMIT", false, 0, "Memory limit in mebibytes (MiB) the water marks are fractions of, default 0 uses the cgroup memory limit")
+var processLoadSizeClassSize = env.Int("PROCESS_LOAD_SIZE_CLASS_SIZE", false, 0, "Size in bytes of the objects allocated every tick to stress a single size class of the allocator, a size just over a class boundary e.g. 1025 wastes memory as internal fragmentation, default 0 is disabled")
+var processLoadSizeClassCount = env.Int("PROCESS_LOAD_SIZE_CLASS_COUNT", false, 1000, "Number of objects of PROCESS_LOAD_SIZE_CLASS_SIZE allocated every tick, the objects are held until the next tick")
+var processLoadMemoryReplayLoop = env.Bool("PROCESS_LOAD_MEMORY_REPLAY_LOOP", false, true, "When true the memory replay restarts from the beginning once the series ends, otherwise the last value is held")
 
 // request load generation
 var loadCPUAllocated = env.Float64("LOAD_CPU_ALLOCATED", false, 0, "MHz of CPU allocated to the service, when specified, load percentage is a percentage of CPU allocated")
 var loadCPUClockSpeed = env.Float64("LOAD_CPU_CLOCK_SPEED", false, 1000, "MHz of a Single logical core, default 1000Mhz")
 var loadCPUCores = env.Float64("LOAD_CPU_CORES", false, -1, "Number of cores to generate fake CPU load over, by default fake-service will use all cores")
 var loadCPUPercentage = env.Float64("LOAD_CPU_PERCENTAGE", false, 0, "Percentage of CPU cores to consume as a percentage. I.e: 50, 50% load for LOAD_CPU_CORES. If LOAD_CPU_ALLOCATED is not specified CPU percentage is based on the Total CPU available")
+var loadCPUCgroupClamp = env.Bool("LOAD_CPU_CGROUP_CLAMP", false, false, "When true LOAD_CPU_CORES and GOMAXPROCS are clamped to the whole cores of the cgroup CPU quota so that the generated load is not throttled")
+
+var loadWorkUnits = env.Int("LOAD_WORK_UNITS", false, 0, "Number of CPU bound work units (SHA256 iterations) performed per request, the count and elapsed time are reported in the response")
 
 var loadMemoryAllocated = env.Int("LOAD_MEMORY_PER_REQUEST", false, 0, "Memory in bytes consumed per request")
+var loadMemoryCgroupFraction = env.Float64("LOAD_MEMORY_CGROUP_FRACTION", false, 0.8, "Fraction of the cgroup memory limit the memory consumed per request, including variance, is clamped to, 0 disables the clamp")
 var loadMemoryVariance = env.Int("LOAD_MEMORY_VARIANCE", false, 0, "Percentage variance of the memory consumed per request, i.e with a value of 50 = 50%, and given a LOAD_MEMORY_PER_REQUEST of 1024 bytes, actual consumption per request would be in the range 516 - 1540 bytes")
 
+var requestLoadHeaders = env.Bool("LOAD_REQUEST_HEADERS", false, false, "When true a request can set its own CPU and memory load with the X-Fake-CPU-Ms and X-Fake-Mem-MB headers")
+var requestLoadMaxCPU = env.Duration("LOAD_REQUEST_MAX_CPU", false, 1*time.Second, "Maximum CPU time a request can ask for with the X-Fake-CPU-Ms header")
+var requestLoadMaxMemory = env.Int("LOAD_REQUEST_MAX_MEMORY_MB", false, 512, "Maximum megabytes of memory a request can ask for with the X-Fake-Mem-MB header")
+var fileAllocationCount = env.Int("LOAD_FILE_DESCRIPTORS", false, 0, "Number of file descriptors opened and held for the duration of each request, concurrent requests increase the number of open descriptors")
+var fileAllocationMax = env.Int("LOAD_FILE_DESCRIPTORS_MAX", false, 1000, "Maximum number of file descriptors held across all requests by LOAD_FILE_DESCRIPTORS, 0 is unlimited")
+var requestAllocationMB = env.Int("LOAD_PPROF_ALLOCATION_MB", false, 0, "Megabytes of memory allocated and held for the duration of each request from a labelled function which is visible in heap profiles at /debug/pprof/heap")
+var pprofEnabled = env.Bool("PPROF_ENABLED", false, false, "When true the pprof handlers are served at /debug/pprof/")
+
 // metrics / tracing / logging
 var zipkinEndpoint = env.String("TRACING_ZIPKIN", false, "", "Location of Zipkin tracing collector")
 var datadogTracingEndpointHost = env.String("TRACING_DATADOG_HOST", false, "", "Hostname or IP for Datadog tracing collector")
 var datadogTracingEndpointPort = env.String("TRACING_DATADOG_PORT", false, "8126", "Port for Datadog tracing collector")
+var tracingSampleRate = env.Float64("TRACING_SAMPLE_RATE", false, 1.0, "Fraction of requests [0.0-1.0] which will export tracing spans, context is always propagated to upstreams, the rate is reported at /stats/tracing")
 var datadogMetricsEndpointHost = env.String("METRICS_DATADOG_HOST", false, "", "Hostname or IP for Datadog metrics collector")
 var datadogMetricsEndpointPort = env.String("METRICS_DATADOG_PORT", false, "8125", "Port for Datadog metrics collector")
 var datadogMetricsEnvironment = env.String("METRICS_DATADOG_ENVIRONMENT", false, "production", "Environment tag for Datadog metrics collector")
+var otlpMetricsEndpoint = env.String("METRICS_OTLP_ENDPOINT", false, "", "Endpoint of an OpenTelemetry collector which receives metrics with the OTLP HTTP protocol e.g. http://localhost:4318, can not be combined with METRICS_DATADOG_HOST, failed exports are logged")
+var otlpMetricsInterval = env.Duration("METRICS_OTLP_INTERVAL", false, 10*time.Second, "Interval between exports of metrics to the OpenTelemetry collector")
+var metricsWarmUpRequests = env.Int("METRICS_WARM_UP_REQUESTS", false, 0, "Number of requests after startup which are excluded from the request timing metrics")
+var metricsWarmUpDuration = env.Duration("METRICS_WARM_UP_DURATION", false, 0, "Duration after startup during which requests are excluded from the request timing metrics")
+var metricsRSSInterval = env.Duration("METRICS_RSS_INTERVAL", false, 10*time.Second, "Interval between reports of the resident set size (RSS) of the process to the process.memory.rss.bytes gauge, only supported on Linux, 0 disables")
+var metricsPathRules = env.String("METRICS_PATH_RULES", false, `^[0-9]+$=:id;^[0-9a-fA-F]{8}-[0-9a-fA-F-]{27}$=:uuid`, "Semicolon separated rules in the format regex=replacement which collapse matching path segments in the request metrics path label")
+var metricsPathMax = env.Int("METRICS_PATH_MAX", false, 100, "Maximum number of distinct paths used as metric labels, further paths are labelled :other")
 var logFormat = env.String("LOG_FORMAT", false, "text", "Log file format. [text|json]")
 var logLevel = env.String("LOG_LEVEL", false, "info", "Log level for output. [info|debug|trace|warn|error]")
+var logCallTree = env.String("LOG_CALL_TREE", false, "", "Log the responses of all upstream calls and their upstreams when an upstream call fails, default is disabled. [json|fields]")
 var logOutput = env.String("LOG_OUTPUT", false, "stdout", "Location to write log output, default is stdout, e.g. /var/log/web.log")
 
 // TLS Certs
 var tlsCertificate = env.String("TLS_CERT_LOCATION", false, "", "Location of PEM encoded x.509 certificate for securing server")
 var tlsKey = env.String("TLS_KEY_LOCATION", false, "", "Location of PEM encoded private key for securing server")
+var tlsReportResumption = env.Bool("TLS_REPORT_RESUMPTION", false, false, "When true the TLS version and whether the TLS session was resumed rather than a full handshake are added to the response of HTTP requests")
+var staticDir = env.String("STATIC_DIR", false, "", "Directory of static files served under STATIC_PATH, index.html is served for directories, default is disabled")
+var staticPath = env.String("STATIC_PATH", false, "/static/", "Path prefix the files in STATIC_DIR are served under, all other paths are handled by the service")
 
 var healthResponseCode = env.Int("HEALTH_CHECK_RESPONSE_CODE", false, 200, "Response code returned from the HTTP health check at /health")
 var readyResponseCode = env.Int("READY_CHECK_RESPONSE_CODE", false, 200, "Response code returned from the HTTP readyness check at /ready")
+var readOnlyMode = env.Bool("READ_ONLY_MODE", false, false, "Start in read-only mode, requests with the methods POST, PUT, PATCH and DELETE return READ_ONLY_CODE, the mode can be changed at runtime with /control/read-only")
+var readOnlyCode = env.Int("READ_ONLY_CODE", false, 503, "Response code returned for requests which modify data in read-only mode")
+var readOnlyMessage = env.String("READ_ONLY_MESSAGE", false, "read-only mode", "Error message returned for requests which modify data in read-only mode")
+var consistencyDelay = env.Duration("EVENTUAL_CONSISTENCY_DELAY", false, 0*time.Second, "Delay before the body of a POST request is visible to a GET request for the same path, until then the previous value or 404 is returned, default 0 is disabled")
+var consistencyStoreSize = env.Int("EVENTUAL_CONSISTENCY_STORE_SIZE", false, 1000, "Maximum number of paths held in the eventually consistent store, the path written least recently is removed when full")
+var resourceStore = env.Bool("RESOURCE_STORE", false, false, "When true the JSON body of a PUT request replaces the resource at the path, the body of a PATCH request is merged into it, and GET returns the stored resource")
+var resourceStoreSize = env.Int("RESOURCE_STORE_SIZE", false, 1000, "Maximum number of resources held in the resource store, the resource written least recently is removed when full")
+var startupDelay = env.Duration("STARTUP_DELAY", false, 0*time.Second, "Delay after the server starts listening during which requests are held and the readiness check returns 503, models a slow initializing service")
 var readyResponseDelay = env.Duration("READY_CHECK_RESPONSE_DELAY", false, 0*time.Second, "Delay before the readyness check returns the READY_CHECK_RESPONSE_CODE")
+var readyDependencyFile = env.String("READY_CHECK_DEPENDENCY_FILE", false, "", "Path to a file which must exist before the readiness check returns the READY_CHECK_RESPONSE_CODE, until then 503 is returned")
+var readyDependencyContent = env.String("READY_CHECK_DEPENDENCY_CONTENT", false, "", "Expected content of READY_CHECK_DEPENDENCY_FILE, when set the file must also contain this value")
+var readyFlapRate = env.Float64("READY_CHECK_FLAP_RATE", false, 0.0, "Decimal percentage of the time the readiness check returns 503 to simulate an unstable dependency, 0 disables flapping")
+var readyFlapDwell = env.Duration("READY_CHECK_FLAP_DWELL", false, 10*time.Second, "Minimum time the readiness check holds a flapped state before it can change")
+var recordMode = env.String("RECORD_MODE", false, "", "When set requests and responses are recorded to RECORD_FILE or replayed from it without calling upstreams [record, replay], default disabled")
+var recordFile = env.String("RECORD_FILE", false, "recordings.jsonl", "File where recorded requests and responses are written to or replayed from")
+var recordMatch = env.String("RECORD_MATCH", false, "method,path", "Comma separated request attributes used to match a request with a recording [method, path, query]")
+var selfTrafficInterval = env.Duration("SELF_TRAFFIC_INTERVAL", false, 0*time.Second, "Mean interval between background requests sent to the upstreams, default 0 disables background traffic")
+var selfTrafficJitter = env.Duration("SELF_TRAFFIC_JITTER", false, 0*time.Second, "Maximum random variation of the interval between background requests, gaps are chosen uniformly in SELF_TRAFFIC_INTERVAL ± SELF_TRAFFIC_JITTER")
+var selfTrafficTargets = env.String("SELF_TRAFFIC_TARGETS", false, "", "Comma separated subset of UPSTREAM_URIS which receive background requests, default all upstreams")
+var eventsCount = env.Int("EVENTS_COUNT", false, 10, "Number of Server-Sent Events streamed from /events before the stream is closed")
+var eventsInterval = env.Duration("EVENTS_INTERVAL", false, 1*time.Second, "Interval between the Server-Sent Events streamed from /events")
+var redirectMaxChain = env.Int("REDIRECT_MAX_CHAIN", false, 10, "Maximum length of the redirect chain served at /redirect/N, /redirect/ starts a chain of this length")
 
 var version = "dev"
 
@@ -115,13 +298,13 @@ func main() {
 
 	// do we need to setup tracing
 	if *zipkinEndpoint != "" {
-		tracing.NewOpenTracingClient(*zipkinEndpoint, *name, *listenAddress)
+		tracing.NewOpenTracingClient(*zipkinEndpoint, *name, *listenAddress, *tracingSampleRate)
 		sdf = tracing.GetZipkinSpanDetails
 	}
 
 	if *datadogTracingEndpointHost != "" {
 		hostname := fmt.Sprintf("%s:%s", *datadogTracingEndpointHost, *datadogTracingEndpointPort)
-		tracing.NewDataDogClient(hostname, *name)
+		tracing.NewDataDogClient(hostname, *name, *tracingSampleRate)
 		sdf = tracing.GetDataDogSpanDetails
 	}
 
@@ -133,6 +316,17 @@ func main() {
 		metrics = logging.NewStatsDMetrics(*name, *datadogMetricsEnvironment, hostname)
 	}
 
+	var otlpMetrics *logging.OTLPMetrics
+	if *otlpMetricsEndpoint != "" {
+		otlpMetrics = logging.NewOTLPMetrics(*name, *otlpMetricsEndpoint)
+		metrics = otlpMetrics
+	}
+
+	// exclude the warm-up requests from the request timings
+	if *metricsWarmUpRequests > 0 || *metricsWarmUpDuration > 0 {
+		metrics = logging.NewWarmUpMetrics(metrics, *metricsWarmUpRequests, *metricsWarmUpDuration)
+	}
+
 	lo := hclog.DefaultOptions
 	lo.Level = hclog.LevelFromString(*logLevel) // set the log level
 
@@ -157,6 +351,74 @@ func main() {
 
 	logger := logging.NewLogger(metrics, hclog.New(lo), sdf)
 
+	// metrics are sent to a single collector
+	if *datadogMetricsEndpointHost != "" && *otlpMetricsEndpoint != "" {
+		logger.Log().Error("METRICS_DATADOG_HOST and METRICS_OTLP_ENDPOINT can not be used together")
+		os.Exit(1)
+	}
+
+	if otlpMetrics != nil {
+		if *otlpMetricsInterval <= 0 {
+			logger.Log().Error("Invalid OTLP metrics interval, must be greater than 0", "interval", *otlpMetricsInterval)
+			os.Exit(1)
+		}
+
+		otlpMetrics.Start(*otlpMetricsInterval, logger.Log().Named("otlp_metrics"))
+		defer otlpMetrics.Stop()
+	}
+
+	// publish the resident memory of the process
+	if *metricsRSSInterval > 0 {
+		rr := load.NewRSSReporter(*metricsRSSInterval, logger.ProcessMemory, logger.Log().Named("rss"))
+		rr.Start()
+		defer rr.Stop()
+	}
+
+	// label request metrics with the method and normalized path
+	pn, err := logging.NewPathNormalizer(*metricsPathRules, *metricsPathMax)
+	if err != nil {
+		logger.Log().Error("Invalid metrics path rules", "error", err)
+		os.Exit(1)
+	}
+	logger.SetPathNormalizer(pn)
+
+	// log the upstream call tree when an upstream fails
+	if *logCallTree != "" {
+		if *logCallTree != logging.CallTreeJSON && *logCallTree != logging.CallTreeFields {
+			logger.Log().Error("Invalid call tree log format", "format", *logCallTree)
+			os.Exit(1)
+		}
+
+		logger.SetCallTreeFormat(*logCallTree)
+	}
+
+	// select the addresses of the service reported in the response
+	ipf := &handlers.IPFilter{Interfaces: tidyURIs(*ipInterfaces), ExcludeLinkLocal: *ipExcludeLinkLocal}
+	if *ipPreferCIDR != "" {
+		_, ipf.Prefer, err = net.ParseCIDR(*ipPreferCIDR)
+		if err != nil {
+			logger.Log().Error("Invalid preferred IP CIDR", "cidr", *ipPreferCIDR, "error", err)
+			os.Exit(1)
+		}
+	}
+	handlers.SetIPFilter(ipf)
+
+	if *paginationTotal > 0 && *paginationPageSize < 1 {
+		logger.Log().Error("Invalid pagination page size, must be greater than 0", "page_size", *paginationPageSize)
+		os.Exit(1)
+	}
+
+	// a window of 0 would start a new window for every request
+	if *quotaLimit > 0 && *quotaWindow <= 0 {
+		logger.Log().Error("Invalid quota window, must be greater than 0", "window", *quotaWindow)
+		os.Exit(1)
+	}
+
+	if *timingCorrelation < 0 || *timingCorrelation >= 1 {
+		logger.Log().Error("Invalid timing correlation, must be at least 0 and less than 1", "correlation", *timingCorrelation)
+		os.Exit(1)
+	}
+
 	requestDuration := timing.NewRequestDuration(
 		*timing50Percentile,
 		*timing90Percentile,
@@ -164,6 +426,32 @@ func main() {
 		*timingVariance,
 	)
 
+	requestDuration.SetCorrelation(*timingCorrelation)
+
+	tailLatency := timing.NewTailLatency(*timingTailRate, *timingTailDelay)
+
+	var degradation *timing.Degradation
+	if *degradedErrorThreshold > 0 {
+		degradation = timing.NewDegradation(*degradedErrorThreshold, *degradedWindow, *degradedDelayStep)
+	}
+
+	// draw the response codes from a distribution
+	var codes *errors.CodeDistribution
+	if *errorCodeDistribution != "" {
+		codes, err = errors.ParseCodeDistribution(*errorCodeDistribution)
+		if err != nil {
+			logger.Log().Error("Error parsing code distribution", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// the rate limit of a gRPC service can be enforced by an interceptor in
+	// place of the error injector
+	injectorRateLimitRPS := *rateLimitRPS
+	if *serviceType == "grpc" && *grpcRateLimitInterceptor {
+		injectorRateLimitRPS = 0
+	}
+
 	// create the error injector
 	errorInjector := errors.NewInjector(
 		logger.Log().Named("error_injector"),
@@ -171,9 +459,22 @@ func main() {
 		*errorCode,
 		*errorType,
 		*errorDelay,
-		*rateLimitRPS,
+		injectorRateLimitRPS,
 		*rateLimitCode,
+		*errorEveryN,
+		*errorEveryNCode,
+		*errorContentLengthOffset,
+		*rateLimitRetryAfterMin,
+		*rateLimitRetryAfterMax,
+		codes,
 	)
+	errorInjector.SetFailThenSucceed(*errorFailThenSucceed)
+
+	// fail requests with a large body
+	var sizeInjector *errors.SizeInjector
+	if *errorRequestSizeLimit > 0 {
+		sizeInjector = errors.NewSizeInjector(int64(*errorRequestSizeLimit), *errorRequestSizeRate, *errorRequestSizeCode)
+	}
 
 	// create the load generator
 	// get the total CPU amount
@@ -189,22 +490,147 @@ func main() {
 		*loadCPUPercentage = *loadCPUAllocated / (*loadCPUClockSpeed * *loadCPUCores) * *loadCPUPercentage
 	}
 
+	// load any recorded memory series to replay
+	var memoryReplay []int
+	if *processLoadMemoryReplayFile != "" {
+		var err error
+		memoryReplay, err = load.LoadMemoryReplay(*processLoadMemoryReplayFile)
+		if err != nil {
+			logger.Log().Error("Unable to load memory replay file", "file", *processLoadMemoryReplayFile, "error", err)
+			os.Exit(1)
+		}
+	}
+
 	// create a generator that will be used to create memory and CPU load per request
-	processLoadGenerator := load.NewNodeGenerator(*processLoadCPUCores, *processLoadCPUPercentage, *processLoadMemoryAllocated, *processLoadMemoryVariance, *processLoadMemoryVarianceFunction, *processLoadMemoryVariancePeriod, logger.Log().Named("process_load_generator"))
+	processLoadGenerator := load.NewNodeGenerator(*processLoadCPUCores, *processLoadCPUPercentage, *processLoadMemoryAllocated, *processLoadMemoryVariance, *processLoadMemoryVarianceFunction, *processLoadMemoryVariancePeriod, memoryReplay, *processLoadMemoryReplayLoop, *processLoadCPUStartSpread, *processLoadMemoryTouchStride, *processLoadMemoryTouchMode, logger.Log().Named("process_load_generator"))
+
+	// touch large allocations in parallel so the tick is not blocked
+	processLoadGenerator.SetMemoryTouchWorkers(*processLoadMemoryTouchWorkers)
+
+	// reduce the process memory when it nears the memory limit
+	if *processLoadMemoryHighWater > 0 {
+		limit := uint64(*processLoadMemoryLimit) * 1024 * 1024
+		if limit == 0 {
+			var err error
+			limit, err = load.CgroupMemoryLimit()
+			if err != nil {
+				logger.Log().Warn("Unable to detect cgroup memory limit", "error", err)
+			}
+		}
+
+		if limit == 0 {
+			logger.Log().Warn("No memory limit, process memory will not back off")
+		}
+
+		processLoadGenerator.SetMemoryBackoff(limit, *processLoadMemoryHighWater, *processLoadMemoryLowWater)
+	}
 
 	// create a generator that will be used to create memory and CPU load per request
 	generator := load.NewGenerator(*loadCPUCores, *loadCPUPercentage, *loadMemoryAllocated, *loadMemoryVariance, logger.Log().Named("load_generator"))
 
+	// keep the generated memory within the memory limit of the container
+	if *loadMemoryAllocated > 0 && *loadMemoryCgroupFraction > 0 {
+		limit, err := load.CgroupMemoryLimit()
+		if err != nil {
+			logger.Log().Warn("Unable to detect cgroup memory limit", "error", err)
+		} else {
+			generator.ClampMemory(limit, *loadMemoryCgroupFraction)
+		}
+	}
+
+	// keep the generated CPU load within the CPU quota of the container
+	if *loadCPUPercentage > 0 && *loadCPUCgroupClamp {
+		quota, err := load.CgroupCPUQuota()
+		if err != nil {
+			logger.Log().Warn("Unable to detect cgroup CPU quota", "error", err)
+		} else {
+			generator.ClampCPU(quota)
+		}
+	}
+	logger.LoadGenerator(*loadCPUCores, *loadCPUPercentage, *loadMemoryAllocated)
+
+	// allocate memory for each request which is visible in heap profiles
+	var requestAllocation *load.RequestAllocation
+	if *requestAllocationMB > 0 {
+		requestAllocation = load.NewRequestAllocation(*requestAllocationMB)
+	}
+
+	// dispatch upstreams in a random but reproducible order
+	var shuffle *worker.Shuffle
+	if *upstreamDispatchOrder == "random" {
+		seed := int64(*upstreamDispatchSeed)
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+
+		logger.Log().Info("Dispatching upstreams in random order", "seed", seed)
+		shuffle = worker.NewShuffle(seed)
+	}
+
+	// open file descriptors for each request to model descriptor exhaustion
+	var fileAllocation *load.FileAllocation
+	if *fileAllocationCount > 0 {
+		fileAllocation = load.NewFileAllocation(*fileAllocationCount, *fileAllocationMax)
+	}
+
+	// return a stale message for a fraction of requests
+	var splitBrain *handlers.SplitBrain
+	if *splitBrainRate > 0 {
+		splitBrain = handlers.NewSplitBrain(*splitBrainRate, *splitBrainMessage)
+	}
+
+	// return a new message to a percentage of request identities
+	var rollout *handlers.Rollout
+	if *rolloutMessage != "" {
+		rollout = handlers.NewRollout(*rolloutPercentage, *rolloutMessage, *rolloutIdentityHeader, *rolloutCohortHeader)
+	}
+
+	// allow requests to set their own load with the load headers
+	var requestLoad *load.RequestLoad
+	if *requestLoadHeaders {
+		requestLoad = load.NewRequestLoad(*requestLoadMaxCPU, *requestLoadMaxMemory)
+	}
+
+	// create a resolver for upstream host names when any DNS behaviour is configured
+	var resolver *client.Resolver
+	if *dnsServer != "" || *dnsCacheTTL > 0 || *dnsDelay > 0 || *dnsFailureRate > 0 {
+		resolver = client.NewResolver(*dnsServer, *dnsCacheTTL, *dnsDelay, *dnsFailureRate)
+	}
+
+	// parse the TLS server names sent to upstreams called by address
+	var serverNames map[string]string
+	if *upstreamServerNames != "" {
+		serverNames, err = parseUpstreamServerNames(*upstreamServerNames)
+		if err != nil {
+			logger.Log().Error("Invalid upstream TLS server names", "error", err)
+			os.Exit(1)
+		}
+	}
+
 	// create the httpClient
-	defaultClient := client.NewHTTP(*upstreamClientKeepAlives, *upstreamAppendRequest, *upstreamRequestTimeout, *upstreamAllowInsecure)
+	defaultClient := client.NewHTTP(*upstreamClientKeepAlives, *upstreamAppendRequest, *upstreamRequestTimeout, *upstreamConnectTimeout, *upstreamAllowInsecure, socketOptions(), int64(*upstreamMaxResponseBytes), resolver, serverNameHosts(serverNames))
+
+	// resolve any templated upstream URIs
+	upstreams, err := resolveURIs(tidyURIs(*upstreamURIs))
+	if err != nil {
+		logger.Log().Error("Invalid upstream URIs", "error", err)
+		os.Exit(1)
+	}
 
 	// build the map of gRPCClients
 	grpcClients := make(map[string]client.GRPC)
-	for _, u := range tidyURIs(*upstreamURIs) {
-		//strip the grpc:// from the uri
-		u2 := strings.TrimPrefix(u, "grpc://")
+	for _, u := range upstreams {
+		// https upstreams are only called with HTTP, http upstreams are only
+		// called with gRPC when the protocol is detected
+		if strings.HasPrefix(u, "https://") || (strings.HasPrefix(u, "http://") && !*upstreamProtocolDetect) {
+			continue
+		}
+
+		//strip the grpc:// from the uri, http:// is also stripped so that the
+		// upstream can be called with gRPC when the protocol is detected
+		u2 := strings.TrimPrefix(strings.TrimPrefix(u, "grpc://"), "http://")
 
-		c, err := client.NewGRPC(u2, *upstreamRequestTimeout)
+		c, err := client.NewGRPC(u2, *upstreamRequestTimeout, *upstreamConnectTimeout, *grpcMaxMessageSize, socketOptions(), resolver, *grpcClientCompression, serverNames[u])
 		if err != nil {
 			logger.Log().Error("Error creating gRPC client", "error", err)
 			os.Exit(1)
@@ -212,18 +638,304 @@ func main() {
 
 		grpcClients[u] = c
 	}
+	// parse the transforms applied to upstream responses
+	transforms, err := parseTransforms(*upstreamTransforms)
+	if err != nil {
+		logger.Log().Error("Invalid upstream transforms", "error", err)
+		os.Exit(1)
+	}
+
+	// parse the weights used to roll up the upstream critical path
+	var weights map[string]float64
+	if *upstreamWeights != "" {
+		weights, err = parseUpstreamWeights(*upstreamWeights)
+		if err != nil {
+			logger.Log().Error("Invalid upstream weights", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// create the traffic mirror
+	var mirror *handlers.Mirror
+	if *mirrorURI != "" {
+		mirror = handlers.NewMirror(*mirrorURI, *mirrorRate, defaultClient, logger)
+	}
+
+	// create the circuit breakers for the upstreams
+	var circuits *handlers.Circuits
+	if *circuitBreakerThreshold > 0 {
+		circuits = handlers.NewCircuits(upstreams, *circuitBreakerThreshold, *circuitBreakerOpenDuration)
+	}
+
+	// create the response body when it is read from a file or rendered as a template
+	var body *handlers.Body
+	if *messageFile != "" || *messageTemplate {
+		body = handlers.NewBody(*message, *messageFile, *messageTemplate)
+	}
+
+	// load the scenario which steps through a timeline of errors and delays
+	var se *scenario.Engine
+	if *scenarioFile != "" {
+		s, err := scenario.Load(*scenarioFile)
+		if err != nil {
+			logger.Log().Error("Unable to load scenario file", "file", *scenarioFile, "error", err)
+			os.Exit(1)
+		}
+
+		se = scenario.NewEngine(s, errorInjector, logger.Log().Named("scenario"))
+	}
+
+	// load the behaviour of each tenant
+	var tenants *handlers.Tenants
+	if *tenantFile != "" {
+		tenants, err = handlers.LoadTenants(*tenantFile, *tenantHeader)
+		if err != nil {
+			logger.Log().Error("Unable to load tenant file", "file", *tenantFile, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// create the pipeline which passes data between sequential upstreams
+	var pipeline *handlers.Pipeline
+	if *upstreamPipelineMode != "" {
+		pipeline, err = handlers.NewPipeline(*upstreamPipelineField, *upstreamPipelineMode, *upstreamPipelineHeader)
+		if err != nil {
+			logger.Log().Error("Invalid upstream pipeline", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// detect the protocol served by upstreams rather than relying on the scheme
+	var protocols *handlers.ProtocolDetector
+	if *upstreamProtocolDetect {
+		protocols = handlers.NewProtocolDetector()
+	}
+
 	finishProcessLoadGenerator := processLoadGenerator.Generate()
 
+	// run periodic bursts of load independent of the requests
+	if *noisyNeighborInterval > 0 {
+		// the next burst is scheduled once a burst ends, a burst as long as
+		// the interval would skip every other burst
+		if *noisyNeighborDuration >= *noisyNeighborInterval {
+			logger.Log().Error("Noisy neighbor duration must be shorter than the interval", "duration", *noisyNeighborDuration, "interval", *noisyNeighborInterval)
+			os.Exit(1)
+		}
+
+		schedule := load.NewSchedule(*noisyNeighborInterval, *noisyNeighborDuration, func() *load.NodeGenerator {
+			return load.NewNodeGenerator(*noisyNeighborCPUCores, *noisyNeighborCPUPercentage, *noisyNeighborMemory, 0, "", 1, nil, false, 0, 0, "", logger.Log().Named("noisy_neighbor"))
+		}, logger.Log().Named("noisy_neighbor"))
+
+		schedule.Start()
+		defer schedule.Stop()
+	}
+
+	// churn objects of a single size class to fragment the heap
+	if *processLoadSizeClassSize > 0 {
+		sizeClass := load.NewSizeClassAllocation(*processLoadSizeClassSize, *processLoadSizeClassCount, logger.Log().Named("size_class_allocation"))
+		sizeClass.Start()
+		defer sizeClass.Stop()
+	}
+
+	// reshape responses during and after a schema migration
+	var migration *response.Migration
+	if *schemaMigrationDuring != "" || *schemaMigrationAfter != "" {
+		during, err := response.ParseTransforms(*schemaMigrationDuring)
+		if err != nil {
+			logger.Log().Error("Invalid schema migration transforms", "error", err)
+			os.Exit(1)
+		}
+
+		after, err := response.ParseTransforms(*schemaMigrationAfter)
+		if err != nil {
+			logger.Log().Error("Invalid schema migration transforms", "error", err)
+			os.Exit(1)
+		}
+
+		migration = response.NewMigration(time.Now().Add(*schemaMigrationDelay), *schemaMigrationWindow, during, after)
+	}
+
+	// measure the CPU usage of the service to shed load when overloaded
+	var cpuUsage *load.CPUUsage
+	if *loadShedCPUThreshold > 0 {
+		cores := float64(runtime.NumCPU())
+		if quota, err := load.CgroupCPUQuota(); err == nil && quota > 0 {
+			cores = quota
+		}
+
+		cpuUsage = load.NewCPUUsage(cores, *loadShedInterval, logger.Log().Named("cpu_usage"))
+		cpuUsage.Start()
+		defer cpuUsage.Stop()
+	}
+
+	// simulate database queries for each request
+	var queries *handlers.Queries
+	if *dbQueries > 0 || *dbQueriesVariance > 0 {
+		queries = handlers.NewQueries(
+			*dbQueries,
+			*dbQueriesVariance,
+			timing.NewRequestDuration(*dbQuery50Percentile, *dbQuery90Percentile, *dbQuery99Percentile, 0),
+			*dbQueryErrorRate,
+		)
+	}
+
+	// retry failed upstream calls
+	var retries *handlers.Retries
+	if *upstreamRetries > 0 {
+		retries = handlers.NewRetries(*upstreamRetries, *upstreamRetryBackoff)
+	}
+
+	// hedge slow upstream calls with a second request
+	var hedging *handlers.Hedging
+	if *upstreamHedgeDelay > 0 {
+		hedging = handlers.NewHedging(*upstreamHedgeDelay)
+	}
+
+	// route requests only to healthy upstreams
+	var healthChecks *handlers.HealthChecks
+	if *upstreamHealthCheckInterval > 0 {
+		healthChecks = handlers.NewHealthChecks(upstreams, *upstreamHealthCheckInterval, *upstreamHealthCheckThreshold, healthCheckCall(defaultClient, grpcClients, *upstreamHealthCheckPath), logger.Log().Named("health_checks"))
+		stopHealthChecks := healthChecks.Start()
+		defer stopHealthChecks()
+	}
+
+	// send background traffic to the upstreams
+	if *selfTrafficInterval > 0 {
+		targets := upstreams
+		if *selfTrafficTargets != "" {
+			targets = tidyURIs(*selfTrafficTargets)
+		}
+
+		driver := traffic.NewDriver(*selfTrafficInterval, *selfTrafficJitter, targets, selfTrafficCall(defaultClient, grpcClients), logger.Log().Named("self_traffic"))
+		stopDriver := driver.Start()
+		defer stopDriver()
+	}
+
 	logger.ServiceStarted(*name, *upstreamURIs, *upstreamWorkers, *listenAddress, *serviceType)
 
+	if sdf != nil {
+		logger.Log().Info("Tracing enabled", "sample_rate", *tracingSampleRate)
+	}
+
 	var httpServer *http.Server
 	var grpcServer *grpc.Server
 
 	switch *serviceType {
 	case "http":
-		httpServer = startupHTTP(logger, requestDuration, errorInjector, generator, grpcClients, defaultClient)
+		httpServer = startupHTTP(logger, handlers.RequestConfig{
+			Name:              *name,
+			Message:           *message,
+			Duration:          requestDuration,
+			UpstreamURIs:      upstreams,
+			WorkerCount:       *upstreamWorkers,
+			DefaultClient:     defaultClient,
+			GRPCClients:       grpcClients,
+			ErrorInjector:     errorInjector,
+			LoadGenerator:     generator,
+			Log:               logger,
+			OmitFields:        tidyURIs(*responseOmitFields),
+			WorkUnits:         *loadWorkUnits,
+			Mirror:            mirror,
+			TailLatency:       tailLatency,
+			VaryHeaders:       tidyURIs(*responseVaryHeaders),
+			Degradation:       degradation,
+			NamePool:          tidyURIs(*namePool),
+			Transforms:        transforms,
+			WorkerQueueSize:   *upstreamWorkerQueueSize,
+			ReportWorkerQueue: *upstreamWorkerQueueReport,
+			Circuits:          circuits,
+			Body:              body,
+			Scenario:          se,
+			Pipeline:          pipeline,
+			Protocols:         protocols,
+			Variants:          *responseVariants,
+			ReportClientIP:    *proxyProtocol,
+			UpstreamWeights:   weights,
+			HeadUpstreams:     *headUpstreams,
+			Budget:            *upstreamBudget,
+			RawBodyLimit:      *upstreamRawBodyLimit,
+			SchemaVersion:     *responseSchemaVersion,
+			RequestLoad:       requestLoad,
+			SplitBrain:        splitBrain,
+			UpstreamRepeat:    *upstreamRepeat,
+			UpstreamStats:     *upstreamStats,
+			ClockSkew:         *clockSkew,
+			RequestAllocation: requestAllocation,
+			EchoTrailers:      *echoHTTPTrailers,
+			RedactTrailers:    tidyURIs(*echoRedact),
+			PartialSuccess:    *upstreamFailureMode == "partial",
+			FileAllocation:    fileAllocation,
+			Shuffle:           shuffle,
+			SizeInjector:      sizeInjector,
+			HealthChecks:      healthChecks,
+			PageSize:          *paginationPageSize,
+			PageTotal:         *paginationTotal,
+			ReportTLS:         *tlsReportResumption,
+			ReportDeadline:    *upstreamReportDeadline,
+			Queries:           queries,
+			Tenants:           tenants,
+			Rollout:           rollout,
+			Retries:           retries,
+			WriteChunkSize:    *responseWriteChunkSize,
+			Migration:         migration,
+			Hedging:           hedging,
+		}, cpuUsage)
 	case "grpc":
-		grpcServer = startupGRPC(logger, requestDuration, errorInjector, generator, grpcClients, defaultClient)
+		grpcServer = startupGRPC(logger, handlers.FakeServerConfig{
+			Name:              *name,
+			Message:           *message,
+			Duration:          requestDuration,
+			UpstreamURIs:      upstreams,
+			WorkerCount:       *upstreamWorkers,
+			DefaultClient:     defaultClient,
+			GRPCClients:       grpcClients,
+			ErrorInjector:     errorInjector,
+			LoadGenerator:     generator,
+			Log:               logger,
+			OmitFields:        tidyURIs(*responseOmitFields),
+			WorkUnits:         *loadWorkUnits,
+			Mirror:            mirror,
+			TailLatency:       tailLatency,
+			EchoMetadata:      *echoGRPCMetadata,
+			RedactMetadata:    tidyURIs(*echoRedact),
+			Degradation:       degradation,
+			NamePool:          tidyURIs(*namePool),
+			Transforms:        transforms,
+			WorkerQueueSize:   *upstreamWorkerQueueSize,
+			ReportWorkerQueue: *upstreamWorkerQueueReport,
+			Circuits:          circuits,
+			Body:              body,
+			Scenario:          se,
+			Pipeline:          pipeline,
+			Protocols:         protocols,
+			UpstreamWeights:   weights,
+			Budget:            *upstreamBudget,
+			RawBodyLimit:      *upstreamRawBodyLimit,
+			SchemaVersion:     *responseSchemaVersion,
+			RequestLoad:       requestLoad,
+			SplitBrain:        splitBrain,
+			UpstreamRepeat:    *upstreamRepeat,
+			UpstreamStats:     *upstreamStats,
+			ClockSkew:         *clockSkew,
+			RequestAllocation: requestAllocation,
+			ReportCompression: *grpcReportCompression,
+			ForceCompression:  *grpcServerCompression,
+			PartialSuccess:    *upstreamFailureMode == "partial",
+			FileAllocation:    fileAllocation,
+			Shuffle:           shuffle,
+			HealthChecks:      healthChecks,
+			ReportDeadline:    *upstreamReportDeadline,
+			Queries:           queries,
+			Retries:           retries,
+			Migration:         migration,
+			Hedging:           hedging,
+		})
+	}
+
+	// allow the behaviour of the service to be changed at runtime
+	var controlServer *grpc.Server
+	if *controlAddress != "" {
+		controlServer = startupControl(logger, requestDuration, errorInjector, generator)
 	}
 
 	// trap sigterm or interupt and gracefully shutdown the server
@@ -251,33 +963,97 @@ func main() {
 		grpcServer.GracefulStop()
 		timer.Stop()
 	}
+
+	if controlServer != nil {
+		controlServer.Stop()
+	}
+
 	finishProcessLoadGenerator()
 }
 
-func startupHTTP(
-	logger *logging.Logger,
-	rd *timing.RequestDuration,
-	errorInjector *errors.Injector,
-	generator *load.Generator,
-	grpcClients map[string]client.GRPC,
-	defaultClient client.HTTP,
-) *http.Server {
-
-	rq := handlers.NewRequest(
-		*name,
-		*message,
-		rd,
-		tidyURIs(*upstreamURIs),
-		*upstreamWorkers,
-		defaultClient,
-		grpcClients,
-		errorInjector,
-		generator,
-		logger,
-	)
+func startupHTTP(logger *logging.Logger, rc handlers.RequestConfig, cpuUsage *load.CPUUsage) *http.Server {
+
+	rq := handlers.NewRequest(rc)
+
+	// record responses or replay them for offline demos
+	handle := rq.Handle
+	if *recordMode != "" {
+		rc, err := handlers.NewRecorder(logger, *recordMode, *recordFile, tidyURIs(*recordMatch), rq.Handle)
+		if err != nil {
+			logger.Log().Error("Unable to create recorder", "file", *recordFile, "error", err)
+			os.Exit(1)
+		}
+
+		handle = rc.Handle
+	}
+
+	// hold requests until the service has started
+	var startup *handlers.Startup
+	if *startupDelay > 0 {
+		startup = handlers.NewStartup(logger, *startupDelay, handle)
+		handle = startup.Handle
+	}
+
+	// reject requests which modify data while the service is read-only
+	ro := handlers.NewReadOnly(logger, *name, *readOnlyMode, *readOnlyCode, *readOnlyMessage, handle)
+	handle = ro.Handle
+
+	// store written values and only return them once they have propagated
+	if *consistencyDelay > 0 {
+		es := handlers.NewEventualStore(logger, *name, *consistencyDelay, *consistencyStoreSize, handle)
+		handle = es.Handle
+	}
+
+	// replace and merge resources with PUT and PATCH requests
+	if *resourceStore {
+		rs := handlers.NewResourceStore(logger, *name, *resourceStoreSize, handle)
+		handle = rs.Handle
+	}
+
+	// return the cached response for a repeated idempotency key
+	if *idempotencyTTL > 0 {
+		id := handlers.NewIdempotency(logger, *name, *idempotencyTTL, *idempotencyStoreSize, handle)
+		handle = id.Handle
+	}
+
+	// reject requests once the quota of the window has been used
+	if *quotaLimit > 0 {
+		q := handlers.NewQuota(logger, *name, *quotaLimit, *quotaWindow, handle)
+		handle = q.Handle
+	}
+
+	// reject bearer tokens once their lifetime has passed
+	if *tokenLifetime > 0 {
+		te := handlers.NewTokenExpiry(logger, *name, *tokenLifetime, *tokenStoreSize, handle)
+		handle = te.Handle
+	}
+
+	// reject a fraction of requests while the CPU usage is over the threshold
+	if cpuUsage != nil {
+		ls := handlers.NewLoadShedder(logger, *name, *loadShedCPUThreshold, cpuUsage.Usage, handle)
+		handle = ls.Handle
+	}
+
+	// wrap responses in an envelope like an API gateway
+	if *responseEnvelope {
+		// the envelope buffers the response and sets the Content-Length of
+		// the wrapped body
+		if *errorType == "content_length" {
+			logger.Log().Warn("ERROR_TYPE content_length can not be combined with RESPONSE_ENVELOPE, responses will have a correct Content-Length")
+		}
+
+		ev, err := handlers.NewEnvelope(logger, *responseEnvelopeTemplate, handle)
+		if err != nil {
+			logger.Log().Error("Unable to create response envelope", "error", err)
+			os.Exit(1)
+		}
+
+		handle = ev.Handle
+	}
 
 	hh := handlers.NewHealth(logger, *healthResponseCode)
-	rh := handlers.NewReady(logger, *readyResponseCode, *readyResponseDelay)
+	rh := handlers.NewReady(logger, *readyResponseCode, *readyResponseDelay, *readyDependencyFile, *readyDependencyContent, startup, *readyFlapRate, *readyFlapDwell)
+	cc := handlers.NewConnections(logger)
 
 	mux := http.NewServeMux()
 
@@ -291,18 +1067,50 @@ func startupHTTP(
 	// Add the User interface handler
 	mux.Handle("/ui/", http.StripPrefix("/ui", http.FileServer(box)))
 
+	// serve a directory of static files alongside the dynamic responses
+	if *staticDir != "" {
+		sh := handlers.NewStatic(logger, *staticPath, *staticDir)
+		logger.Log().Info("Adding handler for static files", "path", sh.Prefix(), "dir", *staticDir)
+		mux.HandleFunc(sh.Prefix(), sh.Handle)
+	}
+
 	// Add the generic health and ready handlers
 	mux.HandleFunc("/health", hh.Handle)
 	mux.HandleFunc("/ready", rh.Handle)
 
-	// uncomment to enable pprof
-	//mux.HandleFunc("/debug/pprof/", pprof.Index)
-	//mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	//mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	//mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	//mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	// Add the stats handlers
+	mux.HandleFunc("/stats/connections", cc.Handle)
+
+	th := handlers.NewTracing(*zipkinEndpoint != "" || *datadogTracingEndpointHost != "", *tracingSampleRate)
+	mux.HandleFunc("/stats/tracing", th.Handle)
+
+	if rc.Mirror != nil {
+		mux.HandleFunc("/stats/mirror", rc.Mirror.Handle)
+	}
+
+	if rc.Circuits != nil {
+		mux.HandleFunc("/stats/circuits", rc.Circuits.Handle)
+	}
+
+	if *pprofEnabled {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+
+	// Add the control handlers
+	mux.HandleFunc(handlers.ReadOnlyPath, ro.ControlHandle)
+
+	rdh := handlers.NewRedirect(logger, *redirectMaxChain, rq.Handle)
+	mux.HandleFunc(handlers.RedirectPath, rdh.Handle)
 
-	mux.HandleFunc("/", rq.Handle)
+	// stream Server-Sent Events for real-time clients
+	ev := handlers.NewEvents(logger, *name, *message, *eventsCount, *eventsInterval)
+	mux.HandleFunc(handlers.EventsPath, ev.Handle)
+
+	mux.HandleFunc("/", handle)
 
 	// CORS
 	corsOptions := make([]cors.CORSOption, 0)
@@ -320,17 +1128,62 @@ func startupHTTP(
 
 	logger.Log().Info("Settings CORS options", "allow_creds", *allowCredentials, "allow_headers", *allowedHeaders, "allow_origins", *allowedOrigins)
 	ch := cors.CORS(corsOptions...)
+	handler := ch(mux)
+
+	// advertise the configured behaviour of the service to OPTIONS requests,
+	// CORS preflight requests are still handled by the CORS handler
+	if *optionsCapabilities {
+		versions := []int{}
+		for v := response.SchemaV1; v <= response.SchemaLatest; v++ {
+			versions = append(versions, v)
+		}
+
+		oh := handlers.NewOptions(logger, handlers.Capabilities{
+			Name:      *name,
+			Type:      "http",
+			Upstreams: rc.UpstreamURIs,
+			Errors: handlers.CapabilityErrors{
+				Rate: *errorRate,
+				Code: *errorCode,
+				Type: *errorType,
+			},
+			Load: handlers.CapabilityLoad{
+				CPUCores:       *loadCPUCores,
+				CPUPercentage:  *loadCPUPercentage,
+				MemoryBytes:    *loadMemoryAllocated,
+				WorkUnits:      *loadWorkUnits,
+				RequestHeaders: *requestLoadHeaders,
+			},
+			ContentTypes:           []string{"application/json"},
+			Methods:                []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
+			ResponseSchemaVersions: versions,
+		}, handler.ServeHTTP)
+
+		handler = http.HandlerFunc(oh.Handle)
+	}
 
 	var err error
-	server := &http.Server{Addr: *listenAddress, Handler: ch(mux)}
+	server := &http.Server{Addr: *listenAddress, Handler: handler, ConnState: cc.ConnState}
 	server.SetKeepAlivesEnabled(*upstreamClientKeepAlives)
 
+	lis, err := socketOptions().Listen(*listenAddress, logger.Log())
+	if err != nil {
+		logger.Log().Error("Error starting service", "address", *listenAddress, "error", err)
+		os.Exit(1)
+	}
+
+	// recover the original client address from load balancers which send
+	// the PROXY protocol
+	if *proxyProtocol {
+		lis = client.NewProxyProtocolListener(lis)
+	}
+
 	go func() {
 		if *tlsCertificate != "" && *tlsKey != "" {
 			logger.Log().Info("Enabling TLS")
-			err = server.ListenAndServeTLS(*tlsCertificate, *tlsKey)
+			err = server.ServeTLS(lis, *tlsCertificate, *tlsKey)
 		} else {
-			err = server.ListenAndServe()
+			err = server.Serve(lis)
 		}
 
 		if err != nil && err != http.ErrServerClosed {
@@ -342,22 +1195,28 @@ func startupHTTP(
 	return server
 }
 
-func startupGRPC(
-	logger *logging.Logger,
-	rd *timing.RequestDuration,
-	errorInjector *errors.Injector,
-	generator *load.Generator,
-	grpcClients map[string]client.GRPC,
-	defaultClient client.HTTP,
-) *grpc.Server {
+func startupGRPC(logger *logging.Logger, fc handlers.FakeServerConfig) *grpc.Server {
 
-	lis, err := net.Listen("tcp", *listenAddress)
+	lis, err := socketOptions().Listen(*listenAddress, logger.Log())
 	if err != nil {
 		logger.Log().Error("failed to create lister", "address", *listenAddress, "error", err)
 		os.Exit(1)
 	}
 
-	serverOptions := []grpc.ServerOption{}
+	serverOptions := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(*grpcMaxMessageSize),
+	}
+
+	// compress all responses regardless of the compression used by the client
+	if *grpcServerCompression {
+		serverOptions = append(serverOptions, grpc.RPCCompressor(grpc.NewGZIPCompressor()))
+	}
+
+	// reject calls over the rate limit before they reach the service
+	if *grpcRateLimitInterceptor && *rateLimitRPS > 0 {
+		rl := handlers.NewRateLimitInterceptor(*rateLimitRPS, logger.Log().Named("rate_limit"))
+		serverOptions = append(serverOptions, grpc.UnaryInterceptor(rl.Unary))
+	}
 
 	// disable keep alives
 	if !*upstreamClientKeepAlives {
@@ -378,25 +1237,28 @@ func startupGRPC(
 	// for this gRPC service
 	reflection.Register(grpcServer)
 
-	fakeServer := handlers.NewFakeServer(
-		*name,
-		*message,
-		rd,
-		tidyURIs(*upstreamURIs),
-		*upstreamWorkers,
-		defaultClient,
-		grpcClients,
-		errorInjector,
-		generator,
-		logger,
-	)
+	fakeServer := handlers.NewFakeServer(fc)
 
 	api.RegisterFakeServiceServer(grpcServer, fakeServer)
+
+	// stream messages to clients at the rate they read them
+	streamServer := handlers.NewStreamServer(*grpcStreamMessages, *grpcStreamMessageSize, logger.Log().Named("stream"))
+	api.RegisterStreamServiceServer(grpcServer, streamServer)
 	go grpcServer.Serve(lis)
 
 	return grpcServer
 }
 
+// socketOptions returns the TCP options applied to inbound and upstream
+// connections
+func socketOptions() client.SocketOptions {
+	return client.SocketOptions{
+		NoDelay:       *socketNoDelay,
+		SendBuffer:    *socketSendBuffer,
+		ReceiveBuffer: *socketReceiveBuffer,
+	}
+}
+
 // tidyURIs splits the upstream URIs passed by environment variable and returns
 // a sanitized slice
 func tidyURIs(uris string) []string {
@@ -413,4 +1275,221 @@ func tidyURIs(uris string) []string {
 	return resp
 }
 
+// resolveURIs renders any template placeholders in the upstream URIs, e.g.
+// http://{{env "BACKEND_HOST"}}/api, and validates the resulting URIs
+func resolveURIs(uris []string) ([]string, error) {
+	funcs := template.FuncMap{
+		"env": func(k string) (string, error) {
+			v, ok := os.LookupEnv(k)
+			if !ok {
+				return "", fmt.Errorf("environment variable %s is not set", k)
+			}
+
+			return v, nil
+		},
+	}
+
+	resp := []string{}
+	for _, u := range uris {
+		tmpl, err := template.New("uri").Funcs(funcs).Option("missingkey=error").Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse upstream URI %s: %s", u, err)
+		}
+
+		buf := new(bytes.Buffer)
+		err = tmpl.Execute(buf, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve upstream URI %s: %s", u, err)
+		}
+
+		r := buf.String()
+		pu, err := url.Parse(r)
+		if err != nil || pu.Scheme == "" || pu.Host == "" {
+			return nil, fmt.Errorf("upstream URI %s resolved to an invalid URI: %s", u, r)
+		}
+
+		resp = append(resp, r)
+	}
+
+	return resp, nil
+}
+
+// parseTransforms parses the upstream response transforms, each entry maps an
+// upstream URI to the transforms applied to its response
+func parseTransforms(s string) (map[string][]response.Transform, error) {
+	resp := map[string][]response.Transform{}
+
+	for _, e := range strings.Split(s, ";") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+
+		// transforms do not contain = so split on the last to allow query strings
+		// in the URI
+		i := strings.LastIndex(e, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid upstream transform %s, expected uri=transform", e)
+		}
+
+		uris, err := resolveURIs([]string{e[:i]})
+		if err != nil {
+			return nil, err
+		}
+
+		ts, err := response.ParseTransforms(e[i+1:])
+		if err != nil {
+			return nil, err
+		}
+
+		resp[uris[0]] = ts
+	}
+
+	return resp, nil
+}
+
+// selfTrafficCall returns a function which calls an upstream for the
+// background traffic driver, the response is discarded
+func selfTrafficCall(defaultClient client.HTTP, grpcClients map[string]client.GRPC) traffic.CallFunc {
+	return func(uri string) error {
+		if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+			req, err := http.NewRequest(http.MethodGet, uri, nil)
+			if err != nil {
+				return err
+			}
+
+			_, _, _, _, err = defaultClient.Do(req, nil)
+			return err
+		}
+
+		c, ok := grpcClients[uri]
+		if !ok {
+			return fmt.Errorf("no gRPC client for %s, background traffic targets must be in UPSTREAM_URIS", uri)
+		}
+
+		_, _, err := c.Handle(context.Background(), &api.Nil{})
+		return err
+	}
+}
+
+// healthCheckCall returns a function which checks the health of an upstream,
+// HTTP upstreams are healthy when path returns 200 and gRPC upstreams when the
+// service returns without an error
+func healthCheckCall(defaultClient client.HTTP, grpcClients map[string]client.GRPC, path string) handlers.HealthCheckFunc {
+	return func(uri string) error {
+		if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
+			req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(uri, "/")+path, nil)
+			if err != nil {
+				return err
+			}
+
+			_, _, _, _, err = defaultClient.Do(req, nil)
+			return err
+		}
+
+		c, ok := grpcClients[uri]
+		if !ok {
+			return fmt.Errorf("no gRPC client for %s", uri)
+		}
+
+		_, _, err := c.Handle(context.Background(), &api.Nil{})
+		return err
+	}
+}
+
+// parseUpstreamWeights parses the weights of upstreams in the format
+// uri=weight;uri=weight
+func parseUpstreamWeights(s string) (map[string]float64, error) {
+	resp := map[string]float64{}
+
+	for _, e := range strings.Split(s, ";") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+
+		i := strings.LastIndex(e, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid upstream weight %s, expected uri=weight", e)
+		}
+
+		uris, err := resolveURIs([]string{e[:i]})
+		if err != nil {
+			return nil, err
+		}
+
+		w, err := strconv.ParseFloat(strings.TrimSpace(e[i+1:]), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid upstream weight %s, weight must be a positive number", e)
+		}
+
+		resp[uris[0]] = w
+	}
+
+	return resp, nil
+}
+
+// parseUpstreamServerNames parses the TLS server names of upstreams in the
+// format uri=name;uri=name
+func parseUpstreamServerNames(s string) (map[string]string, error) {
+	resp := map[string]string{}
+
+	for _, e := range strings.Split(s, ";") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+
+		i := strings.LastIndex(e, "=")
+		if i < 1 || strings.TrimSpace(e[i+1:]) == "" {
+			return nil, fmt.Errorf("invalid upstream TLS server name %s, expected uri=name", e)
+		}
+
+		uris, err := resolveURIs([]string{e[:i]})
+		if err != nil {
+			return nil, err
+		}
+
+		resp[uris[0]] = strings.TrimSpace(e[i+1:])
+	}
+
+	return resp, nil
+}
+
+// serverNameHosts keys the TLS server names of upstreams by the host of the
+// upstream URI which the HTTP client matches requests on
+func serverNameHosts(names map[string]string) map[string]string {
+	hosts := map[string]string{}
+	for uri, name := range names {
+		u, err := url.Parse(uri)
+		if err != nil {
+			continue
+		}
+
+		hosts[u.Host] = name
+	}
+
+	return hosts
+}
+
 // return the ip addresses for this service
+
+// startupControl starts the gRPC control service on a separate listener so
+// that it is not affected by the errors, latency, or rate limits of the service
+func startupControl(logger *logging.Logger, rd *timing.RequestDuration, errorInjector *errors.Injector, generator *load.Generator) *grpc.Server {
+	lis, err := net.Listen("tcp", *controlAddress)
+	if err != nil {
+		logger.Log().Error("failed to create control listener", "address", *controlAddress, "error", err)
+		os.Exit(1)
+	}
+
+	grpcServer := grpc.NewServer()
+
+	controlServer := handlers.NewControlServer(logger, *controlToken, errorInjector, rd, generator)
+	api.RegisterControlServiceServer(grpcServer, controlServer)
+	go grpcServer.Serve(lis)
+
+	logger.Log().Info("Started control service", "address", *controlAddress)
+
+	return grpcServer
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,3 +16,79 @@ func TestSanitizesURIParameters(t *testing.T) {
 	assert.Equal(t, "http://abc.com", out[0])
 	assert.Equal(t, "https://123.com", out[1])
 }
+
+func TestResolvesLiteralURIs(t *testing.T) {
+	out, err := resolveURIs([]string{"http://abc.com", "grpc://123.com:9090"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://abc.com", "grpc://123.com:9090"}, out)
+}
+
+func TestResolvesEnvironmentPlaceholdersInURIs(t *testing.T) {
+	os.Setenv("FAKE_BACKEND_HOST", "backend:9090")
+	defer os.Unsetenv("FAKE_BACKEND_HOST")
+
+	out, err := resolveURIs([]string{`http://{{env "FAKE_BACKEND_HOST"}}/api`})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://backend:9090/api"}, out)
+}
+
+func TestResolveURIsReturnsErrorForMissingVariable(t *testing.T) {
+	os.Unsetenv("FAKE_MISSING_HOST")
+
+	_, err := resolveURIs([]string{`http://{{env "FAKE_MISSING_HOST"}}/api`})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "FAKE_MISSING_HOST is not set")
+}
+
+func TestResolveURIsReturnsErrorForInvalidURI(t *testing.T) {
+	os.Setenv("FAKE_BACKEND_HOST", "")
+	defer os.Unsetenv("FAKE_BACKEND_HOST")
+
+	_, err := resolveURIs([]string{`http://{{env "FAKE_BACKEND_HOST"}}`})
+
+	assert.Error(t, err)
+}
+
+func TestParsesTransformsForUpstreams(t *testing.T) {
+	out, err := parseTransforms("http://abc.com?a=b=strip:headers|rename:body:payload; grpc://123.com:9090=wrap:data")
+
+	assert.NoError(t, err)
+	assert.Len(t, out["http://abc.com?a=b"], 2)
+	assert.Len(t, out["grpc://123.com:9090"], 1)
+}
+
+func TestParseTransformsReturnsErrorForMissingURI(t *testing.T) {
+	_, err := parseTransforms("strip:headers")
+
+	assert.Error(t, err)
+}
+
+func TestParsesUpstreamWeights(t *testing.T) {
+	out, err := parseUpstreamWeights("http://abc.com=0.5; grpc://123.com:9090=0")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]float64{"http://abc.com": 0.5, "grpc://123.com:9090": 0}, out)
+}
+
+func TestParsesUpstreamServerNames(t *testing.T) {
+	out, err := parseUpstreamServerNames("https://10.0.0.5:8443=payments.internal; grpc://10.0.0.6:9090=orders.internal")
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"https://10.0.0.5:8443": "payments.internal", "grpc://10.0.0.6:9090": "orders.internal"}, out)
+	assert.Equal(t, map[string]string{"10.0.0.5:8443": "payments.internal", "10.0.0.6:9090": "orders.internal"}, serverNameHosts(out))
+}
+
+func TestParseUpstreamServerNamesReturnsErrorForMissingName(t *testing.T) {
+	_, err := parseUpstreamServerNames("https://10.0.0.5:8443=")
+
+	assert.Error(t, err)
+}
+
+func TestParseUpstreamWeightsReturnsErrorForInvalidWeight(t *testing.T) {
+	_, err := parseUpstreamWeights("http://abc.com=fast")
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,69 @@
+package response
+
+import (
+	"time"
+)
+
+const (
+	// MigrationPending is the phase before the migration window starts
+	MigrationPending = "pending"
+	// MigrationRunning is the phase during the migration window
+	MigrationRunning = "running"
+	// MigrationComplete is the phase after the migration window ends
+	MigrationComplete = "complete"
+)
+
+// Migration models a rolling schema change, responses keep the old schema
+// until the window starts, are reshaped by the during transforms while the
+// window is open, e.g. with fields missing while the change rolls out, and
+// are reshaped by the after transforms once the new schema has taken over
+type Migration struct {
+	start  time.Time
+	end    time.Time
+	during []Transform
+	after  []Transform
+	now    func() time.Time
+}
+
+// NewMigration creates a Migration with a window of the given duration which
+// starts at start
+func NewMigration(start time.Time, window time.Duration, during, after []Transform) *Migration {
+	return &Migration{
+		start:  start,
+		end:    start.Add(window),
+		during: during,
+		after:  after,
+		now:    time.Now,
+	}
+}
+
+// Phase returns the phase of the migration at the current time
+func (m *Migration) Phase() string {
+	now := m.now()
+
+	switch {
+	case now.Before(m.start):
+		return MigrationPending
+	case now.Before(m.end):
+		return MigrationRunning
+	default:
+		return MigrationComplete
+	}
+}
+
+// Transforms returns the transforms for the current phase of the migration,
+// a nil Migration or a pending migration has no transforms
+func (m *Migration) Transforms() []Transform {
+	if m == nil {
+		return nil
+	}
+
+	switch m.Phase() {
+	case MigrationRunning:
+		return m.during
+	case MigrationComplete:
+		return m.after
+	}
+
+	return nil
+}
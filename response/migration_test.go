@@ -0,0 +1,52 @@
+package response
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func migratedFields(t *testing.T, m *Migration) map[string]json.RawMessage {
+	r := &Response{Name: "test", Body: json.RawMessage(`"hello"`), Code: 200}
+	r.ApplyTransforms(m.Transforms())
+
+	fields := map[string]json.RawMessage{}
+	assert.NoError(t, json.Unmarshal([]byte(r.ToJSON()), &fields))
+
+	return fields
+}
+
+func TestMigrationReshapesResponseInEachPhase(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	m := NewMigration(now.Add(time.Minute), time.Minute, []Transform{Strip("body")}, []Transform{Rename("body", "payload")})
+	m.now = func() time.Time { return now }
+
+	// before the window the old schema is returned
+	assert.Equal(t, MigrationPending, m.Phase())
+	f := migratedFields(t, m)
+	assert.Contains(t, f, "body")
+	assert.NotContains(t, f, "payload")
+
+	// during the window the field is missing
+	now = now.Add(time.Minute)
+	assert.Equal(t, MigrationRunning, m.Phase())
+	f = migratedFields(t, m)
+	assert.NotContains(t, f, "body")
+	assert.NotContains(t, f, "payload")
+
+	// after the window the new schema takes over
+	now = now.Add(time.Minute)
+	assert.Equal(t, MigrationComplete, m.Phase())
+	f = migratedFields(t, m)
+	assert.NotContains(t, f, "body")
+	assert.JSONEq(t, `"hello"`, string(f["payload"]))
+}
+
+func TestNilMigrationHasNoTransforms(t *testing.T) {
+	var m *Migration
+
+	assert.Nil(t, m.Transforms())
+}
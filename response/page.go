@@ -0,0 +1,59 @@
+package response
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Page is a page of synthetic items which allows clients to exercise
+// pagination, Next and Prev link to the adjacent pages
+type Page struct {
+	Page     int        `json:"page"`
+	PageSize int        `json:"page_size"`
+	Total    int        `json:"total"`
+	Items    []PageItem `json:"items"`
+	Next     string     `json:"next,omitempty"`
+	Prev     string     `json:"prev,omitempty"`
+}
+
+// PageItem is a synthetic item in a Page
+type PageItem struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// NewPage returns page of a list of total items split into pages of size,
+// the links keep the path and query of u. A page after the last page has no
+// items and links back to the last page
+func NewPage(u *url.URL, page, size, total int) *Page {
+	p := &Page{Page: page, PageSize: size, Total: total, Items: []PageItem{}}
+
+	for id := (page-1)*size + 1; id <= page*size && id <= total; id++ {
+		p.Items = append(p.Items, PageItem{ID: id, Name: fmt.Sprintf("item-%d", id)})
+	}
+
+	last := (total + size - 1) / size
+	if page < last {
+		p.Next = pageLink(u, page+1)
+	}
+
+	if page > 1 && last > 0 {
+		prev := page - 1
+		if prev > last {
+			prev = last
+		}
+
+		p.Prev = pageLink(u, prev)
+	}
+
+	return p
+}
+
+// pageLink returns the path and query of u with the page parameter set
+func pageLink(u *url.URL, page int) string {
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+
+	return u.Path + "?" + q.Encode()
+}
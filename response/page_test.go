@@ -0,0 +1,49 @@
+package response
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPageReturnsFirstPage(t *testing.T) {
+	u, _ := url.Parse("/items?page=1&sort=name")
+	p := NewPage(u, 1, 10, 25)
+
+	assert.Len(t, p.Items, 10)
+	assert.Equal(t, 1, p.Items[0].ID)
+	assert.Equal(t, 10, p.Items[9].ID)
+	assert.Equal(t, 25, p.Total)
+	assert.Equal(t, "/items?page=2&sort=name", p.Next)
+	assert.Empty(t, p.Prev)
+}
+
+func TestNewPageReturnsMiddlePage(t *testing.T) {
+	u, _ := url.Parse("/items?page=2")
+	p := NewPage(u, 2, 10, 25)
+
+	assert.Len(t, p.Items, 10)
+	assert.Equal(t, 11, p.Items[0].ID)
+	assert.Equal(t, "/items?page=3", p.Next)
+	assert.Equal(t, "/items?page=1", p.Prev)
+}
+
+func TestNewPageReturnsPartialLastPage(t *testing.T) {
+	u, _ := url.Parse("/items?page=3")
+	p := NewPage(u, 3, 10, 25)
+
+	assert.Len(t, p.Items, 5)
+	assert.Equal(t, 25, p.Items[4].ID)
+	assert.Empty(t, p.Next)
+	assert.Equal(t, "/items?page=2", p.Prev)
+}
+
+func TestNewPageAfterLastPageIsEmpty(t *testing.T) {
+	u, _ := url.Parse("/items?page=9")
+	p := NewPage(u, 9, 10, 25)
+
+	assert.Empty(t, p.Items)
+	assert.Empty(t, p.Next)
+	assert.Equal(t, "/items?page=3", p.Prev)
+}
@@ -7,20 +7,98 @@ import (
 
 // Response defines the type which is returned from the service
 type Response struct {
+	SchemaVersion int                 `json:"schema_version,omitempty"`
 	Name          string              `json:"name,omitempty"`
 	URI           string              `json:"uri,omitempty"` // Called URI by downstream
 	Type          string              `json:"type,omitempty"`
 	IPAddresses   []string            `json:"ip_addresses,omitempty"`
-	Path          []string            `json:"path,omitempty"` // Path received by upstream
+	ClientIP      string              `json:"client_ip,omitempty"` // Address of the client, recovered from the PROXY protocol when enabled
+	Tenant        string              `json:"tenant,omitempty"`    // Tenant of the request resolved from the tenant header
+	Cohort        string              `json:"cohort,omitempty"`    // Cohort of the request in a feature rollout
+	TLS           *TLS                `json:"tls,omitempty"`       // TLS connection of the request when the service terminates TLS
+	Path          []string            `json:"path,omitempty"`      // Path received by upstream
 	StartTime     string              `json:"start_time,omitempty"`
 	EndTime       string              `json:"end_time,omitempty"`
 	Duration      string              `json:"duration,omitempty"`
+	CriticalPath  string              `json:"critical_path,omitempty"`  // Weighted roll-up of upstream durations
+	UpstreamStats *UpstreamStats      `json:"upstream_stats,omitempty"` // Latency summary of the upstream calls
 	Headers       map[string]string   `json:"headers,omitempty"`
 	Cookies       map[string]string   `json:"cookies,omitempty"`
+	Vary          map[string]string   `json:"vary,omitempty"`     // Request header values the response varies by
+	Variant       *int                `json:"variant,omitempty"`  // Variant derived from a hash of the request
+	Page          *Page               `json:"page,omitempty"`     // Page of synthetic items for a paginated request
+	Replica       string              `json:"replica,omitempty"`  // Replica which served the body in split brain mode
+	Metadata      map[string]string   `json:"metadata,omitempty"` // Metadata received with a gRPC request
+	Trailers      map[string]string   `json:"trailers,omitempty"` // Trailers received after the body of an HTTP request
 	Body          json.RawMessage     `json:"body,omitempty"`
 	UpstreamCalls map[string]Response `json:"upstream_calls,omitempty"`
+	Repeats       []Response          `json:"repeats,omitempty"` // Further responses when an upstream is called repeatedly
+	WorkUnits     *WorkUnits          `json:"work_units,omitempty"`
+	Queries       *Queries            `json:"queries,omitempty"` // Simulated database queries made by the request
+	RequestLoad   *RequestLoad        `json:"request_load,omitempty"`
+	Compression   *Compression        `json:"compression,omitempty"` // Compression negotiated for a gRPC request
+	WorkerQueue   *WorkerQueue        `json:"worker_queue,omitempty"`
 	Code          int                 `json:"code"`
 	Error         string              `json:"error,omitempty"`
+	Truncated     bool                `json:"truncated,omitempty"`  // Upstream body exceeded the maximum response size
+	BytesRead     int64               `json:"bytes_read,omitempty"` // Bytes read from a truncated upstream body
+
+	DeadlineExceeded  bool   `json:"deadline_exceeded,omitempty"`  // Upstream was skipped because the request deadline passed
+	DeadlineRemaining string `json:"deadline_remaining,omitempty"` // Time left before the request deadline when the upstream was called
+	Retries           int    `json:"retries,omitempty"`            // Number of times the upstream call was retried
+	Hedge             *Hedge `json:"hedge,omitempty"`              // Hedged request made when the upstream was slow
+	Skipped           bool   `json:"skipped,omitempty"`            // Upstream was skipped because it is failing health checks
+	PartialSuccess    bool   `json:"partial_success,omitempty"`    // Some upstreams failed but the request succeeded
+	NonJSON           bool   `json:"non_json,omitempty"`           // Upstream response was not fake-service JSON
+	RawBody           string `json:"raw_body,omitempty"`           // Start of the body of a non JSON upstream response
+
+	// transformed holds the reshaped response once transforms are applied
+	transformed json.RawMessage
+}
+
+// WorkUnits reports the CPU bound work performed by the request
+type WorkUnits struct {
+	Count    int    `json:"count"`
+	Duration string `json:"duration"`
+}
+
+// Queries reports the simulated database queries made by a request
+type Queries struct {
+	Count    int    `json:"count"`
+	Duration string `json:"duration"`
+	Errors   int    `json:"errors"`
+}
+
+// Hedge reports whether a hedged upstream request was fired and which
+// attempt responded first
+type Hedge struct {
+	Fired  bool   `json:"fired"`
+	Winner string `json:"winner"`
+}
+
+// RequestLoad reports the load generated for a single request
+type RequestLoad struct {
+	CPU      string `json:"cpu"`
+	MemoryMB int    `json:"memory_mb"`
+}
+
+// TLS reports the TLS connection of a request
+type TLS struct {
+	Version string `json:"version"`
+	Resumed bool   `json:"resumed"` // Session was resumed rather than a full handshake
+}
+
+// Compression reports the compression of a gRPC request and its response
+type Compression struct {
+	Request  string `json:"request"`
+	Response string `json:"response"`
+}
+
+// WorkerQueue reports the saturation of the upstream worker queue
+type WorkerQueue struct {
+	MaxDepth int    `json:"max_depth"`
+	MaxWait  string `json:"max_wait"`
+	Dropped  int    `json:"dropped"`
 }
 
 // ToJSON converts the response to a JSON string
@@ -37,6 +115,37 @@ func (r *Response) ToJSON() string {
 	return buffer.String()
 }
 
+// ToFilteredJSON converts the response to a JSON string omitting the given
+// top level fields, fields are referenced by their JSON name e.g. duration
+func (r *Response) ToFilteredJSON(omit []string) string {
+	if len(omit) == 0 {
+		return r.ToJSON()
+	}
+
+	// round trip the response through a map so that any field can be removed
+	// regardless of its omitempty setting
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal([]byte(r.ToJSON()), &fields)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, f := range omit {
+		delete(fields, f)
+	}
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetIndent("", "  ")
+
+	err = encoder.Encode(fields)
+	if err != nil {
+		panic(err)
+	}
+
+	return buffer.String()
+}
+
 // FromJSON populates the response from a JSON string
 func (r *Response) FromJSON(d []byte) error {
 	resp := &Response{}
@@ -65,3 +174,29 @@ func (r *Response) AppendUpstream(key string, resp Response) {
 
 	r.UpstreamCalls[key] = resp
 }
+
+// AnyUpstreamSucceeded returns true when at least one upstream call in
+// upstreams completed without an error
+func AnyUpstreamSucceeded(upstreams map[string]Response) bool {
+	for _, u := range upstreams {
+		if u.Error == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AppendRepeatedUpstream appends an upstream response to this object, when
+// the upstream already has a response the new response is added to its
+// repeats
+func (r *Response) AppendRepeatedUpstream(key string, resp Response) {
+	u, ok := r.UpstreamCalls[key]
+	if !ok {
+		r.AppendUpstream(key, resp)
+		return
+	}
+
+	u.Repeats = append(u.Repeats, resp)
+	r.UpstreamCalls[key] = u
+}
@@ -1,6 +1,7 @@
 package response
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -23,3 +24,40 @@ func TestConvertsFromJSON(t *testing.T) {
 	assert.Len(t, r.UpstreamCalls, 1)
 	assert.Equal(t, "upstream", r.UpstreamCalls["abc"].Name)
 }
+
+func TestToFilteredJSONOmitsFields(t *testing.T) {
+	r := &Response{
+		Name:        "Test App",
+		Duration:    "10ms",
+		IPAddresses: []string{"10.0.0.1"},
+		Code:        200,
+	}
+
+	d := map[string]interface{}{}
+	err := json.Unmarshal([]byte(r.ToFilteredJSON([]string{"duration", "ip_addresses", "code"})), &d)
+	assert.NoError(t, err)
+
+	assert.NotContains(t, d, "duration")
+	assert.NotContains(t, d, "ip_addresses")
+	assert.NotContains(t, d, "code")
+	assert.Equal(t, "Test App", d["name"])
+}
+
+func TestToFilteredJSONWithNoFieldsReturnsFullResponse(t *testing.T) {
+	r := &Response{Name: "Test App", Duration: "10ms"}
+
+	assert.Equal(t, r.ToJSON(), r.ToFilteredJSON(nil))
+}
+
+func TestAppendRepeatedUpstreamGroupsResponses(t *testing.T) {
+	r := Response{}
+	r.AppendRepeatedUpstream("http://a", Response{Name: "a1"})
+	r.AppendRepeatedUpstream("http://a", Response{Name: "a2"})
+	r.AppendRepeatedUpstream("http://b", Response{Name: "b1"})
+
+	assert.Len(t, r.UpstreamCalls, 2)
+	assert.Equal(t, "a1", r.UpstreamCalls["http://a"].Name)
+	assert.Len(t, r.UpstreamCalls["http://a"].Repeats, 1)
+	assert.Equal(t, "a2", r.UpstreamCalls["http://a"].Repeats[0].Name)
+	assert.Empty(t, r.UpstreamCalls["http://b"].Repeats)
+}
@@ -0,0 +1,102 @@
+package response
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// CriticalPath rolls up the time spent in upstream calls, the duration of
+// each upstream is multiplied by its weight. A weight of 1 models a
+// synchronous dependency, 0 an asynchronous dependency which does not add to
+// the response time. Upstreams without a weight have a weight of 1
+func CriticalPath(upstreams map[string]Response, weights map[string]float64) time.Duration {
+	var total time.Duration
+
+	for uri, r := range upstreams {
+		d, err := time.ParseDuration(r.Duration)
+		if err != nil {
+			continue
+		}
+
+		w, ok := weights[uri]
+		if !ok {
+			w = 1
+		}
+
+		total += time.Duration(float64(d) * w)
+	}
+
+	return total
+}
+
+// UpstreamStats summarizes the latency of the upstream calls for a request
+type UpstreamStats struct {
+	Count   int    `json:"count"`
+	Min     string `json:"min"`
+	Max     string `json:"max"`
+	Mean    string `json:"mean"`
+	P50     string `json:"p50"`
+	P95     string `json:"p95"`
+	Slowest string `json:"slowest"` // URI of the slowest upstream
+}
+
+// NewUpstreamStats computes the UpstreamStats from the durations of the
+// upstreams, including any repeated calls. Upstreams without a duration are
+// ignored, nil is returned when no upstream has a duration
+func NewUpstreamStats(upstreams map[string]Response) *UpstreamStats {
+	durations := []time.Duration{}
+	var total, max time.Duration
+	slowest := ""
+
+	// sort the URIs so that ties for the slowest upstream are stable
+	uris := make([]string, 0, len(upstreams))
+	for uri := range upstreams {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	for _, uri := range uris {
+		u := upstreams[uri]
+		for _, r := range append([]Response{u}, u.Repeats...) {
+			d, err := time.ParseDuration(r.Duration)
+			if err != nil {
+				continue
+			}
+
+			durations = append(durations, d)
+			total += d
+
+			if slowest == "" || d > max {
+				max = d
+				slowest = uri
+			}
+		}
+	}
+
+	if len(durations) == 0 {
+		return nil
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	return &UpstreamStats{
+		Count:   len(durations),
+		Min:     durations[0].String(),
+		Max:     max.String(),
+		Mean:    (total / time.Duration(len(durations))).String(),
+		P50:     percentile(durations, 50).String(),
+		P95:     percentile(durations, 95).String(),
+		Slowest: slowest,
+	}
+}
+
+// percentile returns the nearest rank percentile p of the sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
@@ -0,0 +1,58 @@
+package response
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCriticalPathAppliesUpstreamWeights(t *testing.T) {
+	upstreams := map[string]Response{
+		"http://sync":    {Duration: "100ms"},
+		"http://async":   {Duration: "500ms"},
+		"http://partial": {Duration: "200ms"},
+		"http://default": {Duration: "50ms"},
+	}
+
+	weights := map[string]float64{
+		"http://sync":    1,
+		"http://async":   0,
+		"http://partial": 0.25,
+	}
+
+	// 100ms + 0 + 50ms + 50ms
+	assert.Equal(t, 200*time.Millisecond, CriticalPath(upstreams, weights))
+}
+
+func TestCriticalPathIgnoresUpstreamsWithoutDuration(t *testing.T) {
+	upstreams := map[string]Response{
+		"http://failed": {Error: "boom"},
+		"http://ok":     {Duration: "10ms"},
+	}
+
+	assert.Equal(t, 10*time.Millisecond, CriticalPath(upstreams, nil))
+}
+
+func TestNewUpstreamStatsSummarizesDurations(t *testing.T) {
+	upstreams := map[string]Response{
+		"http://a":      {Duration: "10ms"},
+		"http://b":      {Duration: "40ms", Repeats: []Response{{Duration: "20ms"}}},
+		"http://c":      {Duration: "30ms"},
+		"http://failed": {Error: "boom"},
+	}
+
+	s := NewUpstreamStats(upstreams)
+
+	assert.Equal(t, 4, s.Count)
+	assert.Equal(t, "10ms", s.Min)
+	assert.Equal(t, "40ms", s.Max)
+	assert.Equal(t, "25ms", s.Mean)
+	assert.Equal(t, "20ms", s.P50)
+	assert.Equal(t, "40ms", s.P95)
+	assert.Equal(t, "http://b", s.Slowest)
+}
+
+func TestNewUpstreamStatsReturnsNilWithoutDurations(t *testing.T) {
+	assert.Nil(t, NewUpstreamStats(map[string]Response{"http://failed": {Error: "boom"}}))
+}
@@ -0,0 +1,112 @@
+package response
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const (
+	// SchemaV1 is the original response format, upstream_calls is a list of
+	// responses and there is no schema_version field
+	SchemaV1 = 1
+	// SchemaV2 is the current response format, upstream_calls is an object
+	// keyed by the upstream URI
+	SchemaV2 = 2
+	// SchemaLatest is the newest response format
+	SchemaLatest = SchemaV2
+)
+
+// ParseSchemaVersion parses a requested schema version, an empty version
+// returns def or the latest version when def is 0
+func ParseSchemaVersion(v string, def int) (int, error) {
+	if v == "" && def == 0 {
+		return SchemaLatest, nil
+	}
+
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < SchemaV1 || i > SchemaLatest {
+		return 0, fmt.Errorf("unsupported schema version %q, supported versions are %d to %d", v, SchemaV1, SchemaLatest)
+	}
+
+	return i, nil
+}
+
+// ToSchemaJSON converts the response to a JSON string in the given schema
+// version omitting the given top level fields
+func (r *Response) ToSchemaJSON(version int, omit []string) string {
+	if version == SchemaLatest {
+		return r.ToFilteredJSON(omit)
+	}
+
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal([]byte(r.ToJSON()), &fields)
+	if err != nil {
+		panic(err)
+	}
+
+	fields = toSchemaV1(fields)
+
+	for _, f := range omit {
+		delete(fields, f)
+	}
+
+	buffer := new(bytes.Buffer)
+	encoder := json.NewEncoder(buffer)
+	encoder.SetIndent("", "  ")
+
+	err = encoder.Encode(fields)
+	if err != nil {
+		panic(err)
+	}
+
+	return buffer.String()
+}
+
+// toSchemaV1 converts the fields of a response to the version 1 format, the
+// upstream responses are converted recursively
+func toSchemaV1(fields map[string]json.RawMessage) map[string]json.RawMessage {
+	delete(fields, "schema_version")
+
+	raw, ok := fields["upstream_calls"]
+	if !ok {
+		return fields
+	}
+
+	upstreams := map[string]map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &upstreams); err != nil {
+		// upstream_calls has been reshaped by a transform, leave it as is
+		return fields
+	}
+
+	// the upstreams are sorted by URI to give a stable order
+	list := []map[string]json.RawMessage{}
+	for _, k := range sortedKeys(upstreams) {
+		u := toSchemaV1(upstreams[k])
+		if _, ok := u["uri"]; !ok {
+			u["uri"], _ = json.Marshal(k)
+		}
+
+		list = append(list, u)
+	}
+
+	fields["upstream_calls"], _ = json.Marshal(list)
+
+	return fields
+}
+
+func sortedKeys(m map[string]map[string]json.RawMessage) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,67 @@
+package response
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSchemaVersionDefaultsToLatest(t *testing.T) {
+	v, err := ParseSchemaVersion("", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaLatest, v)
+
+	v, err = ParseSchemaVersion("", SchemaV1)
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaV1, v)
+}
+
+func TestParseSchemaVersionReturnsErrorForUnknownVersion(t *testing.T) {
+	_, err := ParseSchemaVersion("3", 0)
+	assert.Error(t, err)
+
+	_, err = ParseSchemaVersion("abc", 0)
+	assert.Error(t, err)
+}
+
+func TestToSchemaJSONV1ReturnsUpstreamsAsList(t *testing.T) {
+	r := &Response{SchemaVersion: SchemaLatest, Name: "web"}
+	r.AppendUpstream("http://b", Response{SchemaVersion: SchemaLatest, Name: "b"})
+	r.AppendUpstream("http://a", Response{
+		SchemaVersion: SchemaLatest,
+		Name:          "a",
+		UpstreamCalls: map[string]Response{"http://c": {Name: "c"}},
+	})
+
+	v1 := map[string]interface{}{}
+	err := json.Unmarshal([]byte(r.ToSchemaJSON(SchemaV1, nil)), &v1)
+	assert.NoError(t, err)
+
+	assert.NotContains(t, v1, "schema_version")
+
+	calls := v1["upstream_calls"].([]interface{})
+	assert.Len(t, calls, 2)
+
+	a := calls[0].(map[string]interface{})
+	assert.Equal(t, "a", a["name"])
+	assert.Equal(t, "http://a", a["uri"])
+	assert.NotContains(t, a, "schema_version")
+
+	// nested upstreams are converted
+	c := a["upstream_calls"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "c", c["name"])
+}
+
+func TestToSchemaJSONV2ReturnsUpstreamsAsObject(t *testing.T) {
+	r := &Response{SchemaVersion: SchemaLatest, Name: "web"}
+	r.AppendUpstream("http://a", Response{Name: "a"})
+
+	v2 := map[string]interface{}{}
+	err := json.Unmarshal([]byte(r.ToSchemaJSON(SchemaV2, []string{"name"})), &v2)
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(SchemaV2), v2["schema_version"])
+	assert.NotContains(t, v2, "name")
+	assert.Contains(t, v2["upstream_calls"].(map[string]interface{}), "http://a")
+}
@@ -0,0 +1,145 @@
+package response
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Transform reshapes the top level fields of a response, fields are
+// referenced by their JSON name e.g. upstream_calls
+type Transform func(fields map[string]json.RawMessage) map[string]json.RawMessage
+
+// ParseTransforms parses a pipe separated list of transforms, the supported
+// transforms are:
+//
+//	strip:field[,field]  removes the given fields
+//	rename:from:to       renames the field from to to
+//	wrap:field           nests all fields under the given field
+//
+// e.g. strip:headers,cookies|rename:body:payload
+func ParseTransforms(s string) ([]Transform, error) {
+	ts := []Transform{}
+
+	for _, p := range strings.Split(s, "|") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		parts := strings.Split(p, ":")
+		switch {
+		case parts[0] == "strip" && len(parts) == 2:
+			ts = append(ts, Strip(strings.Split(parts[1], ",")...))
+		case parts[0] == "rename" && len(parts) == 3:
+			ts = append(ts, Rename(parts[1], parts[2]))
+		case parts[0] == "wrap" && len(parts) == 2:
+			ts = append(ts, Wrap(parts[1]))
+		default:
+			return nil, fmt.Errorf("invalid transform: %s", p)
+		}
+	}
+
+	return ts, nil
+}
+
+// Strip returns a transform which removes the given fields
+func Strip(fields ...string) Transform {
+	return func(f map[string]json.RawMessage) map[string]json.RawMessage {
+		for _, k := range fields {
+			delete(f, strings.TrimSpace(k))
+		}
+
+		return f
+	}
+}
+
+// Rename returns a transform which moves the value of the field from to the
+// field to
+func Rename(from, to string) Transform {
+	return func(f map[string]json.RawMessage) map[string]json.RawMessage {
+		if v, ok := f[from]; ok {
+			delete(f, from)
+			f[to] = v
+		}
+
+		return f
+	}
+}
+
+// Wrap returns a transform which nests all fields under the given field
+func Wrap(field string) Transform {
+	return func(f map[string]json.RawMessage) map[string]json.RawMessage {
+		d, err := json.Marshal(f)
+		if err != nil {
+			panic(err)
+		}
+
+		return map[string]json.RawMessage{field: d}
+	}
+}
+
+// ApplyTransforms reshapes the response using the given transforms, once
+// transformed the response is serialized in its reshaped form. Repeated
+// responses are reshaped individually and kept under repeats
+func (r *Response) ApplyTransforms(ts []Transform) {
+	if len(ts) == 0 {
+		return
+	}
+
+	p := *r
+	p.Repeats = nil
+
+	d, err := json.Marshal(p)
+	if err != nil {
+		panic(err)
+	}
+
+	fields := map[string]json.RawMessage{}
+	err = json.Unmarshal(d, &fields)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, t := range ts {
+		fields = t(fields)
+	}
+
+	if len(r.Repeats) > 0 {
+		for i := range r.Repeats {
+			r.Repeats[i].ApplyTransforms(ts)
+		}
+
+		fields["repeats"], err = json.Marshal(r.Repeats)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	r.transformed, err = json.Marshal(fields)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// TransformUpstreams reshapes each upstream response using the transforms
+// keyed by its upstream URI, this is applied once the repeated responses
+// have been grouped
+func (r *Response) TransformUpstreams(ts map[string][]Transform) {
+	for uri, u := range r.UpstreamCalls {
+		u.ApplyTransforms(ts[uri])
+		r.UpstreamCalls[uri] = u
+	}
+}
+
+// MarshalJSON returns the reshaped response when transforms have been
+// applied, otherwise the response is serialized as normal
+func (r Response) MarshalJSON() ([]byte, error) {
+	if r.transformed != nil {
+		return r.transformed, nil
+	}
+
+	// alias the type to avoid recursively calling MarshalJSON
+	type plain Response
+	return json.Marshal(plain(r))
+}
@@ -0,0 +1,53 @@
+package response
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTransformsReturnsTransforms(t *testing.T) {
+	ts, err := ParseTransforms("strip:headers,cookies|rename:body:payload|wrap:data")
+
+	assert.NoError(t, err)
+	assert.Len(t, ts, 3)
+}
+
+func TestParseTransformsReturnsErrorForUnknownTransform(t *testing.T) {
+	_, err := ParseTransforms("explode:body")
+
+	assert.Error(t, err)
+}
+
+func TestApplyTransformsReshapesResponse(t *testing.T) {
+	r := &Response{Name: "upstream", Body: json.RawMessage(`"OK"`), Headers: map[string]string{"a": "b"}, Code: 200}
+	r.ApplyTransforms([]Transform{Strip("headers"), Rename("body", "payload"), Wrap("data")})
+
+	d := map[string]map[string]interface{}{}
+	err := json.Unmarshal([]byte(r.ToJSON()), &d)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "upstream", d["data"]["name"])
+	assert.Equal(t, "OK", d["data"]["payload"])
+	assert.NotContains(t, d["data"], "body")
+	assert.NotContains(t, d["data"], "headers")
+}
+
+func TestApplyTransformsReshapesEachRepeat(t *testing.T) {
+	r := &Response{Name: "a1", Repeats: []Response{{Name: "a2"}, {Name: "a3"}}}
+	r.ApplyTransforms([]Transform{Wrap("data")})
+
+	d := struct {
+		Data    map[string]interface{}              `json:"data"`
+		Repeats []map[string]map[string]interface{} `json:"repeats"`
+	}{}
+	err := json.Unmarshal([]byte(r.ToJSON()), &d)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "a1", d.Data["name"])
+	assert.NotContains(t, d.Data, "repeats")
+	assert.Len(t, d.Repeats, 2)
+	assert.Equal(t, "a2", d.Repeats[0]["data"]["name"])
+	assert.Equal(t, "a3", d.Repeats[1]["data"]["name"])
+}
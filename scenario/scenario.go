@@ -0,0 +1,144 @@
+package scenario
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Phase defines the behavior of the service from Start until the start of the
+// next phase
+type Phase struct {
+	// Start is the offset from the start of the scenario
+	Start time.Duration `yaml:"start"`
+	// ErrorRate is the decimal percentage of requests which return an error
+	ErrorRate float64 `yaml:"error_rate"`
+	// ErrorCode is the code returned for errors, 0 uses the code the injector
+	// was created with
+	ErrorCode int `yaml:"error_code"`
+	// Delay is added to the duration of every request
+	Delay time.Duration `yaml:"delay"`
+}
+
+// Scenario is a timeline of phases, when Loop is true the timeline restarts
+// once Duration has elapsed
+type Scenario struct {
+	Phases   []Phase       `yaml:"phases"`
+	Loop     bool          `yaml:"loop"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Load reads a scenario from a YAML or JSON file
+func Load(path string) (*Scenario, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scenario{}
+	err = yaml.Unmarshal(d, s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse scenario: %s", err)
+	}
+
+	if len(s.Phases) == 0 {
+		return nil, fmt.Errorf("scenario must contain at least one phase")
+	}
+
+	if s.Loop && s.Duration <= 0 {
+		return nil, fmt.Errorf("a looping scenario must have a duration")
+	}
+
+	for _, p := range s.Phases {
+		if p.ErrorRate < 0 || p.ErrorRate > 1 {
+			return nil, fmt.Errorf("invalid error rate %v for the phase starting at %s, must be between 0 and 1", p.ErrorRate, p.Start)
+		}
+	}
+
+	sort.Slice(s.Phases, func(i, j int) bool { return s.Phases[i].Start < s.Phases[j].Start })
+
+	return s, nil
+}
+
+// Engine steps through a scenario over wall clock time adjusting the error
+// injector as each phase starts
+type Engine struct {
+	scenario *Scenario
+	injector *errors.Injector
+	logger   hclog.Logger
+	now      func() time.Time
+	// errorCode is the code the injector was created with, it is restored
+	// for phases which do not set a code
+	errorCode int
+
+	mutex   sync.Mutex
+	start   time.Time
+	current int
+}
+
+// NewEngine creates a new Engine, the scenario starts immediately
+func NewEngine(s *Scenario, i *errors.Injector, l hclog.Logger) *Engine {
+	e := &Engine{
+		scenario: s,
+		injector: i,
+		logger:   l,
+		now:      time.Now,
+		current:  -1,
+	}
+
+	e.start = e.now()
+	_, e.errorCode = i.Errors()
+
+	return e
+}
+
+// Apply configures the error injector for the current phase and returns the
+// delay which should be added to the request
+func (e *Engine) Apply() time.Duration {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	i := e.phaseIndex()
+	if i < 0 {
+		return 0
+	}
+
+	p := e.scenario.Phases[i]
+
+	if i != e.current {
+		code := p.ErrorCode
+		if code == 0 {
+			code = e.errorCode
+		}
+
+		e.logger.Info("Starting scenario phase", "phase", i, "error_rate", p.ErrorRate, "error_code", code, "delay", p.Delay)
+		e.injector.SetErrors(p.ErrorRate, code)
+		e.current = i
+	}
+
+	return p.Delay
+}
+
+// phaseIndex returns the index of the phase for the current time, -1 is
+// returned before the first phase starts
+func (e *Engine) phaseIndex() int {
+	elapsed := e.now().Sub(e.start)
+	if e.scenario.Loop {
+		elapsed = elapsed % e.scenario.Duration
+	}
+
+	index := -1
+	for n, p := range e.scenario.Phases {
+		if elapsed >= p.Start {
+			index = n
+		}
+	}
+
+	return index
+}
@@ -0,0 +1,125 @@
+package scenario
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nicholasjackson/fake-service/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const testScenario = `
+loop: true
+duration: 180s
+phases:
+  - start: 60s
+    error_rate: 0.5
+    error_code: 503
+  - start: 0s
+  - start: 120s
+    delay: 500ms
+`
+
+func setupEngine(t *testing.T) (*Engine, *errors.Injector, *time.Time) {
+	p := filepath.Join(t.TempDir(), "scenario.yaml")
+	err := ioutil.WriteFile(p, []byte(testScenario), 0644)
+	assert.NoError(t, err)
+
+	s, err := Load(p)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	i := errors.NewInjector(hclog.NewNullLogger(), 0, http.StatusInternalServerError, "http_error", 0, 0, 0, 0, 0, 0, 0, 0, nil)
+	e := NewEngine(s, i, hclog.NewNullLogger())
+	e.now = func() time.Time { return now }
+	e.start = now
+
+	return e, i, &now
+}
+
+// errorsFor returns the number of errors injected in n requests
+func errorsFor(e *Engine, i *errors.Injector, n int) (int, int, time.Duration) {
+	count := 0
+	code := 0
+	delay := time.Duration(0)
+
+	for r := 0; r < n; r++ {
+		delay = e.Apply()
+		if er := i.Do(); er != nil {
+			count++
+			code = er.Code
+		}
+	}
+
+	return count, code, delay
+}
+
+func TestLoadSortsPhases(t *testing.T) {
+	e, _, _ := setupEngine(t)
+
+	assert.Equal(t, time.Duration(0), e.scenario.Phases[0].Start)
+	assert.Equal(t, 120*time.Second, e.scenario.Phases[2].Start)
+}
+
+func TestLoadReturnsErrorForLoopWithoutDuration(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "scenario.json")
+	ioutil.WriteFile(p, []byte(`{"loop": true, "phases": [{"start": "0s"}]}`), 0644)
+
+	_, err := Load(p)
+
+	assert.Error(t, err)
+}
+
+func TestLoadReturnsErrorForInvalidErrorRate(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "scenario.json")
+	ioutil.WriteFile(p, []byte(`{"phases": [{"start": "0s", "error_rate": 1.5}]}`), 0644)
+
+	_, err := Load(p)
+
+	assert.Error(t, err)
+}
+
+func TestEngineRestoresErrorCodeForPhaseWithoutCode(t *testing.T) {
+	e, i, now := setupEngine(t)
+	e.scenario.Phases[2].ErrorRate = 1
+
+	*now = now.Add(60 * time.Second)
+	_, code, _ := errorsFor(e, i, 10)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	*now = now.Add(60 * time.Second)
+	_, code, _ = errorsFor(e, i, 10)
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestEngineStepsThroughPhases(t *testing.T) {
+	e, i, now := setupEngine(t)
+
+	// normal
+	count, _, delay := errorsFor(e, i, 10)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, time.Duration(0), delay)
+
+	// 50% errors
+	*now = now.Add(60 * time.Second)
+	count, code, delay := errorsFor(e, i, 10)
+	assert.Equal(t, 5, count)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, time.Duration(0), delay)
+
+	// added latency
+	*now = now.Add(60 * time.Second)
+	count, _, delay = errorsFor(e, i, 10)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, 500*time.Millisecond, delay)
+
+	// loops back to normal
+	*now = now.Add(60 * time.Second)
+	count, _, delay = errorsFor(e, i, 10)
+	assert.Equal(t, 0, count)
+	assert.Equal(t, time.Duration(0), delay)
+}
@@ -0,0 +1,64 @@
+package timing
+
+import (
+	"sync"
+	"time"
+)
+
+// Degradation slows down requests once the number of errors in a window
+// exceeds a threshold, every additional error increases the delay by a step
+// until the window resets
+type Degradation struct {
+	threshold int
+	window    time.Duration
+	step      time.Duration
+	now       func() time.Time
+
+	mutex       sync.Mutex
+	errors      int
+	windowStart time.Time
+}
+
+// NewDegradation creates a new Degradation
+func NewDegradation(threshold int, window, step time.Duration) *Degradation {
+	return &Degradation{
+		threshold: threshold,
+		window:    window,
+		step:      step,
+		now:       time.Now,
+	}
+}
+
+// RecordError records an error which occurred while handling a request
+func (d *Degradation) RecordError() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.resetExpiredWindow()
+
+	if d.errors == 0 {
+		d.windowStart = d.now()
+	}
+
+	d.errors++
+}
+
+// Calculate returns the additional delay which should be added to a request
+func (d *Degradation) Calculate() time.Duration {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.resetExpiredWindow()
+
+	if d.errors <= d.threshold {
+		return 0
+	}
+
+	return time.Duration(d.errors-d.threshold) * d.step
+}
+
+func (d *Degradation) resetExpiredWindow() {
+	if d.errors > 0 && d.now().Sub(d.windowStart) >= d.window {
+		d.errors = 0
+	}
+}
@@ -0,0 +1,54 @@
+package timing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupDegradation(t *testing.T) (*Degradation, *time.Time) {
+	now := time.Now()
+	d := NewDegradation(2, 10*time.Second, 100*time.Millisecond)
+	d.now = func() time.Time { return now }
+
+	return d, &now
+}
+
+func TestDegradationDoesNotDelayBelowThreshold(t *testing.T) {
+	d, _ := setupDegradation(t)
+
+	d.RecordError()
+	d.RecordError()
+
+	assert.Equal(t, time.Duration(0), d.Calculate())
+}
+
+func TestDegradationDelayGrowsWithErrors(t *testing.T) {
+	d, _ := setupDegradation(t)
+
+	delays := []time.Duration{}
+	for i := 0; i < 5; i++ {
+		d.RecordError()
+		delays = append(delays, d.Calculate())
+	}
+
+	assert.Equal(
+		t,
+		[]time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond},
+		delays,
+	)
+}
+
+func TestDegradationResetsAfterWindow(t *testing.T) {
+	d, now := setupDegradation(t)
+
+	for i := 0; i < 5; i++ {
+		d.RecordError()
+	}
+	assert.Equal(t, 300*time.Millisecond, d.Calculate())
+
+	*now = now.Add(10 * time.Second)
+
+	assert.Equal(t, time.Duration(0), d.Calculate())
+}
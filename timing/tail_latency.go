@@ -0,0 +1,34 @@
+package timing
+
+import (
+	"math/rand"
+	"time"
+)
+
+// TailLatency adds a large extra delay to a small fraction of requests to
+// simulate realistic tail latency spikes
+type TailLatency struct {
+	rate       float64
+	delay      time.Duration
+	randomFunc func() float64
+}
+
+// NewTailLatency creates a new TailLatency, rate is the decimal percentage of
+// requests which will receive the additional delay e.g. 0.01 = 1%
+func NewTailLatency(rate float64, delay time.Duration) *TailLatency {
+	return &TailLatency{
+		rate:       rate,
+		delay:      delay,
+		randomFunc: rand.Float64,
+	}
+}
+
+// Calculate returns the extra delay for a request, this is zero for requests
+// which are not in the tail
+func (t *TailLatency) Calculate() time.Duration {
+	if t.rate <= 0 || t.randomFunc() >= t.rate {
+		return 0
+	}
+
+	return t.delay
+}
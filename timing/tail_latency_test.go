@@ -0,0 +1,31 @@
+package timing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailLatencyDelaysConfiguredFraction(t *testing.T) {
+	tl := NewTailLatency(0.05, 5*time.Second)
+
+	requests := 20000
+	slow := 0
+	for i := 0; i < requests; i++ {
+		if d := tl.Calculate(); d > 0 {
+			assert.Equal(t, 5*time.Second, d)
+			slow++
+		}
+	}
+
+	assert.InDelta(t, 0.05, float64(slow)/float64(requests), 0.01)
+}
+
+func TestTailLatencyDisabledWithZeroRate(t *testing.T) {
+	tl := NewTailLatency(0, 5*time.Second)
+
+	for i := 0; i < 1000; i++ {
+		assert.Equal(t, time.Duration(0), tl.Calculate())
+	}
+}
@@ -2,6 +2,7 @@ package timing
 
 import (
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -19,6 +20,10 @@ type RequestDuration struct {
 	// random variance for the request as percentage of total
 	variance   int
 	randomFunc func(max int) int
+	// weight of the previous duration in the next duration
+	correlation float64
+	previous    time.Duration
+	mutex       sync.Mutex
 }
 
 // NewRequestDuration creates a new RequestDuration
@@ -41,8 +46,63 @@ func NewRequestDuration(percentile50, percentile90, percentile99 time.Duration,
 	}
 }
 
+// Set changes the percentiles while the service is running, the same
+// defaults are applied as NewRequestDuration
+func (r *RequestDuration) Set(percentile50, percentile90, percentile99 time.Duration) {
+	if percentile50 > 0 && percentile90 == 0 {
+		percentile90 = percentile50
+	}
+
+	if percentile90 > 0 && percentile99 == 0 {
+		percentile99 = percentile90
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.percentile50 = percentile50
+	r.percentile90 = percentile90
+	r.percentile99 = percentile99
+}
+
+// Percentiles returns the 50th, 90th, and 99th percentile durations
+func (r *RequestDuration) Percentiles() (time.Duration, time.Duration, time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.percentile50, r.percentile90, r.percentile99
+}
+
+// SetCorrelation sets the correlation coefficient between the durations of
+// consecutive requests, each duration is a blend of a random duration and the
+// previous duration weighted by correlation (an AR(1) process) so that slow
+// requests cluster together. A correlation of 0 makes every duration
+// independent
+func (r *RequestDuration) SetCorrelation(correlation float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.correlation = correlation
+}
+
 // Calculate a new random request duration
 func (r *RequestDuration) Calculate() time.Duration {
+	d := r.random()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.correlation > 0 && r.previous > 0 {
+		d = time.Duration(r.correlation*float64(r.previous) + (1-r.correlation)*float64(d))
+	}
+
+	r.previous = d
+	return d
+}
+
+// random returns an independent random request duration
+func (r *RequestDuration) random() time.Duration {
+	percentile50, percentile90, percentile99 := r.Percentiles()
 
 	// calculate the random variance percentage
 	var rv = 0
@@ -53,11 +113,11 @@ func (r *RequestDuration) Calculate() time.Duration {
 	// generate a random percentile
 	switch p := r.randomFunc(100); {
 	case p < 90:
-		return r.calculateDuration(r.percentile50, rv)
+		return r.calculateDuration(percentile50, rv)
 	case p < 99:
-		return r.calculateDuration(r.percentile90, rv)
+		return r.calculateDuration(percentile90, rv)
 	default:
-		return r.calculateDuration(r.percentile99, rv)
+		return r.calculateDuration(percentile99, rv)
 	}
 }
 
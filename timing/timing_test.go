@@ -1,6 +1,7 @@
 package timing
 
 import (
+	"math/rand"
 	"testing"
 	"time"
 
@@ -55,3 +56,43 @@ func TestGenerates99Percentile(t *testing.T) {
 
 	assert.Equal(t, 3300*time.Microsecond, d)
 }
+
+func TestCorrelatedDurationsHaveConfiguredAutocorrelation(t *testing.T) {
+	rd := NewRequestDuration(10*time.Millisecond, 50*time.Millisecond, 100*time.Millisecond, 50)
+	rd.randomFunc = rand.New(rand.NewSource(1)).Intn
+	rd.SetCorrelation(0.8)
+
+	samples := make([]float64, 20000)
+	for i := range samples {
+		samples[i] = float64(rd.Calculate())
+	}
+
+	// lag 1 autocorrelation of the series
+	mean := 0.0
+	for _, s := range samples {
+		mean += s
+	}
+	mean /= float64(len(samples))
+
+	var num, den float64
+	for i, s := range samples {
+		den += (s - mean) * (s - mean)
+		if i > 0 {
+			num += (s - mean) * (samples[i-1] - mean)
+		}
+	}
+
+	assert.InDelta(t, 0.8, num/den, 0.05)
+}
+
+func TestUncorrelatedDurationsAreIndependent(t *testing.T) {
+	rd := setup(t, 50)
+	rd.Calculate()
+
+	// a slow request does not affect the next request
+	randomPercentile = 99
+	assert.Equal(t, 3300*time.Microsecond, rd.Calculate())
+
+	randomPercentile = 50
+	assert.Equal(t, 1100*time.Microsecond, rd.Calculate())
+}
@@ -9,8 +9,13 @@ import (
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
 )
 
-func NewDataDogClient(uri, name string) {
-	t := opentracer.New(tracer.WithAgentAddr(uri), tracer.WithServiceName(name), tracer.WithAnalytics(true))
+func NewDataDogClient(uri, name string, sampleRate float64) {
+	t := opentracer.New(
+		tracer.WithAgentAddr(uri),
+		tracer.WithServiceName(name),
+		tracer.WithAnalytics(true),
+		tracer.WithSampler(tracer.NewRateSampler(sampleRate)),
+	)
 
 	opentracing.SetGlobalTracer(t)
 }
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	zipkinot "github.com/openzipkin-contrib/zipkin-go-opentracing"
@@ -26,8 +27,9 @@ type Client interface {
 type OpenTracingClient struct {
 }
 
-// NewOpenTracingClient creates a new open tracing client
-func NewOpenTracingClient(uri, name, serviceURI string) Client {
+// NewOpenTracingClient creates a new open tracing client, sampleRate is the
+// fraction of traces (0.0-1.0) which will be exported to the collector
+func NewOpenTracingClient(uri, name, serviceURI string, sampleRate float64) Client {
 	var reporter reporter.Reporter
 
 	if strings.HasPrefix(uri, "http://") || strings.HasPrefix(uri, "https://") {
@@ -36,14 +38,7 @@ func NewOpenTracingClient(uri, name, serviceURI string) Client {
 		reporter = logreporter.NewReporter(log.New(os.Stderr, "", log.LstdFlags))
 	}
 
-	// create our local service endpoint
-	endpoint, err := zipkin.NewEndpoint(name, serviceURI)
-	if err != nil {
-		log.Fatalf("unable to create local endpoint: %s, error: %+v\n", serviceURI, err)
-	}
-
-	// initialize our tracer
-	nativeTracer, err := zipkin.NewTracer(reporter, zipkin.WithLocalEndpoint(endpoint))
+	nativeTracer, err := newZipkinTracer(reporter, name, serviceURI, sampleRate)
 	if err != nil {
 		log.Fatalf("unable to create tracer: %+v\n", err)
 	}
@@ -67,6 +62,24 @@ func (otc *OpenTracingClient) StartSpan(operation string, opts ...opentracing.St
 	return opentracing.StartSpan(operation, opts...)
 }
 
+// newZipkinTracer creates a zipkin tracer which uses a head based sampler,
+// the sampling decision is made once when the root span is created and is
+// propagated to upstreams with the span context
+func newZipkinTracer(r reporter.Reporter, name, serviceURI string, sampleRate float64) (*zipkin.Tracer, error) {
+	// create our local service endpoint
+	endpoint, err := zipkin.NewEndpoint(name, serviceURI)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create local endpoint: %s, error: %s", serviceURI, err)
+	}
+
+	sampler, err := zipkin.NewBoundarySampler(sampleRate, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+
+	return zipkin.NewTracer(r, zipkin.WithLocalEndpoint(endpoint), zipkin.WithSampler(sampler))
+}
+
 func GetZipkinSpanDetails(ctx opentracing.SpanContext) *SpanDetails {
 	if s, ok := ctx.(zipkinot.SpanContext); ok {
 		return &SpanDetails{
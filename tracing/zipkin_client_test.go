@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"testing"
+
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZipkinTracerSamplesConfiguredFraction(t *testing.T) {
+	r := recorder.NewReporter()
+	defer r.Close()
+
+	tr, err := newZipkinTracer(r, "test", "localhost:9090", 0.25)
+	assert.NoError(t, err)
+
+	requests := 10000
+	for i := 0; i < requests; i++ {
+		tr.StartSpan("handle_request").Finish()
+	}
+
+	sampled := float64(len(r.Flush())) / float64(requests)
+	assert.InDelta(t, 0.25, sampled, 0.05)
+}
+
+func TestZipkinTracerPropagatesContextWhenNotSampled(t *testing.T) {
+	r := recorder.NewReporter()
+	defer r.Close()
+
+	tr, err := newZipkinTracer(r, "test", "localhost:9090", 0)
+	assert.NoError(t, err)
+
+	sp := tr.StartSpan("handle_request")
+	sp.Finish()
+
+	assert.Len(t, r.Flush(), 0)
+	assert.NotEmpty(t, sp.Context().TraceID.String())
+	assert.False(t, *sp.Context().Sampled)
+}
+
+func TestZipkinTracerReturnsErrorForInvalidRate(t *testing.T) {
+	_, err := newZipkinTracer(recorder.NewReporter(), "test", "localhost:9090", 2)
+	assert.Error(t, err)
+}
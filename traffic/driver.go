@@ -0,0 +1,75 @@
+package traffic
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+// CallFunc calls the upstream at the given URI
+type CallFunc func(uri string) error
+
+// Driver generates background traffic from the service to its upstreams,
+// requests are sent to a random target from the list with a jittered gap
+// between each request
+type Driver struct {
+	mean    time.Duration
+	jitter  time.Duration
+	targets []string
+	call    CallFunc
+	logger  hclog.Logger
+
+	randomFunc func() float64
+}
+
+// NewDriver creates a new Driver, the gap between requests is chosen
+// uniformly in the band mean ± jitter
+func NewDriver(mean, jitter time.Duration, targets []string, call CallFunc, logger hclog.Logger) *Driver {
+	return &Driver{
+		mean:       mean,
+		jitter:     jitter,
+		targets:    targets,
+		call:       call,
+		logger:     logger,
+		randomFunc: rand.Float64,
+	}
+}
+
+// Start sending traffic, the returned function stops the driver
+func (d *Driver) Start() func() {
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-time.After(d.nextGap()):
+			case <-done:
+				return
+			}
+
+			if len(d.targets) == 0 {
+				continue
+			}
+
+			uri := d.targets[int(d.randomFunc()*float64(len(d.targets)))%len(d.targets)]
+
+			err := d.call(uri)
+			if err != nil {
+				d.logger.Debug("Background request failed", "uri", uri, "error", err)
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+// nextGap returns the time to wait before the next request
+func (d *Driver) nextGap() time.Duration {
+	g := d.mean + time.Duration((2*d.randomFunc()-1)*float64(d.jitter))
+	if g < 0 {
+		return 0
+	}
+
+	return g
+}
@@ -0,0 +1,74 @@
+package traffic
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextGapIsWithinJitterBand(t *testing.T) {
+	d := NewDriver(100*time.Millisecond, 20*time.Millisecond, nil, nil, hclog.NewNullLogger())
+
+	for i := 0; i < 1000; i++ {
+		g := d.nextGap()
+
+		assert.GreaterOrEqual(t, int64(g), int64(80*time.Millisecond))
+		assert.LessOrEqual(t, int64(g), int64(120*time.Millisecond))
+	}
+}
+
+func TestNextGapUsesBandEdges(t *testing.T) {
+	d := NewDriver(100*time.Millisecond, 20*time.Millisecond, nil, nil, hclog.NewNullLogger())
+
+	d.randomFunc = func() float64 { return 0 }
+	assert.Equal(t, 80*time.Millisecond, d.nextGap())
+
+	d.randomFunc = func() float64 { return 0.5 }
+	assert.Equal(t, 100*time.Millisecond, d.nextGap())
+}
+
+func TestNextGapIsNotNegative(t *testing.T) {
+	d := NewDriver(10*time.Millisecond, 20*time.Millisecond, nil, nil, hclog.NewNullLogger())
+	d.randomFunc = func() float64 { return 0 }
+
+	assert.Equal(t, time.Duration(0), d.nextGap())
+}
+
+func TestDriverCallsTargetsWithJitteredGaps(t *testing.T) {
+	calls := []time.Time{}
+	uris := map[string]bool{}
+	mutex := sync.Mutex{}
+
+	d := NewDriver(30*time.Millisecond, 10*time.Millisecond, []string{"http://a", "http://b"}, func(uri string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		calls = append(calls, time.Now())
+		uris[uri] = true
+		return nil
+	}, hclog.NewNullLogger())
+
+	st := time.Now()
+	stop := d.Start()
+	time.Sleep(300 * time.Millisecond)
+	stop()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	assert.Greater(t, len(calls), 2)
+
+	// timers never fire early so each gap is at least the bottom of the band
+	prev := st
+	for _, c := range calls {
+		assert.GreaterOrEqual(t, int64(c.Sub(prev)), int64(20*time.Millisecond))
+		prev = c
+	}
+
+	for u := range uris {
+		assert.Contains(t, []string{"http://a", "http://b"}, u)
+	}
+}
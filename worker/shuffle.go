@@ -0,0 +1,37 @@
+package worker
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Shuffle randomizes the order in which upstreams are dispatched to the
+// workers, the order is deterministic for a given seed so that demos can be
+// reproduced
+type Shuffle struct {
+	rand  *rand.Rand
+	mutex sync.Mutex
+}
+
+// NewShuffle creates a Shuffle whose sequence of dispatch orders is
+// determined by seed
+func NewShuffle(seed int64) *Shuffle {
+	return &Shuffle{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Order returns a shuffled copy of uris, a nil Shuffle returns uris
+// unchanged
+func (s *Shuffle) Order(uris []string) []string {
+	if s == nil {
+		return uris
+	}
+
+	o := append([]string{}, uris...)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rand.Shuffle(len(o), func(i, j int) { o[i], o[j] = o[j], o[i] })
+
+	return o
+}
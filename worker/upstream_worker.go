@@ -1,11 +1,16 @@
 package worker
 
 import (
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/nicholasjackson/fake-service/response"
 )
 
+// ErrQueueFull is returned when work is dropped because the queue is full
+var ErrQueueFull = fmt.Errorf("worker queue is full, upstream call dropped")
+
 // WorkFunc defines a function which is called when work is to be done
 type WorkFunc func(uri string) (*response.Response, error)
 
@@ -15,48 +20,116 @@ type Done struct {
 	Response *response.Response
 }
 
+// Stats reports the saturation of the worker queue
+type Stats struct {
+	// MaxQueueDepth is the largest number of tasks waiting for a worker
+	MaxQueueDepth int
+	// QueueWaits is the time each task waited before a worker picked it up
+	QueueWaits []time.Duration
+	// Dropped is the number of tasks discarded because the queue was full
+	Dropped int
+}
+
+// MaxQueueWait returns the longest time a task waited for a worker
+func (s Stats) MaxQueueWait() time.Duration {
+	max := time.Duration(0)
+	for _, w := range s.QueueWaits {
+		if w > max {
+			max = w
+		}
+	}
+
+	return max
+}
+
+// task is a unit of work waiting in the queue
+type task struct {
+	uri    string
+	queued time.Time
+}
+
 // UpstreamWorker manages parallel upstream requests
 type UpstreamWorker struct {
 	workerCount int
-	workChan    chan string
+	queueSize   int
+	workChan    chan task
 	err         error
 	workFunc    WorkFunc
 	waitGroup   *sync.WaitGroup
 	responses   []Done
+	stats       Stats
+	depth       int
+	shuffle     *Shuffle
+	mutex       sync.Mutex
 }
 
 // New UpstreamWorker
 func New(workerCount int, f WorkFunc) *UpstreamWorker {
+	return NewBounded(workerCount, 0, f)
+}
+
+// NewBounded creates an UpstreamWorker whose queue holds at most queueSize
+// tasks, work submitted to a full queue is dropped. A queueSize of 0 creates
+// an unbounded queue
+func NewBounded(workerCount, queueSize int, f WorkFunc) *UpstreamWorker {
 	return &UpstreamWorker{
 		workerCount: workerCount,
-		workChan:    make(chan string),
+		queueSize:   queueSize,
 		workFunc:    f,
 		waitGroup:   &sync.WaitGroup{},
 		responses:   []Done{},
 	}
 }
 
+// SetShuffle dispatches the uris to the workers in the order chosen by s,
+// when s is nil the uris are dispatched in the order they are given
+func (u *UpstreamWorker) SetShuffle(s *Shuffle) {
+	u.shuffle = s
+}
+
 // Do runs the worker with the given uris
 func (u *UpstreamWorker) Do(uris []string) error {
+	uris = u.shuffle.Order(uris)
+
 	if u.workerCount > len(uris) {
 		u.workerCount = len(uris)
 	}
 
+	size := u.queueSize
+	if size == 0 {
+		size = len(uris)
+	}
+
+	u.workChan = make(chan task, size)
+
 	// start the workers
 	u.waitGroup.Add(u.workerCount)
 	for n := 0; n < u.workerCount; n++ {
 		go u.worker()
 	}
 
-	// start the work
-	go func() {
-		for _, uri := range uris {
-			u.workChan <- uri
+	// queue the work, when the queue is full the work is dropped
+	for _, uri := range uris {
+		u.mutex.Lock()
+		select {
+		case u.workChan <- task{uri, time.Now()}:
+			u.depth++
+			if u.depth > u.stats.MaxQueueDepth {
+				u.stats.MaxQueueDepth = u.depth
+			}
+		default:
+			u.stats.Dropped++
+			u.responses = append(u.responses, Done{uri, &response.Response{URI: uri, Error: ErrQueueFull.Error()}})
+
+			if u.err == nil {
+				u.err = ErrQueueFull
+			}
 		}
+		u.mutex.Unlock()
+	}
 
-		// close the work channel
-		close(u.workChan)
-	}()
+	// close the work channel
+	close(u.workChan)
 
 	u.waitGroup.Wait()
 	return u.err
@@ -67,21 +140,34 @@ func (u *UpstreamWorker) Responses() []Done {
 	return u.responses
 }
 
+// Stats returns the queue statistics, this should be called after Do
+func (u *UpstreamWorker) Stats() Stats {
+	return u.stats
+}
+
 func (u *UpstreamWorker) worker() {
 	for {
-		uri, ok := <-u.workChan
+		t, ok := <-u.workChan
 
 		// all work is complete exit
 		if !ok {
 			break
 		}
 
-		resp, err := u.workFunc(uri)
-		u.responses = append(u.responses, Done{uri, resp})
+		u.mutex.Lock()
+		u.depth--
+		u.stats.QueueWaits = append(u.stats.QueueWaits, time.Since(t.queued))
+		u.mutex.Unlock()
+
+		resp, err := u.workFunc(t.uri)
+
+		u.mutex.Lock()
+		u.responses = append(u.responses, Done{t.uri, resp})
 
 		if err != nil && u.err == nil {
 			u.err = err
 		}
+		u.mutex.Unlock()
 	}
 	u.waitGroup.Done()
 }
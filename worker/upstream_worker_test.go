@@ -82,3 +82,72 @@ func TestUpstreamWorkerWithTwoURIAndTwoWorkerFirstFail(t *testing.T) {
 
 	assert.Equal(t, w.err.Error(), "1")
 }
+
+func TestUpstreamWorkerRecordsQueueDepthAndWait(t *testing.T) {
+	w := New(1, func(uri string) (*response.Response, error) {
+		time.Sleep(5 * time.Millisecond)
+
+		return &response.Response{}, nil
+	})
+
+	err := w.Do([]string{"1", "2", "3", "4"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, w.Stats().Dropped)
+	assert.GreaterOrEqual(t, w.Stats().MaxQueueDepth, 3)
+	assert.Len(t, w.Stats().QueueWaits, 4)
+	assert.GreaterOrEqual(t, int64(w.Stats().MaxQueueWait()), int64(15*time.Millisecond))
+}
+
+func TestBoundedUpstreamWorkerDropsWorkWhenQueueIsFull(t *testing.T) {
+	w := NewBounded(1, 1, func(uri string) (*response.Response, error) {
+		time.Sleep(5 * time.Millisecond)
+
+		return &response.Response{}, nil
+	})
+
+	err := w.Do([]string{"1", "2", "3", "4"})
+
+	assert.Equal(t, ErrQueueFull, err)
+	assert.GreaterOrEqual(t, w.Stats().Dropped, 2)
+	assert.Equal(t, 1, w.Stats().MaxQueueDepth)
+	assert.Len(t, w.Responses(), 4)
+}
+
+func dispatchSequence(seed int64) []string {
+	s := NewShuffle(seed)
+	seq := []string{}
+
+	for i := 0; i < 5; i++ {
+		w := New(1, func(uri string) (*response.Response, error) {
+			seq = append(seq, uri)
+			return &response.Response{}, nil
+		})
+		w.SetShuffle(s)
+
+		w.Do([]string{"a", "b", "c", "d", "e"})
+	}
+
+	return seq
+}
+
+func TestUpstreamWorkerDispatchesInSameOrderForSeed(t *testing.T) {
+	first := dispatchSequence(42)
+	second := dispatchSequence(42)
+
+	assert.Len(t, first, 25)
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first, dispatchSequence(7))
+}
+
+func TestUpstreamWorkerDispatchesInOrderWithoutShuffle(t *testing.T) {
+	seq := []string{}
+	w := New(1, func(uri string) (*response.Response, error) {
+		seq = append(seq, uri)
+		return &response.Response{}, nil
+	})
+
+	w.Do([]string{"a", "b", "c"})
+
+	assert.Equal(t, []string{"a", "b", "c"}, seq)
+}